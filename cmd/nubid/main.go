@@ -4,6 +4,7 @@ import (
 	"context"
 	"flag"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/shsm0520/nubi/internal/api"
@@ -14,6 +15,8 @@ func main() {
 	addr := flag.String("addr", ":8080", "HTTP listen address")
 	staticDir := flag.String("static", "web/dist", "path to static assets to serve")
 	nginxBin := flag.String("nginx-bin", "", "path to the nginx binary (defaults to looking up on PATH)")
+	snippetDeny := flag.String("snippet-deny", "", "comma-separated directives denied in custom nginx snippets, * as wildcard (defaults to alias, root, load_module, lua_* and similar)")
+	snippetIncludeDirs := flag.String("snippet-include-dirs", "", "comma-separated directories custom nginx snippets may include from (defaults to /etc/nginx/snippets)")
 
 	flag.Parse()
 
@@ -27,7 +30,7 @@ func main() {
 	}
 
 	// Initialize default route on startup
-	defaultRoute, err := nginx.NewDefaultRouteManager("")
+	defaultRoute, err := nginx.NewDefaultRouteManager(controller, "")
 	if err != nil {
 		log.Printf("warning: failed to create default route manager: %v", err)
 	} else {
@@ -54,11 +57,37 @@ func main() {
 
 	srv := api.NewServer(controller, *staticDir)
 
+	snippetPolicy := nginx.DefaultSnippetPolicy()
+	if *snippetDeny != "" {
+		snippetPolicy.DeniedDirectives = splitList(*snippetDeny)
+	}
+	if *snippetIncludeDirs != "" {
+		snippetPolicy.IncludeDirs = splitList(*snippetIncludeDirs)
+	}
+	srv.SetSnippetPolicy(snippetPolicy)
+
 	// Start WebSocket status broadcaster (every 5 seconds)
 	srv.StartStatusBroadcaster(5 * time.Second)
 
+	// Start active backend health checks
+	srv.StartHealthChecks(context.Background())
+
+	// Start applying scheduled maintenance windows
+	srv.StartMaintenanceScheduler(context.Background())
+
 	log.Printf("Starting Nubi server on %s", *addr)
 	if err := srv.Router().Run(*addr); err != nil {
 		log.Fatalf("failed to start http server: %v", err)
 	}
 }
+
+// splitList splits a comma-separated flag value, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shsm0520/nubi/internal/nginx"
+)
+
+// redactAccessLists strips password hashes from lists for API output
+func redactAccessLists(lists []*nginx.AccessList) []*nginx.AccessList {
+	out := make([]*nginx.AccessList, len(lists))
+	for i, l := range lists {
+		out[i] = l.Redacted()
+	}
+	return out
+}
+
+// handleListAccessLists returns all access lists
+func (s *Server) handleListAccessLists(ctx *gin.Context) {
+	lists := redactAccessLists(s.accessLists.List())
+	ctx.JSON(http.StatusOK, gin.H{
+		"accessLists": lists,
+		"count":       len(lists),
+	})
+}
+
+// handleGetAccessList returns a single access list and the hosts using it
+func (s *Server) handleGetAccessList(ctx *gin.Context) {
+	list, err := s.accessLists.Get(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"accessList": list.Redacted(),
+		"hosts":      s.proxyHosts.HostsUsingAccessList(list.ID),
+	})
+}
+
+// handleCreateAccessList creates a new access list. Users need a plaintext
+// password or an existing bcrypt/apr1 hash.
+func (s *Server) handleCreateAccessList(ctx *gin.Context) {
+	var req nginx.AccessList
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.accessLists.Create(requestContext(ctx), &req); err != nil {
+		respondApplyError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{
+		"accessList": req.Redacted(),
+		"message":    "Access list created successfully",
+	})
+}
+
+// handleUpdateAccessList updates an access list and re-renders every host
+// using it. Users sent without a password keep their current one.
+func (s *Server) handleUpdateAccessList(ctx *gin.Context) {
+	id := ctx.Param("id")
+
+	var req nginx.AccessList
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.accessLists.Update(requestContext(ctx), id, &req); err != nil {
+		respondApplyError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	hosts := s.proxyHosts.HostsUsingAccessList(id)
+	if len(hosts) > 0 && !s.reloadAfterChange(ctx, "Access list updated") {
+		return
+	}
+
+	list, _ := s.accessLists.Get(id)
+	ctx.JSON(http.StatusOK, gin.H{
+		"accessList": list.Redacted(),
+		"hosts":      hosts,
+		"message":    "Access list updated successfully",
+	})
+}
+
+// handleDeleteAccessList deletes an access list that no host uses
+func (s *Server) handleDeleteAccessList(ctx *gin.Context) {
+	id := ctx.Param("id")
+
+	if hosts := s.proxyHosts.HostsUsingAccessList(id); len(hosts) > 0 {
+		ctx.JSON(http.StatusConflict, gin.H{
+			"error": "access list is attached to hosts",
+			"hosts": hosts,
+		})
+		return
+	}
+
+	if err := s.accessLists.Delete(requestContext(ctx), id); err != nil {
+		respondApplyError(ctx, http.StatusNotFound, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Access list deleted successfully"})
+}
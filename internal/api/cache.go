@@ -0,0 +1,27 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handlePurgeHostCache deletes every cached response of a host
+func (s *Server) handlePurgeHostCache(ctx *gin.Context) {
+	host, err := s.proxyHosts.Get(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	removed, err := s.proxyHosts.PurgeCache(host.ID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Cache purged successfully",
+		"removed": removed,
+	})
+}
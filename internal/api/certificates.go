@@ -3,6 +3,7 @@ package api
 import (
 	"crypto/x509"
 	"encoding/pem"
+	"fmt"
 	"io"
 	"net/http"
 	"time"
@@ -44,43 +45,79 @@ func (s *Server) handleUploadCertificate(ctx *gin.Context) {
 		return
 	}
 
+	certContent, keyContent, ok := readCertificateUpload(ctx)
+	if !ok {
+		return
+	}
+
+	// Parse certificate to extract domains and expiry
+	expiresAt, domains := parseCertificateInfo(certContent)
+
+	newCert := &nginx.Certificate{
+		Name:      name,
+		Domains:   domains,
+		Type:      "uploaded",
+		ExpiresAt: expiresAt,
+		AutoRenew: false,
+	}
+
+	created, err := s.certManager.CreateCertificate(ctx.Request.Context(), newCert, certContent, keyContent)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{
+		"message":     "Certificate uploaded successfully",
+		"certificate": created,
+	})
+}
+
+// readCertificateUpload reads the certificate and key files from a multipart
+// form, writing an error response and returning false if either is missing
+func readCertificateUpload(ctx *gin.Context) (certContent, keyContent []byte, ok bool) {
 	// Get certificate file
 	certFile, err := ctx.FormFile("certificate")
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": "certificate file is required"})
-		return
+		return nil, nil, false
 	}
 
 	// Get key file
 	keyFile, err := ctx.FormFile("key")
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": "key file is required"})
-		return
+		return nil, nil, false
 	}
 
 	// Read certificate content
 	certReader, err := certFile.Open()
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read certificate"})
-		return
+		return nil, nil, false
 	}
 	defer certReader.Close()
-	certContent, _ := io.ReadAll(certReader)
+	certContent, _ = io.ReadAll(certReader)
 
 	// Read key content
 	keyReader, err := keyFile.Open()
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read key"})
-		return
+		return nil, nil, false
 	}
 	defer keyReader.Close()
-	keyContent, _ := io.ReadAll(keyReader)
+	keyContent, _ = io.ReadAll(keyReader)
 
-	// Parse certificate to extract domains and expiry
-	block, _ := pem.Decode(certContent)
+	return certContent, keyContent, true
+}
+
+// parseCertificateInfo extracts the expiry and covered domains from a PEM
+// certificate, returning zero values if it cannot be parsed
+func parseCertificateInfo(certContent []byte) (time.Time, []string) {
 	var expiresAt time.Time
 	var domains []string
 
+	block, _ := pem.Decode(certContent)
 	if block != nil {
 		cert, err := x509.ParseCertificate(block.Bytes)
 		if err == nil {
@@ -92,26 +129,156 @@ func (s *Server) handleUploadCertificate(ctx *gin.Context) {
 		}
 	}
 
-	newCert := &nginx.Certificate{
+	return expiresAt, domains
+}
+
+// handleUploadCABundle uploads a CA bundle used to verify client
+// certificates, with an optional CRL
+func (s *Server) handleUploadCABundle(ctx *gin.Context) {
+	name := ctx.PostForm("name")
+	if name == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+		return
+	}
+
+	bundleContent, ok := readFormFile(ctx, "bundle")
+	if !ok {
+		return
+	}
+	expiresAt, subjects, err := parseCABundle(bundleContent)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var crlContent []byte
+	if _, err := ctx.FormFile("crl"); err == nil {
+		if crlContent, ok = readFormFile(ctx, "crl"); !ok {
+			return
+		}
+		if err := parseCRL(crlContent); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	newCA := &nginx.Certificate{
 		Name:      name,
-		Domains:   domains,
-		Type:      "uploaded",
+		Domains:   subjects,
 		ExpiresAt: expiresAt,
-		AutoRenew: false,
 	}
 
-	created, err := s.certManager.CreateCertificate(ctx.Request.Context(), newCert, certContent, keyContent)
+	created, err := s.certManager.CreateCABundle(ctx.Request.Context(), newCA, bundleContent, crlContent)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
 	ctx.JSON(http.StatusCreated, gin.H{
-		"message":     "Certificate uploaded successfully",
+		"message":     "CA bundle uploaded successfully",
 		"certificate": created,
 	})
 }
 
+// handleReplaceCRL uploads a new CRL for a CA bundle and reloads nginx when
+// hosts check it
+func (s *Server) handleReplaceCRL(ctx *gin.Context) {
+	id := ctx.Param("id")
+
+	crlContent, ok := readFormFile(ctx, "crl")
+	if !ok {
+		return
+	}
+	if err := parseCRL(crlContent); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	cert, err := s.certManager.ReplaceCRL(requestContext(ctx), id, crlContent)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	hosts := s.certBinder.InUse(id)
+	if len(hosts) > 0 && !s.reloadAfterChange(ctx, "CRL replaced") {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message":     "CRL replaced",
+		"certificate": cert,
+		"hosts":       hosts,
+	})
+}
+
+// readFormFile reads a file from a multipart form, writing an error response
+// and returning false if it is missing
+func readFormFile(ctx *gin.Context, field string) ([]byte, bool) {
+	file, err := ctx.FormFile(field)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": field + " file is required"})
+		return nil, false
+	}
+
+	reader, err := file.Open()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read " + field})
+		return nil, false
+	}
+	defer reader.Close()
+	content, _ := io.ReadAll(reader)
+
+	return content, true
+}
+
+// parseCABundle checks that a PEM bundle holds CA certificates, returning the
+// earliest expiry and the subjects of the certificates
+func parseCABundle(content []byte) (time.Time, []string, error) {
+	var expiresAt time.Time
+	var subjects []string
+
+	for rest := content; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return time.Time{}, nil, fmt.Errorf("invalid certificate in CA bundle: %w", err)
+		}
+		if !cert.IsCA {
+			return time.Time{}, nil, fmt.Errorf("certificate %q in the bundle is not a CA", cert.Subject.CommonName)
+		}
+		if expiresAt.IsZero() || cert.NotAfter.Before(expiresAt) {
+			expiresAt = cert.NotAfter
+		}
+		subjects = append(subjects, cert.Subject.CommonName)
+	}
+
+	if len(subjects) == 0 {
+		return time.Time{}, nil, fmt.Errorf("CA bundle must contain at least one PEM certificate")
+	}
+	return expiresAt, subjects, nil
+}
+
+// parseCRL checks that content is a PEM certificate revocation list, the
+// format ssl_crl reads
+func parseCRL(content []byte) error {
+	block, _ := pem.Decode(content)
+	if block == nil || block.Type != "X509 CRL" {
+		return fmt.Errorf("CRL must be PEM encoded")
+	}
+	if _, err := x509.ParseRevocationList(block.Bytes); err != nil {
+		return fmt.Errorf("invalid CRL: %w", err)
+	}
+	return nil
+}
+
 // handleUpdateCertificate updates certificate metadata
 func (s *Server) handleUpdateCertificate(ctx *gin.Context) {
 	id := ctx.Param("id")
@@ -138,6 +305,15 @@ func (s *Server) handleUpdateCertificate(ctx *gin.Context) {
 func (s *Server) handleDeleteCertificate(ctx *gin.Context) {
 	id := ctx.Param("id")
 
+	// Refuse to delete a certificate that hosts still reference
+	if hosts := s.certBinder.InUse(id); len(hosts) > 0 {
+		ctx.JSON(http.StatusConflict, gin.H{
+			"error": "certificate is still bound to hosts",
+			"hosts": hosts,
+		})
+		return
+	}
+
 	if err := s.certManager.DeleteCertificate(ctx.Request.Context(), id); err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -226,47 +402,76 @@ func (s *Server) handleBulkApplyCertificate(ctx *gin.Context) {
 		return
 	}
 
-	// Get certificate paths
-	certPath, keyPath, err := s.certManager.GetCertificatePaths(ctx.Request.Context(), req.CertificateID)
+	report, err := s.certBinder.Bind(requestContext(ctx), req.CertificateID, req.HostIDs, req.TagID)
 	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	respondBindReport(ctx, "Certificate applied", report)
+}
+
+// handleReplaceCertificate uploads new certificate and key files for an
+// existing certificate and re-renders every host bound to it in the same
+// apply. When the apply fails the old files stay in place.
+func (s *Server) handleReplaceCertificate(ctx *gin.Context) {
+	id := ctx.Param("id")
+
+	if _, err := s.certManager.GetCertificate(ctx.Request.Context(), id); err != nil {
 		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Get host IDs to update
-	hostIDs := req.HostIDs
+	certContent, keyContent, ok := readCertificateUpload(ctx)
+	if !ok {
+		return
+	}
 
-	// If tag ID is provided, get all hosts with that tag
-	if req.TagID != "" {
-		hosts := s.proxyHosts.List()
+	expiresAt, _ := parseCertificateInfo(certContent)
 
-		for _, host := range hosts {
-			for _, tag := range host.Tags {
-				if tag == req.TagID {
-					hostIDs = append(hostIDs, host.ID)
-					break
-				}
-			}
+	cert, report, err := s.certManager.ReplaceCertificateFiles(requestContext(ctx), id, certContent, keyContent, expiresAt)
+	if report == nil {
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
 		}
+		ctx.JSON(http.StatusOK, gin.H{"message": "Certificate replaced", "certificate": cert})
+		return
+	}
+	if err != nil && report.ConfigTest == nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "report": report})
+		return
 	}
 
-	// Apply certificate to each host
-	updated := 0
-	for _, hostID := range hostIDs {
-		err := s.proxyHosts.ApplyCertificate(ctx.Request.Context(), hostID, req.CertificateID, certPath, keyPath)
-		if err == nil {
-			updated++
-		}
+	respondBindReport(ctx, "Certificate replaced", report)
+}
+
+// handleRefreshCertificate re-renders every host bound to a certificate
+func (s *Server) handleRefreshCertificate(ctx *gin.Context) {
+	report, err := s.certBinder.Refresh(requestContext(ctx), ctx.Param("id"))
+	if report == nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
 	}
 
-	// Reload nginx
-	if updated > 0 {
-		s.nginx.Reload(ctx.Request.Context())
+	respondBindReport(ctx, "Certificate hosts refreshed", report)
+}
+
+// respondBindReport writes a certificate bind report with per-host results
+func respondBindReport(ctx *gin.Context, message string, report *nginx.BindReport) {
+	status := http.StatusOK
+	if report.ConfigTest != nil {
+		status = http.StatusUnprocessableEntity
+		message = report.ConfigTest.Error()
+	} else if report.ReloadError != "" {
+		status = http.StatusBadGateway
+		message = "Certificate applied but nginx reload failed"
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{
-		"message":      "Certificate applied",
-		"updatedHosts": updated,
+	ctx.JSON(status, gin.H{
+		"message":      message,
+		"updatedHosts": report.Updated,
+		"report":       report,
 	})
 }
 
@@ -290,10 +495,16 @@ func (s *Server) handleBulkTagHosts(ctx *gin.Context) {
 	updated := 0
 	for _, hostID := range req.HostIDs {
 		var err error
-		if req.Action == "add" {
-			err = s.proxyHosts.AddTag(ctx.Request.Context(), hostID, req.TagID)
+		if _, redirectErr := s.redirectHosts.Get(hostID); redirectErr == nil {
+			if req.Action == "add" {
+				err = s.redirectHosts.AddTag(requestContext(ctx), hostID, req.TagID)
+			} else {
+				err = s.redirectHosts.RemoveTag(requestContext(ctx), hostID, req.TagID)
+			}
+		} else if req.Action == "add" {
+			err = s.proxyHosts.AddTag(requestContext(ctx), hostID, req.TagID)
 		} else {
-			err = s.proxyHosts.RemoveTag(ctx.Request.Context(), hostID, req.TagID)
+			err = s.proxyHosts.RemoveTag(requestContext(ctx), hostID, req.TagID)
 		}
 		if err == nil {
 			updated++
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shsm0520/nubi/internal/nginx"
+)
+
+// handleListCORSPolicies returns the CORS policy library
+func (s *Server) handleListCORSPolicies(ctx *gin.Context) {
+	policies := s.corsPolicies.List()
+	ctx.JSON(http.StatusOK, gin.H{
+		"corsPolicies": policies,
+		"count":        len(policies),
+	})
+}
+
+// handleGetCORSPolicy returns a policy and the hosts using it
+func (s *Server) handleGetCORSPolicy(ctx *gin.Context) {
+	policy, err := s.corsPolicies.Get(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"corsPolicy": policy,
+		"hosts":      s.proxyHosts.HostsUsingCORSPolicy(policy.ID),
+	})
+}
+
+// handleCreateCORSPolicy adds a policy to the library
+func (s *Server) handleCreateCORSPolicy(ctx *gin.Context) {
+	var req nginx.CORSPolicy
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.corsPolicies.Create(requestContext(ctx), &req); err != nil {
+		respondApplyError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{
+		"corsPolicy": req,
+		"message":    "CORS policy created successfully",
+	})
+}
+
+// handleUpdateCORSPolicy updates a policy and re-renders every host using it
+func (s *Server) handleUpdateCORSPolicy(ctx *gin.Context) {
+	id := ctx.Param("id")
+
+	var req nginx.CORSPolicy
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.corsPolicies.Update(requestContext(ctx), id, &req); err != nil {
+		respondApplyError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	hosts := s.proxyHosts.HostsUsingCORSPolicy(id)
+	if len(hosts) > 0 && !s.reloadAfterChange(ctx, "CORS policy updated") {
+		return
+	}
+
+	policy, _ := s.corsPolicies.Get(id)
+	ctx.JSON(http.StatusOK, gin.H{
+		"corsPolicy": policy,
+		"hosts":      hosts,
+		"message":    "CORS policy updated successfully",
+	})
+}
+
+// handleDeleteCORSPolicy deletes a policy that no host uses
+func (s *Server) handleDeleteCORSPolicy(ctx *gin.Context) {
+	id := ctx.Param("id")
+
+	if hosts := s.proxyHosts.HostsUsingCORSPolicy(id); len(hosts) > 0 {
+		ctx.JSON(http.StatusConflict, gin.H{
+			"error": "CORS policy is used by hosts",
+			"hosts": hosts,
+		})
+		return
+	}
+
+	if err := s.corsPolicies.Delete(requestContext(ctx), id); err != nil {
+		respondApplyError(ctx, http.StatusNotFound, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "CORS policy deleted successfully"})
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shsm0520/nubi/internal/nginx"
+)
+
+// handleListErrorPages returns the error page library
+func (s *Server) handleListErrorPages(ctx *gin.Context) {
+	pages := s.errorPages.List()
+	ctx.JSON(http.StatusOK, gin.H{
+		"errorPages": pages,
+		"count":      len(pages),
+	})
+}
+
+// handleGetErrorPage returns a library page and the hosts using it
+func (s *Server) handleGetErrorPage(ctx *gin.Context) {
+	page, err := s.errorPages.Get(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"errorPage": page,
+		"hosts":     s.proxyHosts.HostsUsingErrorPage(page.ID),
+	})
+}
+
+// handlePreviewErrorPage renders a library page for a status code
+func (s *Server) handlePreviewErrorPage(ctx *gin.Context) {
+	page, err := s.errorPages.Get(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	code := http.StatusBadGateway
+	if c := ctx.Query("code"); c != "" {
+		code, err = strconv.Atoi(c)
+		if err != nil || code < 300 || code > 599 {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "code must be a status code between 300 and 599"})
+			return
+		}
+	}
+
+	html, err := page.Render(code)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.Data(http.StatusOK, "text/html; charset=utf-8", html)
+}
+
+// handleCreateErrorPage adds a page to the library
+func (s *Server) handleCreateErrorPage(ctx *gin.Context) {
+	var req nginx.ErrorPage
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.errorPages.Create(requestContext(ctx), &req); err != nil {
+		respondApplyError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{
+		"errorPage": req,
+		"message":   "Error page created successfully",
+	})
+}
+
+// handleUpdateErrorPage updates a library page and re-renders every host
+// using it
+func (s *Server) handleUpdateErrorPage(ctx *gin.Context) {
+	id := ctx.Param("id")
+
+	var req nginx.ErrorPage
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.errorPages.Update(requestContext(ctx), id, &req); err != nil {
+		respondApplyError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	hosts := s.proxyHosts.HostsUsingErrorPage(id)
+	if len(hosts) > 0 && !s.reloadAfterChange(ctx, "Error page updated") {
+		return
+	}
+
+	page, _ := s.errorPages.Get(id)
+	ctx.JSON(http.StatusOK, gin.H{
+		"errorPage": page,
+		"hosts":     hosts,
+		"message":   "Error page updated successfully",
+	})
+}
+
+// handleDeleteErrorPage deletes a library page that no host uses
+func (s *Server) handleDeleteErrorPage(ctx *gin.Context) {
+	id := ctx.Param("id")
+
+	if hosts := s.proxyHosts.HostsUsingErrorPage(id); len(hosts) > 0 {
+		ctx.JSON(http.StatusConflict, gin.H{
+			"error": "error page is used by hosts",
+			"hosts": hosts,
+		})
+		return
+	}
+
+	if err := s.errorPages.Delete(requestContext(ctx), id); err != nil {
+		respondApplyError(ctx, http.StatusNotFound, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Error page deleted successfully"})
+}
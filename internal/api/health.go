@@ -0,0 +1,30 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StartHealthChecks starts probing the backends of hosts with a health check
+// until ctx is cancelled
+func (s *Server) StartHealthChecks(ctx context.Context) {
+	s.health.Start(ctx)
+}
+
+// handleGetHostHealth returns the current health of a host's backends
+func (s *Server) handleGetHostHealth(ctx *gin.Context) {
+	host, err := s.proxyHosts.Get(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"hostId":      host.ID,
+		"enabled":     host.HealthCheck != nil && host.Enabled,
+		"healthCheck": host.HealthCheck,
+		"backends":    s.health.Health(host.ID),
+	})
+}
@@ -0,0 +1,160 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shsm0520/nubi/internal/nginx"
+)
+
+// handleListHistory returns recorded revisions, newest first
+func (s *Server) handleListHistory(ctx *gin.Context) {
+	limit := 100
+	if l := ctx.Query("limit"); l != "" {
+		if n, err := strconv.Atoi(l); err == nil && n >= 0 {
+			limit = n
+		}
+	}
+
+	revisions := s.history.List(ctx.Query("entity"), ctx.Query("entityId"), limit)
+	ctx.JSON(http.StatusOK, gin.H{
+		"revisions": revisions,
+		"count":     len(revisions),
+	})
+}
+
+// handleGetRevision returns a single revision
+func (s *Server) handleGetRevision(ctx *gin.Context) {
+	rev, err := s.history.Get(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"revision": rev})
+}
+
+// handleDiffHistory returns a unified diff between two revisions
+func (s *Server) handleDiffHistory(ctx *gin.Context) {
+	from := ctx.Query("from")
+	to := ctx.Query("to")
+	if from == "" || to == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "from and to revision IDs are required"})
+		return
+	}
+
+	diff, err := s.history.Diff(from, to)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"diff": diff})
+}
+
+// handleRestoreRevision re-applies the state recorded by a revision, tests it
+// and reloads nginx
+func (s *Server) handleRestoreRevision(ctx *gin.Context) {
+	rev, err := s.history.Get(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.restoreRevision(ctx, rev); err != nil {
+		respondApplyError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := s.nginx.Reload(ctx.Request.Context()); err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{
+			"message": "revision restored but nginx reload failed",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message":  "Revision restored",
+		"revision": rev.ID,
+	})
+}
+
+// restoreRevision dispatches a restore to the manager owning the entity
+func (s *Server) restoreRevision(ctx *gin.Context, rev *nginx.Revision) error {
+	switch rev.Entity {
+	case nginx.EntityProxyHost:
+		var host *nginx.ProxyHost
+		if err := json.Unmarshal(rev.After, &host); err != nil {
+			return fmt.Errorf("invalid revision state: %w", err)
+		}
+		return s.proxyHosts.Restore(requestContext(ctx), rev.EntityID, host)
+
+	case nginx.EntityRedirectHost:
+		var redirect *nginx.RedirectHost
+		if err := json.Unmarshal(rev.After, &redirect); err != nil {
+			return fmt.Errorf("invalid revision state: %w", err)
+		}
+		return s.redirectHosts.Restore(requestContext(ctx), rev.EntityID, redirect)
+
+	case nginx.EntityStaticHost:
+		var site *nginx.StaticHost
+		if err := json.Unmarshal(rev.After, &site); err != nil {
+			return fmt.Errorf("invalid revision state: %w", err)
+		}
+		return s.staticHosts.Restore(requestContext(ctx), rev.EntityID, site)
+
+	case nginx.EntityStreamHost:
+		var stream *nginx.StreamHost
+		if err := json.Unmarshal(rev.After, &stream); err != nil {
+			return fmt.Errorf("invalid revision state: %w", err)
+		}
+		return s.streamHosts.Restore(requestContext(ctx), rev.EntityID, stream)
+
+	case nginx.EntityAccessList:
+		var list *nginx.AccessList
+		if err := json.Unmarshal(rev.After, &list); err != nil {
+			return fmt.Errorf("invalid revision state: %w", err)
+		}
+		if list == nil && len(s.proxyHosts.HostsUsingAccessList(rev.EntityID)) > 0 {
+			return fmt.Errorf("access list %s is still attached to hosts", rev.EntityID)
+		}
+		return s.accessLists.Restore(requestContext(ctx), rev.EntityID, list)
+
+	case nginx.EntityErrorPage:
+		var page *nginx.ErrorPage
+		if err := json.Unmarshal(rev.After, &page); err != nil {
+			return fmt.Errorf("invalid revision state: %w", err)
+		}
+		if page == nil && len(s.proxyHosts.HostsUsingErrorPage(rev.EntityID)) > 0 {
+			return fmt.Errorf("error page %s is still used by hosts", rev.EntityID)
+		}
+		return s.errorPages.Restore(requestContext(ctx), rev.EntityID, page)
+
+	case nginx.EntityCORSPolicy:
+		var policy *nginx.CORSPolicy
+		if err := json.Unmarshal(rev.After, &policy); err != nil {
+			return fmt.Errorf("invalid revision state: %w", err)
+		}
+		if policy == nil && len(s.proxyHosts.HostsUsingCORSPolicy(rev.EntityID)) > 0 {
+			return fmt.Errorf("CORS policy %s is still used by hosts", rev.EntityID)
+		}
+		return s.corsPolicies.Restore(requestContext(ctx), rev.EntityID, policy)
+
+	case nginx.EntityDefaultRoute:
+		var config *nginx.DefaultRouteConfig
+		if err := json.Unmarshal(rev.After, &config); err != nil {
+			return fmt.Errorf("invalid revision state: %w", err)
+		}
+		if config == nil || !config.Enabled {
+			return s.defaultRoute.Disable(requestContext(ctx))
+		}
+		return s.defaultRoute.Apply(requestContext(ctx), config)
+
+	default:
+		return fmt.Errorf("cannot restore %s revisions", rev.Entity)
+	}
+}
@@ -2,6 +2,7 @@ package api
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -32,24 +33,192 @@ func (s *Server) handleGetHost(ctx *gin.Context) {
 
 // BackendRequest represents a backend server in the request
 type BackendRequest struct {
-	Address string `json:"address"`
-	Weight  int    `json:"weight"`
-	Backup  bool   `json:"backup"`
+	Address     string `json:"address"`
+	Weight      int    `json:"weight"`
+	Backup      bool   `json:"backup"`
+	MaxFails    *int   `json:"maxFails"`
+	FailTimeout int    `json:"failTimeout"`
+	MaxConns    int    `json:"maxConns"`
+	SlowStart   int    `json:"slowStart"`
 }
 
 // CreateHostRequest represents the request body for creating a host
 type CreateHostRequest struct {
-	Domain      string           `json:"domain" binding:"required"`
-	Target      string           `json:"target"`
-	Backends    []BackendRequest `json:"backends"`
-	LBMethod    string           `json:"lbMethod"`
-	SSL         bool             `json:"ssl"`
-	ForceSSL    bool             `json:"forceSSL"`
-	Enabled     bool             `json:"enabled"`
-	Maintenance bool             `json:"maintenance"`
-	WebSocket   bool             `json:"websocket"`
-	CustomNginx string           `json:"customNginx"`
-	Tags        []string         `json:"tags"`
+	Domain          string                     `json:"domain" binding:"required"`
+	Aliases         []string                   `json:"aliases"`
+	RedirectAliases bool                       `json:"redirectAliases"`
+	Target          string                     `json:"target"`
+	Backends        []BackendRequest           `json:"backends"`
+	LBMethod        string                     `json:"lbMethod"`
+	LBOptions       *nginx.LBOptions           `json:"lbOptions"`
+	HeaderRules     []nginx.HeaderRule         `json:"headerRules"`
+	SecurityHeaders *nginx.SecurityHeaders     `json:"securityHeaders"`
+	Cache           *nginx.CachePolicy         `json:"cache"`
+	Compression     *nginx.CompressionSettings `json:"compression"`
+	ErrorPages      []nginx.HostErrorPage      `json:"errorPages"`
+	InterceptErrors bool                       `json:"interceptErrors"`
+	Mirror          *nginx.Mirror              `json:"mirror"`
+	SSL             bool                       `json:"ssl"`
+	ForceSSL        bool                       `json:"forceSSL"`
+	Enabled         bool                       `json:"enabled"`
+	Maintenance     bool                       `json:"maintenance"`
+	WebSocket       bool                       `json:"websocket"`
+	CustomNginx     string                     `json:"customNginx"`
+	Tags            []string                   `json:"tags"`
+	CertificateID   string                     `json:"certificateId"`
+	TLS             *nginx.TLSPolicy           `json:"tls"`
+	ClientAuth      *nginx.ClientAuth          `json:"clientAuth"`
+	Locations       []nginx.Location           `json:"locations"`
+	AccessListID    string                     `json:"accessListId"`
+	CORSPolicyID    string                     `json:"corsPolicyId"`
+	RateLimit       *nginx.RateLimit           `json:"rateLimit"`
+	HealthCheck     *nginx.HealthCheck         `json:"healthCheck"`
+	Upstream        *nginx.UpstreamSettings    `json:"upstream"`
+}
+
+// toProxyHost converts the request into a ProxyHost
+func (req *CreateHostRequest) toProxyHost() *nginx.ProxyHost {
+	// Convert backends
+	var backends []nginx.Backend
+	for _, b := range req.Backends {
+		backends = append(backends, nginx.Backend{
+			Address:     b.Address,
+			Weight:      b.Weight,
+			Backup:      b.Backup,
+			MaxFails:    b.MaxFails,
+			FailTimeout: b.FailTimeout,
+			MaxConns:    b.MaxConns,
+			SlowStart:   b.SlowStart,
+		})
+	}
+
+	return &nginx.ProxyHost{
+		Domain:          req.Domain,
+		Aliases:         req.Aliases,
+		RedirectAliases: req.RedirectAliases,
+		Target:          req.Target,
+		Backends:        backends,
+		LBMethod:        req.LBMethod,
+		LBOptions:       req.LBOptions,
+		HeaderRules:     req.HeaderRules,
+		SecurityHeaders: req.SecurityHeaders,
+		Cache:           req.Cache,
+		Compression:     req.Compression,
+		ErrorPages:      req.ErrorPages,
+		InterceptErrors: req.InterceptErrors,
+		Mirror:          req.Mirror,
+		SSL:             req.SSL,
+		ForceSSL:        req.ForceSSL,
+		Enabled:         req.Enabled,
+		Maintenance:     req.Maintenance,
+		WebSocket:       req.WebSocket,
+		CustomNginx:     req.CustomNginx,
+		Tags:            req.Tags,
+		CertificateID:   req.CertificateID,
+		TLS:             req.TLS,
+		ClientAuth:      req.ClientAuth,
+		Locations:       req.Locations,
+		AccessListID:    req.AccessListID,
+		CORSPolicyID:    req.CORSPolicyID,
+		RateLimit:       req.RateLimit,
+		HealthCheck:     req.HealthCheck,
+		Upstream:        req.Upstream,
+	}
+}
+
+// resolveCertificate fills in the certificate file paths for the host's
+// certificate ID, clearing them when no certificate is bound
+func (s *Server) resolveCertificate(ctx context.Context, host *nginx.ProxyHost) error {
+	if host.CertificateID == "" {
+		host.CertPath = ""
+		host.KeyPath = ""
+		host.ChainPath = ""
+		return nil
+	}
+
+	cert, err := s.certManager.GetCertificate(ctx, host.CertificateID)
+	if err != nil {
+		return err
+	}
+
+	host.CertPath = cert.CertPath
+	host.KeyPath = cert.KeyPath
+	host.ChainPath = cert.ChainPath
+	return nil
+}
+
+// resolveClientCA fills in the CA bundle and CRL paths for the host's client
+// certificate verification. The paths are cleared first, so a host whose
+// bundle cannot be resolved fails validation.
+func (s *Server) resolveClientCA(ctx context.Context, host *nginx.ProxyHost) error {
+	if host.ClientAuth == nil {
+		return nil
+	}
+	host.ClientAuth.CAPath = ""
+	host.ClientAuth.CRLPath = ""
+	if host.ClientAuth.CAID == "" {
+		return nil
+	}
+
+	ca, err := s.certManager.GetCertificate(ctx, host.ClientAuth.CAID)
+	if err != nil {
+		return err
+	}
+	if ca.Type != nginx.CertificateTypeCA {
+		return fmt.Errorf("certificate %s is not a CA bundle", ca.ID)
+	}
+
+	host.ClientAuth.CAPath = ca.CertPath
+	host.ClientAuth.CRLPath = ca.CRLPath
+	return nil
+}
+
+// resolveHostFiles fills in the certificate and client CA bundle paths of a
+// host from the certificate store
+func (s *Server) resolveHostFiles(ctx context.Context, host *nginx.ProxyHost) error {
+	if err := s.resolveCertificate(ctx, host); err != nil {
+		return err
+	}
+	return s.resolveClientCA(ctx, host)
+}
+
+// importedHost converts an exported host into one to create or update. The
+// ID, timestamps, resolved file paths and any rollout in progress are left
+// out.
+func importedHost(host nginx.ProxyHost) *nginx.ProxyHost {
+	return &nginx.ProxyHost{
+		Domain:             host.Domain,
+		Aliases:            host.Aliases,
+		RedirectAliases:    host.RedirectAliases,
+		Target:             host.Target,
+		Backends:           host.Backends,
+		LBMethod:           host.LBMethod,
+		LBOptions:          host.LBOptions,
+		SSL:                host.SSL,
+		ForceSSL:           host.ForceSSL,
+		CertificateID:      host.CertificateID,
+		TLS:                host.TLS,
+		ClientAuth:         host.ClientAuth,
+		Enabled:            host.Enabled,
+		Maintenance:        host.Maintenance,
+		MaintenanceMessage: host.MaintenanceMessage,
+		WebSocket:          host.WebSocket,
+		Locations:          host.Locations,
+		AccessListID:       host.AccessListID,
+		CORSPolicyID:       host.CORSPolicyID,
+		RateLimit:          host.RateLimit,
+		HealthCheck:        host.HealthCheck,
+		Upstream:           host.Upstream,
+		Mirror:             host.Mirror,
+		HeaderRules:        host.HeaderRules,
+		SecurityHeaders:    host.SecurityHeaders,
+		Cache:              host.Cache,
+		Compression:        host.Compression,
+		ErrorPages:         host.ErrorPages,
+		InterceptErrors:    host.InterceptErrors,
+		CustomNginx:        host.CustomNginx,
+		Tags:               host.Tags,
+	}
 }
 
 // handleCreateHost creates a new proxy host
@@ -60,38 +229,20 @@ func (s *Server) handleCreateHost(ctx *gin.Context) {
 		return
 	}
 
-	// Convert backends
-	var backends []nginx.Backend
-	for _, b := range req.Backends {
-		backends = append(backends, nginx.Backend{
-			Address: b.Address,
-			Weight:  b.Weight,
-			Backup:  b.Backup,
-		})
-	}
-
 	// Require either target or backends
-	if req.Target == "" && len(backends) == 0 {
+	if req.Target == "" && len(req.Backends) == 0 {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Either target or backends is required"})
 		return
 	}
 
-	host := &nginx.ProxyHost{
-		Domain:      req.Domain,
-		Target:      req.Target,
-		Backends:    backends,
-		LBMethod:    req.LBMethod,
-		SSL:         req.SSL,
-		ForceSSL:    req.ForceSSL,
-		Enabled:     req.Enabled,
-		Maintenance: req.Maintenance,
-		WebSocket:   req.WebSocket,
-		CustomNginx: req.CustomNginx,
-		Tags:        req.Tags,
+	host := req.toProxyHost()
+	if err := s.resolveHostFiles(ctx.Request.Context(), host); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
-	if err := s.proxyHosts.Create(context.Background(), host); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if err := s.proxyHosts.Create(requestContext(ctx), host); err != nil {
+		respondApplyError(ctx, http.StatusBadRequest, err)
 		return
 	}
 
@@ -122,32 +273,14 @@ func (s *Server) handleUpdateHost(ctx *gin.Context) {
 		return
 	}
 
-	// Convert backends
-	var backends []nginx.Backend
-	for _, b := range req.Backends {
-		backends = append(backends, nginx.Backend{
-			Address: b.Address,
-			Weight:  b.Weight,
-			Backup:  b.Backup,
-		})
-	}
-
-	updates := &nginx.ProxyHost{
-		Domain:      req.Domain,
-		Target:      req.Target,
-		Backends:    backends,
-		LBMethod:    req.LBMethod,
-		SSL:         req.SSL,
-		ForceSSL:    req.ForceSSL,
-		Enabled:     req.Enabled,
-		Maintenance: req.Maintenance,
-		WebSocket:   req.WebSocket,
-		CustomNginx: req.CustomNginx,
-		Tags:        req.Tags,
+	updates := req.toProxyHost()
+	if err := s.resolveHostFiles(ctx.Request.Context(), updates); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
-	if err := s.proxyHosts.Update(context.Background(), id, updates); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if err := s.proxyHosts.Update(requestContext(ctx), id, updates); err != nil {
+		respondApplyError(ctx, http.StatusBadRequest, err)
 		return
 	}
 
@@ -170,8 +303,8 @@ func (s *Server) handleUpdateHost(ctx *gin.Context) {
 func (s *Server) handleDeleteHost(ctx *gin.Context) {
 	id := ctx.Param("id")
 
-	if err := s.proxyHosts.Delete(context.Background(), id); err != nil {
-		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	if err := s.proxyHosts.Delete(requestContext(ctx), id); err != nil {
+		respondApplyError(ctx, http.StatusNotFound, err)
 		return
 	}
 
@@ -203,8 +336,8 @@ func (s *Server) handleToggleHost(ctx *gin.Context) {
 		return
 	}
 
-	if err := s.proxyHosts.Toggle(context.Background(), id, req.Enabled); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if err := s.proxyHosts.Toggle(requestContext(ctx), id, req.Enabled); err != nil {
+		respondApplyError(ctx, http.StatusBadRequest, err)
 		return
 	}
 
@@ -238,8 +371,8 @@ func (s *Server) handleToggleMaintenance(ctx *gin.Context) {
 		return
 	}
 
-	if err := s.proxyHosts.SetMaintenance(context.Background(), id, req.Maintenance); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if err := s.proxyHosts.SetMaintenance(requestContext(ctx), id, req.Maintenance); err != nil {
+		respondApplyError(ctx, http.StatusBadRequest, err)
 		return
 	}
 
@@ -258,6 +391,20 @@ func (s *Server) handleToggleMaintenance(ctx *gin.Context) {
 	})
 }
 
+// handlePreviewHostConfig returns the nginx config rendered for a host
+func (s *Server) handlePreviewHostConfig(ctx *gin.Context) {
+	config, httpConfig, err := s.proxyHosts.Preview(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"config":     string(config),
+		"httpConfig": string(httpConfig),
+	})
+}
+
 // handleExportHosts exports all hosts as JSON
 func (s *Server) handleExportHosts(ctx *gin.Context) {
 	hosts := s.proxyHosts.List()
@@ -267,16 +414,16 @@ func (s *Server) handleExportHosts(ctx *gin.Context) {
 	ctx.Header("Content-Type", "application/json")
 
 	ctx.JSON(http.StatusOK, gin.H{
-		"version":   "1.0",
+		"version":    "1.0",
 		"exportedAt": ctx.GetHeader("Date"),
-		"hosts":     hosts,
+		"hosts":      hosts,
 	})
 }
 
 // ImportHostsRequest represents the request body for importing hosts
 type ImportHostsRequest struct {
-	Hosts      []nginx.ProxyHost `json:"hosts"`
-	Overwrite  bool              `json:"overwrite"`
+	Hosts     []nginx.ProxyHost `json:"hosts"`
+	Overwrite bool              `json:"overwrite"`
 }
 
 // handleImportHosts imports hosts from JSON
@@ -296,59 +443,36 @@ func (s *Server) handleImportHosts(ctx *gin.Context) {
 	skipped := 0
 	errors := []string{}
 
-	for _, host := range req.Hosts {
+	for _, exported := range req.Hosts {
 		// Check if host with same domain exists
-		existingHosts := s.proxyHosts.List()
-		exists := false
-		for _, existing := range existingHosts {
-			if existing.Domain == host.Domain {
-				exists = true
-				if req.Overwrite {
-					// Update existing host
-					updates := &nginx.ProxyHost{
-						Domain:      host.Domain,
-						Target:      host.Target,
-						Backends:    host.Backends,
-						LBMethod:    host.LBMethod,
-						SSL:         host.SSL,
-						ForceSSL:    host.ForceSSL,
-						Enabled:     host.Enabled,
-						Maintenance: host.Maintenance,
-						WebSocket:   host.WebSocket,
-						CustomNginx: host.CustomNginx,
-					}
-					if err := s.proxyHosts.Update(context.Background(), existing.ID, updates); err != nil {
-						errors = append(errors, "Failed to update "+host.Domain+": "+err.Error())
-					} else {
-						imported++
-					}
-				} else {
-					skipped++
-				}
+		var existing *nginx.ProxyHost
+		for _, h := range s.proxyHosts.List() {
+			if h.Domain == exported.Domain {
+				existing = h
 				break
 			}
 		}
+		if existing != nil && !req.Overwrite {
+			skipped++
+			continue
+		}
 
-		if !exists {
-			// Create new host
-			newHost := &nginx.ProxyHost{
-				Domain:      host.Domain,
-				Target:      host.Target,
-				Backends:    host.Backends,
-				LBMethod:    host.LBMethod,
-				SSL:         host.SSL,
-				ForceSSL:    host.ForceSSL,
-				Enabled:     host.Enabled,
-				Maintenance: host.Maintenance,
-				WebSocket:   host.WebSocket,
-				CustomNginx: host.CustomNginx,
-			}
-			if err := s.proxyHosts.Create(context.Background(), newHost); err != nil {
-				errors = append(errors, "Failed to create "+host.Domain+": "+err.Error())
-			} else {
-				imported++
+		host := importedHost(exported)
+		if err := s.resolveHostFiles(ctx.Request.Context(), host); err != nil {
+			errors = append(errors, "Failed to import "+host.Domain+": "+err.Error())
+			continue
+		}
+
+		if existing != nil {
+			if err := s.proxyHosts.Update(requestContext(ctx), existing.ID, host); err != nil {
+				errors = append(errors, "Failed to update "+host.Domain+": "+err.Error())
+				continue
 			}
+		} else if err := s.proxyHosts.Create(requestContext(ctx), host); err != nil {
+			errors = append(errors, "Failed to create "+host.Domain+": "+err.Error())
+			continue
 		}
+		imported++
 	}
 
 	// Reload nginx
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shsm0520/nubi/internal/nginx"
+)
+
+// handleListLocations returns the path-based locations of a host
+func (s *Server) handleListLocations(ctx *gin.Context) {
+	host, err := s.proxyHosts.Get(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	locations := host.Locations
+	if locations == nil {
+		locations = []nginx.Location{}
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"locations": locations,
+		"count":     len(locations),
+	})
+}
+
+// handleCreateLocation adds a location to a host
+func (s *Server) handleCreateLocation(ctx *gin.Context) {
+	var req nginx.Location
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	loc, err := s.proxyHosts.AddLocation(requestContext(ctx), ctx.Param("id"), req)
+	if err != nil {
+		respondApplyError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	if !s.reloadAfterChange(ctx, "Location created") {
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{
+		"location": loc,
+		"message":  "Location created successfully",
+	})
+}
+
+// handleUpdateLocation replaces a location of a host
+func (s *Server) handleUpdateLocation(ctx *gin.Context) {
+	var req nginx.Location
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	loc, err := s.proxyHosts.UpdateLocation(requestContext(ctx), ctx.Param("id"), ctx.Param("locationId"), req)
+	if err != nil {
+		respondApplyError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	if !s.reloadAfterChange(ctx, "Location updated") {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"location": loc,
+		"message":  "Location updated successfully",
+	})
+}
+
+// handleDeleteLocation removes a location from a host
+func (s *Server) handleDeleteLocation(ctx *gin.Context) {
+	if err := s.proxyHosts.DeleteLocation(requestContext(ctx), ctx.Param("id"), ctx.Param("locationId")); err != nil {
+		respondApplyError(ctx, http.StatusNotFound, err)
+		return
+	}
+
+	if !s.reloadAfterChange(ctx, "Location deleted") {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Location deleted successfully"})
+}
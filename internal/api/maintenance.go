@@ -6,6 +6,7 @@ import (
 	"sync"
 
 	"github.com/gin-gonic/gin"
+	"github.com/shsm0520/nubi/internal/nginx"
 )
 
 var (
@@ -38,65 +39,11 @@ func (s *Server) handleSetMaintenance(ctx *gin.Context) {
 	}
 
 	maintenanceMu.Lock()
-	s.maintenanceMode = req.Enabled
-	s.maintenanceMessage = req.Message
+	err := s.setMaintenance(requestContext(ctx), req.Enabled, req.Message)
 	maintenanceMu.Unlock()
-
-	// Apply maintenance mode via default route
-	if req.Enabled {
-		// Create a maintenance page
-		maintenanceHTML := `<!DOCTYPE html>
-<html>
-<head>
-  <title>Maintenance</title>
-  <meta charset="utf-8">
-  <style>
-    body { font-family: system-ui, sans-serif; background: #0f172a; color: #e2e8f0; display: flex; align-items: center; justify-content: center; min-height: 100vh; margin: 0; }
-    .container { text-align: center; padding: 2rem; }
-    h1 { font-size: 3rem; margin: 0; color: #f59e0b; }
-    p { font-size: 1.25rem; color: #94a3b8; margin-top: 1rem; }
-    .icon { font-size: 4rem; margin-bottom: 1rem; }
-  </style>
-</head>
-<body>
-  <div class="container">
-    <div class="icon">🔧</div>
-    <h1>Under Maintenance</h1>
-    <p>` + escapeHTML(req.Message) + `</p>
-    <p style="font-size: 0.875rem; margin-top: 2rem;">We'll be back shortly.</p>
-  </div>
-</body>
-</html>`
-		if req.Message == "" {
-			maintenanceHTML = `<!DOCTYPE html>
-<html>
-<head>
-  <title>Maintenance</title>
-  <meta charset="utf-8">
-  <style>
-    body { font-family: system-ui, sans-serif; background: #0f172a; color: #e2e8f0; display: flex; align-items: center; justify-content: center; min-height: 100vh; margin: 0; }
-    .container { text-align: center; padding: 2rem; }
-    h1 { font-size: 3rem; margin: 0; color: #f59e0b; }
-    p { font-size: 1.25rem; color: #94a3b8; margin-top: 1rem; }
-    .icon { font-size: 4rem; margin-bottom: 1rem; }
-  </style>
-</head>
-<body>
-  <div class="container">
-    <div class="icon">🔧</div>
-    <h1>Under Maintenance</h1>
-    <p>The server is currently undergoing maintenance.</p>
-    <p style="font-size: 0.875rem; margin-top: 2rem;">We'll be back shortly.</p>
-  </div>
-</body>
-</html>`
-		}
-
-		// Save previous config and apply maintenance
-		s.defaultRoute.ApplyMaintenance(context.Background(), maintenanceHTML)
-	} else {
-		// Restore previous config
-		s.defaultRoute.DisableMaintenance(context.Background())
+	if err != nil {
+		respondApplyError(ctx, http.StatusInternalServerError, err)
+		return
 	}
 
 	// Reload nginx
@@ -123,23 +70,38 @@ func (s *Server) handleSetMaintenance(ctx *gin.Context) {
 	})
 }
 
-func escapeHTML(s string) string {
-	result := ""
-	for _, c := range s {
-		switch c {
-		case '<':
-			result += "&lt;"
-		case '>':
-			result += "&gt;"
-		case '&':
-			result += "&amp;"
-		case '"':
-			result += "&quot;"
-		case '\'':
-			result += "&#39;"
-		default:
-			result += string(c)
+// setMaintenance applies or removes the default route maintenance page and
+// records the state. nginx is not reloaded. Callers must hold maintenanceMu.
+func (s *Server) setMaintenance(ctx context.Context, enabled bool, message string) error {
+	if enabled {
+		// Save previous config and apply maintenance
+		if err := s.defaultRoute.ApplyMaintenance(ctx, nginx.MaintenancePage(message)); err != nil {
+			return err
+		}
+	} else {
+		// Restore previous config
+		if err := s.defaultRoute.DisableMaintenance(ctx); err != nil {
+			return err
 		}
 	}
-	return result
+
+	s.maintenanceMode = enabled
+	s.maintenanceMessage = message
+	return nil
+}
+
+// scheduledMaintenance switches global maintenance for a maintenance window.
+// A maintenance mode already enabled by hand is left as it is, and so is one
+// already disabled.
+func (s *Server) scheduledMaintenance(ctx context.Context, enabled bool, message string) (bool, error) {
+	maintenanceMu.Lock()
+	defer maintenanceMu.Unlock()
+
+	if s.maintenanceMode == enabled {
+		return false, nil
+	}
+	if err := s.setMaintenance(ctx, enabled, message); err != nil {
+		return false, err
+	}
+	return true, nil
 }
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shsm0520/nubi/internal/nginx"
+)
+
+// MaintenanceWindowStatus is a maintenance window with its current or next
+// occurrence
+type MaintenanceWindowStatus struct {
+	*nginx.MaintenanceWindow
+	Active    bool       `json:"active"`
+	NextStart *time.Time `json:"nextStart,omitempty"` // Start of the occurrence in progress or next, if any
+	NextEnd   *time.Time `json:"nextEnd,omitempty"`
+}
+
+// StartMaintenanceScheduler starts applying maintenance windows until ctx is
+// cancelled
+func (s *Server) StartMaintenanceScheduler(ctx context.Context) {
+	s.maintenanceWindows.Start(nginx.WithActor(ctx, "maintenance-scheduler"))
+}
+
+// windowStatus returns a window with its schedule state
+func (s *Server) windowStatus(w *nginx.MaintenanceWindow) MaintenanceWindowStatus {
+	status := MaintenanceWindowStatus{
+		MaintenanceWindow: w,
+		Active:            s.maintenanceWindows.Active(w.ID),
+	}
+	if start, end, ok := w.Occurrence(time.Now()); ok {
+		status.NextStart = &start
+		status.NextEnd = &end
+	}
+	return status
+}
+
+// handleListMaintenanceWindows returns all maintenance windows
+func (s *Server) handleListMaintenanceWindows(ctx *gin.Context) {
+	windows := s.maintenanceWindows.List()
+	statuses := make([]MaintenanceWindowStatus, 0, len(windows))
+	for _, w := range windows {
+		statuses = append(statuses, s.windowStatus(w))
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"windows": statuses,
+		"count":   len(statuses),
+	})
+}
+
+// handleGetMaintenanceWindow returns a maintenance window
+func (s *Server) handleGetMaintenanceWindow(ctx *gin.Context) {
+	w, err := s.maintenanceWindows.Get(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"window": s.windowStatus(w)})
+}
+
+// handleCreateMaintenanceWindow schedules a maintenance window
+func (s *Server) handleCreateMaintenanceWindow(ctx *gin.Context) {
+	var req nginx.MaintenanceWindow
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.maintenanceWindows.Create(requestContext(ctx), &req); err != nil {
+		respondApplyError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{
+		"window":  s.windowStatus(&req),
+		"message": "Maintenance window created successfully",
+	})
+}
+
+// handleUpdateMaintenanceWindow changes a maintenance window
+func (s *Server) handleUpdateMaintenanceWindow(ctx *gin.Context) {
+	id := ctx.Param("id")
+
+	var req nginx.MaintenanceWindow
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.maintenanceWindows.Update(requestContext(ctx), id, &req); err != nil {
+		respondApplyError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	w, _ := s.maintenanceWindows.Get(id)
+	ctx.JSON(http.StatusOK, gin.H{
+		"window":  s.windowStatus(w),
+		"message": "Maintenance window updated successfully",
+	})
+}
+
+// handleDeleteMaintenanceWindow removes a maintenance window. Hosts it put
+// into maintenance are taken out of it.
+func (s *Server) handleDeleteMaintenanceWindow(ctx *gin.Context) {
+	if err := s.maintenanceWindows.Delete(requestContext(ctx), ctx.Param("id")); err != nil {
+		respondApplyError(ctx, http.StatusNotFound, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Maintenance window deleted successfully"})
+}
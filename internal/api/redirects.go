@@ -0,0 +1,176 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shsm0520/nubi/internal/nginx"
+)
+
+// resolveRedirectCertificate fills in the certificate file paths for the
+// redirect's certificate ID, clearing them when no certificate is bound
+func (s *Server) resolveRedirectCertificate(ctx context.Context, host *nginx.RedirectHost) error {
+	host.CertPath = ""
+	host.KeyPath = ""
+	host.ChainPath = ""
+	if host.CertificateID == "" {
+		return nil
+	}
+
+	cert, err := s.certManager.GetCertificate(ctx, host.CertificateID)
+	if err != nil {
+		return err
+	}
+
+	host.CertPath = cert.CertPath
+	host.KeyPath = cert.KeyPath
+	host.ChainPath = cert.ChainPath
+	return nil
+}
+
+// handleListRedirects returns all redirect hosts
+func (s *Server) handleListRedirects(ctx *gin.Context) {
+	redirects := s.redirectHosts.List()
+	ctx.JSON(http.StatusOK, gin.H{
+		"redirects": redirects,
+		"count":     len(redirects),
+	})
+}
+
+// handleGetRedirect returns a single redirect host
+func (s *Server) handleGetRedirect(ctx *gin.Context) {
+	redirect, err := s.redirectHosts.Get(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"redirect": redirect})
+}
+
+// handleCreateRedirect creates a new redirect host
+func (s *Server) handleCreateRedirect(ctx *gin.Context) {
+	var req nginx.RedirectHost
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.resolveRedirectCertificate(ctx.Request.Context(), &req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.redirectHosts.Create(requestContext(ctx), &req); err != nil {
+		respondApplyError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	if req.Enabled && !s.reloadAfterChange(ctx, "Redirect created") {
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{
+		"redirect": req,
+		"message":  "Redirect created successfully",
+	})
+}
+
+// handleUpdateRedirect updates an existing redirect host
+func (s *Server) handleUpdateRedirect(ctx *gin.Context) {
+	id := ctx.Param("id")
+
+	var req nginx.RedirectHost
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.resolveRedirectCertificate(ctx.Request.Context(), &req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.redirectHosts.Update(requestContext(ctx), id, &req); err != nil {
+		respondApplyError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	if !s.reloadAfterChange(ctx, "Redirect updated") {
+		return
+	}
+
+	redirect, _ := s.redirectHosts.Get(id)
+	ctx.JSON(http.StatusOK, gin.H{
+		"redirect": redirect,
+		"message":  "Redirect updated successfully",
+	})
+}
+
+// handleDeleteRedirect deletes a redirect host
+func (s *Server) handleDeleteRedirect(ctx *gin.Context) {
+	if err := s.redirectHosts.Delete(requestContext(ctx), ctx.Param("id")); err != nil {
+		respondApplyError(ctx, http.StatusNotFound, err)
+		return
+	}
+
+	if !s.reloadAfterChange(ctx, "Redirect deleted") {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Redirect deleted successfully"})
+}
+
+// handleToggleRedirect enables or disables a redirect host
+func (s *Server) handleToggleRedirect(ctx *gin.Context) {
+	id := ctx.Param("id")
+
+	var req ToggleHostRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.redirectHosts.Toggle(requestContext(ctx), id, req.Enabled); err != nil {
+		respondApplyError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	if !s.reloadAfterChange(ctx, "Redirect toggled") {
+		return
+	}
+
+	redirect, _ := s.redirectHosts.Get(id)
+	ctx.JSON(http.StatusOK, gin.H{
+		"redirect": redirect,
+		"message":  "Redirect " + map[bool]string{true: "enabled", false: "disabled"}[req.Enabled],
+	})
+}
+
+// handleToggleRedirectMaintenance enables or disables maintenance mode for a
+// redirect host
+func (s *Server) handleToggleRedirectMaintenance(ctx *gin.Context) {
+	id := ctx.Param("id")
+
+	var req MaintenanceHostRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.redirectHosts.SetMaintenance(requestContext(ctx), id, req.Maintenance); err != nil {
+		respondApplyError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	if !s.reloadAfterChange(ctx, "Maintenance toggled") {
+		return
+	}
+
+	redirect, _ := s.redirectHosts.Get(id)
+	ctx.JSON(http.StatusOK, gin.H{
+		"redirect": redirect,
+		"message":  "Maintenance mode " + map[bool]string{true: "enabled", false: "disabled"}[req.Maintenance],
+	})
+}
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shsm0520/nubi/internal/nginx"
+)
+
+// handleGetRollout returns the rollout in progress on a host, if any
+func (s *Server) handleGetRollout(ctx *gin.Context) {
+	host, err := s.proxyHosts.Get(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"hostId":  host.ID,
+		"active":  host.Rollout != nil,
+		"rollout": host.Rollout,
+	})
+}
+
+// handleSetRollout starts a rollout or changes the one in progress, e.g. to
+// raise the candidate's share of traffic
+func (s *Server) handleSetRollout(ctx *gin.Context) {
+	id := ctx.Param("id")
+
+	var req nginx.Rollout
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.proxyHosts.SetRollout(requestContext(ctx), id, &req); err != nil {
+		respondApplyError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	if !s.reloadAfterChange(ctx, "Rollout updated") {
+		return
+	}
+
+	host, _ := s.proxyHosts.Get(id)
+	ctx.JSON(http.StatusOK, gin.H{
+		"host":    host,
+		"message": "Rollout updated successfully",
+	})
+}
+
+// handlePromoteRollout makes the candidate the host's backends
+func (s *Server) handlePromoteRollout(ctx *gin.Context) {
+	id := ctx.Param("id")
+
+	if err := s.proxyHosts.PromoteRollout(requestContext(ctx), id); err != nil {
+		respondApplyError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	if !s.reloadAfterChange(ctx, "Rollout promoted") {
+		return
+	}
+
+	host, _ := s.proxyHosts.Get(id)
+	ctx.JSON(http.StatusOK, gin.H{
+		"host":    host,
+		"message": "Rollout promoted successfully",
+	})
+}
+
+// handleAbortRollout sends all traffic back to the stable backends
+func (s *Server) handleAbortRollout(ctx *gin.Context) {
+	id := ctx.Param("id")
+
+	if err := s.proxyHosts.AbortRollout(requestContext(ctx), id); err != nil {
+		respondApplyError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	if !s.reloadAfterChange(ctx, "Rollout aborted") {
+		return
+	}
+
+	host, _ := s.proxyHosts.Get(id)
+	ctx.JSON(http.StatusOK, gin.H{
+		"host":    host,
+		"message": "Rollout aborted successfully",
+	})
+}
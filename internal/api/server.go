@@ -1,6 +1,9 @@
 package api
 
 import (
+	"context"
+	"errors"
+	"log"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -17,7 +20,17 @@ type Server struct {
 	nginx              *nginx.Controller
 	defaultRoute       *nginx.DefaultRouteManager
 	proxyHosts         *nginx.ProxyHostManager
+	redirectHosts      *nginx.RedirectHostManager
+	streamHosts        *nginx.StreamHostManager
+	staticHosts        *nginx.StaticHostManager
+	accessLists        *nginx.AccessListManager
+	errorPages         *nginx.ErrorPageManager
+	corsPolicies       *nginx.CORSPolicyManager
+	health             *nginx.HealthChecker
+	maintenanceWindows *nginx.MaintenanceScheduler
 	certManager        *nginx.CertificateManager
+	certBinder         *nginx.CertificateBinder
+	history            *nginx.HistoryStore
 	hub                *Hub
 	maintenanceMode    bool
 	maintenanceMessage string
@@ -31,22 +44,78 @@ func NewServer(ctrl *nginx.Controller, staticDir string) *Server {
 	router := gin.New()
 	router.Use(gin.Logger(), gin.Recovery())
 
-	defaultRoute, _ := nginx.NewDefaultRouteManager("")
-	proxyHosts, _ := nginx.NewProxyHostManager("", "", "")
+	defaultRoute, _ := nginx.NewDefaultRouteManager(ctrl, "")
+	proxyHosts, _ := nginx.NewProxyHostManager(ctrl, "", "", "", "")
+	redirectHosts, _ := nginx.NewRedirectHostManager(ctrl, "", "", "")
+	streamHosts, _ := nginx.NewStreamHostManager(ctrl, "", "")
+	staticHosts, _ := nginx.NewStaticHostManager(ctrl, "", "", "", "")
+	accessLists, _ := nginx.NewAccessListManager(ctrl, "", "")
+	errorPages, _ := nginx.NewErrorPageManager(ctrl, "")
+	corsPolicies, _ := nginx.NewCORSPolicyManager(ctrl, "")
 	certManager, _ := nginx.NewCertificateManager("/var/lib/nubi")
+	history, err := nginx.NewHistoryStore("")
+	if err != nil {
+		log.Printf("warning: %v", err)
+		history, _ = nginx.NewHistoryStore(os.DevNull)
+	}
+	defaultRoute.SetHistory(history)
+	proxyHosts.SetHistory(history)
+	redirectHosts.SetHistory(history)
+	streamHosts.SetHistory(history)
+	staticHosts.SetHistory(history)
+	accessLists.SetHistory(history)
+	errorPages.SetHistory(history)
+	corsPolicies.SetHistory(history)
+	proxyHosts.SetAccessLists(accessLists)
+	accessLists.Track(proxyHosts)
+	proxyHosts.SetErrorPages(errorPages)
+	errorPages.Track(proxyHosts)
+	proxyHosts.SetCORSPolicies(corsPolicies)
+	corsPolicies.Track(proxyHosts)
+	nginx.ShareServerNames(proxyHosts, redirectHosts, staticHosts)
+	streamHosts.SetHTTPListeners(defaultRoute, proxyHosts, redirectHosts, staticHosts)
+	certBinder := nginx.NewCertificateBinder(certManager, proxyHosts, ctrl)
+	certBinder.SetRedirects(redirectHosts)
+	certBinder.Track(streamHosts)
+	certBinder.Track(staticHosts)
+	health := nginx.NewHealthChecker(proxyHosts, ctrl)
 	hub := NewHub()
 	go hub.Run()
+	health.OnEvent(func(ev nginx.HealthEvent) {
+		hub.Broadcast(StatusMessage{Type: "backend_health", Payload: ev})
+	})
 
 	srv := &Server{
-		router:       router,
-		nginx:        ctrl,
-		defaultRoute: defaultRoute,
-		proxyHosts:   proxyHosts,
-		certManager:  certManager,
-		hub:          hub,
-		startTime:    time.Now(),
+		router:        router,
+		nginx:         ctrl,
+		defaultRoute:  defaultRoute,
+		proxyHosts:    proxyHosts,
+		redirectHosts: redirectHosts,
+		streamHosts:   streamHosts,
+		staticHosts:   staticHosts,
+		accessLists:   accessLists,
+		errorPages:    errorPages,
+		corsPolicies:  corsPolicies,
+		health:        health,
+		certManager:   certManager,
+		certBinder:    certBinder,
+		history:       history,
+		hub:           hub,
+		startTime:     time.Now(),
 	}
 
+	maintenanceWindows, _ := nginx.NewMaintenanceScheduler(proxyHosts, ctrl, srv.scheduledMaintenance, "")
+	maintenanceWindows.OnEvent(func(ev nginx.MaintenanceEvent) {
+		hub.Broadcast(StatusMessage{Type: "maintenance_mode", Payload: ev})
+	})
+	// A global window in progress across a restart keeps the default route
+	// in maintenance
+	if message, ok := maintenanceWindows.ActiveGlobal(); ok {
+		srv.maintenanceMode = true
+		srv.maintenanceMessage = message
+	}
+	srv.maintenanceWindows = maintenanceWindows
+
 	// WebSocket endpoint
 	router.GET("/ws", srv.HandleWebSocket)
 
@@ -74,11 +143,90 @@ func NewServer(ctrl *nginx.Controller, staticDir string) *Server {
 		hostsAPI.POST("", srv.handleCreateHost)
 		hostsAPI.GET("/export", srv.handleExportHosts)
 		hostsAPI.POST("/import", srv.handleImportHosts)
+		hostsAPI.POST("/validate-snippet", srv.handleValidateSnippet)
 		hostsAPI.GET("/:id", srv.handleGetHost)
 		hostsAPI.PUT("/:id", srv.handleUpdateHost)
 		hostsAPI.DELETE("/:id", srv.handleDeleteHost)
+		hostsAPI.GET("/:id/config", srv.handlePreviewHostConfig)
 		hostsAPI.POST("/:id/toggle", srv.handleToggleHost)
 		hostsAPI.POST("/:id/maintenance", srv.handleToggleMaintenance)
+		hostsAPI.GET("/:id/health", srv.handleGetHostHealth)
+		hostsAPI.POST("/:id/cache/purge", srv.handlePurgeHostCache)
+		hostsAPI.GET("/:id/rollout", srv.handleGetRollout)
+		hostsAPI.PUT("/:id/rollout", srv.handleSetRollout)
+		hostsAPI.POST("/:id/rollout/promote", srv.handlePromoteRollout)
+		hostsAPI.POST("/:id/rollout/abort", srv.handleAbortRollout)
+		hostsAPI.GET("/:id/locations", srv.handleListLocations)
+		hostsAPI.POST("/:id/locations", srv.handleCreateLocation)
+		hostsAPI.PUT("/:id/locations/:locationId", srv.handleUpdateLocation)
+		hostsAPI.DELETE("/:id/locations/:locationId", srv.handleDeleteLocation)
+	}
+
+	// Redirect hosts API
+	redirectsAPI := router.Group("/api/redirects")
+	{
+		redirectsAPI.GET("", srv.handleListRedirects)
+		redirectsAPI.POST("", srv.handleCreateRedirect)
+		redirectsAPI.GET("/:id", srv.handleGetRedirect)
+		redirectsAPI.PUT("/:id", srv.handleUpdateRedirect)
+		redirectsAPI.DELETE("/:id", srv.handleDeleteRedirect)
+		redirectsAPI.POST("/:id/toggle", srv.handleToggleRedirect)
+		redirectsAPI.POST("/:id/maintenance", srv.handleToggleRedirectMaintenance)
+	}
+
+	// Access lists API
+	accessAPI := router.Group("/api/access-lists")
+	{
+		accessAPI.GET("", srv.handleListAccessLists)
+		accessAPI.POST("", srv.handleCreateAccessList)
+		accessAPI.GET("/:id", srv.handleGetAccessList)
+		accessAPI.PUT("/:id", srv.handleUpdateAccessList)
+		accessAPI.DELETE("/:id", srv.handleDeleteAccessList)
+	}
+
+	// Error page library API
+	errorPagesAPI := router.Group("/api/error-pages")
+	{
+		errorPagesAPI.GET("", srv.handleListErrorPages)
+		errorPagesAPI.POST("", srv.handleCreateErrorPage)
+		errorPagesAPI.GET("/:id", srv.handleGetErrorPage)
+		errorPagesAPI.GET("/:id/preview", srv.handlePreviewErrorPage)
+		errorPagesAPI.PUT("/:id", srv.handleUpdateErrorPage)
+		errorPagesAPI.DELETE("/:id", srv.handleDeleteErrorPage)
+	}
+
+	// CORS policy library API
+	corsAPI := router.Group("/api/cors-policies")
+	{
+		corsAPI.GET("", srv.handleListCORSPolicies)
+		corsAPI.POST("", srv.handleCreateCORSPolicy)
+		corsAPI.GET("/:id", srv.handleGetCORSPolicy)
+		corsAPI.PUT("/:id", srv.handleUpdateCORSPolicy)
+		corsAPI.DELETE("/:id", srv.handleDeleteCORSPolicy)
+	}
+
+	// Static sites API
+	sitesAPI := router.Group("/api/sites")
+	{
+		sitesAPI.GET("", srv.handleListSites)
+		sitesAPI.POST("", srv.handleCreateSite)
+		sitesAPI.GET("/:id", srv.handleGetSite)
+		sitesAPI.PUT("/:id", srv.handleUpdateSite)
+		sitesAPI.DELETE("/:id", srv.handleDeleteSite)
+		sitesAPI.POST("/:id/toggle", srv.handleToggleSite)
+		sitesAPI.POST("/:id/upload", srv.handleUploadSite)
+		sitesAPI.POST("/:id/rollback", srv.handleRollbackSite)
+	}
+
+	// Streams API (TCP/UDP)
+	streamsAPI := router.Group("/api/streams")
+	{
+		streamsAPI.GET("", srv.handleListStreams)
+		streamsAPI.POST("", srv.handleCreateStream)
+		streamsAPI.GET("/:id", srv.handleGetStream)
+		streamsAPI.PUT("/:id", srv.handleUpdateStream)
+		streamsAPI.DELETE("/:id", srv.handleDeleteStream)
+		streamsAPI.POST("/:id/toggle", srv.handleToggleStream)
 	}
 
 	// Certificates API
@@ -89,7 +237,11 @@ func NewServer(ctrl *nginx.Controller, staticDir string) *Server {
 		certsAPI.GET("/:id", srv.handleGetCertificate)
 		certsAPI.PUT("/:id", srv.handleUpdateCertificate)
 		certsAPI.DELETE("/:id", srv.handleDeleteCertificate)
+		certsAPI.POST("/:id/replace", srv.handleReplaceCertificate)
+		certsAPI.POST("/:id/refresh", srv.handleRefreshCertificate)
 		certsAPI.POST("/bulk-apply", srv.handleBulkApplyCertificate)
+		certsAPI.POST("/ca", srv.handleUploadCABundle)
+		certsAPI.POST("/:id/crl", srv.handleReplaceCRL)
 	}
 
 	// Tags API
@@ -111,6 +263,15 @@ func NewServer(ctrl *nginx.Controller, staticDir string) *Server {
 		leAPI.GET("/dns-providers", srv.handleGetDNSProviders)
 	}
 
+	// Configuration history API
+	historyAPI := router.Group("/api/history")
+	{
+		historyAPI.GET("", srv.handleListHistory)
+		historyAPI.GET("/diff", srv.handleDiffHistory)
+		historyAPI.GET("/:id", srv.handleGetRevision)
+		historyAPI.POST("/:id/restore", srv.handleRestoreRevision)
+	}
+
 	// Logs & Analytics API
 	logsAPI := router.Group("/api/logs")
 	{
@@ -123,6 +284,16 @@ func NewServer(ctrl *nginx.Controller, staticDir string) *Server {
 	router.GET("/api/maintenance", srv.handleGetMaintenance)
 	router.POST("/api/maintenance", srv.handleSetMaintenance)
 
+	// Maintenance windows API
+	windowsAPI := router.Group("/api/maintenance-windows")
+	{
+		windowsAPI.GET("", srv.handleListMaintenanceWindows)
+		windowsAPI.POST("", srv.handleCreateMaintenanceWindow)
+		windowsAPI.GET("/:id", srv.handleGetMaintenanceWindow)
+		windowsAPI.PUT("/:id", srv.handleUpdateMaintenanceWindow)
+		windowsAPI.DELETE("/:id", srv.handleDeleteMaintenanceWindow)
+	}
+
 	if staticDir != "" {
 		indexPath := filepath.Join(staticDir, "index.html")
 		router.StaticFile("/favicon.ico", filepath.Join(staticDir, "favicon.ico"))
@@ -160,6 +331,52 @@ func (s *Server) Router() *gin.Engine {
 	return s.router
 }
 
+// requestContext returns the request context tagged with the actor making
+// the change, taken from the X-Nubi-Actor header or the client address.
+func requestContext(ctx *gin.Context) context.Context {
+	actor := strings.TrimSpace(ctx.GetHeader("X-Nubi-Actor"))
+	if actor == "" {
+		actor = ctx.ClientIP()
+	}
+	return nginx.WithActor(ctx.Request.Context(), actor)
+}
+
+// respondApplyError reports a failed config change. nginx -t failures carry the
+// parsed test output so the UI can point at the offending line.
+func respondApplyError(ctx *gin.Context, status int, err error) {
+	var testErr *nginx.ConfigTestError
+	if errors.As(err, &testErr) {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":      testErr.Error(),
+			"configTest": testErr,
+		})
+		return
+	}
+	var snippetErr *nginx.SnippetError
+	if errors.As(err, &snippetErr) {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":         snippetErr.Error(),
+			"snippetIssues": snippetErr.Issues,
+			"snippetOutput": snippetErr.Output,
+		})
+		return
+	}
+
+	ctx.JSON(status, gin.H{"error": err.Error()})
+}
+
+// reloadAfterChange reloads nginx after a committed change. On failure it
+// writes a response prefixed with what was changed and returns false.
+func (s *Server) reloadAfterChange(ctx *gin.Context, what string) bool {
+	if err := s.nginx.Reload(ctx.Request.Context()); err != nil {
+		ctx.JSON(http.StatusOK, gin.H{
+			"message": what + " but nginx reload failed: " + err.Error(),
+		})
+		return false
+	}
+	return true
+}
+
 func (s *Server) handleStatus(ctx *gin.Context) {
 	status, err := s.nginx.Status(ctx.Request.Context())
 	if err != nil {
@@ -245,8 +462,8 @@ func (s *Server) handleSetDefaultRoute(ctx *gin.Context) {
 	}
 
 	req.Enabled = true
-	if err := s.defaultRoute.Apply(ctx.Request.Context(), &req); err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := s.defaultRoute.Apply(requestContext(ctx), &req); err != nil {
+		respondApplyError(ctx, http.StatusInternalServerError, err)
 		return
 	}
 
@@ -263,8 +480,8 @@ func (s *Server) handleSetDefaultRoute(ctx *gin.Context) {
 }
 
 func (s *Server) handleDeleteDefaultRoute(ctx *gin.Context) {
-	if err := s.defaultRoute.Disable(ctx.Request.Context()); err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := s.defaultRoute.Disable(requestContext(ctx)); err != nil {
+		respondApplyError(ctx, http.StatusInternalServerError, err)
 		return
 	}
 
@@ -0,0 +1,192 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shsm0520/nubi/internal/nginx"
+)
+
+// resolveSiteCertificate fills in the certificate file paths for the site's
+// certificate ID, clearing them when no certificate is bound
+func (s *Server) resolveSiteCertificate(ctx context.Context, site *nginx.StaticHost) error {
+	site.CertPath = ""
+	site.KeyPath = ""
+	site.ChainPath = ""
+	if site.CertificateID == "" {
+		return nil
+	}
+
+	cert, err := s.certManager.GetCertificate(ctx, site.CertificateID)
+	if err != nil {
+		return err
+	}
+
+	site.CertPath = cert.CertPath
+	site.KeyPath = cert.KeyPath
+	site.ChainPath = cert.ChainPath
+	return nil
+}
+
+// handleListSites returns all static sites
+func (s *Server) handleListSites(ctx *gin.Context) {
+	sites := s.staticHosts.List()
+	ctx.JSON(http.StatusOK, gin.H{
+		"sites": sites,
+		"count": len(sites),
+	})
+}
+
+// handleGetSite returns a single static site
+func (s *Server) handleGetSite(ctx *gin.Context) {
+	site, err := s.staticHosts.Get(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"site": site})
+}
+
+// handleCreateSite creates a new static site
+func (s *Server) handleCreateSite(ctx *gin.Context) {
+	var req nginx.StaticHost
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.resolveSiteCertificate(ctx.Request.Context(), &req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.staticHosts.Create(requestContext(ctx), &req); err != nil {
+		respondApplyError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	if req.Enabled && !s.reloadAfterChange(ctx, "Site created") {
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{
+		"site":    req,
+		"message": "Static site created successfully",
+	})
+}
+
+// handleUpdateSite updates the settings of a static site
+func (s *Server) handleUpdateSite(ctx *gin.Context) {
+	id := ctx.Param("id")
+
+	var req nginx.StaticHost
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.resolveSiteCertificate(ctx.Request.Context(), &req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.staticHosts.Update(requestContext(ctx), id, &req); err != nil {
+		respondApplyError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	if !s.reloadAfterChange(ctx, "Site updated") {
+		return
+	}
+
+	site, _ := s.staticHosts.Get(id)
+	ctx.JSON(http.StatusOK, gin.H{
+		"site":    site,
+		"message": "Static site updated successfully",
+	})
+}
+
+// handleDeleteSite deletes a static site and its files
+func (s *Server) handleDeleteSite(ctx *gin.Context) {
+	if err := s.staticHosts.Delete(requestContext(ctx), ctx.Param("id")); err != nil {
+		respondApplyError(ctx, http.StatusNotFound, err)
+		return
+	}
+
+	if !s.reloadAfterChange(ctx, "Site deleted") {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Static site deleted successfully"})
+}
+
+// handleToggleSite enables or disables a static site
+func (s *Server) handleToggleSite(ctx *gin.Context) {
+	id := ctx.Param("id")
+
+	var req ToggleHostRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.staticHosts.Toggle(requestContext(ctx), id, req.Enabled); err != nil {
+		respondApplyError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	if !s.reloadAfterChange(ctx, "Site toggled") {
+		return
+	}
+
+	site, _ := s.staticHosts.Get(id)
+	ctx.JSON(http.StatusOK, gin.H{
+		"site":    site,
+		"message": "Static site " + map[bool]string{true: "enabled", false: "disabled"}[req.Enabled],
+	})
+}
+
+// handleUploadSite publishes a tar.gz or zip archive (multipart field
+// "archive") as the site's new release. The switch is a symlink swap, so
+// nginx does not need to be reloaded.
+func (s *Server) handleUploadSite(ctx *gin.Context) {
+	file, err := ctx.FormFile("archive")
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "archive file is required"})
+		return
+	}
+
+	reader, err := file.Open()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read archive"})
+		return
+	}
+	defer reader.Close()
+
+	site, err := s.staticHosts.Publish(requestContext(ctx), ctx.Param("id"), reader, file.Size, file.Filename)
+	if err != nil {
+		respondApplyError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"site":    site,
+		"message": "Release " + site.Version + " published",
+	})
+}
+
+// handleRollbackSite switches a static site back to its previous release
+func (s *Server) handleRollbackSite(ctx *gin.Context) {
+	site, err := s.staticHosts.Rollback(requestContext(ctx), ctx.Param("id"))
+	if err != nil {
+		respondApplyError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"site":    site,
+		"message": "Rolled back to release " + site.Version,
+	})
+}
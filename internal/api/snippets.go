@@ -0,0 +1,54 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shsm0520/nubi/internal/nginx"
+)
+
+// SnippetRequest is a CustomNginx snippet to check, optionally against an
+// existing host
+type SnippetRequest struct {
+	HostID      string `json:"hostId"`
+	CustomNginx string `json:"customNginx"`
+}
+
+// SetSnippetPolicy sets the policy applied to CustomNginx snippets
+func (s *Server) SetSnippetPolicy(policy nginx.SnippetPolicy) {
+	s.proxyHosts.SetSnippetPolicy(policy)
+}
+
+// handleValidateSnippet checks a snippet without saving it and returns the
+// line-numbered issues found
+func (s *Server) handleValidateSnippet(ctx *gin.Context) {
+	var req SnippetRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	host := &nginx.ProxyHost{ID: "snippet-check", Domain: "snippet-check.invalid", Target: "http://127.0.0.1"}
+	if req.HostID != "" {
+		existing, err := s.proxyHosts.Get(req.HostID)
+		if err != nil {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		copied := *existing
+		host = &copied
+	}
+	host.CustomNginx = req.CustomNginx
+
+	err := s.proxyHosts.CheckSnippet(requestContext(ctx), host)
+	var snippetErr *nginx.SnippetError
+	switch {
+	case err == nil:
+		ctx.JSON(http.StatusOK, gin.H{"valid": true, "issues": []nginx.SnippetIssue{}})
+	case errors.As(err, &snippetErr):
+		ctx.JSON(http.StatusOK, gin.H{"valid": false, "issues": snippetErr.Issues, "output": snippetErr.Output})
+	default:
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
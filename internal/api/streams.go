@@ -0,0 +1,147 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shsm0520/nubi/internal/nginx"
+)
+
+// resolveStreamCertificate fills in the certificate file paths for the
+// stream's certificate ID
+func (s *Server) resolveStreamCertificate(ctx context.Context, host *nginx.StreamHost) error {
+	host.CertPath = ""
+	host.KeyPath = ""
+	if !host.SSL || host.CertificateID == "" {
+		return nil
+	}
+
+	cert, err := s.certManager.GetCertificate(ctx, host.CertificateID)
+	if err != nil {
+		return err
+	}
+
+	host.CertPath = cert.CertPath
+	host.KeyPath = cert.KeyPath
+	return nil
+}
+
+// handleListStreams returns all stream hosts
+func (s *Server) handleListStreams(ctx *gin.Context) {
+	streams := s.streamHosts.List()
+	ctx.JSON(http.StatusOK, gin.H{
+		"streams": streams,
+		"count":   len(streams),
+	})
+}
+
+// handleGetStream returns a single stream host
+func (s *Server) handleGetStream(ctx *gin.Context) {
+	stream, err := s.streamHosts.Get(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"stream": stream})
+}
+
+// handleCreateStream creates a new stream host
+func (s *Server) handleCreateStream(ctx *gin.Context) {
+	var req nginx.StreamHost
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.resolveStreamCertificate(ctx.Request.Context(), &req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.streamHosts.Create(requestContext(ctx), &req); err != nil {
+		respondApplyError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	if req.Enabled && !s.reloadAfterChange(ctx, "Stream created") {
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{
+		"stream":  req,
+		"message": "Stream created successfully",
+	})
+}
+
+// handleUpdateStream updates an existing stream host
+func (s *Server) handleUpdateStream(ctx *gin.Context) {
+	id := ctx.Param("id")
+
+	var req nginx.StreamHost
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.resolveStreamCertificate(ctx.Request.Context(), &req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.streamHosts.Update(requestContext(ctx), id, &req); err != nil {
+		respondApplyError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	if !s.reloadAfterChange(ctx, "Stream updated") {
+		return
+	}
+
+	stream, _ := s.streamHosts.Get(id)
+	ctx.JSON(http.StatusOK, gin.H{
+		"stream":  stream,
+		"message": "Stream updated successfully",
+	})
+}
+
+// handleDeleteStream deletes a stream host
+func (s *Server) handleDeleteStream(ctx *gin.Context) {
+	if err := s.streamHosts.Delete(requestContext(ctx), ctx.Param("id")); err != nil {
+		respondApplyError(ctx, http.StatusNotFound, err)
+		return
+	}
+
+	if !s.reloadAfterChange(ctx, "Stream deleted") {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Stream deleted successfully"})
+}
+
+// handleToggleStream enables or disables a stream host
+func (s *Server) handleToggleStream(ctx *gin.Context) {
+	id := ctx.Param("id")
+
+	var req ToggleHostRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.streamHosts.Toggle(requestContext(ctx), id, req.Enabled); err != nil {
+		respondApplyError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	if !s.reloadAfterChange(ctx, "Stream toggled") {
+		return
+	}
+
+	stream, _ := s.streamHosts.Get(id)
+	ctx.JSON(http.StatusOK, gin.H{
+		"stream":  stream,
+		"message": "Stream " + map[bool]string{true: "enabled", false: "disabled"}[req.Enabled],
+	})
+}
@@ -0,0 +1,625 @@
+package nginx
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password hash algorithms for access list users
+const (
+	HashBcrypt = "bcrypt"
+	HashAPR1   = "apr1"
+)
+
+// AccessUser is a basic auth user of an access list
+type AccessUser struct {
+	Username     string `json:"username"`
+	Password     string `json:"password,omitempty"` // Plaintext, accepted on input only and never stored
+	PasswordHash string `json:"passwordHash"`       // bcrypt ($2a$, $2b$, $2y$) or apr1 ($apr1$) hash
+}
+
+// AccessRule allows or denies a client address
+type AccessRule struct {
+	Action  string `json:"action"`  // allow or deny
+	Address string `json:"address"` // IP, CIDR or "all"
+}
+
+// AccessList is a reusable set of basic auth users and IP rules that can be
+// attached to hosts and locations
+type AccessList struct {
+	ID            string       `json:"id"`
+	Name          string       `json:"name"`
+	Realm         string       `json:"realm"`         // Basic auth realm (defaults to "Restricted")
+	Users         []AccessUser `json:"users"`         // Basic auth users
+	Rules         []AccessRule `json:"rules"`         // Evaluated in order; the first match wins
+	Satisfy       string       `json:"satisfy"`       // all (IP and password) or any (either one)
+	HashAlgorithm string       `json:"hashAlgorithm"` // bcrypt or apr1, used for new passwords
+	CreatedAt     time.Time    `json:"createdAt"`
+	UpdatedAt     time.Time    `json:"updatedAt"`
+}
+
+// Redacted returns a copy of the list without password hashes, for API output
+func (l *AccessList) Redacted() *AccessList {
+	out := *l
+	out.Users = make([]AccessUser, len(l.Users))
+	for i, u := range l.Users {
+		out.Users[i] = AccessUser{Username: u.Username}
+	}
+	return &out
+}
+
+// AccessListManager stores access lists and the htpasswd files nginx reads
+// for them
+type AccessListManager struct {
+	mu       sync.RWMutex
+	lists    map[string]*AccessList
+	ctrl     *Controller      // used to test staged config before it goes live
+	history  *HistoryStore    // optional revision log
+	dir      string           // e.g., /etc/nginx/nubi-access
+	dataFile string           // e.g., /var/lib/nubi/access_lists.json
+	users    []accessListUser // host managers re-rendered on change, see Track
+
+	// applyMu serialises changes. A change stages the hosts using the list
+	// without holding mu, so their templates can still read other lists.
+	applyMu sync.Mutex
+}
+
+// accessListUser is a host manager whose hosts render access lists
+type accessListUser interface {
+	stageAccessListRefresh(tx *Transaction, listID string, lists map[string]*AccessList) (*hostRefresh, error)
+}
+
+// NewAccessListManager creates a new access list manager
+func NewAccessListManager(ctrl *Controller, dir, dataFile string) (*AccessListManager, error) {
+	if dir == "" {
+		dir = "/etc/nginx/nubi-access"
+	}
+	if dataFile == "" {
+		dataFile = "/var/lib/nubi/access_lists.json"
+	}
+
+	mgr := &AccessListManager{
+		lists:    make(map[string]*AccessList),
+		ctrl:     ctrl,
+		dir:      dir,
+		dataFile: dataFile,
+	}
+
+	if err := mgr.load(); err != nil {
+		// Not a fatal error - might be first run
+		fmt.Printf("Note: Could not load existing access lists: %v\n", err)
+	}
+
+	return mgr, nil
+}
+
+// load reads access lists from the JSON data file
+func (m *AccessListManager) load() error {
+	data, err := os.ReadFile(m.dataFile)
+	if err != nil {
+		return err
+	}
+
+	var lists []*AccessList
+	if err := json.Unmarshal(data, &lists); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lists = make(map[string]*AccessList)
+	for _, l := range lists {
+		m.lists[l.ID] = l
+	}
+
+	return nil
+}
+
+// SetHistory attaches a revision log; committed changes are recorded to it
+func (m *AccessListManager) SetHistory(history *HistoryStore) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.history = history
+}
+
+// Track makes changes to a list re-render the hosts of user that use it, in
+// the same transaction as the list's own files. Call it once at startup,
+// before serving requests.
+func (m *AccessListManager) Track(user accessListUser) {
+	m.users = append(m.users, user)
+}
+
+// HtpasswdPath returns the htpasswd file of a list
+func (m *AccessListManager) HtpasswdPath(id string) string {
+	return filepath.Join(m.dir, id+".htpasswd")
+}
+
+// List returns all access lists ordered by name
+func (m *AccessListManager) List() []*AccessList {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	lists := make([]*AccessList, 0, len(m.lists))
+	for _, l := range m.lists {
+		lists = append(lists, l)
+	}
+	sort.Slice(lists, func(i, j int) bool { return lists[i].Name < lists[j].Name })
+	return lists
+}
+
+// Get returns an access list by ID
+func (m *AccessListManager) Get(id string) (*AccessList, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	list, ok := m.lists[id]
+	if !ok {
+		return nil, fmt.Errorf("access list not found: %s", id)
+	}
+	return list, nil
+}
+
+// Create adds a new access list
+func (m *AccessListManager) Create(ctx context.Context, list *AccessList) error {
+	if err := prepareAccessList(list, nil); err != nil {
+		return err
+	}
+
+	m.applyMu.Lock()
+	defer m.applyMu.Unlock()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list.ID = uuid.New().String()
+	list.CreatedAt = time.Now()
+	list.UpdatedAt = time.Now()
+
+	next := m.cloneLists()
+	next[list.ID] = list
+
+	return m.commit(ctx, "create", next, list, nil)
+}
+
+// Update replaces an access list. Users sent without a password keep their
+// current hash. Every host using the list is re-rendered and committed
+// together with it.
+func (m *AccessListManager) Update(ctx context.Context, id string, updates *AccessList) error {
+	m.applyMu.Lock()
+	defer m.applyMu.Unlock()
+
+	current, err := m.Get(id)
+	if err != nil {
+		return err
+	}
+
+	list := *updates
+	list.ID = id
+	list.CreatedAt = current.CreatedAt
+	list.UpdatedAt = time.Now()
+	if err := prepareAccessList(&list, current); err != nil {
+		return err
+	}
+
+	m.mu.RLock()
+	next := m.cloneLists()
+	m.mu.RUnlock()
+	next[id] = &list
+
+	return m.commitWithHosts(ctx, "update", next, &list)
+}
+
+// Delete removes an access list. Callers must make sure no host uses it.
+func (m *AccessListManager) Delete(ctx context.Context, id string) error {
+	m.applyMu.Lock()
+	defer m.applyMu.Unlock()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list, ok := m.lists[id]
+	if !ok {
+		return fmt.Errorf("access list not found: %s", id)
+	}
+
+	next := m.cloneLists()
+	delete(next, id)
+
+	return m.commit(ctx, "delete", next, nil, list)
+}
+
+// Restore puts an access list back to a recorded state. A nil snapshot
+// deletes the list.
+func (m *AccessListManager) Restore(ctx context.Context, id string, snapshot *AccessList) error {
+	m.applyMu.Lock()
+	defer m.applyMu.Unlock()
+
+	m.mu.RLock()
+	current, exists := m.lists[id]
+	next := m.cloneLists()
+	m.mu.RUnlock()
+
+	if snapshot == nil {
+		if !exists {
+			return nil
+		}
+		delete(next, id)
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		return m.commit(ctx, "restore", next, nil, current)
+	}
+
+	list := *snapshot
+	list.ID = id
+	list.UpdatedAt = time.Now()
+	if err := prepareAccessList(&list, current); err != nil {
+		return err
+	}
+	next[id] = &list
+
+	return m.commitWithHosts(ctx, "restore", next, &list)
+}
+
+func (m *AccessListManager) cloneLists() map[string]*AccessList {
+	next := make(map[string]*AccessList, len(m.lists))
+	for id, l := range m.lists {
+		next[id] = l
+	}
+	return next
+}
+
+// commit writes the htpasswd file of the written list (or removes the one of
+// the removed list) together with the data file. Callers must hold m.mu.
+func (m *AccessListManager) commit(ctx context.Context, action string, next map[string]*AccessList, written, removed *AccessList) error {
+	tx := NewTransaction(m.ctrl)
+	if err := m.stageCommit(tx, next, written, removed); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	m.swap(ctx, action, next, written, removed)
+	return nil
+}
+
+// commitWithHosts commits a changed list together with the re-rendered hosts
+// using it, so nginx never enforces the new htpasswd file under the old
+// directives. Callers hold m.applyMu but not m.mu.
+func (m *AccessListManager) commitWithHosts(ctx context.Context, action string, next map[string]*AccessList, written *AccessList) error {
+	tx := NewTransaction(m.ctrl)
+	if err := m.stageCommit(tx, next, written, nil); err != nil {
+		return err
+	}
+
+	var refreshes []*hostRefresh
+	var err error
+	for _, user := range m.users {
+		refresh, stageErr := user.stageAccessListRefresh(tx, written.ID, next)
+		if stageErr != nil {
+			err = stageErr
+			break
+		}
+		refreshes = append(refreshes, refresh)
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+	if err == nil {
+		m.mu.Lock()
+		m.swap(ctx, action, next, written, nil)
+		m.mu.Unlock()
+	}
+
+	for _, refresh := range refreshes {
+		refresh.finish(ctx, err)
+	}
+	return err
+}
+
+// stageCommit stages the htpasswd file of the written list (or the removal
+// of the removed list's) and the data file into tx
+func (m *AccessListManager) stageCommit(tx *Transaction, next map[string]*AccessList, written, removed *AccessList) error {
+	if removed != nil {
+		tx.Remove(m.HtpasswdPath(removed.ID))
+	}
+	if written != nil {
+		// nginx workers read the file after dropping privileges
+		tx.WriteFile(m.HtpasswdPath(written.ID), renderHtpasswd(written), 0644)
+	}
+
+	list := make([]*AccessList, 0, len(next))
+	for _, l := range next {
+		list = append(list, l)
+	}
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	tx.WriteState(m.dataFile, data)
+	return nil
+}
+
+// swap makes a committed list set live and records the change. Callers must
+// hold m.mu.
+func (m *AccessListManager) swap(ctx context.Context, action string, next map[string]*AccessList, written, removed *AccessList) {
+	prev := m.lists
+	m.lists = next
+
+	if m.history != nil {
+		changed := written
+		if changed == nil {
+			changed = removed
+		}
+		rev := &Revision{
+			Entity:   EntityAccessList,
+			EntityID: changed.ID,
+			Action:   action,
+			Before:   marshalRevisionState(prev[changed.ID]),
+			After:    marshalRevisionState(next[changed.ID]),
+		}
+		if err := m.history.Record(ctx, rev); err != nil {
+			log.Printf("warning: failed to record history for access list %s: %v", changed.ID, err)
+		}
+	}
+}
+
+// listDirectives returns the nginx directives enforcing a list. With override
+// set (used inside locations) the list fully replaces whatever the server
+// block configured: missing users turn auth_basic off and missing rules
+// allow everyone. Lists with allow rules but no final rule for "all" deny
+// every other address. It reads no manager state, so templates can render
+// lists that are not committed yet.
+func (m *AccessListManager) listDirectives(list *AccessList, override bool) []string {
+	var lines []string
+	lines = append(lines, "# Access list: "+list.Name)
+
+	if len(list.Users) > 0 && len(list.Rules) > 0 {
+		lines = append(lines, "satisfy "+list.Satisfy+";")
+	} else if override {
+		lines = append(lines, "satisfy all;")
+	}
+
+	hasAllow := false
+	for _, r := range list.Rules {
+		lines = append(lines, r.Action+" "+r.Address+";")
+		if r.Action == "allow" {
+			hasAllow = true
+		}
+	}
+	if n := len(list.Rules); n > 0 && hasAllow && list.Rules[n-1].Address != "all" {
+		lines = append(lines, "deny all;")
+	} else if n == 0 && override {
+		lines = append(lines, "allow all;")
+	}
+
+	if len(list.Users) > 0 {
+		lines = append(lines,
+			fmt.Sprintf("auth_basic \"%s\";", list.Realm),
+			"auth_basic_user_file "+m.HtpasswdPath(list.ID)+";",
+		)
+	} else if override {
+		lines = append(lines, "auth_basic off;")
+	}
+
+	return lines
+}
+
+// renderHtpasswd returns the htpasswd file contents for a list
+func renderHtpasswd(list *AccessList) []byte {
+	var b strings.Builder
+	b.WriteString("# Nubi managed access list: " + list.Name + "\n")
+	for _, u := range list.Users {
+		b.WriteString(u.Username + ":" + u.PasswordHash + "\n")
+	}
+	return []byte(b.String())
+}
+
+// prepareAccessList fills in defaults, hashes new passwords and validates the
+// list. current is the stored version of the list, if any, whose hashes are
+// kept for users sent without a password.
+func prepareAccessList(list *AccessList, current *AccessList) error {
+	if strings.TrimSpace(list.Name) == "" {
+		return fmt.Errorf("name is required")
+	}
+	if strings.ContainsAny(list.Name, "\r\n") {
+		return fmt.Errorf("name must be a single line")
+	}
+
+	if list.Realm == "" {
+		list.Realm = "Restricted"
+	}
+	if strings.ContainsAny(list.Realm, "\"\\\r\n") {
+		return fmt.Errorf("invalid realm: %q", list.Realm)
+	}
+
+	if list.Satisfy == "" {
+		list.Satisfy = "all"
+	}
+	if list.Satisfy != "all" && list.Satisfy != "any" {
+		return fmt.Errorf("invalid satisfy mode: %s (expected all or any)", list.Satisfy)
+	}
+
+	if list.HashAlgorithm == "" {
+		list.HashAlgorithm = HashBcrypt
+	}
+	if list.HashAlgorithm != HashBcrypt && list.HashAlgorithm != HashAPR1 {
+		return fmt.Errorf("invalid hash algorithm: %s (expected bcrypt or apr1)", list.HashAlgorithm)
+	}
+
+	existing := make(map[string]string)
+	if current != nil {
+		for _, u := range current.Users {
+			existing[u.Username] = u.PasswordHash
+		}
+	}
+
+	users := make([]AccessUser, 0, len(list.Users))
+	seen := make(map[string]bool)
+	for _, u := range list.Users {
+		if u.Username == "" || strings.ContainsAny(u.Username, ":\r\n \t") {
+			return fmt.Errorf("invalid username: %q", u.Username)
+		}
+		if seen[u.Username] {
+			return fmt.Errorf("duplicate username: %s", u.Username)
+		}
+		seen[u.Username] = true
+
+		hash, err := resolvePasswordHash(u, existing[u.Username], list.HashAlgorithm)
+		if err != nil {
+			return err
+		}
+		users = append(users, AccessUser{Username: u.Username, PasswordHash: hash})
+	}
+	list.Users = users
+
+	for _, r := range list.Rules {
+		if r.Action != "allow" && r.Action != "deny" {
+			return fmt.Errorf("invalid access rule action: %s (expected allow or deny)", r.Action)
+		}
+		if r.Address == "all" {
+			continue
+		}
+		if net.ParseIP(r.Address) == nil {
+			if _, _, err := net.ParseCIDR(r.Address); err != nil {
+				return fmt.Errorf("invalid access rule address: %s (expected an IP, CIDR or \"all\")", r.Address)
+			}
+		}
+	}
+
+	if len(list.Users) == 0 && len(list.Rules) == 0 {
+		return fmt.Errorf("access list needs at least one user or rule")
+	}
+
+	return nil
+}
+
+// resolvePasswordHash returns the hash to store for a user: a new hash of the
+// plaintext password, a supplied hash, or the user's existing hash
+func resolvePasswordHash(u AccessUser, existing, algorithm string) (string, error) {
+	switch {
+	case u.Password != "":
+		if algorithm == HashAPR1 {
+			return apr1Hash(u.Password, randomSalt(8)), nil
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(u.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return "", fmt.Errorf("failed to hash password for %s: %w", u.Username, err)
+		}
+		return string(hash), nil
+
+	case u.PasswordHash != "":
+		valid := strings.HasPrefix(u.PasswordHash, "$apr1$")
+		for _, prefix := range []string{"$2a$", "$2b$", "$2y$"} {
+			valid = valid || strings.HasPrefix(u.PasswordHash, prefix)
+		}
+		if !valid || strings.ContainsAny(u.PasswordHash, ":\r\n") {
+			return "", fmt.Errorf("unsupported password hash for %s (expected bcrypt or apr1)", u.Username)
+		}
+		return u.PasswordHash, nil
+
+	case existing != "":
+		return existing, nil
+
+	default:
+		return "", fmt.Errorf("password is required for new user %s", u.Username)
+	}
+}
+
+// apr1Alphabet is the crypt(3) base64 alphabet
+const apr1Alphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// randomSalt returns n random characters from the crypt alphabet
+func randomSalt(n int) string {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	for i, b := range buf {
+		buf[i] = apr1Alphabet[int(b)%len(apr1Alphabet)]
+	}
+	return string(buf)
+}
+
+// apr1Hash implements Apache's MD5-based "$apr1$" password hash, which nginx
+// verifies natively on every platform
+func apr1Hash(password, salt string) string {
+	const magic = "$apr1$"
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+	pw := []byte(password)
+
+	alt := md5.New()
+	alt.Write(pw)
+	alt.Write([]byte(salt))
+	alt.Write(pw)
+	altSum := alt.Sum(nil)
+
+	h := md5.New()
+	h.Write(pw)
+	h.Write([]byte(magic + salt))
+	for i := len(pw); i > 0; i -= 16 {
+		h.Write(altSum[:min(i, 16)])
+	}
+	for i := len(pw); i > 0; i >>= 1 {
+		if i&1 == 1 {
+			h.Write([]byte{0})
+		} else {
+			h.Write(pw[:1])
+		}
+	}
+	sum := h.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		r := md5.New()
+		if i&1 == 1 {
+			r.Write(pw)
+		} else {
+			r.Write(sum)
+		}
+		if i%3 != 0 {
+			r.Write([]byte(salt))
+		}
+		if i%7 != 0 {
+			r.Write(pw)
+		}
+		if i&1 == 1 {
+			r.Write(sum)
+		} else {
+			r.Write(pw)
+		}
+		sum = r.Sum(nil)
+	}
+
+	var out []byte
+	encode := func(b2, b1, b0 byte, n int) {
+		v := uint(b2)<<16 | uint(b1)<<8 | uint(b0)
+		for ; n > 0; n-- {
+			out = append(out, apr1Alphabet[v&0x3f])
+			v >>= 6
+		}
+	}
+	encode(sum[0], sum[6], sum[12], 4)
+	encode(sum[1], sum[7], sum[13], 4)
+	encode(sum[2], sum[8], sum[14], 4)
+	encode(sum[3], sum[9], sum[15], 4)
+	encode(sum[4], sum[10], sum[5], 4)
+	encode(0, 0, sum[11], 2)
+
+	return magic + salt + "$" + string(out)
+}
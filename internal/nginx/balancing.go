@@ -0,0 +1,192 @@
+package nginx
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Load balancing methods of proxy hosts and locations
+const (
+	LBRoundRobin = "round_robin"
+	LBLeastConn  = "least_conn"
+	LBIPHash     = "ip_hash"
+	LBHash       = "hash"
+	LBRandom     = "random"
+	LBSticky     = "sticky" // hash on a cookie issued by Nubi
+)
+
+// Keys for the hash method
+const (
+	HashKeyRequestURI = "request_uri"
+	HashKeyRemoteAddr = "remote_addr"
+	HashKeyCookie     = "cookie"
+	HashKeyHeader     = "header"
+)
+
+// cookieNameRegex matches cookie names usable in $cookie_ variables
+var cookieNameRegex = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// LBOptions configures the hash, random and sticky methods
+type LBOptions struct {
+	HashKey      string `json:"hashKey"`      // hash: request_uri (default), remote_addr, cookie or header
+	HashName     string `json:"hashName"`     // hash: cookie or header name for those keys
+	Consistent   bool   `json:"consistent"`   // hash: ketama consistent hashing, so adding a server remaps few keys
+	Two          bool   `json:"two"`          // random: pick two servers and use the one with fewer connections
+	CookieName   string `json:"cookieName"`   // sticky: cookie name (default nubi_sticky)
+	CookieMaxAge int    `json:"cookieMaxAge"` // sticky: cookie lifetime in seconds (0 = browser session)
+}
+
+// validateBalancing checks a balancing method, its options and whether the
+// backends' parameters can be used with it. Options get their defaults;
+// callers run normalizeLBOptions first.
+func validateBalancing(method string, opts *LBOptions, backends []Backend, allowSticky bool) error {
+	switch method {
+	case "", LBRoundRobin, LBLeastConn, LBIPHash:
+		if opts != nil {
+			return fmt.Errorf("lbOptions are only used with the hash, random and sticky methods")
+		}
+	case LBHash:
+		if opts.HashKey == "" {
+			opts.HashKey = HashKeyRequestURI
+		}
+		switch opts.HashKey {
+		case HashKeyRequestURI, HashKeyRemoteAddr:
+			if opts.HashName != "" {
+				return fmt.Errorf("hashName is only used with the cookie and header keys")
+			}
+		case HashKeyCookie:
+			if !cookieNameRegex.MatchString(opts.HashName) {
+				return fmt.Errorf("invalid hash cookie name: %q", opts.HashName)
+			}
+		case HashKeyHeader:
+			if !limitHeaderRegex.MatchString(opts.HashName) {
+				return fmt.Errorf("invalid hash header name: %q", opts.HashName)
+			}
+		default:
+			return fmt.Errorf("invalid hash key: %s (expected request_uri, remote_addr, cookie or header)", opts.HashKey)
+		}
+		if opts.Two || opts.CookieName != "" || opts.CookieMaxAge != 0 {
+			return fmt.Errorf("only hashKey, hashName and consistent apply to the hash method")
+		}
+	case LBRandom:
+		if opts != nil && (opts.HashKey != "" || opts.HashName != "" || opts.Consistent || opts.CookieName != "" || opts.CookieMaxAge != 0) {
+			return fmt.Errorf("only two applies to the random method")
+		}
+	case LBSticky:
+		if !allowSticky {
+			return fmt.Errorf("sticky sessions are only supported on the host, not on locations")
+		}
+		if opts.CookieName == "" {
+			opts.CookieName = "nubi_sticky"
+		}
+		if !cookieNameRegex.MatchString(opts.CookieName) {
+			return fmt.Errorf("invalid sticky cookie name: %q", opts.CookieName)
+		}
+		if opts.CookieMaxAge < 0 {
+			return fmt.Errorf("cookieMaxAge must not be negative")
+		}
+		if opts.HashKey != "" || opts.HashName != "" || opts.Consistent || opts.Two {
+			return fmt.Errorf("only cookieName and cookieMaxAge apply to the sticky method")
+		}
+	default:
+		return fmt.Errorf("invalid load balancing method: %s (expected round_robin, least_conn, ip_hash, hash, random or sticky)", method)
+	}
+
+	// nginx rejects backup and slow_start with the hashing and random methods
+	switch method {
+	case LBIPHash, LBHash, LBRandom, LBSticky:
+		for _, b := range backends {
+			if b.Backup {
+				return fmt.Errorf("backend %s: backup servers cannot be used with %s balancing", b.Address, method)
+			}
+			if b.SlowStart > 0 {
+				return fmt.Errorf("backend %s: slowStart cannot be used with %s balancing", b.Address, method)
+			}
+		}
+	}
+	return nil
+}
+
+// normalizeLBOptions allocates the options of methods that need them, so
+// their defaults can be filled in
+func normalizeLBOptions(method string, opts **LBOptions) {
+	if *opts == nil && (method == LBHash || method == LBSticky) {
+		*opts = &LBOptions{}
+	}
+}
+
+// MethodDirective returns the balancing directive of the upstream block
+func (v upstreamView) MethodDirective() string {
+	switch v.LBMethod {
+	case LBLeastConn:
+		return "least_conn;"
+	case LBIPHash:
+		return "ip_hash;"
+	case LBHash:
+		line := "hash " + v.Options.hashVariable()
+		if v.Options.Consistent {
+			line += " consistent"
+		}
+		return line + ";"
+	case LBRandom:
+		if v.Options != nil && v.Options.Two {
+			return "random two least_conn;"
+		}
+		return "random;"
+	case LBSticky:
+		return "hash " + v.Options.stickyKeyVariable() + " consistent;"
+	default:
+		return ""
+	}
+}
+
+// hashVariable returns the nginx variable hashed by the hash method
+func (o *LBOptions) hashVariable() string {
+	switch o.HashKey {
+	case HashKeyRemoteAddr:
+		return "$remote_addr"
+	case HashKeyCookie:
+		return "$cookie_" + o.HashName
+	case HashKeyHeader:
+		return "$http_" + strings.ReplaceAll(strings.ToLower(o.HashName), "-", "_")
+	default:
+		return "$request_uri"
+	}
+}
+
+// stickyKeyVariable is the client's sticky cookie, or the request ID used
+// for the cookie issued on its first request
+func (o *LBOptions) stickyKeyVariable() string {
+	return "$nubi_sticky_" + strings.ToLower(o.CookieName)
+}
+
+// stickySetCookieVariable holds the Set-Cookie value for clients without a
+// sticky cookie, and is empty otherwise
+func (o *LBOptions) stickySetCookieVariable() string {
+	return fmt.Sprintf("$nubi_sticky_set_%s_%d", strings.ToLower(o.CookieName), o.CookieMaxAge)
+}
+
+// stickyMaps returns the http-level maps behind a sticky method. A client
+// without the cookie is hashed on $request_id, which is also the value of
+// the cookie it is issued, so its next requests reach the same backend.
+func (o *LBOptions) stickyMaps() []string {
+	cookie := "$cookie_" + o.CookieName
+	attrs := "Path=/; HttpOnly; SameSite=Lax"
+	if o.CookieMaxAge > 0 {
+		attrs += fmt.Sprintf("; Max-Age=%d", o.CookieMaxAge)
+	}
+	return []string{
+		fmt.Sprintf("map %s %s {\n    \"\" $request_id;\n    default %s;\n}", cookie, o.stickyKeyVariable(), cookie),
+		fmt.Sprintf("map %s %s {\n    \"\" \"%s=$request_id; %s\";\n    default \"\";\n}", cookie, o.stickySetCookieVariable(), o.CookieName, attrs),
+	}
+}
+
+// StickyCookieHeader returns the add_header value issuing the host's sticky
+// cookie, or "" when the host does not use sticky sessions
+func (h *ProxyHost) StickyCookieHeader() string {
+	if h.LBMethod != LBSticky || !h.UsesUpstream() || h.LBOptions == nil {
+		return ""
+	}
+	return h.LBOptions.stickySetCookieVariable()
+}
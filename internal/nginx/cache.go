@@ -0,0 +1,216 @@
+package nginx
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// cacheRoot holds the proxy_cache_path directory of every caching host
+const cacheRoot = "/var/cache/nubi"
+
+// defaultCacheKey is nginx's own default proxy_cache_key
+const defaultCacheKey = "$scheme$proxy_host$request_uri"
+
+// CacheValid caches responses with the given status codes for a duration
+type CacheValid struct {
+	Status   string `json:"status"`   // Space separated codes, e.g. "200 301", or "any"
+	Duration string `json:"duration"` // e.g., "10m"
+}
+
+// CachePolicy configures response caching for a host. Responses are cached
+// according to Valid, or to the upstream's Cache-Control and Expires headers
+// when Valid is empty.
+type CachePolicy struct {
+	Enabled       bool         `json:"enabled"`
+	ZoneSize      string       `json:"zoneSize"`      // Shared memory for cache keys, e.g. "10m" (defaults to 10m)
+	MaxSize       string       `json:"maxSize"`       // Disk space limit, e.g. "1g" (empty = unlimited)
+	Inactive      string       `json:"inactive"`      // Evict entries not requested for this long, e.g. "60m" (defaults to 10m)
+	Valid         []CacheValid `json:"valid"`         // Durations per status code
+	BypassCookies []string     `json:"bypassCookies"` // Skip the cache when the client sends one of these cookies
+	BypassHeaders []string     `json:"bypassHeaders"` // Skip the cache when the client sends one of these headers
+	Key           string       `json:"key"`           // Cache key (defaults to $scheme$proxy_host$request_uri)
+	StaleOnError  bool         `json:"staleOnError"`  // Serve stale entries while the upstream fails or is being refreshed
+}
+
+// cacheStatusRegex matches a status code accepted by proxy_cache_valid
+var cacheStatusRegex = regexp.MustCompile(`^[1-5][0-9][0-9]$`)
+
+// Validate checks the policy
+func (p *CachePolicy) Validate() error {
+	if p.ZoneSize != "" {
+		size, err := zoneSizeBytes(p.ZoneSize)
+		if err != nil {
+			return err
+		}
+		if size < minZoneSize {
+			return fmt.Errorf("cache zone size must be at least 32k")
+		}
+	}
+	if p.MaxSize != "" && !bandwidthRegex.MatchString(p.MaxSize) {
+		return fmt.Errorf("invalid cache max size: %s (expected e.g. 512m or 1g)", p.MaxSize)
+	}
+	if p.Inactive != "" && !nginxTimeRegex.MatchString(p.Inactive) {
+		return fmt.Errorf("invalid cache inactive time: %s", p.Inactive)
+	}
+
+	for _, v := range p.Valid {
+		codes := strings.Fields(v.Status)
+		if len(codes) == 0 {
+			return fmt.Errorf("cache valid entries need a status")
+		}
+		for _, code := range codes {
+			if code != "any" && !cacheStatusRegex.MatchString(code) {
+				return fmt.Errorf("invalid cache status: %s (expected a status code or any)", code)
+			}
+			if code == "any" && len(codes) > 1 {
+				return fmt.Errorf("cache status any cannot be combined with status codes")
+			}
+		}
+		if !nginxTimeRegex.MatchString(v.Duration) {
+			return fmt.Errorf("invalid cache duration for %s: %q", v.Status, v.Duration)
+		}
+	}
+
+	for _, name := range p.BypassCookies {
+		if !cookieNameRegex.MatchString(name) {
+			return fmt.Errorf("invalid cache bypass cookie: %q", name)
+		}
+	}
+	for _, name := range p.BypassHeaders {
+		if !limitHeaderRegex.MatchString(name) {
+			return fmt.Errorf("invalid cache bypass header: %q", name)
+		}
+	}
+
+	if p.Key != "" {
+		if err := validateHeaderValue(p.Key); err != nil {
+			return fmt.Errorf("invalid cache key: %w", err)
+		}
+	}
+	return nil
+}
+
+// validateCachePolicy checks an optional cache policy
+func validateCachePolicy(p *CachePolicy) error {
+	if p == nil {
+		return nil
+	}
+	return p.Validate()
+}
+
+// CachingEnabled reports whether the host caches responses
+func (h *ProxyHost) CachingEnabled() bool {
+	return h.Cache != nil && h.Cache.Enabled
+}
+
+// CacheDir returns the directory holding the host's cached responses
+func (h *ProxyHost) CacheDir() string {
+	return filepath.Join(cacheRoot, h.ID)
+}
+
+// CacheZone returns the name of the host's cache zone
+func (h *ProxyHost) CacheZone() string {
+	return "nubi_cache_" + regexp.MustCompile(`[^a-zA-Z0-9]`).ReplaceAllString(h.ID, "_")
+}
+
+// cachePathDefinition returns the http-level proxy_cache_path of the host
+func (h *ProxyHost) cachePathDefinition() string {
+	zoneSize := h.Cache.ZoneSize
+	if zoneSize == "" {
+		zoneSize = "10m"
+	}
+	line := fmt.Sprintf("proxy_cache_path %s levels=1:2 keys_zone=%s:%s", h.CacheDir(), h.CacheZone(), zoneSize)
+	if h.Cache.MaxSize != "" {
+		line += " max_size=" + h.Cache.MaxSize
+	}
+	inactive := h.Cache.Inactive
+	if inactive == "" {
+		inactive = "10m"
+	}
+	return line + " inactive=" + inactive + " use_temp_path=off;"
+}
+
+// CacheDirectives returns the server-level caching directives of the host
+func (h *ProxyHost) CacheDirectives() []string {
+	if !h.CachingEnabled() {
+		return nil
+	}
+	p := h.Cache
+
+	key := p.Key
+	if key == "" {
+		key = defaultCacheKey
+	}
+	lines := []string{
+		"proxy_cache " + h.CacheZone() + ";",
+		fmt.Sprintf("proxy_cache_key \"%s\";", key),
+	}
+	for _, v := range p.Valid {
+		lines = append(lines, fmt.Sprintf("proxy_cache_valid %s %s;", strings.Join(strings.Fields(v.Status), " "), v.Duration))
+	}
+
+	var bypass []string
+	for _, name := range p.BypassCookies {
+		bypass = append(bypass, "$cookie_"+name)
+	}
+	for _, name := range p.BypassHeaders {
+		bypass = append(bypass, requestHeaderVariable(name))
+	}
+	if len(bypass) > 0 {
+		vars := strings.Join(bypass, " ")
+		lines = append(lines, "proxy_cache_bypass "+vars+";", "proxy_no_cache "+vars+";")
+	}
+
+	if p.StaleOnError {
+		lines = append(lines,
+			"proxy_cache_use_stale error timeout updating http_500 http_502 http_503 http_504;",
+			"proxy_cache_background_update on;",
+			"proxy_cache_lock on;",
+		)
+	}
+	return lines
+}
+
+// cacheStatusHeader exposes HIT, MISS, BYPASS, STALE... to clients
+const cacheStatusHeader = "add_header X-Cache-Status $upstream_cache_status always;"
+
+// PurgeCache deletes every cached response of a host and returns the number
+// of files removed. nginx treats entries whose file is gone as misses.
+func (m *ProxyHostManager) PurgeCache(id string) (int, error) {
+	m.mu.RLock()
+	host, ok := m.hosts[id]
+	m.mu.RUnlock()
+	if !ok {
+		return 0, fmt.Errorf("proxy host not found: %s", id)
+	}
+	if !host.CachingEnabled() {
+		return 0, fmt.Errorf("caching is not enabled for %s", host.Domain)
+	}
+
+	dir := host.CacheDir()
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read cache directory: %w", err)
+	}
+
+	removed := 0
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		filepath.WalkDir(path, func(_ string, d os.DirEntry, err error) error {
+			if err == nil && !d.IsDir() {
+				removed++
+			}
+			return nil
+		})
+		if err := os.RemoveAll(path); err != nil {
+			return removed, fmt.Errorf("failed to purge cache: %w", err)
+		}
+	}
+	return removed, nil
+}
@@ -0,0 +1,243 @@
+package nginx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// HostBindResult reports what happened to a single host during a certificate
+// bind or refresh
+type HostBindResult struct {
+	HostID string `json:"hostId"`
+	Domain string `json:"domain,omitempty"`
+	OK     bool   `json:"ok"`
+	Error  string `json:"error,omitempty"`
+}
+
+// BindReport summarises a certificate bind or refresh across all hosts
+type BindReport struct {
+	CertificateID string           `json:"certificateId"`
+	Results       []HostBindResult `json:"results"`
+	Updated       int              `json:"updated"`
+	ConfigTest    *ConfigTestError `json:"configTest,omitempty"` // Set when the shared nginx -t failed
+	ReloadError   string           `json:"reloadError,omitempty"`
+}
+
+// CertificateBinder keeps hosts in sync with the certificates bound to them.
+// Binding re-renders the selected hosts, validates them with a single
+// nginx -t and reloads once; when a certificate's files are replaced the new
+// files and every host bound to it are committed in one transaction and
+// nginx reloaded.
+type CertificateBinder struct {
+	certs     *CertificateManager
+	hosts     *ProxyHostManager
+	redirects *RedirectHostManager // optional, set with SetRedirects
+	others    []certificateUser    // further host kinds refreshed on change, see Track
+	ctrl      *Controller
+}
+
+// certificateUser is a host manager whose hosts can reference certificates
+type certificateUser interface {
+	stageCertificateRefresh(tx *Transaction, cert *Certificate) (*certificateRefresh, error)
+	HostsUsingCertificate(certID string) []string
+}
+
+// certificateRefresh is one manager's share of a certificate refresh staged
+// into a shared transaction. The manager stays locked until finish is called.
+type certificateRefresh struct {
+	results []HostBindResult
+	swap    func(ctx context.Context) // makes the staged hosts live, nil when none changed
+	unlock  func()
+}
+
+// finish applies the outcome of the shared commit to the results, swaps the
+// staged hosts in when it succeeded and unlocks the manager
+func (r *certificateRefresh) finish(ctx context.Context, err error) {
+	defer r.unlock()
+
+	for i := range r.results {
+		if r.results[i].Error != "" {
+			continue
+		}
+		if err != nil {
+			r.results[i].Error = err.Error()
+		} else {
+			r.results[i].OK = true
+		}
+	}
+	if err == nil && r.swap != nil {
+		r.swap(ctx)
+	}
+}
+
+// NewCertificateBinder creates a binder and routes certificate file
+// replacements through it
+func NewCertificateBinder(certs *CertificateManager, hosts *ProxyHostManager, ctrl *Controller) *CertificateBinder {
+	b := &CertificateBinder{
+		certs: certs,
+		hosts: hosts,
+		ctrl:  ctrl,
+	}
+	certs.setApplier(b.apply)
+	return b
+}
+
+// SetRedirects makes the binder bind and refresh redirect hosts as well
+func (b *CertificateBinder) SetRedirects(redirects *RedirectHostManager) {
+	b.redirects = redirects
+	b.Track(redirects)
+}
+
+// Track makes the binder refresh another kind of host (streams, static
+// sites) when certificate files change and count it in InUse
+func (b *CertificateBinder) Track(user certificateUser) {
+	b.others = append(b.others, user)
+}
+
+// Bind binds a certificate to the given hosts plus every host carrying tagID
+func (b *CertificateBinder) Bind(ctx context.Context, certID string, hostIDs []string, tagID string) (*BindReport, error) {
+	cert, err := b.certs.GetCertificate(ctx, certID)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(hostIDs))
+	seen := make(map[string]bool)
+	for _, id := range hostIDs {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+
+	// If tag ID is provided, add all hosts with that tag
+	if tagID != "" {
+		for _, host := range b.hosts.List() {
+			if containsString(host.Tags, tagID) && !seen[host.ID] {
+				seen[host.ID] = true
+				ids = append(ids, host.ID)
+			}
+		}
+		if b.redirects != nil {
+			for _, id := range b.redirects.HostsWithTag(tagID) {
+				if !seen[id] {
+					seen[id] = true
+					ids = append(ids, id)
+				}
+			}
+		}
+	}
+
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("no hosts selected")
+	}
+
+	// Redirect host IDs go to the redirect manager, everything else is
+	// treated as a proxy host
+	var proxyIDs, redirectIDs []string
+	for _, id := range ids {
+		if b.redirects != nil {
+			if _, err := b.redirects.Get(id); err == nil {
+				redirectIDs = append(redirectIDs, id)
+				continue
+			}
+		}
+		proxyIDs = append(proxyIDs, id)
+	}
+
+	var results []HostBindResult
+	var applyErr error
+	if len(proxyIDs) > 0 {
+		results, applyErr = b.hosts.BindCertificate(ctx, cert, proxyIDs)
+	}
+	if len(redirectIDs) > 0 {
+		redirectResults, redirectErr := b.redirects.BindCertificate(ctx, cert, redirectIDs)
+		results = append(results, redirectResults...)
+		if applyErr == nil {
+			applyErr = redirectErr
+		}
+	}
+	return b.finish(ctx, cert, results, applyErr), nil
+}
+
+// Refresh re-renders every host bound to a certificate and reloads nginx
+func (b *CertificateBinder) Refresh(ctx context.Context, certID string) (*BindReport, error) {
+	cert, err := b.certs.GetCertificate(ctx, certID)
+	if err != nil {
+		return nil, err
+	}
+	return b.refresh(ctx, cert)
+}
+
+func (b *CertificateBinder) refresh(ctx context.Context, cert *Certificate) (*BindReport, error) {
+	return b.apply(ctx, cert, nil)
+}
+
+// apply re-renders every host bound to cert in a single transaction,
+// together with whatever stage adds to it (a certificate's new files), and
+// reloads nginx once. When the shared nginx -t fails nothing is written.
+func (b *CertificateBinder) apply(ctx context.Context, cert *Certificate, stage func(tx *Transaction)) (*BindReport, error) {
+	tx := NewTransaction(b.ctrl)
+	if stage != nil {
+		stage(tx)
+	}
+
+	var refreshes []*certificateRefresh
+	var err error
+	for _, user := range append([]certificateUser{b.hosts}, b.others...) {
+		refresh, stageErr := user.stageCertificateRefresh(tx, cert)
+		if stageErr != nil {
+			err = stageErr
+			break
+		}
+		refreshes = append(refreshes, refresh)
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+
+	var results []HostBindResult
+	for _, refresh := range refreshes {
+		refresh.finish(ctx, err)
+		results = append(results, refresh.results...)
+	}
+	return b.finish(ctx, cert, results, err), err
+}
+
+// finish turns per-host results into a report and reloads nginx once if any
+// host changed
+func (b *CertificateBinder) finish(ctx context.Context, cert *Certificate, results []HostBindResult, applyErr error) *BindReport {
+	report := &BindReport{
+		CertificateID: cert.ID,
+		Results:       results,
+	}
+
+	var testErr *ConfigTestError
+	if errors.As(applyErr, &testErr) {
+		report.ConfigTest = testErr
+	}
+
+	for _, r := range results {
+		if r.OK {
+			report.Updated++
+		}
+	}
+
+	if report.Updated > 0 {
+		if err := b.ctrl.Reload(ctx); err != nil {
+			report.ReloadError = err.Error()
+		}
+	}
+
+	return report
+}
+
+// InUse returns the IDs of every host that references a certificate
+func (b *CertificateBinder) InUse(certID string) []string {
+	ids := b.hosts.HostsUsingCertificate(certID)
+	for _, user := range b.others {
+		ids = append(ids, user.HostsUsingCertificate(certID)...)
+	}
+	return ids
+}
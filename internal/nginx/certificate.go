@@ -2,6 +2,7 @@ package nginx
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -20,7 +21,8 @@ type Certificate struct {
 	CertPath    string    `json:"certPath"`    // Path to certificate file
 	KeyPath     string    `json:"keyPath"`     // Path to private key file
 	ChainPath   string    `json:"chainPath"`   // Path to CA chain (optional)
-	Type        string    `json:"type"`        // "uploaded", "letsencrypt", "self-signed"
+	CRLPath     string    `json:"crlPath"`     // Path to the CRL of a CA bundle (optional)
+	Type        string    `json:"type"`        // "uploaded", "letsencrypt", "self-signed", "ca"
 	ExpiresAt   time.Time `json:"expiresAt"`   // Certificate expiration date
 	AutoRenew   bool      `json:"autoRenew"`   // Auto-renew (for Let's Encrypt)
 	Tags        []string  `json:"tags"`        // Associated tags for bulk operations
@@ -28,6 +30,10 @@ type Certificate struct {
 	UpdatedAt   time.Time `json:"updatedAt"`
 }
 
+// CertificateTypeCA marks a CA bundle used to verify client certificates.
+// It has no private key and cannot be bound as a server certificate.
+const CertificateTypeCA = "ca"
+
 // Tag represents a tag for grouping hosts
 type Tag struct {
 	ID        string    `json:"id"`
@@ -44,6 +50,7 @@ type CertificateManager struct {
 	dataFile     string
 	tagsFile     string
 	certsDir     string // Directory to store cert files
+	applier      func(ctx context.Context, cert *Certificate, stage func(tx *Transaction)) (*BindReport, error)
 }
 
 // NewCertificateManager creates a new certificate manager
@@ -200,6 +207,148 @@ func (m *CertificateManager) CreateCertificate(ctx context.Context, cert *Certif
 	return cert, nil
 }
 
+// CreateCABundle stores a CA bundle used to verify client certificates,
+// together with an optional CRL
+func (m *CertificateManager) CreateCABundle(ctx context.Context, cert *Certificate, bundleContent, crlContent []byte) (*Certificate, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cert.ID = uuid.New().String()
+	cert.Type = CertificateTypeCA
+	cert.CreatedAt = time.Now()
+	cert.UpdatedAt = time.Now()
+
+	certPath := filepath.Join(m.certsDir, cert.ID+".ca.crt")
+	if err := os.WriteFile(certPath, bundleContent, 0644); err != nil {
+		return nil, fmt.Errorf("failed to save CA bundle: %w", err)
+	}
+	cert.CertPath = certPath
+
+	if len(crlContent) > 0 {
+		crlPath := filepath.Join(m.certsDir, cert.ID+".crl")
+		if err := os.WriteFile(crlPath, crlContent, 0644); err != nil {
+			os.Remove(certPath) // Cleanup
+			return nil, fmt.Errorf("failed to save CRL: %w", err)
+		}
+		cert.CRLPath = crlPath
+	}
+
+	m.certs[cert.ID] = cert
+
+	if err := m.save(); err != nil {
+		return nil, err
+	}
+
+	return cert, nil
+}
+
+// ReplaceCRL swaps in a new CRL for a CA bundle. nginx reads it on reload.
+func (m *CertificateManager) ReplaceCRL(ctx context.Context, id string, crlContent []byte) (*Certificate, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cert, ok := m.certs[id]
+	if !ok {
+		return nil, fmt.Errorf("certificate not found: %s", id)
+	}
+	if cert.Type != CertificateTypeCA {
+		return nil, fmt.Errorf("certificate %s is not a CA bundle", id)
+	}
+
+	crlPath := cert.CRLPath
+	if crlPath == "" {
+		crlPath = filepath.Join(m.certsDir, cert.ID+".crl")
+	}
+	if err := writeFileAtomic(crlPath, crlContent, 0644); err != nil {
+		return nil, fmt.Errorf("failed to save CRL: %w", err)
+	}
+
+	cert.CRLPath = crlPath
+	cert.UpdatedAt = time.Now()
+
+	if err := m.save(); err != nil {
+		return nil, err
+	}
+
+	return cert, nil
+}
+
+// setApplier routes certificate file replacements through apply, which
+// stages the new files together with every host bound to the certificate
+// and commits them in one transaction
+func (m *CertificateManager) setApplier(apply func(ctx context.Context, cert *Certificate, stage func(tx *Transaction)) (*BindReport, error)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.applier = apply
+}
+
+// ReplaceCertificateFiles swaps in new certificate and key content for an
+// existing certificate, keeping its ID and file paths. The pair is checked
+// first, then the files are committed together with the hosts bound to the
+// certificate, so a failed nginx -t leaves the old files in place. The report
+// is nil when the replacement was rejected before anything was staged.
+func (m *CertificateManager) ReplaceCertificateFiles(ctx context.Context, id string, certContent, keyContent []byte, expiresAt time.Time) (*Certificate, *BindReport, error) {
+	if _, err := tls.X509KeyPair(certContent, keyContent); err != nil {
+		return nil, nil, fmt.Errorf("invalid certificate or key: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current, ok := m.certs[id]
+	if !ok {
+		return nil, nil, fmt.Errorf("certificate not found: %s", id)
+	}
+	if current.Type == CertificateTypeCA {
+		return nil, nil, fmt.Errorf("certificate %s is a CA bundle and has no key to replace", id)
+	}
+
+	cert := *current
+	if cert.CertPath == "" {
+		cert.CertPath = filepath.Join(m.certsDir, cert.ID+".crt")
+	}
+	if cert.KeyPath == "" {
+		cert.KeyPath = filepath.Join(m.certsDir, cert.ID+".key")
+	}
+	if !expiresAt.IsZero() {
+		cert.ExpiresAt = expiresAt
+	}
+	cert.UpdatedAt = time.Now()
+
+	certs := make([]*Certificate, 0, len(m.certs))
+	for _, c := range m.certs {
+		if c.ID == id {
+			c = &cert
+		}
+		certs = append(certs, c)
+	}
+	data, err := json.MarshalIndent(certs, "", "  ")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stage := func(tx *Transaction) {
+		tx.WriteFile(cert.KeyPath, keyContent, 0600)
+		tx.WriteFile(cert.CertPath, certContent, 0644)
+		tx.WriteState(m.dataFile, data)
+	}
+
+	var report *BindReport
+	if m.applier != nil {
+		report, err = m.applier(ctx, &cert, stage)
+	} else {
+		tx := NewTransaction(nil)
+		stage(tx)
+		err = tx.Commit(ctx)
+	}
+	if err != nil {
+		return nil, report, err
+	}
+
+	m.certs[id] = &cert
+	return &cert, report, nil
+}
+
 // UpdateCertificate updates a certificate
 func (m *CertificateManager) UpdateCertificate(ctx context.Context, id string, updates *Certificate) (*Certificate, error) {
 	m.mu.Lock()
@@ -243,6 +392,9 @@ func (m *CertificateManager) DeleteCertificate(ctx context.Context, id string) e
 	if cert.ChainPath != "" {
 		os.Remove(cert.ChainPath)
 	}
+	if cert.CRLPath != "" {
+		os.Remove(cert.CRLPath)
+	}
 
 	delete(m.certs, id)
 
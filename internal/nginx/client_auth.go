@@ -0,0 +1,122 @@
+package nginx
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Client certificate verification modes
+const (
+	ClientVerifyOn       = "on"
+	ClientVerifyOptional = "optional"
+)
+
+// clientCertHeaders are the request headers carrying the verification result
+// to the backends, with the variables filling them
+var clientCertHeaders = []struct{ name, variable string }{
+	{"X-Client-Verify", "$ssl_client_verify"},
+	{"X-Client-Subject", "$ssl_client_s_dn"},
+	{"X-Client-Fingerprint", "$ssl_client_fingerprint"},
+}
+
+// ClientAuth requires clients to present a certificate issued by a CA bundle
+// from the certificate store (mutual TLS)
+type ClientAuth struct {
+	CAID           string `json:"caId"`           // CA bundle in the certificate store
+	CAPath         string `json:"caPath"`         // Path to the CA bundle (filled in from caId)
+	CRLPath        string `json:"crlPath"`        // Path to the bundle's CRL (filled in from caId)
+	Verify         string `json:"verify"`         // on (default) rejects clients without a valid certificate, optional lets them through
+	Depth          int    `json:"depth"`          // Maximum length of the client's chain (default 1)
+	CheckCRL       bool   `json:"checkCrl"`       // Reject certificates revoked by the bundle's CRL
+	ForwardHeaders bool   `json:"forwardHeaders"` // Pass the verification result, subject and fingerprint to the backends
+}
+
+// validateClientAuth checks optional client certificate verification against
+// the host it belongs to
+func validateClientAuth(host *ProxyHost) error {
+	c := host.ClientAuth
+	if c == nil {
+		return nil
+	}
+
+	if !host.SSL {
+		return fmt.Errorf("client certificate verification requires ssl to be enabled")
+	}
+	if host.CertPath == "" || host.KeyPath == "" {
+		return fmt.Errorf("client certificate verification requires a certificate to be bound to the host")
+	}
+	// ssl_verify_client only applies to TLS connections
+	if !host.ForceSSL {
+		return fmt.Errorf("client certificate verification requires forceSSL so plain HTTP requests cannot skip it")
+	}
+	if c.CAID == "" || c.CAPath == "" {
+		return fmt.Errorf("client certificate verification requires a CA bundle")
+	}
+
+	if c.Verify == "" {
+		c.Verify = ClientVerifyOn
+	}
+	if c.Verify != ClientVerifyOn && c.Verify != ClientVerifyOptional {
+		return fmt.Errorf("invalid client certificate verification mode: %s (expected on or optional)", c.Verify)
+	}
+	if c.Depth == 0 {
+		c.Depth = 1
+	}
+	if c.Depth < 1 || c.Depth > 10 {
+		return fmt.Errorf("client certificate verify depth must be between 1 and 10")
+	}
+	if c.CheckCRL && c.CRLPath == "" {
+		return fmt.Errorf("CA bundle %s has no CRL to check", c.CAID)
+	}
+
+	// Forwarded headers must carry nginx's result, not the client's
+	if c.ForwardHeaders {
+		rules := append([]HeaderRule{}, host.HeaderRules...)
+		for _, l := range host.Locations {
+			rules = append(rules, l.HeaderRules...)
+		}
+		for _, r := range rules {
+			if !r.requestSide() {
+				continue
+			}
+			for _, h := range clientCertHeaders {
+				if strings.EqualFold(r.Name, h.name) {
+					return fmt.Errorf("header %s is set from the client certificate and cannot have header rules", r.Name)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Directives returns the server directives verifying client certificates
+func (c *ClientAuth) Directives() []string {
+	lines := []string{
+		"ssl_client_certificate " + c.CAPath + ";",
+		"ssl_verify_client " + c.Verify + ";",
+		fmt.Sprintf("ssl_verify_depth %d;", c.Depth),
+	}
+	if c.CheckCRL {
+		lines = append(lines, "ssl_crl "+c.CRLPath+";")
+	}
+	return lines
+}
+
+// clientCertDirectives returns the proxy_set_header directives forwarding
+// the client certificate, repeated in every proxied location. They are
+// always set so clients cannot send their own values.
+func (h *ProxyHost) clientCertDirectives() []string {
+	if h.ClientAuth == nil || !h.ClientAuth.ForwardHeaders {
+		return nil
+	}
+	var lines []string
+	for _, ch := range clientCertHeaders {
+		lines = append(lines, fmt.Sprintf("proxy_set_header %s %s;", ch.name, ch.variable))
+	}
+	return lines
+}
+
+// usesClientCA reports whether the host verifies clients against a CA bundle
+func (h *ProxyHost) usesClientCA(caID string) bool {
+	return h.ClientAuth != nil && h.ClientAuth.CAID == caID
+}
@@ -0,0 +1,169 @@
+package nginx
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// MIME type presets for compression. text/html is always compressed by
+// nginx and must not be listed.
+var compressionPresets = map[string][]string{
+	"web": {
+		"text/css", "text/plain", "text/xml", "text/javascript",
+		"application/javascript", "application/json", "application/xml",
+		"application/rss+xml", "image/svg+xml",
+	},
+	"api": {
+		"application/json", "application/problem+json", "application/xml",
+		"application/x-ndjson", "text/plain",
+	},
+	"text": {
+		"text/css", "text/plain", "text/xml", "text/javascript", "text/csv",
+		"text/markdown",
+	},
+}
+
+// defaultCompressionPreset is used when no preset is given
+const defaultCompressionPreset = "web"
+
+// gzipProxiedValues are the parameters gzip_proxied accepts
+var gzipProxiedValues = map[string]bool{
+	"off": true, "expired": true, "no-cache": true, "no-store": true, "private": true,
+	"no_last_modified": true, "no_etag": true, "auth": true, "any": true,
+}
+
+// mimeTypeRegex matches a MIME type like application/vnd.api+json
+var mimeTypeRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9.+-]*/[a-z0-9*][a-z0-9.+*-]*$`)
+
+// CompressionSettings configures response compression for a host. A nil
+// value leaves compression to the main nginx.conf.
+type CompressionSettings struct {
+	Gzip        bool     `json:"gzip"`        // gzip on or off
+	Level       int      `json:"level"`       // gzip_comp_level 1-9 (defaults to 5)
+	MinLength   int      `json:"minLength"`   // Smallest response compressed, in bytes (defaults to 1024)
+	Preset      string   `json:"preset"`      // MIME type preset: web (default), api or text
+	Types       []string `json:"types"`       // MIME types compressed in addition to the preset
+	Proxied     string   `json:"proxied"`     // gzip_proxied for requests via proxies, e.g. "any" (default) or "expired no-cache"
+	Brotli      bool     `json:"brotli"`      // Also compress with brotli (needs the ngx_brotli module)
+	BrotliLevel int      `json:"brotliLevel"` // brotli_comp_level 1-11 (defaults to 6)
+}
+
+// Validate checks the settings
+func (c *CompressionSettings) Validate() error {
+	if c.Level < 0 || c.Level > 9 {
+		return fmt.Errorf("gzip level must be between 1 and 9")
+	}
+	if c.BrotliLevel < 0 || c.BrotliLevel > 11 {
+		return fmt.Errorf("brotli level must be between 1 and 11")
+	}
+	if c.MinLength < 0 {
+		return fmt.Errorf("compression minLength must not be negative")
+	}
+	if c.Preset != "" {
+		if _, ok := compressionPresets[c.Preset]; !ok {
+			return fmt.Errorf("invalid compression preset: %s (expected web, api or text)", c.Preset)
+		}
+	}
+	for _, t := range c.Types {
+		if !mimeTypeRegex.MatchString(t) {
+			return fmt.Errorf("invalid MIME type: %q", t)
+		}
+	}
+
+	proxied := strings.Fields(c.Proxied)
+	for _, p := range proxied {
+		if !gzipProxiedValues[p] {
+			return fmt.Errorf("invalid gzip proxied value: %s", p)
+		}
+		if (p == "off" || p == "any") && len(proxied) > 1 {
+			return fmt.Errorf("gzip proxied %s cannot be combined with other values", p)
+		}
+	}
+	return nil
+}
+
+// validateCompression checks optional compression settings
+func validateCompression(c *CompressionSettings) error {
+	if c == nil {
+		return nil
+	}
+	return c.Validate()
+}
+
+// MimeTypes returns the compressed MIME types, sorted and without duplicates
+func (c *CompressionSettings) MimeTypes() string {
+	preset := c.Preset
+	if preset == "" {
+		preset = defaultCompressionPreset
+	}
+
+	seen := map[string]bool{"text/html": true}
+	var types []string
+	for _, t := range append(append([]string{}, compressionPresets[preset]...), c.Types...) {
+		if !seen[t] {
+			seen[t] = true
+			types = append(types, t)
+		}
+	}
+	sort.Strings(types)
+	return strings.Join(types, " ")
+}
+
+// Directives returns the compression directives of the server block
+func (c *CompressionSettings) Directives() []string {
+	minLength := c.MinLength
+	if minLength == 0 {
+		minLength = 1024
+	}
+
+	var lines []string
+	if !c.Gzip {
+		lines = append(lines, "gzip off;")
+	} else {
+		level := c.Level
+		if level == 0 {
+			level = 5
+		}
+		proxied := strings.Join(strings.Fields(c.Proxied), " ")
+		if proxied == "" {
+			proxied = "any"
+		}
+		lines = append(lines,
+			"gzip on;",
+			fmt.Sprintf("gzip_comp_level %d;", level),
+			fmt.Sprintf("gzip_min_length %d;", minLength),
+			fmt.Sprintf("gzip_proxied %s;", proxied),
+			"gzip_vary on;",
+			fmt.Sprintf("gzip_types %s;", c.MimeTypes()),
+		)
+	}
+
+	if c.Brotli {
+		level := c.BrotliLevel
+		if level == 0 {
+			level = 6
+		}
+		lines = append(lines,
+			"brotli on;",
+			fmt.Sprintf("brotli_comp_level %d;", level),
+			fmt.Sprintf("brotli_min_length %d;", minLength),
+			fmt.Sprintf("brotli_types %s;", c.MimeTypes()),
+		)
+	}
+	return lines
+}
+
+// checkCompression refuses brotli when the installed nginx lacks the module,
+// as nginx -t would fail on the unknown directives
+func (m *ProxyHostManager) checkCompression(host *ProxyHost) error {
+	if host.Compression == nil || !host.Compression.Brotli {
+		return nil
+	}
+	if !m.ctrl.HasModule(context.Background(), "brotli") {
+		return fmt.Errorf("brotli compression is not available: nginx -V does not list the brotli module")
+	}
+	return nil
+}
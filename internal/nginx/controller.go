@@ -5,18 +5,29 @@ import (
 	"errors"
 	"fmt"
 	"os/exec"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 const (
-	defaultBinary = "nginx"
-	cmdTimeout    = 5 * time.Second
+	defaultBinary   = "nginx"
+	defaultConfPath = "/etc/nginx/nginx.conf"
+	cmdTimeout      = 5 * time.Second
 )
 
 // Controller provides a thin wrapper around the nginx binary.
 type Controller struct {
 	binary string
+
+	// applyMu serialises config transactions so two writers never test or
+	// roll back each other's half-applied files.
+	applyMu sync.Mutex
+
+	buildMu   sync.Mutex
+	buildInfo string // cached `nginx -V` output
 }
 
 // NewController returns a Controller, falling back to the default binary name when empty.
@@ -61,6 +72,101 @@ func (c *Controller) CheckConfig(ctx context.Context) (string, error) {
 	return c.run(ctx, "-t")
 }
 
+// ConfigIssue is a single diagnostic line reported by `nginx -t`.
+type ConfigIssue struct {
+	Level   string `json:"level"` // emerg, alert, crit, warn, ...
+	Message string `json:"message"`
+	File    string `json:"file,omitempty"`
+	Line    int    `json:"line,omitempty"`
+}
+
+// ConfigTestError is returned when a staged config change fails `nginx -t`.
+type ConfigTestError struct {
+	Output string        `json:"output"`
+	Issues []ConfigIssue `json:"issues"`
+}
+
+func (e *ConfigTestError) Error() string {
+	for _, issue := range e.Issues {
+		if issue.Level == "emerg" || issue.Level == "alert" || issue.Level == "crit" {
+			if issue.File != "" {
+				return fmt.Sprintf("nginx config test failed: %s (%s:%d)", issue.Message, issue.File, issue.Line)
+			}
+			return "nginx config test failed: " + issue.Message
+		}
+	}
+	if e.Output == "" {
+		return "nginx config test failed"
+	}
+	return "nginx config test failed: " + e.Output
+}
+
+var configIssueRegex = regexp.MustCompile(`^nginx: \[(\w+)\] (.*?)(?: in (\S+):(\d+))?$`)
+
+// parseConfigTest turns raw `nginx -t` output into a ConfigTestError.
+func parseConfigTest(output string) *ConfigTestError {
+	result := &ConfigTestError{Output: output, Issues: []ConfigIssue{}}
+	for _, line := range strings.Split(output, "\n") {
+		match := configIssueRegex.FindStringSubmatch(strings.TrimSpace(line))
+		if match == nil {
+			continue
+		}
+		issue := ConfigIssue{Level: match[1], Message: match[2], File: match[3]}
+		if match[4] != "" {
+			issue.Line, _ = strconv.Atoi(match[4])
+		}
+		result.Issues = append(result.Issues, issue)
+	}
+	return result
+}
+
+// TestConfig runs `nginx -t` and returns a *ConfigTestError describing the
+// failure, or nil when the configuration is valid.
+func (c *Controller) TestConfig(ctx context.Context) error {
+	output, err := c.CheckConfig(ctx)
+	return testResult(output, err)
+}
+
+// TestConfigFile runs `nginx -t` against a standalone main config, with
+// prefix as the nginx prefix path so nothing outside it is touched.
+func (c *Controller) TestConfigFile(ctx context.Context, prefix, path string) error {
+	output, err := c.run(ctx, "-t", "-p", prefix, "-c", path)
+	return testResult(output, err)
+}
+
+// TestConfigCopy runs `nginx -t` against a copy of the main config at path.
+// nginx's own prefix is kept so relative paths outside the config directory
+// resolve as they do for the live config.
+func (c *Controller) TestConfigCopy(ctx context.Context, path string) error {
+	args := []string{"-t", "-c", path}
+	if prefix := c.configureArg(ctx, "--prefix"); prefix != "" {
+		args = append(args, "-p", prefix)
+	}
+	output, err := c.run(ctx, args...)
+	return testResult(output, err)
+}
+
+// ConfPath returns the main config file nginx loads, falling back to
+// /etc/nginx/nginx.conf when the build does not say.
+func (c *Controller) ConfPath(ctx context.Context) string {
+	if path := c.configureArg(ctx, "--conf-path"); path != "" {
+		return path
+	}
+	return defaultConfPath
+}
+
+// testResult converts the outcome of an `nginx -t` run into a
+// *ConfigTestError, or nil when it passed.
+func testResult(output string, err error) error {
+	if err != nil {
+		if output == "" {
+			output = err.Error()
+		}
+		return parseConfigTest(output)
+	}
+	return nil
+}
+
 // Reload asks nginx to reload its configuration.
 func (c *Controller) Reload(ctx context.Context) error {
 	_, err := c.run(ctx, "-s", "reload")
@@ -72,6 +178,71 @@ func (c *Controller) Version(ctx context.Context) (string, error) {
 	return c.run(ctx, "-v")
 }
 
+// BuildInfo returns the `nginx -V` output listing the configure arguments.
+// It is cached after the first successful call.
+func (c *Controller) BuildInfo(ctx context.Context) (string, error) {
+	c.buildMu.Lock()
+	defer c.buildMu.Unlock()
+
+	if c.buildInfo != "" {
+		return c.buildInfo, nil
+	}
+	output, err := c.run(ctx, "-V")
+	if err != nil {
+		return "", err
+	}
+	c.buildInfo = output
+	return output, nil
+}
+
+// configureArg returns the value of a configure argument such as
+// "--conf-path", or "" when nginx was built without it.
+func (c *Controller) configureArg(ctx context.Context, name string) string {
+	info, err := c.BuildInfo(ctx)
+	if err != nil {
+		return ""
+	}
+	for _, arg := range strings.Fields(info) {
+		if value, ok := strings.CutPrefix(arg, name+"="); ok {
+			return value
+		}
+	}
+	return ""
+}
+
+// HasModule reports whether nginx was built with a module whose configure
+// argument mentions name, e.g. "brotli" for --add-module=../ngx_brotli.
+func (c *Controller) HasModule(ctx context.Context, name string) bool {
+	if c == nil {
+		return false
+	}
+	info, err := c.BuildInfo(ctx)
+	if err != nil {
+		return false
+	}
+	for _, arg := range strings.Fields(info) {
+		if strings.HasPrefix(arg, "--add-module=") || strings.HasPrefix(arg, "--add-dynamic-module=") || strings.HasPrefix(arg, "--with-") {
+			if strings.Contains(arg, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// IsPlus reports whether nginx is NGINX Plus, whose version line names the
+// nginx-plus release, e.g. "nginx/1.25.3 (nginx-plus-r31)".
+func (c *Controller) IsPlus(ctx context.Context) bool {
+	if c == nil {
+		return false
+	}
+	info, err := c.BuildInfo(ctx)
+	if err != nil {
+		return false
+	}
+	return strings.Contains(info, "nginx-plus")
+}
+
 // Status collects version and config test results.
 func (c *Controller) Status(ctx context.Context) (*Status, error) {
 	configOutput, configErr := c.CheckConfig(ctx)
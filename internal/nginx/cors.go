@@ -0,0 +1,626 @@
+package nginx
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// defaultCORSMethods are allowed when a policy lists no methods
+var defaultCORSMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"}
+
+// corsMethodRegex matches HTTP method names
+var corsMethodRegex = regexp.MustCompile(`^[A-Z]+$`)
+
+// corsNameRegex matches characters not allowed in CORS variable names
+var corsNameRegex = regexp.MustCompile(`[^a-zA-Z0-9]`)
+
+// corsResponseHeaders are the headers a policy answers with. Backends' own
+// values are hidden so they cannot duplicate or contradict the policy.
+var corsResponseHeaders = []string{
+	"Access-Control-Allow-Origin",
+	"Access-Control-Allow-Credentials",
+	"Access-Control-Allow-Methods",
+	"Access-Control-Allow-Headers",
+	"Access-Control-Expose-Headers",
+	"Access-Control-Max-Age",
+}
+
+// CORSPolicy is a reusable cross-origin resource sharing policy. Allowed
+// origins get the CORS response headers and preflight requests from them are
+// answered by nginx without reaching the backend.
+type CORSPolicy struct {
+	ID               string    `json:"id"`
+	Name             string    `json:"name"`
+	AllowedOrigins   []string  `json:"allowedOrigins"`   // e.g., ["https://app.example.com"], or ["*"] for any origin
+	OriginRegex      string    `json:"originRegex"`      // Anchored regex matching more origins, e.g. "^https://[a-z0-9-]+\.example\.com$"
+	AllowedMethods   []string  `json:"allowedMethods"`   // Methods allowed in preflights (defaults to GET, HEAD, POST, PUT, PATCH, DELETE)
+	AllowedHeaders   []string  `json:"allowedHeaders"`   // Request headers allowed in preflights (empty = those the client asks for)
+	ExposedHeaders   []string  `json:"exposedHeaders"`   // Response headers readable by scripts
+	AllowCredentials bool      `json:"allowCredentials"` // Allow cookies and authorization headers
+	MaxAge           int       `json:"maxAge"`           // Seconds browsers may cache a preflight (0 = browser default)
+	CreatedAt        time.Time `json:"createdAt"`
+	UpdatedAt        time.Time `json:"updatedAt"`
+}
+
+// validateCORSPolicy normalizes and checks a policy
+func validateCORSPolicy(p *CORSPolicy) error {
+	p.Name = strings.TrimSpace(p.Name)
+	if p.Name == "" {
+		return fmt.Errorf("CORS policy name is required")
+	}
+
+	if len(p.AllowedOrigins) == 0 && p.OriginRegex == "" {
+		return fmt.Errorf("CORS policy requires allowed origins or an origin regex")
+	}
+	seen := make(map[string]bool)
+	for i, origin := range p.AllowedOrigins {
+		if origin == "*" {
+			if len(p.AllowedOrigins) > 1 || p.OriginRegex != "" {
+				return fmt.Errorf("the * origin cannot be combined with other origins")
+			}
+			if p.AllowCredentials {
+				return fmt.Errorf("credentials cannot be allowed for the * origin")
+			}
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil ||
+			(u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" || strings.ContainsAny(u.Host, "\"\\$ ") {
+			return fmt.Errorf("invalid CORS origin: %q (expected scheme://host[:port])", origin)
+		}
+		// Browsers send origins in lowercase without a trailing slash
+		p.AllowedOrigins[i] = strings.ToLower(u.Scheme + "://" + u.Host)
+		if seen[p.AllowedOrigins[i]] {
+			return fmt.Errorf("duplicate CORS origin: %s", origin)
+		}
+		seen[p.AllowedOrigins[i]] = true
+	}
+
+	if p.OriginRegex != "" {
+		if !strings.HasPrefix(p.OriginRegex, "^") || !strings.HasSuffix(p.OriginRegex, "$") {
+			return fmt.Errorf("CORS origin regex must be anchored with ^ and $")
+		}
+		if strings.ContainsAny(p.OriginRegex, "\"'") || strings.IndexFunc(p.OriginRegex, func(c rune) bool { return c < 0x20 || c == 0x7f }) >= 0 {
+			return fmt.Errorf("CORS origin regex must not contain quotes or control characters")
+		}
+		if _, err := regexp.Compile(p.OriginRegex); err != nil {
+			return fmt.Errorf("invalid CORS origin regex: %w", err)
+		}
+	}
+
+	if len(p.AllowedMethods) == 0 {
+		p.AllowedMethods = append([]string(nil), defaultCORSMethods...)
+	}
+	for i, method := range p.AllowedMethods {
+		p.AllowedMethods[i] = strings.ToUpper(strings.TrimSpace(method))
+		if !corsMethodRegex.MatchString(p.AllowedMethods[i]) {
+			return fmt.Errorf("invalid CORS method: %q", method)
+		}
+	}
+	for _, name := range append(append([]string(nil), p.AllowedHeaders...), p.ExposedHeaders...) {
+		if !limitHeaderRegex.MatchString(name) {
+			return fmt.Errorf("invalid CORS header name: %q", name)
+		}
+	}
+
+	if p.MaxAge < 0 || p.MaxAge > 86400 {
+		return fmt.Errorf("CORS max age must be between 0 and 86400 seconds")
+	}
+	return nil
+}
+
+// wildcard reports whether the policy allows any origin
+func (p *CORSPolicy) wildcard() bool {
+	return len(p.AllowedOrigins) == 1 && p.AllowedOrigins[0] == "*"
+}
+
+// varName returns the name of one of the policy's http-level variables
+func (p *CORSPolicy) varName(kind string) string {
+	return "$nubi_cors_" + kind + "_" + corsNameRegex.ReplaceAllString(p.ID, "_")
+}
+
+// Definitions returns the http-level maps of the policy. The origin map
+// echoes allowed origins and is empty for the others; the preflight map is 1
+// for preflight requests of allowed origins. Headers only sent on some
+// responses are mapped to empty values elsewhere, which add_header skips.
+func (p *CORSPolicy) Definitions() []string {
+	origin := p.varName("origin")
+	preflight := p.varName("preflight")
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "map $http_origin %s {\n", origin)
+	if p.wildcard() {
+		b.WriteString("    \"\" \"\";\n    default \"*\";\n")
+	} else {
+		b.WriteString("    default \"\";\n")
+		for _, o := range p.AllowedOrigins {
+			fmt.Fprintf(&b, "    \"%s\" $http_origin;\n", o)
+		}
+		if p.OriginRegex != "" {
+			fmt.Fprintf(&b, "    \"~%s\" $http_origin;\n", p.OriginRegex)
+		}
+	}
+	b.WriteString("}")
+	defs := []string{b.String()}
+
+	defs = append(defs, fmt.Sprintf("map \"$request_method:$http_access_control_request_method:%s\" %s {\n    default 0;\n    \"~^OPTIONS:[^:]+:.\" 1;\n}", origin, preflight))
+
+	// Preflight answers
+	defs = append(defs, fmt.Sprintf("map %s %s {\n    default \"\";\n    1 \"%s\";\n}", preflight, p.varName("methods"), strings.Join(p.AllowedMethods, ", ")))
+	headers := "$http_access_control_request_headers"
+	if len(p.AllowedHeaders) > 0 {
+		headers = "\"" + strings.Join(p.AllowedHeaders, ", ") + "\""
+	}
+	defs = append(defs, fmt.Sprintf("map %s %s {\n    default \"\";\n    1 %s;\n}", preflight, p.varName("headers"), headers))
+	if p.MaxAge > 0 {
+		defs = append(defs, fmt.Sprintf("map %s %s {\n    default \"\";\n    1 \"%d\";\n}", preflight, p.varName("max_age"), p.MaxAge))
+	}
+
+	// Sent on every response to an allowed origin
+	if p.AllowCredentials {
+		defs = append(defs, fmt.Sprintf("map %s %s {\n    \"\" \"\";\n    default \"true\";\n}", origin, p.varName("credentials")))
+	}
+	if len(p.ExposedHeaders) > 0 {
+		defs = append(defs, fmt.Sprintf("map %s %s {\n    \"\" \"\";\n    default \"%s\";\n}", origin, p.varName("expose"), strings.Join(p.ExposedHeaders, ", ")))
+	}
+	return defs
+}
+
+// HeaderDirectives returns the add_header directives of the policy. They use
+// always so error responses are readable by scripts too.
+func (p *CORSPolicy) HeaderDirectives() []string {
+	lines := []string{
+		"# CORS policy: " + p.Name,
+		fmt.Sprintf("add_header Access-Control-Allow-Origin %s always;", p.varName("origin")),
+	}
+	if p.AllowCredentials {
+		lines = append(lines, fmt.Sprintf("add_header Access-Control-Allow-Credentials %s always;", p.varName("credentials")))
+	}
+	if len(p.ExposedHeaders) > 0 {
+		lines = append(lines, fmt.Sprintf("add_header Access-Control-Expose-Headers %s always;", p.varName("expose")))
+	}
+	lines = append(lines,
+		fmt.Sprintf("add_header Access-Control-Allow-Methods %s always;", p.varName("methods")),
+		fmt.Sprintf("add_header Access-Control-Allow-Headers %s always;", p.varName("headers")),
+	)
+	if p.MaxAge > 0 {
+		lines = append(lines, fmt.Sprintf("add_header Access-Control-Max-Age %s always;", p.varName("max_age")))
+	}
+	// Responses differ by origin unless every origin gets "*"
+	if !p.wildcard() {
+		lines = append(lines, "add_header Vary Origin always;")
+	}
+	return lines
+}
+
+// LocationDirectives returns the directives of every proxied location under
+// the policy: preflights are answered directly and the backend's own CORS
+// headers are hidden
+func (p *CORSPolicy) LocationDirectives() []string {
+	lines := []string{
+		"# CORS preflight",
+		fmt.Sprintf("if (%s) {\n            return 204;\n        }", p.varName("preflight")),
+	}
+	for _, name := range corsResponseHeaders {
+		lines = append(lines, "proxy_hide_header "+name+";")
+	}
+	return lines
+}
+
+// CORSPolicyManager stores the shared library of CORS policies
+type CORSPolicyManager struct {
+	mu       sync.RWMutex
+	policies map[string]*CORSPolicy
+	ctrl     *Controller      // used to run transactions
+	history  *HistoryStore    // optional revision log
+	dataFile string           // e.g., /var/lib/nubi/cors_policies.json
+	users    []corsPolicyUser // host managers re-rendered on change, see Track
+
+	// applyMu serialises changes. A change stages the hosts using the policy
+	// without holding mu, so their templates can still read other policies.
+	applyMu sync.Mutex
+}
+
+// corsPolicyUser is a host manager whose hosts render CORS policies
+type corsPolicyUser interface {
+	stageCORSPolicyRefresh(tx *Transaction, policyID string, policies map[string]*CORSPolicy) (*hostRefresh, error)
+}
+
+// NewCORSPolicyManager creates a new CORS policy manager
+func NewCORSPolicyManager(ctrl *Controller, dataFile string) (*CORSPolicyManager, error) {
+	if dataFile == "" {
+		dataFile = "/var/lib/nubi/cors_policies.json"
+	}
+
+	mgr := &CORSPolicyManager{
+		policies: make(map[string]*CORSPolicy),
+		ctrl:     ctrl,
+		dataFile: dataFile,
+	}
+
+	if err := mgr.load(); err != nil {
+		// Not a fatal error - might be first run
+		fmt.Printf("Note: Could not load existing CORS policies: %v\n", err)
+	}
+
+	return mgr, nil
+}
+
+// load reads policies from the JSON data file
+func (m *CORSPolicyManager) load() error {
+	data, err := os.ReadFile(m.dataFile)
+	if err != nil {
+		return err
+	}
+
+	var policies []*CORSPolicy
+	if err := json.Unmarshal(data, &policies); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.policies = make(map[string]*CORSPolicy)
+	for _, p := range policies {
+		m.policies[p.ID] = p
+	}
+
+	return nil
+}
+
+// SetHistory attaches a revision log; committed changes are recorded to it
+func (m *CORSPolicyManager) SetHistory(history *HistoryStore) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.history = history
+}
+
+// Track makes changes to a policy re-render the hosts of user that use it,
+// together with the http-level maps, in the same transaction as the policy.
+// Call it once at startup, before serving requests.
+func (m *CORSPolicyManager) Track(user corsPolicyUser) {
+	m.users = append(m.users, user)
+}
+
+// List returns all policies ordered by name
+func (m *CORSPolicyManager) List() []*CORSPolicy {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	policies := make([]*CORSPolicy, 0, len(m.policies))
+	for _, p := range m.policies {
+		policies = append(policies, p)
+	}
+	sort.Slice(policies, func(i, j int) bool { return policies[i].Name < policies[j].Name })
+	return policies
+}
+
+// Get returns a policy by ID
+func (m *CORSPolicyManager) Get(id string) (*CORSPolicy, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	policy, ok := m.policies[id]
+	if !ok {
+		return nil, fmt.Errorf("CORS policy not found: %s", id)
+	}
+	return policy, nil
+}
+
+// Create adds a policy to the library
+func (m *CORSPolicyManager) Create(ctx context.Context, policy *CORSPolicy) error {
+	if err := validateCORSPolicy(policy); err != nil {
+		return err
+	}
+
+	m.applyMu.Lock()
+	defer m.applyMu.Unlock()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	policy.ID = uuid.New().String()
+	policy.CreatedAt = time.Now()
+	policy.UpdatedAt = time.Now()
+
+	next := m.clonePolicies()
+	next[policy.ID] = policy
+
+	return m.commit(ctx, "create", next, policy, nil)
+}
+
+// Update replaces a policy. Every host using it is re-rendered and committed
+// together with it.
+func (m *CORSPolicyManager) Update(ctx context.Context, id string, updates *CORSPolicy) error {
+	m.applyMu.Lock()
+	defer m.applyMu.Unlock()
+
+	current, err := m.Get(id)
+	if err != nil {
+		return err
+	}
+
+	policy := *updates
+	policy.ID = id
+	policy.CreatedAt = current.CreatedAt
+	policy.UpdatedAt = time.Now()
+	if err := validateCORSPolicy(&policy); err != nil {
+		return err
+	}
+
+	m.mu.RLock()
+	next := m.clonePolicies()
+	m.mu.RUnlock()
+	next[id] = &policy
+
+	return m.commitWithHosts(ctx, "update", next, &policy)
+}
+
+// Delete removes a policy. Callers must make sure no host uses it.
+func (m *CORSPolicyManager) Delete(ctx context.Context, id string) error {
+	m.applyMu.Lock()
+	defer m.applyMu.Unlock()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	policy, ok := m.policies[id]
+	if !ok {
+		return fmt.Errorf("CORS policy not found: %s", id)
+	}
+
+	next := m.clonePolicies()
+	delete(next, id)
+
+	return m.commit(ctx, "delete", next, nil, policy)
+}
+
+// Restore puts a policy back to a recorded state. A nil snapshot deletes the
+// policy.
+func (m *CORSPolicyManager) Restore(ctx context.Context, id string, snapshot *CORSPolicy) error {
+	m.applyMu.Lock()
+	defer m.applyMu.Unlock()
+
+	m.mu.RLock()
+	current, exists := m.policies[id]
+	next := m.clonePolicies()
+	m.mu.RUnlock()
+
+	if snapshot == nil {
+		if !exists {
+			return nil
+		}
+		delete(next, id)
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		return m.commit(ctx, "restore", next, nil, current)
+	}
+
+	policy := *snapshot
+	policy.ID = id
+	policy.UpdatedAt = time.Now()
+	if err := validateCORSPolicy(&policy); err != nil {
+		return err
+	}
+	next[id] = &policy
+
+	return m.commitWithHosts(ctx, "restore", next, &policy)
+}
+
+func (m *CORSPolicyManager) clonePolicies() map[string]*CORSPolicy {
+	next := make(map[string]*CORSPolicy, len(m.policies))
+	for id, p := range m.policies {
+		next[id] = p
+	}
+	return next
+}
+
+// commit writes the data file and records the change. Policies are rendered
+// into the configs of the hosts using them, not here. Callers must hold m.mu.
+func (m *CORSPolicyManager) commit(ctx context.Context, action string, next map[string]*CORSPolicy, written, removed *CORSPolicy) error {
+	tx := NewTransaction(m.ctrl)
+	if err := m.stageCommit(tx, next); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	m.swap(ctx, action, next, written, removed)
+	return nil
+}
+
+// commitWithHosts commits a changed policy together with the re-rendered
+// hosts using it, so history never records a policy nginx rejected. Callers
+// hold m.applyMu but not m.mu.
+func (m *CORSPolicyManager) commitWithHosts(ctx context.Context, action string, next map[string]*CORSPolicy, written *CORSPolicy) error {
+	tx := NewTransaction(m.ctrl)
+	if err := m.stageCommit(tx, next); err != nil {
+		return err
+	}
+
+	var refreshes []*hostRefresh
+	var err error
+	for _, user := range m.users {
+		refresh, stageErr := user.stageCORSPolicyRefresh(tx, written.ID, next)
+		if stageErr != nil {
+			err = stageErr
+			break
+		}
+		refreshes = append(refreshes, refresh)
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+	if err == nil {
+		m.mu.Lock()
+		m.swap(ctx, action, next, written, nil)
+		m.mu.Unlock()
+	}
+
+	for _, refresh := range refreshes {
+		refresh.finish(ctx, err)
+	}
+	return err
+}
+
+// stageCommit stages the data file into tx
+func (m *CORSPolicyManager) stageCommit(tx *Transaction, next map[string]*CORSPolicy) error {
+	list := make([]*CORSPolicy, 0, len(next))
+	for _, p := range next {
+		list = append(list, p)
+	}
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	tx.WriteState(m.dataFile, data)
+	return nil
+}
+
+// swap makes a committed policy set live and records the change. Callers
+// must hold m.mu.
+func (m *CORSPolicyManager) swap(ctx context.Context, action string, next map[string]*CORSPolicy, written, removed *CORSPolicy) {
+	prev := m.policies
+	m.policies = next
+
+	if m.history != nil {
+		changed := written
+		if changed == nil {
+			changed = removed
+		}
+		rev := &Revision{
+			Entity:   EntityCORSPolicy,
+			EntityID: changed.ID,
+			Action:   action,
+			Before:   marshalRevisionState(prev[changed.ID]),
+			After:    marshalRevisionState(next[changed.ID]),
+		}
+		if err := m.history.Record(ctx, rev); err != nil {
+			log.Printf("warning: failed to record history for CORS policy %s: %v", changed.ID, err)
+		}
+	}
+}
+
+// SetCORSPolicies attaches the CORS policy library used by hosts and
+// locations referencing policies
+func (m *ProxyHostManager) SetCORSPolicies(policies *CORSPolicyManager) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.corsPolicies = policies
+}
+
+// corsPolicy resolves a policy for the template, from the policies of a
+// staged policy change while one is in progress. Callers hold m.mu.
+func (m *ProxyHostManager) corsPolicy(id string) (*CORSPolicy, error) {
+	if m.corsPolicies == nil {
+		return nil, fmt.Errorf("CORS policies are not available")
+	}
+	if m.corsPolicyView != nil {
+		policy, ok := m.corsPolicyView[id]
+		if !ok {
+			return nil, fmt.Errorf("CORS policy not found: %s", id)
+		}
+		return policy, nil
+	}
+	return m.corsPolicies.Get(id)
+}
+
+// checkCORSPolicies verifies that every policy a host references exists
+func (m *ProxyHostManager) checkCORSPolicies(host *ProxyHost) error {
+	for _, id := range host.corsPolicyIDs() {
+		if _, err := m.corsPolicy(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// corsDefinitions returns the http-level maps of the policies used by a
+// host. Policies that no longer exist are skipped; stageHost rejects hosts
+// referencing them.
+func (m *ProxyHostManager) corsDefinitions(host *ProxyHost) []string {
+	var defs []string
+	for _, id := range host.corsPolicyIDs() {
+		if p, err := m.corsPolicy(id); err == nil {
+			defs = append(defs, p.Definitions()...)
+		}
+	}
+	return defs
+}
+
+// corsPolicyIDs returns the policies referenced by the host and its locations
+func (h *ProxyHost) corsPolicyIDs() []string {
+	var ids []string
+	if h.CORSPolicyID != "" {
+		ids = append(ids, h.CORSPolicyID)
+	}
+	for _, l := range h.Locations {
+		if l.CORSPolicyID != "" {
+			ids = append(ids, l.CORSPolicyID)
+		}
+	}
+	return ids
+}
+
+// usesCORSPolicy reports whether the host or one of its locations references
+// a policy
+func (h *ProxyHost) usesCORSPolicy(policyID string) bool {
+	for _, id := range h.corsPolicyIDs() {
+		if id == policyID {
+			return true
+		}
+	}
+	return false
+}
+
+// LocationCORSPolicyID returns the policy applying to a location: its own, or
+// the host's
+func (h *ProxyHost) LocationCORSPolicyID(l Location) string {
+	if l.CORSPolicyID != "" {
+		return l.CORSPolicyID
+	}
+	return h.CORSPolicyID
+}
+
+// HostsUsingCORSPolicy returns the IDs of hosts referencing a policy
+func (m *ProxyHostManager) HostsUsingCORSPolicy(policyID string) []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var ids []string
+	for _, h := range m.hosts {
+		if h.usesCORSPolicy(policyID) {
+			ids = append(ids, h.ID)
+		}
+	}
+	return ids
+}
+
+// stageCORSPolicyRefresh stages the re-render of every host using a policy
+// into tx, together with the http-level maps, rendering policies from
+// policies instead of the live set. m.mu stays locked until the returned
+// refresh is finished.
+func (m *ProxyHostManager) stageCORSPolicyRefresh(tx *Transaction, policyID string, policies map[string]*CORSPolicy) (*hostRefresh, error) {
+	m.mu.Lock()
+	m.corsPolicyView = policies
+	unlock := func() {
+		m.corsPolicyView = nil
+		m.mu.Unlock()
+	}
+
+	return m.stageRefresh(tx, "cors_policy_refresh", unlock, func(h *ProxyHost) bool {
+		return h.usesCORSPolicy(policyID)
+	})
+}
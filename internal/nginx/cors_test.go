@@ -0,0 +1,303 @@
+package nginx
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newCORSTestManagers returns a proxy host manager writing to a temporary
+// directory, with a CORS policy library attached
+func newCORSTestManagers(t *testing.T) (*ProxyHostManager, *CORSPolicyManager) {
+	t.Helper()
+	dir := t.TempDir()
+	for _, sub := range []string{"available", "enabled"} {
+		if err := os.Mkdir(filepath.Join(dir, sub), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	hosts, err := NewProxyHostManager(nil, filepath.Join(dir, "available"), filepath.Join(dir, "enabled"),
+		filepath.Join(dir, "proxy_hosts.json"), filepath.Join(dir, "http.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	policies, err := NewCORSPolicyManager(nil, filepath.Join(dir, "cors_policies.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts.SetCORSPolicies(policies)
+	policies.Track(hosts)
+	return hosts, policies
+}
+
+func createCORSPolicy(t *testing.T, policies *CORSPolicyManager, p *CORSPolicy) *CORSPolicy {
+	t.Helper()
+	if err := policies.Create(context.Background(), p); err != nil {
+		t.Fatalf("Create(%s): %v", p.Name, err)
+	}
+	return p
+}
+
+func assertContains(t *testing.T, text string, want ...string) {
+	t.Helper()
+	for _, w := range want {
+		if !strings.Contains(text, w) {
+			t.Errorf("missing %q in:\n%s", w, text)
+		}
+	}
+}
+
+func assertNotContains(t *testing.T, text string, unwanted ...string) {
+	t.Helper()
+	for _, u := range unwanted {
+		if strings.Contains(text, u) {
+			t.Errorf("unexpected %q in:\n%s", u, text)
+		}
+	}
+}
+
+func TestCORSPolicyDefinitions(t *testing.T) {
+	p := &CORSPolicy{
+		ID:               "web-app",
+		Name:             "Web app",
+		AllowedOrigins:   []string{"https://App.example.com/", "http://localhost:3000"},
+		OriginRegex:      `^https://[a-z0-9-]+\.preview\.example\.com$`,
+		AllowedMethods:   []string{"get", "POST"},
+		AllowedHeaders:   []string{"Authorization", "Content-Type"},
+		ExposedHeaders:   []string{"X-Request-Id"},
+		AllowCredentials: true,
+		MaxAge:           600,
+	}
+	if err := validateCORSPolicy(p); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		`map $http_origin $nubi_cors_origin_web_app {
+    default "";
+    "https://app.example.com" $http_origin;
+    "http://localhost:3000" $http_origin;
+    "~^https://[a-z0-9-]+\.preview\.example\.com$" $http_origin;
+}`,
+		`map "$request_method:$http_access_control_request_method:$nubi_cors_origin_web_app" $nubi_cors_preflight_web_app {
+    default 0;
+    "~^OPTIONS:[^:]+:." 1;
+}`,
+		`map $nubi_cors_preflight_web_app $nubi_cors_methods_web_app {
+    default "";
+    1 "GET, POST";
+}`,
+		`map $nubi_cors_preflight_web_app $nubi_cors_headers_web_app {
+    default "";
+    1 "Authorization, Content-Type";
+}`,
+		`map $nubi_cors_preflight_web_app $nubi_cors_max_age_web_app {
+    default "";
+    1 "600";
+}`,
+		`map $nubi_cors_origin_web_app $nubi_cors_credentials_web_app {
+    "" "";
+    default "true";
+}`,
+		`map $nubi_cors_origin_web_app $nubi_cors_expose_web_app {
+    "" "";
+    default "X-Request-Id";
+}`,
+	}
+	got := p.Definitions()
+	if len(got) != len(want) {
+		t.Fatalf("got %d definitions, want %d:\n%s", len(got), len(want), strings.Join(got, "\n"))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("definition %d:\ngot:\n%s\nwant:\n%s", i, got[i], want[i])
+		}
+	}
+
+	wantHeaders := []string{
+		"# CORS policy: Web app",
+		"add_header Access-Control-Allow-Origin $nubi_cors_origin_web_app always;",
+		"add_header Access-Control-Allow-Credentials $nubi_cors_credentials_web_app always;",
+		"add_header Access-Control-Expose-Headers $nubi_cors_expose_web_app always;",
+		"add_header Access-Control-Allow-Methods $nubi_cors_methods_web_app always;",
+		"add_header Access-Control-Allow-Headers $nubi_cors_headers_web_app always;",
+		"add_header Access-Control-Max-Age $nubi_cors_max_age_web_app always;",
+		"add_header Vary Origin always;",
+	}
+	if got := strings.Join(p.HeaderDirectives(), "\n"); got != strings.Join(wantHeaders, "\n") {
+		t.Errorf("header directives:\ngot:\n%s\nwant:\n%s", got, strings.Join(wantHeaders, "\n"))
+	}
+}
+
+func TestCORSPolicyWildcard(t *testing.T) {
+	p := &CORSPolicy{ID: "public", Name: "Public", AllowedOrigins: []string{"*"}}
+	if err := validateCORSPolicy(p); err != nil {
+		t.Fatal(err)
+	}
+
+	defs := strings.Join(p.Definitions(), "\n")
+	assertContains(t, defs,
+		"map $http_origin $nubi_cors_origin_public {\n    \"\" \"\";\n    default \"*\";\n}",
+		// Without allowed headers the requested ones are echoed
+		"map $nubi_cors_preflight_public $nubi_cors_headers_public {\n    default \"\";\n    1 $http_access_control_request_headers;\n}",
+		`1 "GET, HEAD, POST, PUT, PATCH, DELETE";`,
+	)
+	assertNotContains(t, defs, "$nubi_cors_credentials_", "$nubi_cors_expose_", "$nubi_cors_max_age_")
+
+	headers := strings.Join(p.HeaderDirectives(), "\n")
+	assertNotContains(t, headers, "Vary", "Access-Control-Allow-Credentials", "Access-Control-Max-Age")
+}
+
+func TestValidateCORSPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy CORSPolicy
+		err    string
+	}{
+		{"no name", CORSPolicy{AllowedOrigins: []string{"*"}}, "name is required"},
+		{"no origins", CORSPolicy{Name: "p"}, "requires allowed origins"},
+		{"wildcard with credentials", CORSPolicy{Name: "p", AllowedOrigins: []string{"*"}, AllowCredentials: true}, "credentials"},
+		{"wildcard with others", CORSPolicy{Name: "p", AllowedOrigins: []string{"*", "https://a.example.com"}}, "cannot be combined"},
+		{"origin with path", CORSPolicy{Name: "p", AllowedOrigins: []string{"https://a.example.com/app"}}, "invalid CORS origin"},
+		{"origin without scheme", CORSPolicy{Name: "p", AllowedOrigins: []string{"a.example.com"}}, "invalid CORS origin"},
+		{"duplicate origin", CORSPolicy{Name: "p", AllowedOrigins: []string{"https://a.example.com", "https://A.example.com/"}}, "duplicate"},
+		{"unanchored regex", CORSPolicy{Name: "p", OriginRegex: `https://.*\.example\.com`}, "anchored"},
+		{"quoted regex", CORSPolicy{Name: "p", OriginRegex: `^https://"a"$`}, "quotes"},
+		{"broken regex", CORSPolicy{Name: "p", OriginRegex: `^https://(a$`}, "invalid CORS origin regex"},
+		{"bad method", CORSPolicy{Name: "p", AllowedOrigins: []string{"*"}, AllowedMethods: []string{"GET;"}}, "invalid CORS method"},
+		{"bad header", CORSPolicy{Name: "p", AllowedOrigins: []string{"*"}, ExposedHeaders: []string{"X Id"}}, "invalid CORS header"},
+		{"max age", CORSPolicy{Name: "p", AllowedOrigins: []string{"*"}, MaxAge: 90000}, "max age"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateCORSPolicy(&tt.policy)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got error %v, want one containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestRenderCORSHost(t *testing.T) {
+	hosts, policies := newCORSTestManagers(t)
+	app := createCORSPolicy(t, policies, &CORSPolicy{Name: "App", AllowedOrigins: []string{"https://app.example.com"}, AllowCredentials: true})
+	public := createCORSPolicy(t, policies, &CORSPolicy{Name: "Public", AllowedOrigins: []string{"*"}})
+	appVar, publicVar := corsNameRegex.ReplaceAllString(app.ID, "_"), corsNameRegex.ReplaceAllString(public.ID, "_")
+
+	host := &ProxyHost{
+		Domain:       "api.example.com",
+		Target:       "http://127.0.0.1:8080",
+		Enabled:      true,
+		CORSPolicyID: app.ID,
+		Locations: []Location{
+			{Path: "/internal", Target: "http://127.0.0.1:8081"},
+			{Path: "/public", Target: "http://127.0.0.1:8082", CORSPolicyID: public.ID},
+		},
+	}
+	if err := hosts.Create(context.Background(), host); err != nil {
+		t.Fatal(err)
+	}
+	config, httpConfig, err := hosts.Preview(host.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rendered := string(config)
+
+	// The host's headers are set once for the whole server block
+	assertContains(t, rendered,
+		"\n    # CORS policy: App\n    add_header Access-Control-Allow-Origin $nubi_cors_origin_"+appVar+" always;\n",
+		"\n    add_header Access-Control-Allow-Credentials $nubi_cors_credentials_"+appVar+" always;\n",
+	)
+	if n := strings.Count(rendered, "if ($nubi_cors_preflight_"+appVar+") {\n            return 204;\n        }"); n != 2 {
+		t.Errorf("host policy preflight rendered %d times, want 2 (location / and /internal):\n%s", n, rendered)
+	}
+
+	// /public replaces the host's policy and so repeats no header of it
+	start := strings.Index(rendered, "location /public {")
+	if start < 0 {
+		t.Fatalf("missing location /public:\n%s", rendered)
+	}
+	end := start + strings.Index(rendered[start:], "\n    }")
+	block := rendered[start:end]
+	assertContains(t, block,
+		"if ($nubi_cors_preflight_"+publicVar+") {",
+		"proxy_hide_header Access-Control-Allow-Origin;",
+		"        # CORS policy: Public\n        add_header Access-Control-Allow-Origin $nubi_cors_origin_"+publicVar+" always;",
+	)
+	assertNotContains(t, block, appVar)
+
+	assertContains(t, string(httpConfig),
+		"map $http_origin $nubi_cors_origin_"+appVar+" {",
+		"map $http_origin $nubi_cors_origin_"+publicVar+" {",
+		"$nubi_cors_credentials_"+appVar,
+	)
+}
+
+func TestUpdateCORSPolicyRefreshesHosts(t *testing.T) {
+	hosts, policies := newCORSTestManagers(t)
+	app := createCORSPolicy(t, policies, &CORSPolicy{Name: "App", AllowedOrigins: []string{"https://app.example.com"}})
+
+	host := &ProxyHost{Domain: "api.example.com", Target: "http://127.0.0.1:8080", Enabled: true, CORSPolicyID: app.ID}
+	if err := hosts.Create(context.Background(), host); err != nil {
+		t.Fatal(err)
+	}
+
+	update := &CORSPolicy{Name: "App", AllowedOrigins: []string{"https://new.example.com"}}
+	if err := policies.Update(context.Background(), app.ID, update); err != nil {
+		t.Fatal(err)
+	}
+
+	// The http-level maps on disk were rewritten in the policy's commit
+	httpConfig, err := os.ReadFile(hosts.httpConfigPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertContains(t, string(httpConfig), `"https://new.example.com" $http_origin;`)
+	assertNotContains(t, string(httpConfig), "https://app.example.com")
+}
+
+func TestRenderCORSLocationRepeatsServerHeaders(t *testing.T) {
+	hosts, policies := newCORSTestManagers(t)
+	app := createCORSPolicy(t, policies, &CORSPolicy{Name: "App", AllowedOrigins: []string{"https://app.example.com"}})
+
+	// A location with its own add_header drops the server's, so the host's
+	// CORS headers are repeated there
+	host := &ProxyHost{
+		Domain:       "api.example.com",
+		Target:       "http://127.0.0.1:8080",
+		Enabled:      true,
+		CORSPolicyID: app.ID,
+		Locations: []Location{{
+			Path:        "/downloads",
+			Target:      "http://127.0.0.1:8081",
+			HeaderRules: []HeaderRule{{Name: "X-Download", Action: HeaderAdd, Value: "1"}},
+		}},
+	}
+	if err := hosts.Create(context.Background(), host); err != nil {
+		t.Fatal(err)
+	}
+	config, _, err := hosts.Preview(host.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rendered := string(config)
+
+	start := strings.Index(rendered, "location /downloads {")
+	end := start + strings.Index(rendered[start:], "\n    }")
+	assertContains(t, rendered[start:end],
+		"add_header X-Download",
+		"add_header Access-Control-Allow-Origin $nubi_cors_origin_"+corsNameRegex.ReplaceAllString(app.ID, "_")+" always;",
+		"add_header Vary Origin always;",
+	)
+}
+
+func TestRenderCORSMissingPolicy(t *testing.T) {
+	hosts, _ := newCORSTestManagers(t)
+	host := &ProxyHost{Domain: "api.example.com", Target: "http://127.0.0.1:8080", CORSPolicyID: "missing"}
+	if err := hosts.Create(context.Background(), host); err == nil || !strings.Contains(err.Error(), "CORS policy not found") {
+		t.Errorf("got error %v, want a missing policy error", err)
+	}
+}
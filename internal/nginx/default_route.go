@@ -1,9 +1,12 @@
 package nginx
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"html"
+	"log"
 	"os"
 	"path/filepath"
 	"text/template"
@@ -106,13 +109,15 @@ server {
 
 // DefaultRouteManager handles the default server block configuration.
 type DefaultRouteManager struct {
+	ctrl       *Controller   // used to test staged config before it goes live
+	history    *HistoryStore // optional revision log
 	configPath string
 	tmpl       *template.Template
 }
 
 // NewDefaultRouteManager creates a manager for the default route configuration.
 // configPath should be something like "/etc/nginx/sites-available/00-default" or similar.
-func NewDefaultRouteManager(configPath string) (*DefaultRouteManager, error) {
+func NewDefaultRouteManager(ctrl *Controller, configPath string) (*DefaultRouteManager, error) {
 	if configPath == "" {
 		configPath = "/etc/nginx/sites-available/00-nubi-default"
 	}
@@ -123,11 +128,17 @@ func NewDefaultRouteManager(configPath string) (*DefaultRouteManager, error) {
 	}
 
 	return &DefaultRouteManager{
+		ctrl:       ctrl,
 		configPath: configPath,
 		tmpl:       tmpl,
 	}, nil
 }
 
+// SetHistory enables revision recording for default route changes.
+func (m *DefaultRouteManager) SetHistory(history *HistoryStore) {
+	m.history = history
+}
+
 // ConfigPath returns the path where the default config is written.
 func (m *DefaultRouteManager) ConfigPath() string {
 	return m.configPath
@@ -138,76 +149,114 @@ func (m *DefaultRouteManager) SymlinkPath() string {
 	return filepath.Join("/etc/nginx/sites-enabled", filepath.Base(m.configPath))
 }
 
+// listenPorts returns the ports the enabled default server listens on, read
+// from the live config so maintenance mode is covered too
+func (m *DefaultRouteManager) listenPorts() []int {
+	data, err := os.ReadFile(m.SymlinkPath())
+	if err != nil {
+		return nil
+	}
+	return parseListenPorts(data)
+}
+
 // Apply writes the default server configuration and creates symlink if enabled.
+// The change is tested with nginx -t and rolled back if the test fails.
 func (m *DefaultRouteManager) Apply(ctx context.Context, config *DefaultRouteConfig) error {
-	if !config.Enabled {
-		return m.Disable(ctx)
+	tx := NewTransaction(m.ctrl)
+	if err := m.stage(tx, config); err != nil {
+		return err
 	}
+	return m.commit(ctx, tx, "apply", config)
+}
+
+// commit applies tx and records the resulting default route in the history.
+// config is the state the route ends up in (nil or disabled when removed).
+func (m *DefaultRouteManager) commit(ctx context.Context, tx *Transaction, action string, config *DefaultRouteConfig) error {
+	before, _ := m.GetConfig()
 
-	// Ensure directories exist
-	dir := filepath.Dir(m.configPath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return fmt.Errorf("failed to create config directory: %w", err)
+	if err := tx.Commit(ctx); err != nil {
+		return err
 	}
 
-	htmlDir := "/var/lib/nubi/html"
-	if err := os.MkdirAll(htmlDir, 0755); err != nil {
-		return fmt.Errorf("failed to create html directory: %w", err)
+	if m.history == nil {
+		return nil
+	}
+
+	rev := &Revision{
+		Entity:   EntityDefaultRoute,
+		EntityID: "default",
+		Action:   action,
+		Before:   marshalRevisionState(before),
+		After:    marshalRevisionState(config),
+	}
+	if config != nil && config.Enabled {
+		var buf bytes.Buffer
+		if err := m.tmpl.Execute(&buf, config); err == nil {
+			rev.Rendered = buf.String()
+		}
+	}
+	if err := m.history.Record(ctx, rev); err != nil {
+		log.Printf("warning: failed to record default route history: %v", err)
+	}
+
+	return nil
+}
+
+// stage renders the default server and its pages into tx.
+func (m *DefaultRouteManager) stage(tx *Transaction, config *DefaultRouteConfig) error {
+	if !config.Enabled {
+		m.stageDisable(tx)
+		return nil
 	}
 
+	htmlDir := "/var/lib/nubi/html"
+
 	// Write custom HTML page if in custom_page mode
 	if config.Mode == ModeCustomPage && config.CustomHTML != "" {
 		customPath := filepath.Join(htmlDir, "nubi_default.html")
-		if err := os.WriteFile(customPath, []byte(config.CustomHTML), 0644); err != nil {
-			return fmt.Errorf("failed to write custom page: %w", err)
-		}
+		tx.WriteFile(customPath, []byte(config.CustomHTML), 0644)
 	}
 
 	// Write error pages
 	for _, ep := range config.ErrorPages {
 		if ep.CustomHTML != "" {
 			epPath := filepath.Join(htmlDir, fmt.Sprintf("nubi_error_%d.html", ep.Code))
-			if err := os.WriteFile(epPath, []byte(ep.CustomHTML), 0644); err != nil {
-				return fmt.Errorf("failed to write error page %d: %w", ep.Code, err)
-			}
+			tx.WriteFile(epPath, []byte(ep.CustomHTML), 0644)
 		}
 	}
 
-	// Write config file
-	f, err := os.Create(m.configPath)
-	if err != nil {
-		return fmt.Errorf("failed to create config file: %w", err)
-	}
-	defer f.Close()
-
-	if err := m.tmpl.Execute(f, config); err != nil {
+	// Render config file
+	var buf bytes.Buffer
+	if err := m.tmpl.Execute(&buf, config); err != nil {
 		return fmt.Errorf("failed to write config: %w", err)
 	}
+	tx.WriteFile(m.configPath, buf.Bytes(), 0644)
 
 	// Create symlink in sites-enabled
-	symlinkPath := m.SymlinkPath()
-	_ = os.Remove(symlinkPath) // Remove existing symlink if any
-	if err := os.Symlink(m.configPath, symlinkPath); err != nil {
-		return fmt.Errorf("failed to create symlink: %w", err)
-	}
+	tx.Symlink(m.configPath, m.SymlinkPath())
 
 	// Save state to JSON file for persistence
-	if err := m.saveState(config); err != nil {
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
 		return fmt.Errorf("failed to save state: %w", err)
 	}
+	tx.WriteState(m.stateFilePath(), data)
 
 	return nil
 }
 
 // Disable removes the default server configuration.
 func (m *DefaultRouteManager) Disable(ctx context.Context) error {
-	// Remove symlink first
-	_ = os.Remove(m.SymlinkPath())
-	// Remove config file
-	_ = os.Remove(m.configPath)
-	// Remove state file
-	_ = os.Remove(m.stateFilePath())
-	return nil
+	tx := NewTransaction(m.ctrl)
+	m.stageDisable(tx)
+	return m.commit(ctx, tx, "disable", &DefaultRouteConfig{Enabled: false, Mode: ModeNginxDefault})
+}
+
+// stageDisable stages removal of the symlink, config and state files.
+func (m *DefaultRouteManager) stageDisable(tx *Transaction) {
+	tx.Remove(m.SymlinkPath())
+	tx.Remove(m.configPath)
+	tx.RemoveState(m.stateFilePath())
 }
 
 // stateFilePath returns path to the JSON state file.
@@ -215,21 +264,6 @@ func (m *DefaultRouteManager) stateFilePath() string {
 	return "/var/lib/nubi/default_route_state.json"
 }
 
-// saveState persists the configuration to a JSON file.
-func (m *DefaultRouteManager) saveState(config *DefaultRouteConfig) error {
-	dir := filepath.Dir(m.stateFilePath())
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return err
-	}
-
-	data, err := json.MarshalIndent(config, "", "  ")
-	if err != nil {
-		return err
-	}
-
-	return os.WriteFile(m.stateFilePath(), data, 0644)
-}
-
 // GetConfig reads the current state from the JSON file.
 func (m *DefaultRouteManager) GetConfig() (*DefaultRouteConfig, error) {
 	config := &DefaultRouteConfig{Enabled: false, Mode: ModeNginxDefault}
@@ -253,6 +287,36 @@ func (m *DefaultRouteManager) GetConfig() (*DefaultRouteConfig, error) {
 	return config, nil
 }
 
+// MaintenancePage returns the HTML of the maintenance page showing message,
+// or a generic notice when message is empty
+func MaintenancePage(message string) string {
+	if message == "" {
+		message = "The server is currently undergoing maintenance."
+	}
+	return `<!DOCTYPE html>
+<html>
+<head>
+  <title>Maintenance</title>
+  <meta charset="utf-8">
+  <style>
+    body { font-family: system-ui, sans-serif; background: #0f172a; color: #e2e8f0; display: flex; align-items: center; justify-content: center; min-height: 100vh; margin: 0; }
+    .container { text-align: center; padding: 2rem; }
+    h1 { font-size: 3rem; margin: 0; color: #f59e0b; }
+    p { font-size: 1.25rem; color: #94a3b8; margin-top: 1rem; }
+    .icon { font-size: 4rem; margin-bottom: 1rem; }
+  </style>
+</head>
+<body>
+  <div class="container">
+    <div class="icon">🔧</div>
+    <h1>Under Maintenance</h1>
+    <p>` + html.EscapeString(message) + `</p>
+    <p style="font-size: 0.875rem; margin-top: 2rem;">We'll be back shortly.</p>
+  </div>
+</body>
+</html>`
+}
+
 // maintenanceStateFilePath returns path to the maintenance backup state file.
 func (m *DefaultRouteManager) maintenanceStateFilePath() string {
 	return "/var/lib/nubi/maintenance_backup_state.json"
@@ -260,11 +324,13 @@ func (m *DefaultRouteManager) maintenanceStateFilePath() string {
 
 // ApplyMaintenance enables maintenance mode with custom HTML.
 func (m *DefaultRouteManager) ApplyMaintenance(ctx context.Context, html string) error {
+	tx := NewTransaction(m.ctrl)
+
 	// Backup current config
 	currentConfig, _ := m.GetConfig()
 	if currentConfig.Enabled {
 		data, _ := json.MarshalIndent(currentConfig, "", "  ")
-		os.WriteFile(m.maintenanceStateFilePath(), data, 0644)
+		tx.WriteState(m.maintenanceStateFilePath(), data)
 	}
 
 	// Apply maintenance page
@@ -273,7 +339,10 @@ func (m *DefaultRouteManager) ApplyMaintenance(ctx context.Context, html string)
 		Mode:       ModeCustomPage,
 		CustomHTML: html,
 	}
-	return m.Apply(ctx, maintenanceConfig)
+	if err := m.stage(tx, maintenanceConfig); err != nil {
+		return err
+	}
+	return m.commit(ctx, tx, "maintenance_on", maintenanceConfig)
 }
 
 // DisableMaintenance restores the previous configuration.
@@ -290,9 +359,11 @@ func (m *DefaultRouteManager) DisableMaintenance(ctx context.Context) error {
 		return m.Disable(ctx)
 	}
 
-	// Remove backup file
-	os.Remove(m.maintenanceStateFilePath())
-
-	// Restore previous config
-	return m.Apply(ctx, &config)
+	// Restore previous config and drop the backup in the same apply
+	tx := NewTransaction(m.ctrl)
+	if err := m.stage(tx, &config); err != nil {
+		return err
+	}
+	tx.RemoveState(m.maintenanceStateFilePath())
+	return m.commit(ctx, tx, "maintenance_off", &config)
 }
@@ -0,0 +1,658 @@
+package nginx
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// errorPagesRoot holds a directory of rendered error pages per host
+const errorPagesRoot = "/var/lib/nubi/html"
+
+// maxErrorPageSize limits custom error page HTML
+const maxErrorPageSize = 256 << 10
+
+// Built-in error page templates
+const (
+	ErrorTemplateSimple  = "simple"
+	ErrorTemplateDark    = "dark"
+	ErrorTemplateMinimal = "minimal"
+)
+
+var errorPageTemplates = map[string]*template.Template{
+	ErrorTemplateSimple: template.Must(template.New(ErrorTemplateSimple).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{ .Title }}</title>
+<style>
+body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, sans-serif; background: #f5f5f7; color: #1d1d1f; display: flex; align-items: center; justify-content: center; min-height: 100vh; margin: 0; }
+main { text-align: center; padding: 2rem; }
+h1 { font-size: 4rem; margin: 0; color: #6e6e73; }
+h2 { font-weight: 500; }
+</style>
+</head>
+<body>
+<main>
+<h1>{{ .Code }}</h1>
+<h2>{{ .Title }}</h2>
+<p>{{ .Message }}</p>
+</main>
+</body>
+</html>
+`)),
+	ErrorTemplateDark: template.Must(template.New(ErrorTemplateDark).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{ .Title }}</title>
+<style>
+body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, sans-serif; background: #111318; color: #e6e6e6; display: flex; align-items: center; justify-content: center; min-height: 100vh; margin: 0; }
+main { text-align: center; padding: 2rem; }
+h1 { font-size: 4rem; margin: 0; color: #7c8cff; }
+h2 { font-weight: 500; }
+p { color: #a0a0a8; }
+</style>
+</head>
+<body>
+<main>
+<h1>{{ .Code }}</h1>
+<h2>{{ .Title }}</h2>
+<p>{{ .Message }}</p>
+</main>
+</body>
+</html>
+`)),
+	ErrorTemplateMinimal: template.Must(template.New(ErrorTemplateMinimal).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="utf-8"><title>{{ .Title }}</title></head>
+<body>
+<h1>{{ .Title }}</h1>
+<p>{{ .Message }}</p>
+</body>
+</html>
+`)),
+}
+
+// ErrorPageContent is the body of an error page: custom HTML, or a built-in
+// template filled in with a title and message
+type ErrorPageContent struct {
+	Template   string `json:"template"`   // simple (default), dark or minimal
+	Title      string `json:"title"`      // Defaults to the status text, e.g. "Bad Gateway"
+	Message    string `json:"message"`    // Defaults to a generic explanation
+	CustomHTML string `json:"customHtml"` // Served instead of the template when set
+}
+
+// empty reports whether no content was given
+func (c ErrorPageContent) empty() bool {
+	return c.Template == "" && c.Title == "" && c.Message == "" && c.CustomHTML == ""
+}
+
+// validate checks the content
+func (c ErrorPageContent) validate() error {
+	if c.Template != "" {
+		if _, ok := errorPageTemplates[c.Template]; !ok {
+			return fmt.Errorf("invalid error page template: %s (expected simple, dark or minimal)", c.Template)
+		}
+	}
+	if len(c.CustomHTML) > maxErrorPageSize {
+		return fmt.Errorf("custom error page HTML must be at most 256KB")
+	}
+	return nil
+}
+
+// Render returns the page served for a status code
+func (c ErrorPageContent) Render(code int) ([]byte, error) {
+	if c.CustomHTML != "" {
+		return []byte(c.CustomHTML), nil
+	}
+
+	name := c.Template
+	if name == "" {
+		name = ErrorTemplateSimple
+	}
+	title := c.Title
+	if title == "" {
+		title = http.StatusText(code)
+	}
+	message := c.Message
+	if message == "" {
+		message = defaultErrorMessage(code)
+	}
+
+	var buf bytes.Buffer
+	err := errorPageTemplates[name].Execute(&buf, struct {
+		Code           int
+		Title, Message string
+	}{code, title, message})
+	if err != nil {
+		return nil, fmt.Errorf("failed to render error page: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// defaultErrorMessage explains a status code to visitors
+func defaultErrorMessage(code int) string {
+	switch {
+	case code == http.StatusNotFound:
+		return "The page you are looking for could not be found."
+	case code == http.StatusForbidden || code == http.StatusUnauthorized:
+		return "You do not have permission to access this page."
+	case code == http.StatusTooManyRequests:
+		return "Too many requests. Please slow down and try again."
+	case code == http.StatusBadGateway || code == http.StatusServiceUnavailable || code == http.StatusGatewayTimeout:
+		return "The service is temporarily unavailable. Please try again in a moment."
+	case code >= 500:
+		return "Something went wrong on our side. Please try again later."
+	default:
+		return "The request could not be completed."
+	}
+}
+
+// ErrorPage is a reusable error page from the shared library
+type ErrorPage struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	ErrorPageContent
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// HostErrorPage serves a page for some status codes of a host, either from
+// the library or defined inline
+type HostErrorPage struct {
+	Codes  []int  `json:"codes"`  // e.g., [502, 503, 504]
+	PageID string `json:"pageId"` // Library page (leave the content fields empty)
+	ErrorPageContent
+}
+
+// validateHostErrorPages checks the error pages of a host
+func validateHostErrorPages(host *ProxyHost) error {
+	seen := make(map[int]bool)
+	for _, ep := range host.ErrorPages {
+		if len(ep.Codes) == 0 {
+			return fmt.Errorf("error pages need at least one status code")
+		}
+		for _, code := range ep.Codes {
+			// error_page only accepts codes from 300 to 599
+			if code < 300 || code > 599 {
+				return fmt.Errorf("invalid error page status code: %d (expected 300-599)", code)
+			}
+			if seen[code] {
+				return fmt.Errorf("status code %d has more than one error page", code)
+			}
+			seen[code] = true
+		}
+
+		if ep.PageID != "" && !ep.ErrorPageContent.empty() {
+			return fmt.Errorf("error pages use either a library page or their own content")
+		}
+		if err := ep.ErrorPageContent.validate(); err != nil {
+			return err
+		}
+	}
+
+	if host.InterceptErrors && len(host.ErrorPages) == 0 {
+		return fmt.Errorf("interceptErrors requires error pages")
+	}
+	return nil
+}
+
+// ErrorPageCodes returns the status codes with an error page, sorted
+func (h *ProxyHost) ErrorPageCodes() []int {
+	var codes []int
+	for _, ep := range h.ErrorPages {
+		codes = append(codes, ep.Codes...)
+	}
+	sort.Ints(codes)
+	return codes
+}
+
+// ErrorPageDir returns the directory holding the host's rendered error pages
+func (h *ProxyHost) ErrorPageDir() string {
+	return filepath.Join(errorPagesRoot, h.ID)
+}
+
+// maintenancePageFile is the page served by hosts in maintenance
+const maintenancePageFile = "nubi_maintenance.html"
+
+// errorPageFile returns the name of the file served for a status code
+func errorPageFile(code int) string {
+	return fmt.Sprintf("nubi_error_%d.html", code)
+}
+
+// ErrorPageManager stores the shared library of error pages
+type ErrorPageManager struct {
+	mu       sync.RWMutex
+	pages    map[string]*ErrorPage
+	ctrl     *Controller     // used to run transactions
+	history  *HistoryStore   // optional revision log
+	dataFile string          // e.g., /var/lib/nubi/error_pages.json
+	users    []errorPageUser // host managers re-rendered on change, see Track
+
+	// applyMu serialises changes. A change stages the hosts using the page
+	// without holding mu, so they can still read other pages.
+	applyMu sync.Mutex
+}
+
+// errorPageUser is a host manager whose hosts serve library pages
+type errorPageUser interface {
+	stageErrorPageRefresh(tx *Transaction, pageID string, pages map[string]*ErrorPage) (*hostRefresh, error)
+}
+
+// NewErrorPageManager creates a new error page manager
+func NewErrorPageManager(ctrl *Controller, dataFile string) (*ErrorPageManager, error) {
+	if dataFile == "" {
+		dataFile = "/var/lib/nubi/error_pages.json"
+	}
+
+	mgr := &ErrorPageManager{
+		pages:    make(map[string]*ErrorPage),
+		ctrl:     ctrl,
+		dataFile: dataFile,
+	}
+
+	if err := mgr.load(); err != nil {
+		// Not a fatal error - might be first run
+		fmt.Printf("Note: Could not load existing error pages: %v\n", err)
+	}
+
+	return mgr, nil
+}
+
+// load reads error pages from the JSON data file
+func (m *ErrorPageManager) load() error {
+	data, err := os.ReadFile(m.dataFile)
+	if err != nil {
+		return err
+	}
+
+	var pages []*ErrorPage
+	if err := json.Unmarshal(data, &pages); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.pages = make(map[string]*ErrorPage)
+	for _, p := range pages {
+		m.pages[p.ID] = p
+	}
+
+	return nil
+}
+
+// SetHistory attaches a revision log; committed changes are recorded to it
+func (m *ErrorPageManager) SetHistory(history *HistoryStore) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.history = history
+}
+
+// Track makes changes to a page re-render the hosts of user that serve it, in
+// the same transaction as the page. Call it once at startup, before serving
+// requests.
+func (m *ErrorPageManager) Track(user errorPageUser) {
+	m.users = append(m.users, user)
+}
+
+// List returns all error pages ordered by name
+func (m *ErrorPageManager) List() []*ErrorPage {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	pages := make([]*ErrorPage, 0, len(m.pages))
+	for _, p := range m.pages {
+		pages = append(pages, p)
+	}
+	sort.Slice(pages, func(i, j int) bool { return pages[i].Name < pages[j].Name })
+	return pages
+}
+
+// Get returns an error page by ID
+func (m *ErrorPageManager) Get(id string) (*ErrorPage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	page, ok := m.pages[id]
+	if !ok {
+		return nil, fmt.Errorf("error page not found: %s", id)
+	}
+	return page, nil
+}
+
+// validateErrorPage checks a library page
+func validateErrorPage(page *ErrorPage) error {
+	page.Name = strings.TrimSpace(page.Name)
+	if page.Name == "" {
+		return fmt.Errorf("error page name is required")
+	}
+	return page.ErrorPageContent.validate()
+}
+
+// Create adds a page to the library
+func (m *ErrorPageManager) Create(ctx context.Context, page *ErrorPage) error {
+	if err := validateErrorPage(page); err != nil {
+		return err
+	}
+
+	m.applyMu.Lock()
+	defer m.applyMu.Unlock()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	page.ID = uuid.New().String()
+	page.CreatedAt = time.Now()
+	page.UpdatedAt = time.Now()
+
+	next := m.clonePages()
+	next[page.ID] = page
+
+	return m.commit(ctx, "create", next, page, nil)
+}
+
+// Update replaces a library page. Every host using it is re-rendered and
+// committed together with it.
+func (m *ErrorPageManager) Update(ctx context.Context, id string, updates *ErrorPage) error {
+	m.applyMu.Lock()
+	defer m.applyMu.Unlock()
+
+	current, err := m.Get(id)
+	if err != nil {
+		return err
+	}
+
+	page := *updates
+	page.ID = id
+	page.CreatedAt = current.CreatedAt
+	page.UpdatedAt = time.Now()
+	if err := validateErrorPage(&page); err != nil {
+		return err
+	}
+
+	m.mu.RLock()
+	next := m.clonePages()
+	m.mu.RUnlock()
+	next[id] = &page
+
+	return m.commitWithHosts(ctx, "update", next, &page)
+}
+
+// Delete removes a library page. Callers must make sure no host uses it.
+func (m *ErrorPageManager) Delete(ctx context.Context, id string) error {
+	m.applyMu.Lock()
+	defer m.applyMu.Unlock()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	page, ok := m.pages[id]
+	if !ok {
+		return fmt.Errorf("error page not found: %s", id)
+	}
+
+	next := m.clonePages()
+	delete(next, id)
+
+	return m.commit(ctx, "delete", next, nil, page)
+}
+
+// Restore puts a library page back to a recorded state. A nil snapshot
+// deletes the page.
+func (m *ErrorPageManager) Restore(ctx context.Context, id string, snapshot *ErrorPage) error {
+	m.applyMu.Lock()
+	defer m.applyMu.Unlock()
+
+	m.mu.RLock()
+	current, exists := m.pages[id]
+	next := m.clonePages()
+	m.mu.RUnlock()
+
+	if snapshot == nil {
+		if !exists {
+			return nil
+		}
+		delete(next, id)
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		return m.commit(ctx, "restore", next, nil, current)
+	}
+
+	page := *snapshot
+	page.ID = id
+	page.UpdatedAt = time.Now()
+	if err := validateErrorPage(&page); err != nil {
+		return err
+	}
+	next[id] = &page
+
+	return m.commitWithHosts(ctx, "restore", next, &page)
+}
+
+func (m *ErrorPageManager) clonePages() map[string]*ErrorPage {
+	next := make(map[string]*ErrorPage, len(m.pages))
+	for id, p := range m.pages {
+		next[id] = p
+	}
+	return next
+}
+
+// commit writes the data file and records the change. Pages are rendered
+// into the directories of the hosts using them, not here. Callers must hold
+// m.mu.
+func (m *ErrorPageManager) commit(ctx context.Context, action string, next map[string]*ErrorPage, written, removed *ErrorPage) error {
+	tx := NewTransaction(m.ctrl)
+	if err := m.stageCommit(tx, next); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	m.swap(ctx, action, next, written, removed)
+	return nil
+}
+
+// commitWithHosts commits a changed page together with the hosts serving
+// it, so history never records a page the hosts do not serve. Callers hold
+// m.applyMu but not m.mu.
+func (m *ErrorPageManager) commitWithHosts(ctx context.Context, action string, next map[string]*ErrorPage, written *ErrorPage) error {
+	tx := NewTransaction(m.ctrl)
+	if err := m.stageCommit(tx, next); err != nil {
+		return err
+	}
+
+	var refreshes []*hostRefresh
+	var err error
+	for _, user := range m.users {
+		refresh, stageErr := user.stageErrorPageRefresh(tx, written.ID, next)
+		if stageErr != nil {
+			err = stageErr
+			break
+		}
+		refreshes = append(refreshes, refresh)
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+	if err == nil {
+		m.mu.Lock()
+		m.swap(ctx, action, next, written, nil)
+		m.mu.Unlock()
+	}
+
+	for _, refresh := range refreshes {
+		refresh.finish(ctx, err)
+	}
+	return err
+}
+
+// stageCommit stages the data file into tx
+func (m *ErrorPageManager) stageCommit(tx *Transaction, next map[string]*ErrorPage) error {
+	list := make([]*ErrorPage, 0, len(next))
+	for _, p := range next {
+		list = append(list, p)
+	}
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	tx.WriteState(m.dataFile, data)
+	return nil
+}
+
+// swap makes a committed page set live and records the change. Callers must
+// hold m.mu.
+func (m *ErrorPageManager) swap(ctx context.Context, action string, next map[string]*ErrorPage, written, removed *ErrorPage) {
+	prev := m.pages
+	m.pages = next
+
+	if m.history != nil {
+		changed := written
+		if changed == nil {
+			changed = removed
+		}
+		rev := &Revision{
+			Entity:   EntityErrorPage,
+			EntityID: changed.ID,
+			Action:   action,
+			Before:   marshalRevisionState(prev[changed.ID]),
+			After:    marshalRevisionState(next[changed.ID]),
+		}
+		if err := m.history.Record(ctx, rev); err != nil {
+			log.Printf("warning: failed to record history for error page %s: %v", changed.ID, err)
+		}
+	}
+}
+
+// SetErrorPages attaches the error page library used by hosts referencing
+// library pages
+func (m *ProxyHostManager) SetErrorPages(pages *ErrorPageManager) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.errorPages = pages
+}
+
+// errorPageContent resolves the content served by a host error page, from
+// the pages of a staged page change while one is in progress
+func (m *ProxyHostManager) errorPageContent(ep HostErrorPage) (ErrorPageContent, error) {
+	if ep.PageID == "" {
+		return ep.ErrorPageContent, nil
+	}
+	if m.errorPages == nil {
+		return ErrorPageContent{}, fmt.Errorf("error page library is not available")
+	}
+	if m.errorPageView != nil {
+		page, ok := m.errorPageView[ep.PageID]
+		if !ok {
+			return ErrorPageContent{}, fmt.Errorf("error page not found: %s", ep.PageID)
+		}
+		return page.ErrorPageContent, nil
+	}
+	page, err := m.errorPages.Get(ep.PageID)
+	if err != nil {
+		return ErrorPageContent{}, err
+	}
+	return page.ErrorPageContent, nil
+}
+
+// stageErrorPages writes the host's error pages and its scheduled maintenance
+// page, and removes pages it no longer serves
+func (m *ProxyHostManager) stageErrorPages(tx *Transaction, host *ProxyHost) error {
+	dir := host.ErrorPageDir()
+	keep := make(map[string]bool)
+
+	if host.Maintenance && host.MaintenanceMessage != "" {
+		keep[maintenancePageFile] = true
+		tx.WriteFile(filepath.Join(dir, maintenancePageFile), []byte(MaintenancePage(host.MaintenanceMessage)), 0644)
+	}
+
+	for _, ep := range host.ErrorPages {
+		content, err := m.errorPageContent(ep)
+		if err != nil {
+			return err
+		}
+		for _, code := range ep.Codes {
+			data, err := content.Render(code)
+			if err != nil {
+				return err
+			}
+			name := errorPageFile(code)
+			keep[name] = true
+			tx.WriteFile(filepath.Join(dir, name), data, 0644)
+		}
+	}
+
+	stageRemoveErrorPages(tx, dir, keep)
+	return nil
+}
+
+// stageRemoveErrorPages removes the rendered pages in dir not listed in keep
+func stageRemoveErrorPages(tx *Transaction, dir string, keep map[string]bool) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		rendered := strings.HasPrefix(name, "nubi_error_") || name == maintenancePageFile
+		if !entry.IsDir() && rendered && !keep[name] {
+			tx.Remove(filepath.Join(dir, name))
+		}
+	}
+}
+
+// usesErrorPage reports whether the host serves a library page
+func (h *ProxyHost) usesErrorPage(pageID string) bool {
+	for _, ep := range h.ErrorPages {
+		if ep.PageID == pageID {
+			return true
+		}
+	}
+	return false
+}
+
+// HostsUsingErrorPage returns the IDs of hosts serving a library page
+func (m *ProxyHostManager) HostsUsingErrorPage(pageID string) []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var ids []string
+	for _, h := range m.hosts {
+		if h.usesErrorPage(pageID) {
+			ids = append(ids, h.ID)
+		}
+	}
+	return ids
+}
+
+// stageErrorPageRefresh stages the re-render of every host serving a library
+// page into tx, reading pages from pages instead of the live set. m.mu stays
+// locked until the returned refresh is finished.
+func (m *ProxyHostManager) stageErrorPageRefresh(tx *Transaction, pageID string, pages map[string]*ErrorPage) (*hostRefresh, error) {
+	m.mu.Lock()
+	m.errorPageView = pages
+	unlock := func() {
+		m.errorPageView = nil
+		m.mu.Unlock()
+	}
+
+	return m.stageRefresh(tx, "error_page_refresh", unlock, func(h *ProxyHost) bool {
+		return h.usesErrorPage(pageID)
+	})
+}
@@ -0,0 +1,316 @@
+package nginx
+
+import (
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"strings"
+)
+
+// Header rule actions. set, append and remove change the request sent to the
+// upstream; add and hide change the response sent to the client.
+const (
+	HeaderSet    = "set"
+	HeaderAppend = "append"
+	HeaderRemove = "remove"
+	HeaderAdd    = "add"
+	HeaderHide   = "hide"
+)
+
+// HeaderRule manipulates one request or response header
+type HeaderRule struct {
+	Action string `json:"action"` // set, append, remove, add or hide
+	Name   string `json:"name"`   // Header name, e.g. "X-Request-ID"
+	Value  string `json:"value"`  // set, append and add only; may use allowlisted variables like $request_id
+}
+
+// headerVariables lists the nginx variables header values may reference.
+// $http_, $cookie_ and $arg_ variables are allowed as well.
+var headerVariables = map[string]bool{
+	"host":                      true,
+	"remote_addr":               true,
+	"remote_port":               true,
+	"scheme":                    true,
+	"server_name":               true,
+	"server_port":               true,
+	"request_id":                true,
+	"request_method":            true,
+	"request_uri":               true,
+	"uri":                       true,
+	"args":                      true,
+	"proxy_add_x_forwarded_for": true,
+	"proxy_host":                true,
+	"ssl_protocol":              true,
+	"ssl_cipher":                true,
+	"time_iso8601":              true,
+	"msec":                      true,
+	"request_time":              true,
+	"status":                    true,
+	"upstream_addr":             true,
+	"upstream_status":           true,
+	"upstream_response_time":    true,
+}
+
+// headerVariablePrefixes are variable families allowed in header values
+var headerVariablePrefixes = []string{"http_", "cookie_", "arg_"}
+
+// headerVariableRegex matches a variable reference at the start of a value,
+// as $name or ${name}
+var headerVariableRegex = regexp.MustCompile(`^\$(?:\{([A-Za-z0-9_]+)\}|([A-Za-z0-9_]+))`)
+
+// requestSide reports whether the rule changes the request to the upstream
+func (r HeaderRule) requestSide() bool {
+	return r.Action == HeaderSet || r.Action == HeaderAppend || r.Action == HeaderRemove
+}
+
+// key identifies the header a rule targets, so a location rule replaces the
+// host's rule for the same header
+func (r HeaderRule) key() string {
+	class := r.Action
+	if r.requestSide() {
+		class = "request"
+	}
+	return class + ":" + strings.ToLower(r.Name)
+}
+
+// Directive returns the nginx directive of the rule
+func (r HeaderRule) Directive() string {
+	switch r.Action {
+	case HeaderSet:
+		return fmt.Sprintf("proxy_set_header %s \"%s\";", r.Name, r.Value)
+	case HeaderAppend:
+		return fmt.Sprintf("proxy_set_header %s %s;", r.Name, r.appendVariable())
+	case HeaderRemove:
+		// nginx does not pass request headers set to an empty value
+		return fmt.Sprintf("proxy_set_header %s \"\";", r.Name)
+	case HeaderAdd:
+		return fmt.Sprintf("add_header %s \"%s\" always;", r.Name, r.Value)
+	case HeaderHide:
+		return fmt.Sprintf("proxy_hide_header %s;", r.Name)
+	default:
+		return ""
+	}
+}
+
+// requestHeaderVariable returns the variable holding a request header
+func requestHeaderVariable(name string) string {
+	return "$http_" + strings.ReplaceAll(strings.ToLower(name), "-", "_")
+}
+
+// appendVariable names the map variable holding an appended header value
+func (r HeaderRule) appendVariable() string {
+	sum := fnv.New32a()
+	sum.Write([]byte(r.Value))
+	name := strings.ReplaceAll(strings.ToLower(r.Name), "-", "_")
+	return fmt.Sprintf("$nubi_append_%s_%08x", regexp.MustCompile(`[^a-z0-9_]`).ReplaceAllString(name, "_"), sum.Sum32())
+}
+
+// appendMap returns the http-level map behind an append rule. The value is
+// joined to the client's header with a comma, or sent alone when the client
+// did not send the header.
+func (r HeaderRule) appendMap() string {
+	source := requestHeaderVariable(r.Name)
+	return fmt.Sprintf("map %s %s {\n    \"\" \"%s\";\n    default \"%s, %s\";\n}", source, r.appendVariable(), r.Value, source, r.Value)
+}
+
+// headerRuleMaps returns the http-level maps needed by a set of rules
+func headerRuleMaps(rules []HeaderRule) []string {
+	var maps []string
+	for _, r := range rules {
+		if r.Action == HeaderAppend {
+			maps = append(maps, r.appendMap())
+		}
+	}
+	return maps
+}
+
+// validateHeaderValue rejects values that could break out of the quoted
+// directive argument or reference variables outside the allowlist
+func validateHeaderValue(value string) error {
+	for _, c := range value {
+		if c < 0x20 || c == 0x7f {
+			return fmt.Errorf("header value must not contain control characters or newlines")
+		}
+		if c == '"' || c == '\\' {
+			return fmt.Errorf("header value must not contain quotes or backslashes")
+		}
+	}
+
+	for i := strings.IndexByte(value, '$'); i >= 0; {
+		m := headerVariableRegex.FindStringSubmatch(value[i:])
+		if m == nil {
+			return fmt.Errorf("invalid variable reference in header value: %q", value[i:])
+		}
+		name := m[1] + m[2]
+		if !headerVariableAllowed(name) {
+			return fmt.Errorf("variable $%s is not allowed in header values", name)
+		}
+
+		next := strings.IndexByte(value[i+len(m[0]):], '$')
+		if next < 0 {
+			break
+		}
+		i += len(m[0]) + next
+	}
+	return nil
+}
+
+// headerVariableAllowed reports whether a variable is on the allowlist
+func headerVariableAllowed(name string) bool {
+	if headerVariables[name] {
+		return true
+	}
+	for _, prefix := range headerVariablePrefixes {
+		if strings.HasPrefix(name, prefix) && len(name) > len(prefix) && name == strings.ToLower(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateHeaderRules checks the header rules of a host or location
+func validateHeaderRules(rules []HeaderRule) error {
+	seen := make(map[string]bool)
+	for _, r := range rules {
+		if !headerNameRegex.MatchString(r.Name) {
+			return fmt.Errorf("invalid header name: %q", r.Name)
+		}
+
+		switch r.Action {
+		case HeaderSet, HeaderAppend, HeaderAdd:
+			if r.Value == "" {
+				return fmt.Errorf("header %s: %s requires a value", r.Name, r.Action)
+			}
+			if err := validateHeaderValue(r.Value); err != nil {
+				return fmt.Errorf("header %s: %w", r.Name, err)
+			}
+		case HeaderRemove, HeaderHide:
+			if r.Value != "" {
+				return fmt.Errorf("header %s: %s does not take a value", r.Name, r.Action)
+			}
+			// HTTP/1.1 upstreams reject requests without a Host header
+			if r.Action == HeaderRemove && strings.EqualFold(r.Name, "Host") {
+				return fmt.Errorf("the Host header cannot be removed, set it instead")
+			}
+		default:
+			return fmt.Errorf("invalid header action: %s (expected set, append, remove, add or hide)", r.Action)
+		}
+
+		if seen[r.key()] {
+			return fmt.Errorf("header %s has conflicting rules", r.Name)
+		}
+		seen[r.key()] = true
+	}
+	return nil
+}
+
+// mergeHeaderRules returns the host's rules with those replaced by the
+// location's rules for the same header, followed by the location's rules
+func mergeHeaderRules(host, location []HeaderRule) []HeaderRule {
+	overridden := make(map[string]bool)
+	for _, r := range location {
+		overridden[r.key()] = true
+	}
+
+	var merged []HeaderRule
+	for _, r := range host {
+		if !overridden[r.key()] {
+			merged = append(merged, r)
+		}
+	}
+	return append(merged, location...)
+}
+
+// proxyRuleDirectives returns the request header and proxy_hide_header
+// directives of a set of rules. add_header rules are rendered separately
+// because of nginx's inheritance rules.
+func proxyRuleDirectives(rules []HeaderRule) []string {
+	var lines, hides []string
+	for _, r := range rules {
+		switch {
+		case r.requestSide():
+			lines = append(lines, r.Directive())
+		case r.Action == HeaderHide:
+			hides = append(hides, r.Directive())
+		}
+	}
+	return append(lines, hides...)
+}
+
+// addHeaderDirectives returns the add_header directives of a set of rules
+func addHeaderDirectives(rules []HeaderRule) []string {
+	var lines []string
+	for _, r := range rules {
+		if r.Action == HeaderAdd {
+			lines = append(lines, r.Directive())
+		}
+	}
+	return lines
+}
+
+// ResponseHeaderDirectives returns the add_header directives of the host's
+// rules, rendered in the server block
+func (h *ProxyHost) ResponseHeaderDirectives() []string {
+	return addHeaderDirectives(h.HeaderRules)
+}
+
+// serverAddHeaders returns every add_header directive of the server block.
+// nginx drops inherited add_header directives in a location that has its own,
+// so such locations repeat them.
+func (h *ProxyHost) serverAddHeaders() []string {
+	var lines []string
+	if h.SSL {
+		if hsts := h.TLSSettings().HSTS; hsts.Enabled {
+			lines = append(lines, fmt.Sprintf("add_header Strict-Transport-Security \"%s\" always;", hsts.Value()))
+		}
+	}
+	lines = append(lines, h.SecurityHeaderDirectives()...)
+	if v := h.StickyCookieHeader(); v != "" {
+		lines = append(lines, "add_header Set-Cookie "+v+";")
+	}
+	if h.CachingEnabled() {
+		lines = append(lines, cacheStatusHeader)
+	}
+	return lines
+}
+
+// requestHeaderOverrides returns the lowercased names of the request headers
+// changed by a set of rules
+func requestHeaderOverrides(rules []HeaderRule) map[string]bool {
+	names := make(map[string]bool)
+	for _, r := range rules {
+		if r.requestSide() {
+			names[strings.ToLower(r.Name)] = true
+		}
+	}
+	return names
+}
+
+// RootHeaderOptions returns the proxy_headers options of location /
+func (h *ProxyHost) RootHeaderOptions() ProxyHeaderOptions {
+	opts := h.headerOptions(h.WebSocket, h.UsesUpstream())
+	opts.Rules = proxyRuleDirectives(h.HeaderRules)
+	opts.overrides = requestHeaderOverrides(h.HeaderRules)
+	return opts
+}
+
+// LocationHeaderOptions returns the proxy_headers options of a location,
+// whose header rules are layered over the host's
+func (h *ProxyHost) LocationHeaderOptions(l Location) ProxyHeaderOptions {
+	rules := mergeHeaderRules(h.HeaderRules, l.HeaderRules)
+	opts := h.headerOptions(l.WebSocket, l.HasUpstream())
+	opts.Rules = proxyRuleDirectives(rules)
+	if len(addHeaderDirectives(l.HeaderRules)) > 0 || l.CORSPolicyID != "" {
+		opts.Rules = append(opts.Rules, h.serverAddHeaders()...)
+		opts.Rules = append(opts.Rules, addHeaderDirectives(rules)...)
+		opts.CORS = h.LocationCORSPolicyID(l)
+	}
+	opts.overrides = requestHeaderOverrides(rules)
+	return opts
+}
+
+// Keeps reports whether a standard proxy header is sent, i.e. no header rule
+// replaces or removes it
+func (o ProxyHeaderOptions) Keeps(name string) bool {
+	return !o.overrides[strings.ToLower(name)]
+}
@@ -0,0 +1,528 @@
+package nginx
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Health check types
+const (
+	HealthCheckHTTP  = "http"
+	HealthCheckHTTPS = "https"
+	HealthCheckTCP   = "tcp"
+)
+
+// HealthCheck configures active health checking of a host's backends
+type HealthCheck struct {
+	Type         string `json:"type"`         // http (default), https or tcp
+	Path         string `json:"path"`         // Request path for http(s) checks (default /)
+	ExpectStatus int    `json:"expectStatus"` // Required status code (0 = any 2xx or 3xx)
+	ExpectBody   string `json:"expectBody"`   // Substring the response body must contain (optional)
+	Interval     int    `json:"interval"`     // Seconds between checks (default 10)
+	Timeout      int    `json:"timeout"`      // Seconds before a check fails (default 5)
+	Rise         int    `json:"rise"`         // Consecutive successes to mark a backend up (default 2)
+	Fall         int    `json:"fall"`         // Consecutive failures to mark a backend down (default 3)
+}
+
+// normalize fills in defaults
+func (hc *HealthCheck) normalize() {
+	if hc.Type == "" {
+		hc.Type = HealthCheckHTTP
+	}
+	if hc.Path == "" && hc.Type != HealthCheckTCP {
+		hc.Path = "/"
+	}
+	if hc.Interval == 0 {
+		hc.Interval = 10
+	}
+	if hc.Timeout == 0 {
+		hc.Timeout = 5
+	}
+	if hc.Rise == 0 {
+		hc.Rise = 2
+	}
+	if hc.Fall == 0 {
+		hc.Fall = 3
+	}
+}
+
+// Validate checks the health check settings
+func (hc *HealthCheck) Validate() error {
+	switch hc.Type {
+	case HealthCheckHTTP, HealthCheckHTTPS:
+		if !strings.HasPrefix(hc.Path, "/") || strings.ContainsAny(hc.Path, " \r\n") {
+			return fmt.Errorf("invalid health check path: %s", hc.Path)
+		}
+		if hc.ExpectStatus != 0 && (hc.ExpectStatus < 100 || hc.ExpectStatus > 599) {
+			return fmt.Errorf("invalid health check status: %d", hc.ExpectStatus)
+		}
+	case HealthCheckTCP:
+		if hc.Path != "" || hc.ExpectStatus != 0 || hc.ExpectBody != "" {
+			return fmt.Errorf("tcp health checks do not take a path, status or body")
+		}
+	default:
+		return fmt.Errorf("invalid health check type: %s (expected http, https or tcp)", hc.Type)
+	}
+
+	if hc.Interval < 1 || hc.Interval > 3600 {
+		return fmt.Errorf("health check interval must be between 1 and 3600 seconds")
+	}
+	if hc.Timeout < 1 || hc.Timeout > hc.Interval {
+		return fmt.Errorf("health check timeout must be between 1 second and the interval")
+	}
+	if hc.Rise < 1 || hc.Rise > 10 || hc.Fall < 1 || hc.Fall > 10 {
+		return fmt.Errorf("health check rise and fall must be between 1 and 10")
+	}
+	return nil
+}
+
+// validateHealthCheck normalizes and checks the optional health check of a
+// host. Only upstream backends are probed, so a host proxying to a single
+// target has nothing to check.
+func validateHealthCheck(host *ProxyHost) error {
+	hc := host.HealthCheck
+	if hc == nil {
+		return nil
+	}
+	if len(host.backendAddresses()) == 0 {
+		return fmt.Errorf("health checks require backends on the host, its locations or a rollout")
+	}
+	hc.normalize()
+	return hc.Validate()
+}
+
+// BackendHealth is the current health of a single backend
+type BackendHealth struct {
+	Address   string    `json:"address"`
+	Healthy   bool      `json:"healthy"`
+	Successes int       `json:"successes"` // Consecutive successful checks
+	Failures  int       `json:"failures"`  // Consecutive failed checks
+	LastCheck time.Time `json:"lastCheck"`
+	LastError string    `json:"lastError,omitempty"`
+	Since     time.Time `json:"since"` // When the backend last changed state
+}
+
+// HealthEvent reports a backend changing state
+type HealthEvent struct {
+	HostID  string `json:"hostId"`
+	Domain  string `json:"domain"`
+	Address string `json:"address"`
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error,omitempty"`
+}
+
+// HealthChecker probes the backends of every host with a health check and
+// marks failed backends down in their upstreams. State changes found in one
+// round are applied together with a single nginx -t and reload.
+type HealthChecker struct {
+	mu        sync.Mutex
+	hosts     *ProxyHostManager
+	ctrl      *Controller
+	client    *http.Client
+	state     map[string]map[string]*BackendHealth // host ID -> address -> health
+	lastRun   map[string]time.Time                 // host ID -> last probe round
+	pending   bool                                 // the last apply failed and is retried on the next tick
+	listeners []func(HealthEvent)
+}
+
+// NewHealthChecker creates a health checker for the given hosts
+func NewHealthChecker(hosts *ProxyHostManager, ctrl *Controller) *HealthChecker {
+	return &HealthChecker{
+		hosts: hosts,
+		ctrl:  ctrl,
+		client: &http.Client{
+			Transport: &http.Transport{
+				// Backends are usually addressed by IP and commonly use
+				// self-signed certificates
+				TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+				DisableKeepAlives: true,
+			},
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		state:   make(map[string]map[string]*BackendHealth),
+		lastRun: make(map[string]time.Time),
+	}
+}
+
+// OnEvent registers a callback for backend state changes
+func (c *HealthChecker) OnEvent(fn func(HealthEvent)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.listeners = append(c.listeners, fn)
+}
+
+// Start runs the checker until ctx is cancelled
+func (c *HealthChecker) Start(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				c.tick(ctx)
+			}
+		}
+	}()
+}
+
+// Health returns the current health of a host's backends, ordered by address
+func (c *HealthChecker) Health(hostID string) []BackendHealth {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	health := make([]BackendHealth, 0, len(c.state[hostID]))
+	for _, h := range c.state[hostID] {
+		health = append(health, *h)
+	}
+	sort.Slice(health, func(i, j int) bool { return health[i].Address < health[j].Address })
+	return health
+}
+
+// healthProbe is a single backend check due in a round
+type healthProbe struct {
+	host    *ProxyHost
+	address string
+	err     error
+}
+
+// tick probes every host whose interval has elapsed and applies the
+// resulting state changes
+func (c *HealthChecker) tick(ctx context.Context) {
+	now := time.Now()
+	probes, pruned := c.dueProbes(now)
+
+	var wg sync.WaitGroup
+	for i := range probes {
+		wg.Add(1)
+		go func(p *healthProbe) {
+			defer wg.Done()
+			p.err = c.probe(ctx, p.host, p.address)
+		}(&probes[i])
+	}
+	wg.Wait()
+
+	c.mu.Lock()
+	var events []HealthEvent
+	for _, p := range probes {
+		if ev, changed := c.record(p, now); changed {
+			events = append(events, ev)
+		}
+	}
+	down := c.downSets()
+	pending := c.pending
+	listeners := append([]func(HealthEvent){}, c.listeners...)
+	c.mu.Unlock()
+
+	if len(events) == 0 && !pruned && !pending {
+		return
+	}
+
+	changed, err := c.hosts.SetDownBackends(ctx, down)
+	c.mu.Lock()
+	c.pending = err != nil
+	c.mu.Unlock()
+	if err != nil {
+		log.Printf("warning: failed to apply backend health: %v", err)
+	} else if changed && c.ctrl != nil {
+		if err := c.ctrl.Reload(ctx); err != nil {
+			log.Printf("warning: nginx reload after health change failed: %v", err)
+		}
+	}
+
+	for _, ev := range events {
+		if ev.Healthy {
+			log.Printf("Backend %s of %s is up", ev.Address, ev.Domain)
+		} else {
+			log.Printf("Backend %s of %s is down: %s", ev.Address, ev.Domain, ev.Error)
+		}
+		for _, fn := range listeners {
+			fn(ev)
+		}
+	}
+}
+
+// dueProbes returns the checks due at now and drops state for hosts and
+// backends that are gone. pruned reports whether a host lost its state.
+func (c *HealthChecker) dueProbes(now time.Time) (probes []healthProbe, pruned bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	live := make(map[string]bool)
+	for _, h := range c.hosts.List() {
+		if h.HealthCheck == nil || !h.Enabled {
+			continue
+		}
+		live[h.ID] = true
+
+		addresses := h.backendAddresses()
+		state := c.state[h.ID]
+		if state == nil {
+			state = make(map[string]*BackendHealth)
+			c.state[h.ID] = state
+		}
+		current := make(map[string]bool)
+		for _, addr := range addresses {
+			current[addr] = true
+			if state[addr] == nil {
+				// Backends start out healthy, matching the rendered upstream
+				state[addr] = &BackendHealth{Address: addr, Healthy: true, Since: now}
+			}
+		}
+		for addr, health := range state {
+			if !current[addr] {
+				pruned = pruned || !health.Healthy
+				delete(state, addr)
+			}
+		}
+
+		if now.Sub(c.lastRun[h.ID]) < time.Duration(h.HealthCheck.Interval)*time.Second {
+			continue
+		}
+		c.lastRun[h.ID] = now
+		for _, addr := range addresses {
+			probes = append(probes, healthProbe{host: h, address: addr})
+		}
+	}
+
+	for id := range c.state {
+		if !live[id] {
+			delete(c.state, id)
+			delete(c.lastRun, id)
+			pruned = true
+		}
+	}
+	return probes, pruned
+}
+
+// record applies a probe result using the rise/fall thresholds. Callers
+// must hold c.mu.
+func (c *HealthChecker) record(p healthProbe, now time.Time) (HealthEvent, bool) {
+	health := c.state[p.host.ID][p.address]
+	if health == nil {
+		return HealthEvent{}, false
+	}
+	hc := p.host.HealthCheck
+
+	health.LastCheck = now
+	changed := false
+	if p.err == nil {
+		health.Successes++
+		health.Failures = 0
+		health.LastError = ""
+		if !health.Healthy && health.Successes >= hc.Rise {
+			health.Healthy = true
+			changed = true
+		}
+	} else {
+		health.Failures++
+		health.Successes = 0
+		health.LastError = p.err.Error()
+		if health.Healthy && health.Failures >= hc.Fall {
+			health.Healthy = false
+			changed = true
+		}
+	}
+	if !changed {
+		return HealthEvent{}, false
+	}
+
+	health.Since = now
+	return HealthEvent{
+		HostID:  p.host.ID,
+		Domain:  p.host.Domain,
+		Address: p.address,
+		Healthy: health.Healthy,
+		Error:   health.LastError,
+	}, true
+}
+
+// downSets returns the unhealthy backends of every host. Callers must hold c.mu.
+func (c *HealthChecker) downSets() map[string]map[string]bool {
+	down := make(map[string]map[string]bool)
+	for id, state := range c.state {
+		for addr, health := range state {
+			if health.Healthy {
+				continue
+			}
+			if down[id] == nil {
+				down[id] = make(map[string]bool)
+			}
+			down[id][addr] = true
+		}
+	}
+	return down
+}
+
+// probe checks a single backend
+func (c *HealthChecker) probe(ctx context.Context, host *ProxyHost, address string) error {
+	hc := host.HealthCheck
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(hc.Timeout)*time.Second)
+	defer cancel()
+
+	defaultPort := "80"
+	if hc.Type == HealthCheckHTTPS {
+		defaultPort = "443"
+	}
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, defaultPort)
+	}
+
+	if hc.Type == HealthCheckTCP {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", address)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, hc.Type+"://"+address+hc.Path, nil)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(host.Domain, "*.") {
+		req.Host = host.Domain
+	}
+	req.Header.Set("User-Agent", "Nubi-HealthCheck")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if hc.ExpectStatus != 0 {
+		if resp.StatusCode != hc.ExpectStatus {
+			return fmt.Errorf("unexpected status %d (expected %d)", resp.StatusCode, hc.ExpectStatus)
+		}
+	} else if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	if hc.ExpectBody != "" {
+		body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		if err != nil {
+			return err
+		}
+		if !strings.Contains(string(body), hc.ExpectBody) {
+			return fmt.Errorf("response body does not contain %q", hc.ExpectBody)
+		}
+	}
+	return nil
+}
+
+// backendAddresses returns the distinct backend addresses of the host and
+// its locations
+func (h *ProxyHost) backendAddresses() []string {
+	seen := make(map[string]bool)
+	var addresses []string
+	add := func(backends []Backend) {
+		for _, b := range backends {
+			if !seen[b.Address] {
+				seen[b.Address] = true
+				addresses = append(addresses, b.Address)
+			}
+		}
+	}
+
+	add(h.Backends)
+	if h.Rollout != nil {
+		add(h.Rollout.Candidate)
+	}
+	for _, l := range h.Locations {
+		add(l.Backends)
+	}
+	return addresses
+}
+
+// hasUpstreams reports whether any upstream block is rendered for the host
+func (h *ProxyHost) hasUpstreams() bool {
+	if h.UsesUpstream() {
+		return true
+	}
+	for _, l := range h.Locations {
+		if l.HasUpstream() {
+			return true
+		}
+	}
+	return false
+}
+
+// SetDownBackends replaces the backends marked down by health checks and
+// re-renders the hosts whose upstreams change in one transaction. This is
+// runtime state: it is neither persisted nor recorded in the history. It
+// reports whether any config was written.
+func (m *ProxyHostManager) SetDownBackends(ctx context.Context, down map[string]map[string]bool) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var changed []*ProxyHost
+	for id, host := range m.hosts {
+		if host.hasUpstreams() && !sameSet(m.down[id], down[id]) {
+			changed = append(changed, host)
+		}
+	}
+
+	prev := m.down
+	m.down = down
+	if len(changed) == 0 {
+		return false, nil
+	}
+
+	tx := NewTransaction(m.ctrl)
+	for _, host := range changed {
+		if err := m.stageHost(tx, host); err != nil {
+			m.down = prev
+			return false, err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		m.down = prev
+		return false, err
+	}
+	return true, nil
+}
+
+// downBackends returns the backends of an upstream that health checks
+// marked down. When every backend failed none are marked, so nginx keeps
+// trying them rather than failing every request. Callers must hold m.mu.
+func (m *ProxyHostManager) downBackends(hostID string, backends []Backend) map[string]bool {
+	down := m.down[hostID]
+	marked := make(map[string]bool)
+	for _, b := range backends {
+		if down[b.Address] {
+			marked[b.Address] = true
+		}
+	}
+	for _, b := range backends {
+		if !marked[b.Address] {
+			return marked
+		}
+	}
+	return nil
+}
+
+func sameSet(a, b map[string]bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k := range a {
+		if !b[k] {
+			return false
+		}
+	}
+	return true
+}
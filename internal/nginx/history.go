@@ -0,0 +1,236 @@
+package nginx
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Entity kinds recorded in the revision history
+const (
+	EntityProxyHost    = "proxy_host"
+	EntityDefaultRoute = "default_route"
+	EntityStreamHost   = "stream_host"
+	EntityRedirectHost = "redirect_host"
+	EntityStaticHost   = "static_host"
+	EntityAccessList   = "access_list"
+	EntityErrorPage    = "error_page"
+	EntityCORSPolicy   = "cors_policy"
+)
+
+// Revision is a single recorded configuration change
+type Revision struct {
+	ID        string          `json:"id"`
+	Seq       int64           `json:"seq"`      // Monotonic sequence number
+	Entity    string          `json:"entity"`   // e.g., "proxy_host", "default_route"
+	EntityID  string          `json:"entityId"` // ID of the changed object
+	Action    string          `json:"action"`   // e.g., "create", "update", "delete", "restore"
+	Before    json.RawMessage `json:"before"`   // JSON state before the change (null if created)
+	After     json.RawMessage `json:"after"`    // JSON state after the change (null if deleted)
+	Rendered  string          `json:"rendered"` // nginx config rendered for the new state
+	Actor     string          `json:"actor"`    // Who made the change
+	CreatedAt time.Time       `json:"createdAt"`
+}
+
+// RevisionDiff is the difference between two revisions
+type RevisionDiff struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Config string `json:"config"` // Unified diff of the rendered nginx config
+	State  string `json:"state"`  // Unified diff of the JSON state
+}
+
+type actorKey struct{}
+
+// WithActor returns a context carrying the name of whoever is making a change
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext returns the actor stored by WithActor, or "system"
+func ActorFromContext(ctx context.Context) string {
+	if ctx != nil {
+		if actor, ok := ctx.Value(actorKey{}).(string); ok && actor != "" {
+			return actor
+		}
+	}
+	return "system"
+}
+
+// HistoryStore is an append-only log of configuration revisions backed by a
+// JSON lines file
+type HistoryStore struct {
+	mu        sync.RWMutex
+	file      string // e.g., /var/lib/nubi/history.jsonl
+	revisions []*Revision
+	seq       int64
+}
+
+// NewHistoryStore opens the revision log, loading any existing entries
+func NewHistoryStore(file string) (*HistoryStore, error) {
+	if file == "" {
+		file = "/var/lib/nubi/history.jsonl"
+	}
+
+	h := &HistoryStore{file: file}
+	if err := h.load(); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to load history: %w", err)
+	}
+
+	return h, nil
+}
+
+func (h *HistoryStore) load() error {
+	f, err := os.Open(h.file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var rev Revision
+		if err := json.Unmarshal(scanner.Bytes(), &rev); err != nil {
+			// Skip a torn trailing line rather than losing the whole log
+			continue
+		}
+		h.revisions = append(h.revisions, &rev)
+		if rev.Seq > h.seq {
+			h.seq = rev.Seq
+		}
+	}
+
+	return scanner.Err()
+}
+
+// Record appends a revision to the log. ID, sequence, actor and timestamp are
+// filled in here.
+func (h *HistoryStore) Record(ctx context.Context, rev *Revision) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	rev.ID = uuid.New().String()
+	rev.Seq = h.seq + 1
+	rev.Actor = ActorFromContext(ctx)
+	rev.CreatedAt = time.Now()
+
+	line, err := json.Marshal(rev)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(h.file), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(h.file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+
+	h.seq = rev.Seq
+	h.revisions = append(h.revisions, rev)
+	return nil
+}
+
+// List returns revisions newest first, optionally filtered by entity and ID.
+// A limit of zero returns everything.
+func (h *HistoryStore) List(entity, entityID string, limit int) []*Revision {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	result := make([]*Revision, 0)
+	for i := len(h.revisions) - 1; i >= 0; i-- {
+		rev := h.revisions[i]
+		if entity != "" && rev.Entity != entity {
+			continue
+		}
+		if entityID != "" && rev.EntityID != entityID {
+			continue
+		}
+		result = append(result, rev)
+		if limit > 0 && len(result) >= limit {
+			break
+		}
+	}
+	return result
+}
+
+// Get returns a revision by ID
+func (h *HistoryStore) Get(id string) (*Revision, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for _, rev := range h.revisions {
+		if rev.ID == id {
+			return rev, nil
+		}
+	}
+	return nil, fmt.Errorf("revision not found: %s", id)
+}
+
+// Diff returns the unified diff between two revisions, oldest first
+func (h *HistoryStore) Diff(fromID, toID string) (*RevisionDiff, error) {
+	from, err := h.Get(fromID)
+	if err != nil {
+		return nil, err
+	}
+	to, err := h.Get(toID)
+	if err != nil {
+		return nil, err
+	}
+
+	revs := []*Revision{from, to}
+	sort.Slice(revs, func(i, j int) bool { return revs[i].Seq < revs[j].Seq })
+	from, to = revs[0], revs[1]
+
+	fromName := fmt.Sprintf("revision %d (%s)", from.Seq, from.ID)
+	toName := fmt.Sprintf("revision %d (%s)", to.Seq, to.ID)
+
+	return &RevisionDiff{
+		From:   from.ID,
+		To:     to.ID,
+		Config: UnifiedDiff(fromName, toName, from.Rendered, to.Rendered),
+		State:  UnifiedDiff(fromName, toName, indentJSON(from.After), indentJSON(to.After)),
+	}, nil
+}
+
+// indentJSON pretty-prints raw JSON so state diffs are line oriented
+func indentJSON(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return string(raw)
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return string(raw)
+	}
+	return string(data) + "\n"
+}
+
+// marshalRevisionState encodes an entity for Revision.Before/After; nil
+// values become JSON null
+func marshalRevisionState(v interface{}) json.RawMessage {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return json.RawMessage("null")
+	}
+	return data
+}
@@ -0,0 +1,68 @@
+package nginx
+
+import (
+	"sort"
+	"strings"
+)
+
+// renderHTTPConfig returns the http-level include shared by all proxy hosts,
+// holding definitions nginx only accepts in the http context (e.g. rate limit
+// zones). Definitions used by several hosts are written once. It returns nil
+// when no host needs any. Callers must hold m.mu.
+func (m *ProxyHostManager) renderHTTPConfig(hosts map[string]*ProxyHost) []byte {
+	seen := make(map[string]bool)
+	var defs []string
+	add := func(lines []string) {
+		for _, line := range lines {
+			if !seen[line] {
+				seen[line] = true
+				defs = append(defs, line)
+			}
+		}
+	}
+
+	for _, h := range hosts {
+		if h.needsConnectionUpgradeMap() {
+			add([]string{connectionUpgradeMap})
+		}
+		add(h.rateLimitZones())
+		if h.StickyCookieHeader() != "" {
+			add(h.LBOptions.stickyMaps())
+		}
+		add(headerRuleMaps(h.HeaderRules))
+		if h.CachingEnabled() {
+			add([]string{h.cachePathDefinition()})
+		}
+		if h.Rollout != nil {
+			add(h.rolloutDefinitions())
+		}
+		add(h.mirrorDefinitions())
+		add(m.corsDefinitions(h))
+		for _, l := range h.Locations {
+			add(headerRuleMaps(l.HeaderRules))
+		}
+	}
+
+	if len(defs) == 0 {
+		return nil
+	}
+	sort.Strings(defs)
+
+	var b strings.Builder
+	b.WriteString("# Nubi managed http-level definitions\n")
+	b.WriteString("# Do not edit manually - changes will be overwritten\n\n")
+	for _, line := range defs {
+		b.WriteString(line + "\n")
+	}
+	return []byte(b.String())
+}
+
+// stageHTTPConfig stages the http-level include for the given host set,
+// removing it when nothing needs it
+func (m *ProxyHostManager) stageHTTPConfig(tx *Transaction, hosts map[string]*ProxyHost) {
+	if data := m.renderHTTPConfig(hosts); data != nil {
+		tx.WriteFile(m.httpConfigPath, data, 0644)
+	} else {
+		tx.Remove(m.httpConfigPath)
+	}
+}
@@ -71,6 +71,34 @@ func NewLetsEncryptManager(certManager *CertificateManager, email string, stagin
 
 // IssueCertificate issues a new Let's Encrypt certificate using DNS challenge
 func (m *LetsEncryptManager) IssueCertificate(ctx context.Context, domains []string, dnsProvider DNSProvider) (*Certificate, error) {
+	certificates, err := m.obtain(domains, dnsProvider)
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse expiration date
+	expiresAt := time.Now().Add(90 * 24 * time.Hour) // Let's Encrypt certs are valid for 90 days
+
+	// Create certificate entry
+	cert := &Certificate{
+		Name:      domains[0],
+		Domains:   domains,
+		Type:      "letsencrypt",
+		ExpiresAt: expiresAt,
+		AutoRenew: true,
+	}
+
+	// Save certificate to manager
+	created, err := m.certManager.CreateCertificate(ctx, cert, certificates.Certificate, certificates.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save certificate: %w", err)
+	}
+
+	return created, nil
+}
+
+// obtain runs the ACME DNS challenge and returns the issued certificate bundle
+func (m *LetsEncryptManager) obtain(domains []string, dnsProvider DNSProvider) (*certificate.Resource, error) {
 	// Create data directory
 	if err := os.MkdirAll(m.dataDir, 0700); err != nil {
 		return nil, fmt.Errorf("failed to create data directory: %w", err)
@@ -137,25 +165,7 @@ func (m *LetsEncryptManager) IssueCertificate(ctx context.Context, domains []str
 		return nil, fmt.Errorf("failed to obtain certificate: %w", err)
 	}
 
-	// Parse expiration date
-	expiresAt := time.Now().Add(90 * 24 * time.Hour) // Let's Encrypt certs are valid for 90 days
-
-	// Create certificate entry
-	cert := &Certificate{
-		Name:      domains[0],
-		Domains:   domains,
-		Type:      "letsencrypt",
-		ExpiresAt: expiresAt,
-		AutoRenew: true,
-	}
-
-	// Save certificate to manager
-	created, err := m.certManager.CreateCertificate(ctx, cert, certificates.Certificate, certificates.PrivateKey)
-	if err != nil {
-		return nil, fmt.Errorf("failed to save certificate: %w", err)
-	}
-
-	return created, nil
+	return certificates, nil
 }
 
 // RenewCertificate renews an existing Let's Encrypt certificate
@@ -170,23 +180,20 @@ func (m *LetsEncryptManager) RenewCertificate(ctx context.Context, certID string
 		return fmt.Errorf("certificate is not a Let's Encrypt certificate")
 	}
 
-	// Issue new certificate
-	newCert, err := m.IssueCertificate(ctx, cert.Domains, dnsProvider)
+	// Obtain a fresh certificate for the same domains
+	certificates, err := m.obtain(cert.Domains, dnsProvider)
 	if err != nil {
 		return fmt.Errorf("failed to renew certificate: %w", err)
 	}
 
-	// Update existing certificate with new paths
-	cert.CertPath = newCert.CertPath
-	cert.KeyPath = newCert.KeyPath
-	cert.ExpiresAt = newCert.ExpiresAt
-	cert.UpdatedAt = time.Now()
-
-	m.certManager.mu.Lock()
-	m.certManager.certs[certID] = cert
-	m.certManager.mu.Unlock()
+	// Replace the files in place; bound hosts are re-rendered in the same
+	// transaction by the certificate manager's applier
+	expiresAt := time.Now().Add(90 * 24 * time.Hour)
+	if _, _, err := m.certManager.ReplaceCertificateFiles(ctx, certID, certificates.Certificate, certificates.PrivateKey, expiresAt); err != nil {
+		return fmt.Errorf("failed to save renewed certificate: %w", err)
+	}
 
-	return m.certManager.save()
+	return nil
 }
 
 // AutoRenewCheck checks all certificates and renews if needed (within 30 days of expiry)
@@ -0,0 +1,312 @@
+package nginx
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Location match types
+const (
+	MatchPrefix = "prefix" // location /path
+	MatchExact  = "exact"  // location = /path
+	MatchRegex  = "regex"  // location ~ regex (or ~* when case-insensitive)
+)
+
+// ProxyHeader is an extra request header sent to the upstream
+type ProxyHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Location routes a path of a proxy host to its own target or backends
+type Location struct {
+	ID              string        `json:"id"`
+	Path            string        `json:"path"`                // e.g., "/api" or "^/v[0-9]+/"
+	MatchType       string        `json:"matchType"`           // prefix, exact or regex
+	CaseInsensitive bool          `json:"caseInsensitive"`     // Regex only: use ~* instead of ~
+	Target          string        `json:"target"`              // e.g., "http://127.0.0.1:4000" (used for single backend)
+	Backends        []Backend     `json:"backends"`            // Backend set for this location
+	LBMethod        string        `json:"lbMethod"`            // Load balancing method for the backend set (sticky is host only)
+	LBOptions       *LBOptions    `json:"lbOptions,omitempty"` // Options of the hash and random methods
+	StripPrefix     bool          `json:"stripPrefix"`         // Prefix only: remove the matched prefix before proxying
+	Rewrite         string        `json:"rewrite"`             // Replacement path (prefix: replaces the prefix, exact/regex: full URI)
+	WebSocket       bool          `json:"websocket"`           // Enable WebSocket support
+	Headers         []ProxyHeader `json:"headers"`             // Extra proxy_set_header lines
+	AccessListID    string        `json:"accessListId"`        // Access list replacing the host's for this path (optional)
+	CORSPolicyID    string        `json:"corsPolicyId"`        // CORS policy replacing the host's for this path (optional)
+	RateLimit       *RateLimit    `json:"rateLimit,omitempty"` // Limits replacing the host's for this path (optional)
+	HeaderRules     []HeaderRule  `json:"headerRules"`         // Header rules layered over the host's
+	Mirror          *Mirror       `json:"mirror,omitempty"`    // Shadow traffic replacing the host's mirror for this path (optional)
+}
+
+// Modifier returns the nginx location modifier for the match type
+func (l Location) Modifier() string {
+	switch l.MatchType {
+	case MatchExact:
+		return "= "
+	case MatchRegex:
+		if l.CaseInsensitive {
+			return "~* "
+		}
+		return "~ "
+	default:
+		return ""
+	}
+}
+
+// Pattern returns the location pattern, quoting regexes so braces and
+// semicolons inside them do not end the directive
+func (l Location) Pattern() string {
+	if l.MatchType == MatchRegex {
+		return `"` + l.Path + `"`
+	}
+	return l.Path
+}
+
+// HasUpstream reports whether the location proxies to its own backend set
+func (l Location) HasUpstream() bool {
+	return len(l.Backends) > 0
+}
+
+// RewriteDirective returns the rewrite line for the location, if any
+func (l Location) RewriteDirective() string {
+	switch l.MatchType {
+	case MatchPrefix:
+		if !l.StripPrefix && l.Rewrite == "" {
+			return ""
+		}
+		prefix := strings.TrimSuffix(l.Path, "/")
+		replacement := strings.TrimSuffix(l.Rewrite, "/")
+		return fmt.Sprintf(`rewrite "^%s/?(.*)$" %s/$1 break;`, regexp.QuoteMeta(prefix), replacement)
+	case MatchExact:
+		if l.StripPrefix {
+			return "rewrite ^ / break;"
+		}
+		if l.Rewrite != "" {
+			return "rewrite ^ " + l.Rewrite + " break;"
+		}
+	case MatchRegex:
+		if l.Rewrite != "" {
+			return fmt.Sprintf(`rewrite "%s" %s break;`, l.Path, l.Rewrite)
+		}
+	}
+	return ""
+}
+
+// LocationUpstream returns the upstream name used by one of the host's locations
+func (h *ProxyHost) LocationUpstream(l Location) string {
+	id := l.ID
+	if len(id) > 8 {
+		id = id[:8]
+	}
+	return h.UpstreamName() + "_loc_" + regexp.MustCompile(`[^a-zA-Z0-9]`).ReplaceAllString(id, "_")
+}
+
+// OrderedLocations returns the host's locations in nginx precedence order:
+// exact matches, then prefixes longest first, then regexes in the order they
+// were defined (nginx uses the first matching regex).
+func (h *ProxyHost) OrderedLocations() []Location {
+	rank := func(l Location) int {
+		switch l.MatchType {
+		case MatchExact:
+			return 0
+		case MatchRegex:
+			return 2
+		default:
+			return 1
+		}
+	}
+
+	ordered := append([]Location{}, h.Locations...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		ri, rj := rank(ordered[i]), rank(ordered[j])
+		if ri != rj {
+			return ri < rj
+		}
+		if ri == 1 {
+			return len(ordered[i].Path) > len(ordered[j].Path)
+		}
+		return false
+	})
+	return ordered
+}
+
+// AddLocation appends a location to a host
+func (m *ProxyHostManager) AddLocation(ctx context.Context, hostID string, loc Location) (*Location, error) {
+	loc.ID = uuid.New().String()
+	err := m.modify(ctx, hostID, "location_add", true, func(host *ProxyHost) error {
+		host.Locations = append(append([]Location{}, host.Locations...), loc)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m.location(hostID, loc.ID)
+}
+
+// UpdateLocation replaces a location of a host, keeping its ID
+func (m *ProxyHostManager) UpdateLocation(ctx context.Context, hostID, locationID string, loc Location) (*Location, error) {
+	err := m.modify(ctx, hostID, "location_update", true, func(host *ProxyHost) error {
+		locations := append([]Location{}, host.Locations...)
+		for i := range locations {
+			if locations[i].ID == locationID {
+				loc.ID = locationID
+				locations[i] = loc
+				host.Locations = locations
+				return nil
+			}
+		}
+		return fmt.Errorf("location not found: %s", locationID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m.location(hostID, locationID)
+}
+
+// DeleteLocation removes a location from a host
+func (m *ProxyHostManager) DeleteLocation(ctx context.Context, hostID, locationID string) error {
+	return m.modify(ctx, hostID, "location_delete", true, func(host *ProxyHost) error {
+		locations := make([]Location, 0, len(host.Locations))
+		for _, l := range host.Locations {
+			if l.ID != locationID {
+				locations = append(locations, l)
+			}
+		}
+		if len(locations) == len(host.Locations) {
+			return fmt.Errorf("location not found: %s", locationID)
+		}
+		host.Locations = locations
+		return nil
+	})
+}
+
+// location returns a copy of a host's location by ID
+func (m *ProxyHostManager) location(hostID, locationID string) (*Location, error) {
+	host, err := m.Get(hostID)
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range host.Locations {
+		if l.ID == locationID {
+			loc := l
+			return &loc, nil
+		}
+	}
+	return nil, fmt.Errorf("location not found: %s", locationID)
+}
+
+// unsafePathChars are characters that would break out of a location directive
+var unsafePathChars = regexp.MustCompile(`[\s;{}"']`)
+
+// headerNameRegex matches valid HTTP header names
+var headerNameRegex = regexp.MustCompile(`^[A-Za-z0-9!#$%&'*+.^_` + "`" + `|~-]+$`)
+
+// normalizeLocations fills in IDs and default match types
+func normalizeLocations(host *ProxyHost) {
+	for i := range host.Locations {
+		l := &host.Locations[i]
+		if l.ID == "" {
+			l.ID = uuid.New().String()
+		}
+		if l.MatchType == "" {
+			l.MatchType = MatchPrefix
+		}
+	}
+}
+
+// validateLocation checks a single location
+func validateLocation(l *Location) error {
+	switch l.MatchType {
+	case MatchPrefix, MatchExact:
+		if !strings.HasPrefix(l.Path, "/") {
+			return fmt.Errorf("location path must start with /: %s", l.Path)
+		}
+		if unsafePathChars.MatchString(l.Path) {
+			return fmt.Errorf("location path contains invalid characters: %s", l.Path)
+		}
+		if l.MatchType == MatchPrefix && l.Path == "/" {
+			return fmt.Errorf("location / is served by the host target; use the host target instead")
+		}
+	case MatchRegex:
+		if l.Path == "" {
+			return fmt.Errorf("location regex is required")
+		}
+		if strings.ContainsAny(l.Path, "\"\n\r") {
+			return fmt.Errorf("location regex contains invalid characters: %s", l.Path)
+		}
+		if l.StripPrefix {
+			return fmt.Errorf("stripPrefix is only supported for prefix locations; use rewrite instead")
+		}
+	default:
+		return fmt.Errorf("invalid location match type: %s", l.MatchType)
+	}
+
+	if l.Rewrite != "" && (!strings.HasPrefix(l.Rewrite, "/") || unsafePathChars.MatchString(l.Rewrite)) {
+		return fmt.Errorf("invalid rewrite path: %s", l.Rewrite)
+	}
+
+	if err := validateBackends(l.Backends); err != nil {
+		return fmt.Errorf("location %s: %w", l.Path, err)
+	}
+	normalizeLBOptions(l.LBMethod, &l.LBOptions)
+	if err := validateBalancing(l.LBMethod, l.LBOptions, l.Backends, false); err != nil {
+		return fmt.Errorf("location %s: %w", l.Path, err)
+	}
+	if len(l.Backends) == 0 {
+		if err := validateTarget(l.Target); err != nil {
+			return fmt.Errorf("location %s: %w", l.Path, err)
+		}
+		// nginx refuses a URI part in proxy_pass inside regex locations
+		if l.MatchType == MatchRegex {
+			if u, err := url.Parse(l.Target); err == nil && u.Path != "" {
+				return fmt.Errorf("location %s: target must not contain a path for regex locations", l.Path)
+			}
+		}
+	}
+
+	for _, hdr := range l.Headers {
+		if !headerNameRegex.MatchString(hdr.Name) {
+			return fmt.Errorf("location %s: invalid header name: %q", l.Path, hdr.Name)
+		}
+		if strings.ContainsAny(hdr.Value, "\"\n\r") {
+			return fmt.Errorf("location %s: invalid value for header %s", l.Path, hdr.Name)
+		}
+	}
+
+	if err := validateRateLimit(l.RateLimit); err != nil {
+		return fmt.Errorf("location %s: %w", l.Path, err)
+	}
+	if err := validateHeaderRules(l.HeaderRules); err != nil {
+		return fmt.Errorf("location %s: %w", l.Path, err)
+	}
+	if err := validateMirror(l.Mirror); err != nil {
+		return fmt.Errorf("location %s: %w", l.Path, err)
+	}
+
+	return nil
+}
+
+// validateLocations checks every location of a host and rejects duplicates
+func validateLocations(host *ProxyHost) error {
+	seen := make(map[string]bool)
+	for i := range host.Locations {
+		l := &host.Locations[i]
+		if err := validateLocation(l); err != nil {
+			return err
+		}
+
+		key := l.Modifier() + l.Path
+		if seen[key] {
+			return fmt.Errorf("duplicate location: %s%s", l.Modifier(), l.Path)
+		}
+		seen[key] = true
+	}
+	return nil
+}
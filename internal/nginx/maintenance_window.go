@@ -0,0 +1,767 @@
+package nginx
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Maintenance window scopes
+const (
+	MaintenanceScopeHost   = "host"   // A single proxy host
+	MaintenanceScopeTag    = "tag"    // Every proxy host carrying a tag
+	MaintenanceScopeGlobal = "global" // The default route
+)
+
+// Maintenance window recurrences
+const (
+	RecurrenceNone   = "none"
+	RecurrenceDaily  = "daily"
+	RecurrenceWeekly = "weekly"
+)
+
+// maintenanceTimeLayout is the wall clock format of window start and end
+// times, interpreted in the window's time zone
+const maintenanceTimeLayout = "2006-01-02T15:04"
+
+// maintenanceDateLayout is the format of the last day a recurring window may
+// start
+const maintenanceDateLayout = "2006-01-02"
+
+// MaintenanceWindow is a scheduled period during which the targeted hosts
+// serve the maintenance page
+type MaintenanceWindow struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	Scope      string    `json:"scope"`           // host, tag or global
+	Target     string    `json:"target"`          // Host ID or tag ID; empty for global
+	Start      string    `json:"start"`           // Local start time, e.g. "2026-10-20T02:00"
+	End        string    `json:"end"`             // Local end time, after start
+	TimeZone   string    `json:"timeZone"`        // IANA time zone of start and end (default UTC)
+	Recurrence string    `json:"recurrence"`      // none (default), daily or weekly
+	Until      string    `json:"until,omitempty"` // Last day a recurring window may start, e.g. "2026-12-31" (optional)
+	Message    string    `json:"message"`         // Shown on the maintenance page (optional)
+	CreatedAt  time.Time `json:"createdAt"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+// validateMaintenanceWindow normalizes and checks a window
+func validateMaintenanceWindow(w *MaintenanceWindow) error {
+	w.Name = strings.TrimSpace(w.Name)
+	if w.Name == "" {
+		return fmt.Errorf("maintenance window name is required")
+	}
+
+	switch w.Scope {
+	case MaintenanceScopeHost, MaintenanceScopeTag:
+		if w.Target == "" {
+			return fmt.Errorf("maintenance window scope %s requires a target", w.Scope)
+		}
+	case MaintenanceScopeGlobal:
+		if w.Target != "" {
+			return fmt.Errorf("global maintenance windows do not take a target")
+		}
+	default:
+		return fmt.Errorf("invalid maintenance window scope: %s (expected host, tag or global)", w.Scope)
+	}
+
+	if w.TimeZone == "" {
+		w.TimeZone = "UTC"
+	}
+	if w.Recurrence == "" {
+		w.Recurrence = RecurrenceNone
+	}
+
+	start, end, err := w.bounds()
+	if err != nil {
+		return err
+	}
+	if !end.After(start) {
+		return fmt.Errorf("maintenance window must end after it starts")
+	}
+
+	switch w.Recurrence {
+	case RecurrenceNone:
+		if w.Until != "" {
+			return fmt.Errorf("until is only valid for recurring maintenance windows")
+		}
+	case RecurrenceDaily, RecurrenceWeekly:
+		if end.Sub(start) >= w.period() {
+			return fmt.Errorf("a %s maintenance window must be shorter than its period", w.Recurrence)
+		}
+		if w.Until != "" {
+			until, err := time.ParseInLocation(maintenanceDateLayout, w.Until, start.Location())
+			if err != nil {
+				return fmt.Errorf("invalid until date %q (expected YYYY-MM-DD)", w.Until)
+			}
+			if !w.startsBy(start) {
+				return fmt.Errorf("until %s is before the first start on %s", until.Format(maintenanceDateLayout), start.Format(maintenanceDateLayout))
+			}
+		}
+	default:
+		return fmt.Errorf("invalid maintenance window recurrence: %s (expected none, daily or weekly)", w.Recurrence)
+	}
+
+	if strings.ContainsAny(w.Message, "\r\n") {
+		return fmt.Errorf("maintenance message must be a single line")
+	}
+	return nil
+}
+
+// bounds parses the first occurrence of the window
+func (w *MaintenanceWindow) bounds() (start, end time.Time, err error) {
+	loc, err := time.LoadLocation(w.TimeZone)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid time zone: %s", w.TimeZone)
+	}
+	start, err = time.ParseInLocation(maintenanceTimeLayout, w.Start, loc)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid start time %q (expected YYYY-MM-DDTHH:MM)", w.Start)
+	}
+	end, err = time.ParseInLocation(maintenanceTimeLayout, w.End, loc)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid end time %q (expected YYYY-MM-DDTHH:MM)", w.End)
+	}
+	return start, end, nil
+}
+
+// period returns the nominal distance between occurrences
+func (w *MaintenanceWindow) period() time.Duration {
+	if w.Recurrence == RecurrenceWeekly {
+		return 7 * 24 * time.Hour
+	}
+	return 24 * time.Hour
+}
+
+// occurrence returns the k-th occurrence of the window. Recurring windows
+// keep their local wall clock times across daylight saving changes.
+func (w *MaintenanceWindow) occurrence(start, end time.Time, k int) (time.Time, time.Time) {
+	days := k
+	if w.Recurrence == RecurrenceWeekly {
+		days = 7 * k
+	}
+	return start.AddDate(0, 0, days), end.AddDate(0, 0, days)
+}
+
+// startsBy reports whether an occurrence starting at t is within Until
+func (w *MaintenanceWindow) startsBy(t time.Time) bool {
+	if w.Until == "" {
+		return true
+	}
+	until, err := time.ParseInLocation(maintenanceDateLayout, w.Until, t.Location())
+	if err != nil {
+		return false
+	}
+	return t.Before(until.AddDate(0, 0, 1))
+}
+
+// Occurrence returns the occurrence in progress at now, or else the next one.
+// ok is false once the window has no occurrence left.
+func (w *MaintenanceWindow) Occurrence(now time.Time) (start, end time.Time, ok bool) {
+	first, last, err := w.bounds()
+	if err != nil {
+		return time.Time{}, time.Time{}, false
+	}
+	if w.Recurrence == RecurrenceNone {
+		return first, last, now.Before(last)
+	}
+
+	// Estimate the occurrence index from elapsed time, then step around it
+	// to absorb daylight saving shifts
+	k := 0
+	if now.After(first) {
+		k = int(now.Sub(first)/w.period()) - 1
+		if k < 0 {
+			k = 0
+		}
+	}
+	for ; ; k++ {
+		start, end := w.occurrence(first, last, k)
+		if !w.startsBy(start) {
+			return time.Time{}, time.Time{}, false
+		}
+		if now.Before(end) {
+			return start, end, true
+		}
+	}
+}
+
+// ActiveAt reports whether the window is in progress at now
+func (w *MaintenanceWindow) ActiveAt(now time.Time) bool {
+	start, _, ok := w.Occurrence(now)
+	return ok && !now.Before(start)
+}
+
+// MaintenanceEvent reports a maintenance window starting or ending
+type MaintenanceEvent struct {
+	WindowID string   `json:"windowId"`
+	Name     string   `json:"name"`
+	Scope    string   `json:"scope"`
+	Target   string   `json:"target,omitempty"`
+	Hosts    []string `json:"hosts,omitempty"` // Hosts the window targets, when it starts
+	Enabled  bool     `json:"enabled"`
+	Message  string   `json:"message"`
+}
+
+// GlobalMaintenanceFunc switches the default route maintenance page without
+// reloading nginx. It reports whether anything changed.
+type GlobalMaintenanceFunc func(ctx context.Context, enabled bool, message string) (bool, error)
+
+// maintenanceSchedule is the persisted scheduler state
+type maintenanceSchedule struct {
+	Windows []*MaintenanceWindow `json:"windows"`
+	Active  []string             `json:"active"` // Windows in progress at the last run
+	Hosts   []string             `json:"hosts"`  // Hosts the scheduler put into maintenance
+	Global  bool                 `json:"global"` // Whether the scheduler enabled global maintenance
+}
+
+// MaintenanceScheduler puts hosts into maintenance while their windows are in
+// progress. Windows and the hosts it switched are persisted, so windows that
+// started or ended while nubid was down are caught up on start. The changes
+// due at one time are applied together with a single reload, and hosts put
+// into maintenance by hand are left alone.
+type MaintenanceScheduler struct {
+	mu        sync.Mutex
+	hosts     *ProxyHostManager
+	ctrl      *Controller
+	global    GlobalMaintenanceFunc
+	dataFile  string // e.g., /var/lib/nubi/maintenance_windows.json
+	windows   map[string]*MaintenanceWindow
+	active    map[string]bool               // window ID -> in progress
+	deleted   map[string]*MaintenanceWindow // windows deleted while in progress, until their end is reported
+	owned     map[string]bool               // host ID -> put into maintenance by a window
+	ownGlobal bool
+	wake      chan struct{}
+	listeners []func(MaintenanceEvent)
+}
+
+// NewMaintenanceScheduler creates a scheduler for the given hosts. global
+// switches the default route for global windows.
+func NewMaintenanceScheduler(hosts *ProxyHostManager, ctrl *Controller, global GlobalMaintenanceFunc, dataFile string) (*MaintenanceScheduler, error) {
+	if dataFile == "" {
+		dataFile = "/var/lib/nubi/maintenance_windows.json"
+	}
+
+	s := &MaintenanceScheduler{
+		hosts:    hosts,
+		ctrl:     ctrl,
+		global:   global,
+		dataFile: dataFile,
+		windows:  make(map[string]*MaintenanceWindow),
+		active:   make(map[string]bool),
+		deleted:  make(map[string]*MaintenanceWindow),
+		owned:    make(map[string]bool),
+		wake:     make(chan struct{}, 1),
+	}
+
+	if err := s.load(); err != nil {
+		// Not a fatal error - might be first run
+		fmt.Printf("Note: Could not load existing maintenance windows: %v\n", err)
+	}
+
+	return s, nil
+}
+
+// load reads windows and scheduler state from the JSON data file
+func (s *MaintenanceScheduler) load() error {
+	data, err := os.ReadFile(s.dataFile)
+	if err != nil {
+		return err
+	}
+
+	var schedule maintenanceSchedule
+	if err := json.Unmarshal(data, &schedule); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, w := range schedule.Windows {
+		s.windows[w.ID] = w
+	}
+	for _, id := range schedule.Active {
+		s.active[id] = true
+	}
+	for _, id := range schedule.Hosts {
+		s.owned[id] = true
+	}
+	s.ownGlobal = schedule.Global
+	return nil
+}
+
+// save writes windows and scheduler state. Callers must hold s.mu.
+func (s *MaintenanceScheduler) save(ctx context.Context, windows map[string]*MaintenanceWindow) error {
+	schedule := maintenanceSchedule{
+		Windows: make([]*MaintenanceWindow, 0, len(windows)),
+		Active:  sortedKeys(s.active),
+		Hosts:   sortedKeys(s.owned),
+		Global:  s.ownGlobal,
+	}
+	for _, w := range windows {
+		schedule.Windows = append(schedule.Windows, w)
+	}
+	sort.Slice(schedule.Windows, func(i, j int) bool { return schedule.Windows[i].ID < schedule.Windows[j].ID })
+
+	data, err := json.MarshalIndent(schedule, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tx := NewTransaction(s.ctrl)
+	tx.WriteState(s.dataFile, data)
+	return tx.Commit(ctx)
+}
+
+// sortedKeys returns the keys of a set in order
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// OnEvent registers a callback for windows starting and ending
+func (s *MaintenanceScheduler) OnEvent(fn func(MaintenanceEvent)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listeners = append(s.listeners, fn)
+}
+
+// Start runs the scheduler until ctx is cancelled. It first catches up on
+// windows that started or ended while nubid was not running.
+func (s *MaintenanceScheduler) Start(ctx context.Context) {
+	go func() {
+		for {
+			next := s.tick(ctx)
+			timer := time.NewTimer(time.Until(next))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-s.wake:
+				timer.Stop()
+			case <-timer.C:
+			}
+		}
+	}()
+}
+
+// poke makes the scheduler re-evaluate the windows right away
+func (s *MaintenanceScheduler) poke() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// List returns all windows ordered by their next start
+func (s *MaintenanceScheduler) List() []*MaintenanceWindow {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	windows := make([]*MaintenanceWindow, 0, len(s.windows))
+	for _, w := range s.windows {
+		windows = append(windows, w)
+	}
+	sort.Slice(windows, func(i, j int) bool {
+		a, _, _ := windows[i].Occurrence(now)
+		b, _, _ := windows[j].Occurrence(now)
+		if !a.Equal(b) {
+			return a.Before(b)
+		}
+		return windows[i].Name < windows[j].Name
+	})
+	return windows
+}
+
+// Get returns a window by ID
+func (s *MaintenanceScheduler) Get(id string) (*MaintenanceWindow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w, ok := s.windows[id]
+	if !ok {
+		return nil, fmt.Errorf("maintenance window not found: %s", id)
+	}
+	return w, nil
+}
+
+// Active reports whether a window is currently applied
+func (s *MaintenanceScheduler) Active(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.active[id]
+}
+
+// ActiveGlobal returns the message of the global window in progress, if any
+func (s *MaintenanceScheduler) ActiveGlobal() (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.ownGlobal {
+		return "", false
+	}
+	for _, w := range s.sortedActive() {
+		if w.Scope == MaintenanceScopeGlobal {
+			return w.Message, true
+		}
+	}
+	return "", true
+}
+
+// checkTarget makes sure the host a window targets exists
+func (s *MaintenanceScheduler) checkTarget(w *MaintenanceWindow) error {
+	if w.Scope == MaintenanceScopeHost {
+		if _, err := s.hosts.Get(w.Target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Create adds a window. It is applied right away when already in progress.
+func (s *MaintenanceScheduler) Create(ctx context.Context, w *MaintenanceWindow) error {
+	if err := validateMaintenanceWindow(w); err != nil {
+		return err
+	}
+	if err := s.checkTarget(w); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w.ID = uuid.New().String()
+	w.CreatedAt = time.Now()
+	w.UpdatedAt = time.Now()
+
+	next := s.cloneWindows()
+	next[w.ID] = w
+	if err := s.save(ctx, next); err != nil {
+		return err
+	}
+	s.windows = next
+	s.poke()
+	return nil
+}
+
+// Update replaces a window. A window in progress ends or changes as soon as
+// the scheduler runs next.
+func (s *MaintenanceScheduler) Update(ctx context.Context, id string, updates *MaintenanceWindow) error {
+	if err := validateMaintenanceWindow(updates); err != nil {
+		return err
+	}
+	if err := s.checkTarget(updates); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.windows[id]
+	if !ok {
+		return fmt.Errorf("maintenance window not found: %s", id)
+	}
+
+	w := *updates
+	w.ID = id
+	w.CreatedAt = current.CreatedAt
+	w.UpdatedAt = time.Now()
+
+	next := s.cloneWindows()
+	next[id] = &w
+	if err := s.save(ctx, next); err != nil {
+		return err
+	}
+	s.windows = next
+	s.poke()
+	return nil
+}
+
+// Delete removes a window. If it is in progress its hosts leave maintenance
+// when the scheduler runs next.
+func (s *MaintenanceScheduler) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w, ok := s.windows[id]
+	if !ok {
+		return fmt.Errorf("maintenance window not found: %s", id)
+	}
+
+	next := s.cloneWindows()
+	delete(next, id)
+	if err := s.save(ctx, next); err != nil {
+		return err
+	}
+	s.windows = next
+	if s.active[id] {
+		s.deleted[id] = w
+	}
+	s.poke()
+	return nil
+}
+
+func (s *MaintenanceScheduler) cloneWindows() map[string]*MaintenanceWindow {
+	next := make(map[string]*MaintenanceWindow, len(s.windows))
+	for id, w := range s.windows {
+		next[id] = w
+	}
+	return next
+}
+
+// sortedActive returns the windows in progress, oldest first, so the oldest
+// window decides the message when windows overlap. Callers must hold s.mu.
+func (s *MaintenanceScheduler) sortedActive() []*MaintenanceWindow {
+	var windows []*MaintenanceWindow
+	for id := range s.active {
+		if w, ok := s.windows[id]; ok {
+			windows = append(windows, w)
+		}
+	}
+	sort.Slice(windows, func(i, j int) bool { return windows[i].CreatedAt.Before(windows[j].CreatedAt) })
+	return windows
+}
+
+// windowHosts returns the IDs of the hosts a window targets
+func (s *MaintenanceScheduler) windowHosts(w *MaintenanceWindow) []string {
+	switch w.Scope {
+	case MaintenanceScopeHost:
+		if _, err := s.hosts.Get(w.Target); err == nil {
+			return []string{w.Target}
+		}
+	case MaintenanceScopeTag:
+		var ids []string
+		for _, h := range s.hosts.List() {
+			for _, tag := range h.Tags {
+				if tag == w.Target {
+					ids = append(ids, h.ID)
+					break
+				}
+			}
+		}
+		sort.Strings(ids)
+		return ids
+	}
+	return nil
+}
+
+// tick brings hosts in line with the windows in progress, reports windows
+// that started or ended and returns when it should run next
+func (s *MaintenanceScheduler) tick(ctx context.Context) time.Time {
+	next, events := s.apply(ctx)
+
+	s.mu.Lock()
+	listeners := append([]func(MaintenanceEvent){}, s.listeners...)
+	s.mu.Unlock()
+
+	for _, ev := range events {
+		if ev.Enabled {
+			log.Printf("Maintenance window %q started", ev.Name)
+		} else {
+			log.Printf("Maintenance window %q ended", ev.Name)
+		}
+		for _, fn := range listeners {
+			fn(ev)
+		}
+	}
+	return next
+}
+
+// apply switches the hosts whose windows started or ended since the last run
+// and reloads nginx once
+func (s *MaintenanceScheduler) apply(ctx context.Context) (time.Time, []MaintenanceEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	// Re-check at least every minute so hosts newly tagged during a window
+	// follow it
+	next := now.Add(time.Minute)
+
+	active := make(map[string]bool)
+	for id, w := range s.windows {
+		start, end, ok := w.Occurrence(now)
+		if !ok {
+			continue
+		}
+		if now.Before(start) {
+			if start.Before(next) {
+				next = start
+			}
+			continue
+		}
+		active[id] = true
+		if end.Before(next) {
+			next = end
+		}
+	}
+
+	prevActive := s.active
+	s.active = active
+	windows := s.sortedActive()
+
+	// Hosts wanted in maintenance, with the message of the oldest window
+	wanted := make(map[string]string)
+	hostsOf := make(map[string][]string)
+	globalMessage, wantGlobal := "", false
+	for _, w := range windows {
+		if w.Scope == MaintenanceScopeGlobal {
+			if !wantGlobal {
+				globalMessage, wantGlobal = w.Message, true
+			}
+			continue
+		}
+		hostsOf[w.ID] = s.windowHosts(w)
+		for _, id := range hostsOf[w.ID] {
+			if _, ok := wanted[id]; !ok {
+				wanted[id] = w.Message
+			}
+		}
+	}
+
+	enable := make(map[string]string)
+	for id, msg := range wanted {
+		if !s.owned[id] {
+			enable[id] = msg
+		}
+	}
+	var disable []string
+	for id := range s.owned {
+		if _, ok := wanted[id]; !ok {
+			disable = append(disable, id)
+		}
+	}
+
+	reload := false
+	enabled, changed, err := s.hosts.SetScheduledMaintenance(ctx, enable, disable)
+	if err != nil {
+		log.Printf("warning: failed to apply maintenance windows: %v", err)
+		s.active = prevActive
+		return now.Add(time.Minute), nil
+	}
+	reload = changed
+	for _, id := range disable {
+		delete(s.owned, id)
+	}
+	for _, id := range enabled {
+		s.owned[id] = true
+	}
+
+	if s.global != nil && wantGlobal != s.ownGlobal {
+		changed, err := s.global(ctx, wantGlobal, globalMessage)
+		if err != nil {
+			log.Printf("warning: failed to switch global maintenance: %v", err)
+		} else {
+			// A global maintenance enabled by hand is neither taken over
+			// nor ended by a window
+			s.ownGlobal = wantGlobal && changed
+			reload = reload || changed
+		}
+	}
+
+	if reload && s.ctrl != nil {
+		if err := s.ctrl.Reload(ctx); err != nil {
+			log.Printf("warning: nginx reload after maintenance window change failed: %v", err)
+		}
+	}
+
+	var events []MaintenanceEvent
+	for _, w := range windows {
+		if !prevActive[w.ID] {
+			events = append(events, w.event(true, hostsOf[w.ID]))
+		}
+	}
+	for id := range prevActive {
+		if active[id] {
+			continue
+		}
+		w, ok := s.windows[id]
+		if !ok {
+			w, ok = s.deleted[id]
+		}
+		if ok {
+			events = append(events, w.event(false, nil))
+		} else {
+			events = append(events, MaintenanceEvent{WindowID: id, Name: id, Enabled: false})
+		}
+	}
+	s.deleted = make(map[string]*MaintenanceWindow)
+
+	if len(events) > 0 || reload {
+		if err := s.save(ctx, s.windows); err != nil {
+			log.Printf("warning: failed to save maintenance window state: %v", err)
+		}
+	}
+	return next, events
+}
+
+// event builds the event of a window starting or ending
+func (w *MaintenanceWindow) event(enabled bool, hosts []string) MaintenanceEvent {
+	return MaintenanceEvent{
+		WindowID: w.ID,
+		Name:     w.Name,
+		Scope:    w.Scope,
+		Target:   w.Target,
+		Hosts:    hosts,
+		Enabled:  enabled,
+		Message:  w.Message,
+	}
+}
+
+// SetScheduledMaintenance puts hosts into maintenance with a message and
+// takes others out of it, in one transaction. Hosts already in maintenance
+// are not enabled again and are left out of enabled; hosts that are gone are
+// skipped. changed reports whether any config was written.
+func (m *ProxyHostManager) SetScheduledMaintenance(ctx context.Context, enable map[string]string, disable []string) (enabled []string, changed bool, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	next := m.cloneHosts()
+	var written []*ProxyHost
+	for id, msg := range enable {
+		current, ok := m.hosts[id]
+		if !ok || current.Maintenance {
+			continue
+		}
+		host := *current
+		host.Maintenance = true
+		host.MaintenanceMessage = msg
+		host.UpdatedAt = time.Now()
+		next[id] = &host
+		written = append(written, &host)
+		enabled = append(enabled, id)
+	}
+	for _, id := range disable {
+		current, ok := m.hosts[id]
+		if !ok || !current.Maintenance {
+			continue
+		}
+		host := *current
+		host.Maintenance = false
+		host.MaintenanceMessage = ""
+		host.UpdatedAt = time.Now()
+		next[id] = &host
+		written = append(written, &host)
+	}
+
+	if len(written) == 0 {
+		return enabled, false, nil
+	}
+	if err := m.commit(ctx, "maintenance_window", next, written, nil); err != nil {
+		return nil, false, err
+	}
+	return enabled, true, nil
+}
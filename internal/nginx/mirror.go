@@ -0,0 +1,171 @@
+package nginx
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+)
+
+// Mirror copies requests to a shadow backend. Responses of the shadow are
+// discarded, so clients only ever see the real backend's answer.
+type Mirror struct {
+	Target  string `json:"target"`  // Shadow backend without a path, e.g. "http://10.0.0.9:8080"
+	Body    bool   `json:"body"`    // Also mirror request bodies
+	Percent int    `json:"percent"` // Share of requests mirrored, 1-100 (default 100)
+	Timeout int    `json:"timeout"` // Seconds to wait for the shadow backend (default 5)
+}
+
+// validateMirror normalizes and checks optional mirror settings
+func validateMirror(m *Mirror) error {
+	if m == nil {
+		return nil
+	}
+	if err := validateTarget(m.Target); err != nil {
+		return fmt.Errorf("mirror %w", err)
+	}
+	u, err := url.Parse(m.Target)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid mirror target: %s", m.Target)
+	}
+	// The original request URI is appended to the target
+	if (u.Path != "" && u.Path != "/") || u.RawQuery != "" {
+		return fmt.Errorf("mirror target must not have a path: %s", m.Target)
+	}
+	m.Target = u.Scheme + "://" + u.Host
+
+	if m.Percent == 0 {
+		m.Percent = 100
+	}
+	if m.Percent < 1 || m.Percent > 100 {
+		return fmt.Errorf("mirror percent must be between 1 and 100")
+	}
+	if m.Timeout == 0 {
+		m.Timeout = 5
+	}
+	if m.Timeout < 1 || m.Timeout > 300 {
+		return fmt.Errorf("mirror timeout must be between 1 and 300 seconds")
+	}
+	return nil
+}
+
+// mirrorView is a mirror as rendered for the host or one of its locations
+type mirrorView struct {
+	*Mirror
+	URI      string // Internal location sending the copies
+	Upstream string // Upstream holding the shadow backend
+	Sample   string // split_clients variable picking the mirrored requests, "" when all are
+}
+
+// mirrorNameRegex matches characters not allowed in mirror names
+var mirrorNameRegex = regexp.MustCompile(`[^a-zA-Z0-9]`)
+
+// newMirrorView returns the view of a mirror named after a host or location
+// ID, or nil without a mirror
+func newMirrorView(m *Mirror, uri, id string) *mirrorView {
+	if m == nil {
+		return nil
+	}
+	name := "nubi_mirror_" + mirrorNameRegex.ReplaceAllString(id, "_")
+	v := &mirrorView{Mirror: m, URI: uri, Upstream: name}
+	if m.Percent < 100 {
+		v.Sample = "$" + name
+	}
+	return v
+}
+
+// Server returns the shadow backend's address for its upstream block. The
+// copies are sent through an upstream because a proxy_pass with variables
+// resolves host names at runtime, which needs a resolver.
+func (v *mirrorView) Server() string {
+	u, err := url.Parse(v.Target)
+	if err != nil {
+		return v.Target
+	}
+	if u.Port() != "" {
+		return u.Host
+	}
+	port := "80"
+	if u.Scheme == "https" {
+		port = "443"
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// scheme returns the scheme the shadow backend is reached with
+func (v *mirrorView) scheme() string {
+	if u, err := url.Parse(v.Target); err == nil && u.Scheme != "" {
+		return u.Scheme
+	}
+	return "http"
+}
+
+// HostMirror returns the view of the host's mirror, or nil
+func (h *ProxyHost) HostMirror() *mirrorView {
+	return newMirrorView(h.Mirror, "/nubi_mirror", h.ID)
+}
+
+// LocationMirror returns the view of a location's own mirror, or nil
+func (h *ProxyHost) LocationMirror(l Location) *mirrorView {
+	return newMirrorView(l.Mirror, "/nubi_mirror_"+l.ID, l.ID)
+}
+
+// MirrorViews returns every mirror of the host, each needing an internal
+// location
+func (h *ProxyHost) MirrorViews() []*mirrorView {
+	var views []*mirrorView
+	if v := h.HostMirror(); v != nil {
+		views = append(views, v)
+	}
+	for _, l := range h.Locations {
+		if v := h.LocationMirror(l); v != nil {
+			views = append(views, v)
+		}
+	}
+	return views
+}
+
+// Directives returns the directives of the server or location whose requests
+// are mirrored
+func (v *mirrorView) Directives() []string {
+	lines := []string{fmt.Sprintf("mirror %s;", v.URI)}
+	if !v.Body {
+		lines = append(lines, "mirror_request_body off;")
+	}
+	return lines
+}
+
+// LocationDirectives returns the directives of the internal location sending
+// the copies to the shadow backend
+func (v *mirrorView) LocationDirectives() []string {
+	var lines []string
+	if v.Sample != "" {
+		lines = append(lines, fmt.Sprintf("if (%s = 0) {\n            return 204;\n        }", v.Sample))
+	}
+	lines = append(lines,
+		fmt.Sprintf("proxy_pass %s://%s$request_uri;", v.scheme(), v.Upstream),
+		"proxy_set_header Host $host;",
+		"proxy_set_header X-Real-IP $remote_addr;",
+		"proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;",
+		"proxy_set_header X-Forwarded-Proto $scheme;",
+		fmt.Sprintf("proxy_connect_timeout %ds;", v.Timeout),
+		fmt.Sprintf("proxy_send_timeout %ds;", v.Timeout),
+		fmt.Sprintf("proxy_read_timeout %ds;", v.Timeout),
+	)
+	if !v.Body {
+		lines = append(lines, "proxy_pass_request_body off;", `proxy_set_header Content-Length "";`)
+	}
+	return lines
+}
+
+// mirrorDefinitions returns the http-level split_clients sampling the
+// host's mirrored requests
+func (h *ProxyHost) mirrorDefinitions() []string {
+	var defs []string
+	for _, v := range h.MirrorViews() {
+		if v.Sample != "" {
+			defs = append(defs, fmt.Sprintf("split_clients \"${request_id}\" %s {\n    %d%% 1;\n    * 0;\n}", v.Sample, v.Percent))
+		}
+	}
+	return defs
+}
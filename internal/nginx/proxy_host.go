@@ -1,9 +1,12 @@
 package nginx
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"log"
+	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -17,30 +20,55 @@ import (
 
 // Backend represents a single backend server for load balancing
 type Backend struct {
-	Address string `json:"address"` // e.g., "127.0.0.1:3000"
-	Weight  int    `json:"weight"`  // Load balancing weight (1-100)
-	Backup  bool   `json:"backup"`  // Is this a backup server?
+	Address     string `json:"address"`            // e.g., "127.0.0.1:3000"
+	Weight      int    `json:"weight"`             // Load balancing weight (1-100)
+	Backup      bool   `json:"backup"`             // Is this a backup server?
+	MaxFails    *int   `json:"maxFails,omitempty"` // Failed attempts before the server is skipped (nginx default 1, 0 disables)
+	FailTimeout int    `json:"failTimeout"`        // Seconds a failed server is skipped, also the window for max_fails (0 = nginx default 10s)
+	MaxConns    int    `json:"maxConns"`           // Concurrent connections to the server (0 = unlimited)
+	SlowStart   int    `json:"slowStart"`          // Seconds to ramp the weight back up after recovery (NGINX Plus only)
 }
 
 // ProxyHost represents a single reverse proxy configuration
 type ProxyHost struct {
-	ID            string    `json:"id"`
-	Domain        string    `json:"domain"`        // e.g., "example.com" or "*.example.com"
-	Target        string    `json:"target"`        // e.g., "http://127.0.0.1:3000" (used for single backend)
-	Backends      []Backend `json:"backends"`      // Multiple backends for load balancing
-	LBMethod      string    `json:"lbMethod"`      // Load balancing method: round_robin, least_conn, ip_hash
-	SSL           bool      `json:"ssl"`           // Enable SSL/HTTPS
-	ForceSSL      bool      `json:"forceSSL"`      // Redirect HTTP to HTTPS
-	CertificateID string    `json:"certificateId"` // ID of the certificate to use
-	CertPath      string    `json:"certPath"`      // Path to SSL certificate
-	KeyPath       string    `json:"keyPath"`       // Path to SSL private key
-	Enabled       bool      `json:"enabled"`       // Whether this host is active
-	Maintenance   bool      `json:"maintenance"`   // Show maintenance page instead of proxying
-	WebSocket     bool      `json:"websocket"`     // Enable WebSocket support
-	CustomNginx   string    `json:"customNginx"`   // Custom nginx configuration
-	Tags          []string  `json:"tags"`          // Tags for grouping and bulk operations
-	CreatedAt     time.Time `json:"createdAt"`
-	UpdatedAt     time.Time `json:"updatedAt"`
+	ID                 string               `json:"id"`
+	Domain             string               `json:"domain"`                    // Primary name, e.g., "example.com" or "*.example.com"
+	Aliases            []string             `json:"aliases"`                   // Additional server names (wildcards and ~regex names allowed)
+	RedirectAliases    bool                 `json:"redirectAliases"`           // Redirect aliases to the primary domain instead of serving them
+	Target             string               `json:"target"`                    // e.g., "http://127.0.0.1:3000" (used for single backend)
+	Backends           []Backend            `json:"backends"`                  // Multiple backends for load balancing
+	LBMethod           string               `json:"lbMethod"`                  // Load balancing method: round_robin, least_conn, ip_hash, hash, random or sticky
+	LBOptions          *LBOptions           `json:"lbOptions,omitempty"`       // Options of the hash, random and sticky methods
+	SSL                bool                 `json:"ssl"`                       // Enable SSL/HTTPS
+	ForceSSL           bool                 `json:"forceSSL"`                  // Redirect HTTP to HTTPS
+	CertificateID      string               `json:"certificateId"`             // ID of the certificate to use
+	CertPath           string               `json:"certPath"`                  // Path to SSL certificate
+	KeyPath            string               `json:"keyPath"`                   // Path to SSL private key
+	ChainPath          string               `json:"chainPath"`                 // Path to CA chain used for OCSP stapling (optional)
+	TLS                *TLSPolicy           `json:"tls,omitempty"`             // TLS policy (defaults to the intermediate preset)
+	ClientAuth         *ClientAuth          `json:"clientAuth,omitempty"`      // Client certificate verification (optional)
+	Enabled            bool                 `json:"enabled"`                   // Whether this host is active
+	Maintenance        bool                 `json:"maintenance"`               // Show maintenance page instead of proxying
+	MaintenanceMessage string               `json:"maintenanceMessage"`        // Message of the scheduled window that enabled maintenance
+	WebSocket          bool                 `json:"websocket"`                 // Enable WebSocket support
+	Locations          []Location           `json:"locations"`                 // Additional path-based routes
+	AccessListID       string               `json:"accessListId"`              // Access list protecting the whole host (optional)
+	CORSPolicyID       string               `json:"corsPolicyId"`              // CORS policy applied to every location (optional)
+	RateLimit          *RateLimit           `json:"rateLimit,omitempty"`       // Request, connection and bandwidth limits (optional)
+	HealthCheck        *HealthCheck         `json:"healthCheck,omitempty"`     // Active backend health checking (optional)
+	Upstream           *UpstreamSettings    `json:"upstream,omitempty"`        // Upstream keepalive and zone settings (optional)
+	Rollout            *Rollout             `json:"rollout,omitempty"`         // Canary or blue/green release in progress (optional)
+	Mirror             *Mirror              `json:"mirror,omitempty"`          // Copy requests to a shadow backend (optional)
+	HeaderRules        []HeaderRule         `json:"headerRules"`               // Request and response header rules applied to every location
+	SecurityHeaders    *SecurityHeaders     `json:"securityHeaders,omitempty"` // Security response header bundle (optional)
+	Cache              *CachePolicy         `json:"cache,omitempty"`           // Response caching (optional)
+	Compression        *CompressionSettings `json:"compression,omitempty"`     // gzip and brotli settings (optional)
+	ErrorPages         []HostErrorPage      `json:"errorPages"`                // Pages served for error status codes
+	InterceptErrors    bool                 `json:"interceptErrors"`           // Also replace error responses sent by the backends
+	CustomNginx        string               `json:"customNginx"`               // Custom nginx configuration
+	Tags               []string             `json:"tags"`                      // Tags for grouping and bulk operations
+	CreatedAt          time.Time            `json:"createdAt"`
+	UpdatedAt          time.Time            `json:"updatedAt"`
 }
 
 // HasLoadBalancing returns true if the host has multiple backends configured
@@ -48,38 +76,106 @@ func (h *ProxyHost) HasLoadBalancing() bool {
 	return len(h.Backends) > 1
 }
 
-// UpstreamName returns the nginx upstream name for this host
+// ServerNames returns the primary domain followed by all aliases
+func (h *ProxyHost) ServerNames() []string {
+	return append([]string{h.Domain}, h.Aliases...)
+}
+
+// RedirectsAliases reports whether aliases get their own redirect server
+func (h *ProxyHost) RedirectsAliases() bool {
+	return h.RedirectAliases && len(h.Aliases) > 0
+}
+
+// ServerNameList returns the server_name value for the main server block
+func (h *ProxyHost) ServerNameList() string {
+	if h.RedirectsAliases() {
+		return h.Domain
+	}
+	return formatServerNames(h.ServerNames())
+}
+
+// AliasNameList returns the server_name value for the alias redirect block
+func (h *ProxyHost) AliasNameList() string {
+	return formatServerNames(h.Aliases)
+}
+
+// formatServerNames joins server names, quoting regex names so braces in
+// them do not end the directive
+func formatServerNames(names []string) string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		if strings.HasPrefix(name, "~") {
+			quoted[i] = `"` + name + `"`
+		} else {
+			quoted[i] = name
+		}
+	}
+	return strings.Join(quoted, " ")
+}
+
+// UpstreamName returns the nginx upstream name for this host. It is derived
+// from the ID so domains that only differ in punctuation cannot collide and
+// renaming the domain keeps the upstream and its shared memory zone.
 func (h *ProxyHost) UpstreamName() string {
-	// Create a safe name from domain
-	safe := regexp.MustCompile(`[^a-zA-Z0-9]`).ReplaceAllString(h.Domain, "_")
-	return "nubi_" + safe
+	return "nubi_" + regexp.MustCompile(`[^a-zA-Z0-9]`).ReplaceAllString(h.ID, "_")
 }
 
 // ProxyHostManager handles CRUD operations for proxy hosts
 type ProxyHostManager struct {
-	mu         sync.RWMutex
-	hosts      map[string]*ProxyHost
-	configDir  string // e.g., /etc/nginx/sites-available
-	enabledDir string // e.g., /etc/nginx/sites-enabled
-	dataFile   string // e.g., /var/lib/nubi/proxy_hosts.json
-	tmpl       *template.Template
+	mu             sync.RWMutex
+	hosts          map[string]*ProxyHost
+	ctrl           *Controller                // used to test staged config before it goes live
+	history        *HistoryStore              // optional revision log
+	peers          []nameClaimer              // other host kinds whose server names must not clash
+	accessLists    *AccessListManager         // resolves access lists referenced by hosts
+	errorPages     *ErrorPageManager          // resolves library error pages referenced by hosts
+	corsPolicies   *CORSPolicyManager         // resolves CORS policies referenced by hosts
+	accessListView map[string]*AccessList     // lists of a staged list change, see stageAccessListRefresh
+	corsPolicyView map[string]*CORSPolicy     // policies of a staged policy change, see stageCORSPolicyRefresh
+	errorPageView  map[string]*ErrorPage      // pages of a staged page change, see stageErrorPageRefresh
+	down           map[string]map[string]bool // backends marked down by health checks, by host ID
+	configDir      string                     // e.g., /etc/nginx/sites-available
+	enabledDir     string                     // e.g., /etc/nginx/sites-enabled
+	dataFile       string                     // e.g., /var/lib/nubi/proxy_hosts.json
+	httpConfigPath string                     // http-level include, e.g., /etc/nginx/conf.d/00-nubi-http.conf
+	tmpl           *template.Template
+	snippetPolicy  SnippetPolicy // applied to CustomNginx before it is saved
 }
 
 const proxyHostTemplate = `# Nubi managed proxy host: {{ .Domain }}
 # Do not edit manually - changes will be overwritten
 # Host ID: {{ .ID }}
 
-{{- if .HasLoadBalancing }}
+{{- if .UsesUpstream }}
 # Load Balancing Upstream
 upstream {{ .UpstreamName }} {
-{{- if eq .LBMethod "least_conn" }}
-    least_conn;
-{{- else if eq .LBMethod "ip_hash" }}
-    ip_hash;
+{{- template "upstream_body" .HostUpstream }}
+}
+{{- end }}
+
+{{- with .CandidateUpstream }}
+
+# Rollout candidate upstream
+upstream {{ .Name }} {
+{{- template "upstream_body" . }}
+}
+{{- end }}
+
+{{- range .Locations }}
+{{- if .HasUpstream }}
+
+# Upstream for location {{ .Modifier }}{{ .Path }}
+upstream {{ $.LocationUpstream . }} {
+{{- template "upstream_body" ($.LocationUpstreamView .) }}
+}
 {{- end }}
-{{- range .Backends }}
-    server {{ .Address }}{{ if gt .Weight 1 }} weight={{ .Weight }}{{ end }}{{ if .Backup }} backup{{ end }};
 {{- end }}
+
+{{- range .MirrorViews }}
+
+# Shadow backend {{ .Target }}
+upstream {{ .Upstream }} {
+    server {{ .Server }};
 }
 {{- end }}
 
@@ -88,7 +184,7 @@ server {
 {{- if .SSL }}
     listen 443 ssl http2;
 {{- end }}
-    server_name {{ .Domain }};
+    server_name {{ .ServerNameList }};
 
 {{- if and .SSL .ForceSSL }}
     # Force HTTPS redirect
@@ -98,14 +194,85 @@ server {
 {{- end }}
 
 {{- if .SSL }}
-    # SSL Configuration (placeholder - integrate with Let's Encrypt)
-    # ssl_certificate /etc/letsencrypt/live/{{ .Domain }}/fullchain.pem;
-    # ssl_certificate_key /etc/letsencrypt/live/{{ .Domain }}/privkey.pem;
+{{- template "tls" . }}
+{{- end }}
+
+{{- with .ClientAuth }}
+
+    # Client certificate verification
+{{- range .Directives }}
+    {{ . }}
+{{- end }}
+{{- end }}
+
+{{- with .SecurityHeaderDirectives }}
+
+    # Security headers
+{{- range . }}
+    {{ . }}
+{{- end }}
+{{- end }}
+
+{{- with .StickyCookieHeader }}
+
+    # Sticky sessions
+    add_header Set-Cookie {{ . }};
+{{- end }}
+
+{{- with .Compression }}
+
+    # Compression
+{{- range .Directives }}
+    {{ . }}
+{{- end }}
+{{- end }}
+
+{{- if .CachingEnabled }}
+
+    # Response caching
+{{- range .CacheDirectives }}
+    {{ . }}
+{{- end }}
+    add_header X-Cache-Status $upstream_cache_status always;
+{{- end }}
+
+{{- with .ResponseHeaderDirectives }}
+
+    # Response headers
+{{- range . }}
+    {{ . }}
+{{- end }}
+{{- end }}
+
+{{- with .CORSPolicyID }}
+{{- with corsPolicy . }}
+{{ range .HeaderDirectives }}
+    {{ . }}
+{{- end }}
+{{- end }}
+{{- end }}
+
+{{- with .AccessListID }}
+{{ range accessDirectives . false }}
+    {{ . }}
+{{- end }}
+{{- end }}
+
+{{- with .RateLimitDirectives }}
+
+    # Rate limiting
+{{- range . }}
+    {{ . }}
+{{- end }}
 {{- end }}
 
 {{- if .Maintenance }}
     # Maintenance mode - return 503 with custom page
+{{- if .MaintenanceMessage }}
+    root {{ .ErrorPageDir }};
+{{- else }}
     root /var/lib/nubi/html;
+{{- end }}
     error_page 503 /nubi_maintenance.html;
     location / {
         return 503;
@@ -114,39 +281,203 @@ server {
         internal;
     }
 {{- else }}
-    location / {
-{{- if .HasLoadBalancing }}
-        proxy_pass http://{{ .UpstreamName }};
+
+{{- with .HostMirror }}
+
+    # Traffic mirroring to {{ .Target }}{{ if .Sample }} ({{ .Percent }}% of requests){{ end }}
+{{- range .Directives }}
+    {{ . }}
+{{- end }}
+{{- end }}
+
+{{- with .ErrorPageCodes }}
+
+    # Error pages
+{{- if $.InterceptErrors }}
+    proxy_intercept_errors on;
+{{- end }}
+{{- range . }}
+    error_page {{ . }} /nubi_error_{{ . }}.html;
+{{- end }}
+{{- range . }}
+    location = /nubi_error_{{ . }}.html {
+        internal;
+        root {{ $.ErrorPageDir }};
+    }
+{{- end }}
+{{- end }}
+{{- range .OrderedLocations }}
+
+    location {{ .Modifier }}{{ .Pattern }} {
+{{- with .AccessListID }}
+{{- range accessDirectives . true }}
+        {{ . }}
+{{- end }}
+{{- end }}
+{{- range $.LocationRateLimitDirectives . }}
+        {{ . }}
+{{- end }}
+{{- with $.LocationCORSPolicyID . }}
+{{- with corsPolicy . }}
+{{- range .LocationDirectives }}
+        {{ . }}
+{{- end }}
+{{- end }}
+{{- end }}
+{{- with $.LocationMirror . }}
+        # Traffic mirroring to {{ .Target }}{{ if .Sample }} ({{ .Percent }}% of requests){{ end }}
+{{- range .Directives }}
+        {{ . }}
+{{- end }}
+{{- end }}
+{{- with .RewriteDirective }}
+        {{ . }}
+{{- end }}
+{{- if .HasUpstream }}
+        proxy_pass http://{{ $.LocationUpstream . }};
 {{- else }}
         proxy_pass {{ .Target }};
 {{- end }}
+{{- template "proxy_headers" ($.LocationHeaderOptions .) }}
+{{- range .Headers }}
+        proxy_set_header {{ .Name }} "{{ .Value }}";
+{{- end }}
+    }
+{{- end }}
+
+    location / {
+{{- with .CORSPolicyID }}
+{{- with corsPolicy . }}
+{{- range .LocationDirectives }}
+        {{ . }}
+{{- end }}
+{{- end }}
+{{- end }}
+        proxy_pass {{ .ProxyPass }};
+{{- template "proxy_headers" .RootHeaderOptions }}
+    }
+
+{{- range .MirrorViews }}
+
+    # Shadow backend {{ .Target }} - mirrored requests only, responses are discarded
+    location = {{ .URI }} {
+        internal;
+{{- range .LocationDirectives }}
+        {{ . }}
+{{- end }}
+    }
+{{- end }}
+{{- end }}
+
+{{- if .CustomNginx }}
+
+    # Custom configuration
+{{ .CustomNginx }}
+{{- end }}
+}
+
+{{- if .RedirectsAliases }}
+
+# Redirect aliases to the primary domain
+server {
+    listen 80;
+{{- if .SSL }}
+    listen 443 ssl http2;
+    ssl_certificate {{ .CertPath }};
+    ssl_certificate_key {{ .KeyPath }};
+{{- end }}
+    server_name {{ .AliasNameList }};
+    return 301 {{ if .SSL }}https{{ else }}$scheme{{ end }}://{{ .Domain }}$request_uri;
+}
+{{- end }}
+`
+
+// upstreamBodyTemplate renders the contents of an upstream block from an
+// upstreamView
+const upstreamBodyTemplate = `{{ define "upstream_body" }}
+{{- with .MethodDirective }}
+    {{ . }}
+{{- end }}
+{{- with .Settings }}{{ with .ZoneSize }}
+    zone {{ $.Name }} {{ . }};
+{{- end }}{{ end }}
+{{- $down := downBackends .HostID .Servers }}
+{{- range .Servers }}
+    server {{ .Address }}{{ .Params }}{{ if index $down .Address }} down{{ end }};
+{{- end }}
+{{- with .Settings }}
+{{- range .KeepaliveDirectives }}
+    {{ . }}
+{{- end }}
+{{- end }}
+{{- end }}`
+
+// proxyHeadersTemplate renders the standard proxy headers shared by every
+// proxied location. Its argument is a ProxyHeaderOptions.
+const proxyHeadersTemplate = `{{ define "proxy_headers" }}
         proxy_http_version 1.1;
-        
+
         # Standard proxy headers
+{{- if .Keeps "Host" }}
         proxy_set_header Host $host;
+{{- end }}
+{{- if .Keeps "X-Real-IP" }}
         proxy_set_header X-Real-IP $remote_addr;
+{{- end }}
+{{- if .Keeps "X-Forwarded-For" }}
         proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;
+{{- end }}
+{{- if .Keeps "X-Forwarded-Proto" }}
         proxy_set_header X-Forwarded-Proto $scheme;
+{{- end }}
 
 {{- if .WebSocket }}
         # WebSocket support
+{{- if .Keeps "Upgrade" }}
         proxy_set_header Upgrade $http_upgrade;
+{{- end }}
+{{- if .Keeps "Connection" }}
+{{- if .Keepalive }}
+        proxy_set_header Connection $nubi_connection_upgrade;
+{{- else }}
         proxy_set_header Connection "upgrade";
+{{- end }}
+{{- end }}
         proxy_read_timeout 86400;
+{{- else if and .Keepalive (.Keeps "Connection") }}
+        # Reuse upstream connections
+        proxy_set_header Connection "";
+{{- end }}
+
+{{- with .ClientCert }}
+
+        # Verified client certificate
+{{- range . }}
+        {{ . }}
 {{- end }}
-    }
 {{- end }}
 
-{{- if .CustomNginx }}
+{{- with .Rules }}
 
-    # Custom configuration
-{{ .CustomNginx }}
+        # Header rules
+{{- range . }}
+        {{ . }}
 {{- end }}
-}
-`
+{{- end }}
+
+{{- with .CORS }}
+{{- with corsPolicy . }}
+{{ range .HeaderDirectives }}
+        {{ . }}
+{{- end }}
+{{- end }}
+{{- end }}
+{{- end }}`
 
-// NewProxyHostManager creates a new proxy host manager
-func NewProxyHostManager(configDir, enabledDir, dataFile string) (*ProxyHostManager, error) {
+// NewProxyHostManager creates a new proxy host manager. The http-level
+// include must be loaded from the http context; Debian-style installs include
+// conf.d/*.conf there.
+func NewProxyHostManager(ctrl *Controller, configDir, enabledDir, dataFile, httpConfigPath string) (*ProxyHostManager, error) {
 	if configDir == "" {
 		configDir = "/etc/nginx/sites-available"
 	}
@@ -156,19 +487,39 @@ func NewProxyHostManager(configDir, enabledDir, dataFile string) (*ProxyHostMana
 	if dataFile == "" {
 		dataFile = "/var/lib/nubi/proxy_hosts.json"
 	}
-
-	tmpl, err := template.New("proxy_host").Parse(proxyHostTemplate)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse proxy host template: %w", err)
+	if httpConfigPath == "" {
+		httpConfigPath = "/etc/nginx/conf.d/00-nubi-http.conf"
 	}
 
 	mgr := &ProxyHostManager{
-		hosts:      make(map[string]*ProxyHost),
-		configDir:  configDir,
-		enabledDir: enabledDir,
-		dataFile:   dataFile,
-		tmpl:       tmpl,
+		hosts:          make(map[string]*ProxyHost),
+		ctrl:           ctrl,
+		configDir:      configDir,
+		enabledDir:     enabledDir,
+		dataFile:       dataFile,
+		httpConfigPath: httpConfigPath,
+		snippetPolicy:  DefaultSnippetPolicy(),
+	}
+
+	funcs := template.FuncMap{
+		"accessDirectives": mgr.accessDirectives,
+		"corsPolicy":       mgr.corsPolicy,
+		"downBackends":     mgr.downBackends,
+	}
+	tmpl, err := template.New("proxy_host").Funcs(funcs).Parse(proxyHostTemplate)
+	if err == nil {
+		_, err = tmpl.Parse(proxyHeadersTemplate)
+	}
+	if err == nil {
+		_, err = tmpl.Parse(upstreamBodyTemplate)
 	}
+	if err == nil {
+		_, err = tmpl.Parse(tlsTemplate)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse proxy host template: %w", err)
+	}
+	mgr.tmpl = tmpl
 
 	// Load existing hosts from data file
 	if err := mgr.load(); err != nil {
@@ -196,33 +547,117 @@ func (m *ProxyHostManager) load() error {
 
 	m.hosts = make(map[string]*ProxyHost)
 	for _, h := range hosts {
+		normalizeForceSSL(h)
 		m.hosts[h.ID] = h
 	}
 
 	return nil
 }
 
-// save writes hosts to the JSON data file
-func (m *ProxyHostManager) save() error {
-	m.mu.RLock()
-	hosts := make([]*ProxyHost, 0, len(m.hosts))
-	for _, h := range m.hosts {
-		hosts = append(hosts, h)
+// marshalHosts serialises a host set for the JSON data file
+func marshalHosts(hosts map[string]*ProxyHost) ([]byte, error) {
+	list := make([]*ProxyHost, 0, len(hosts))
+	for _, h := range hosts {
+		list = append(list, h)
 	}
-	m.mu.RUnlock()
+	return json.MarshalIndent(list, "", "  ")
+}
 
-	data, err := json.MarshalIndent(hosts, "", "  ")
-	if err != nil {
+// cloneHosts returns a shallow copy of the host map so a mutation can be
+// prepared without touching the live set
+func (m *ProxyHostManager) cloneHosts() map[string]*ProxyHost {
+	next := make(map[string]*ProxyHost, len(m.hosts))
+	for id, h := range m.hosts {
+		next[id] = h
+	}
+	return next
+}
+
+// SetHistory enables revision recording for every host change
+func (m *ProxyHostManager) SetHistory(history *HistoryStore) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.history = history
+}
+
+// commit stages the config files for the written and removed hosts together
+// with the new data file, validates the result with nginx -t and only then
+// swaps next in as the live host set. Callers must hold m.mu.
+func (m *ProxyHostManager) commit(ctx context.Context, action string, next map[string]*ProxyHost, written, removed []*ProxyHost) error {
+	tx := NewTransaction(m.ctrl)
+	if err := m.stageCommit(tx, next, written, removed); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
 		return err
 	}
 
-	// Ensure directory exists
-	dir := filepath.Dir(m.dataFile)
-	if err := os.MkdirAll(dir, 0755); err != nil {
+	m.swap(ctx, action, next, written, removed)
+	return nil
+}
+
+// stageCommit stages the config files and data file of a commit into tx.
+// Callers must hold m.mu.
+func (m *ProxyHostManager) stageCommit(tx *Transaction, next map[string]*ProxyHost, written, removed []*ProxyHost) error {
+	for _, h := range removed {
+		m.stageRemove(tx, h)
+	}
+	for _, h := range written {
+		if err := m.stageHost(tx, h); err != nil {
+			return err
+		}
+	}
+	if len(written)+len(removed) > 0 {
+		m.stageHTTPConfig(tx, next)
+	}
+
+	data, err := marshalHosts(next)
+	if err != nil {
 		return err
 	}
+	tx.WriteState(m.dataFile, data)
+	return nil
+}
+
+// swap makes a committed host set live and records it. Callers must hold m.mu.
+func (m *ProxyHostManager) swap(ctx context.Context, action string, next map[string]*ProxyHost, written, removed []*ProxyHost) {
+	prev := m.hosts
+	m.hosts = next
+	m.record(ctx, action, prev, next, written, removed)
+}
+
+// record writes one revision per host touched by a committed change
+func (m *ProxyHostManager) record(ctx context.Context, action string, prev, next map[string]*ProxyHost, written, removed []*ProxyHost) {
+	if m.history == nil {
+		return
+	}
 
-	return os.WriteFile(m.dataFile, data, 0644)
+	ids := make([]string, 0, len(written)+len(removed))
+	seen := make(map[string]bool)
+	for _, h := range append(append([]*ProxyHost{}, removed...), written...) {
+		if !seen[h.ID] {
+			seen[h.ID] = true
+			ids = append(ids, h.ID)
+		}
+	}
+
+	for _, id := range ids {
+		rev := &Revision{
+			Entity:   EntityProxyHost,
+			EntityID: id,
+			Action:   action,
+			Before:   marshalRevisionState(prev[id]),
+			After:    marshalRevisionState(next[id]),
+		}
+		if host, ok := next[id]; ok {
+			if data, err := m.Render(host); err == nil {
+				rev.Rendered = string(data)
+			}
+		}
+		if err := m.history.Record(ctx, rev); err != nil {
+			log.Printf("warning: failed to record history for host %s: %v", id, err)
+		}
+	}
 }
 
 // List returns all proxy hosts
@@ -251,249 +686,593 @@ func (m *ProxyHostManager) Get(id string) (*ProxyHost, error) {
 
 // Create adds a new proxy host
 func (m *ProxyHostManager) Create(ctx context.Context, host *ProxyHost) error {
-	// Validate domain
-	if err := validateDomain(host.Domain); err != nil {
+	if err := validateHost(host); err != nil {
 		return err
 	}
-
-	// Validate target URL (only if not using load balancing)
-	if len(host.Backends) == 0 {
-		if err := validateTarget(host.Target); err != nil {
-			return err
-		}
+	if err := checkPeerNames(host.ServerNames(), m.peers); err != nil {
+		return err
 	}
 
-	// Check for duplicate domain
-	m.mu.RLock()
-	for _, h := range m.hosts {
-		if h.Domain == host.Domain {
-			m.mu.RUnlock()
-			return fmt.Errorf("domain already exists: %s", host.Domain)
-		}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Check for duplicate names across all hosts
+	if err := m.checkNameConflicts(host, ""); err != nil {
+		return err
 	}
-	m.mu.RUnlock()
 
 	// Generate ID and timestamps
 	host.ID = uuid.New().String()
 	host.CreatedAt = time.Now()
 	host.UpdatedAt = time.Now()
 
-	// Write nginx config
-	if err := m.writeNginxConfig(host); err != nil {
+	if err := m.checkSnippet(ctx, host); err != nil {
 		return err
 	}
 
-	// Add to memory
-	m.mu.Lock()
-	m.hosts[host.ID] = host
-	m.mu.Unlock()
+	next := m.cloneHosts()
+	next[host.ID] = host
 
-	// Save to disk
-	return m.save()
+	return m.commit(ctx, "create", next, []*ProxyHost{host}, nil)
 }
 
 // Update modifies an existing proxy host
 func (m *ProxyHostManager) Update(ctx context.Context, id string, updates *ProxyHost) error {
+	if err := checkPeerNames(updates.ServerNames(), m.peers); err != nil {
+		return err
+	}
+
 	m.mu.Lock()
-	host, ok := m.hosts[id]
+	defer m.mu.Unlock()
+
+	current, ok := m.hosts[id]
 	if !ok {
-		m.mu.Unlock()
 		return fmt.Errorf("proxy host not found: %s", id)
 	}
 
-	// Check domain change doesn't conflict
-	if updates.Domain != host.Domain {
-		for _, h := range m.hosts {
-			if h.ID != id && h.Domain == updates.Domain {
-				m.mu.Unlock()
-				return fmt.Errorf("domain already exists: %s", updates.Domain)
-			}
-		}
+	// Check the new names don't conflict with other hosts
+	if err := m.checkNameConflicts(updates, id); err != nil {
+		return err
 	}
 
-	oldDomain := host.Domain
-
-	// Update fields
+	// Update fields on a copy so a failed apply leaves the live host intact
+	host := *current
 	host.Domain = updates.Domain
+	host.Aliases = updates.Aliases
+	host.RedirectAliases = updates.RedirectAliases
 	host.Target = updates.Target
 	host.Backends = updates.Backends
 	host.LBMethod = updates.LBMethod
+	host.LBOptions = updates.LBOptions
 	host.SSL = updates.SSL
 	host.ForceSSL = updates.ForceSSL
 	host.Enabled = updates.Enabled
 	host.Maintenance = updates.Maintenance
+	if !host.Maintenance {
+		host.MaintenanceMessage = ""
+	}
 	host.WebSocket = updates.WebSocket
 	host.CustomNginx = updates.CustomNginx
 	host.CertificateID = updates.CertificateID
 	host.CertPath = updates.CertPath
 	host.KeyPath = updates.KeyPath
 	host.Tags = updates.Tags
+	host.ChainPath = updates.ChainPath
+	host.TLS = updates.TLS
+	host.ClientAuth = updates.ClientAuth
+	host.Locations = updates.Locations
+	host.AccessListID = updates.AccessListID
+	host.CORSPolicyID = updates.CORSPolicyID
+	host.RateLimit = updates.RateLimit
+	host.HealthCheck = updates.HealthCheck
+	host.Upstream = updates.Upstream
+	host.HeaderRules = updates.HeaderRules
+	host.SecurityHeaders = updates.SecurityHeaders
+	host.Cache = updates.Cache
+	host.Compression = updates.Compression
+	host.ErrorPages = updates.ErrorPages
+	host.InterceptErrors = updates.InterceptErrors
+	host.Mirror = updates.Mirror
 	host.UpdatedAt = time.Now()
 
-	m.mu.Unlock()
-
-	// Remove old config if domain changed
-	if oldDomain != host.Domain {
-		m.removeNginxConfig(oldDomain)
+	if err := validateHost(&host); err != nil {
+		return err
+	}
+	// Snippets saved before a policy change keep working until edited
+	if host.CustomNginx != current.CustomNginx {
+		if err := m.checkSnippet(ctx, &host); err != nil {
+			return err
+		}
 	}
 
-	// Write new nginx config
-	if err := m.writeNginxConfig(host); err != nil {
-		return err
+	next := m.cloneHosts()
+	next[id] = &host
+
+	// Clean up a domain-named config from older versions if the domain changed
+	var removed []*ProxyHost
+	if current.Domain != host.Domain {
+		removed = append(removed, current)
 	}
 
-	return m.save()
+	return m.commit(ctx, "update", next, []*ProxyHost{&host}, removed)
 }
 
 // Delete removes a proxy host
 func (m *ProxyHostManager) Delete(ctx context.Context, id string) error {
 	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	host, ok := m.hosts[id]
 	if !ok {
-		m.mu.Unlock()
 		return fmt.Errorf("proxy host not found: %s", id)
 	}
 
-	delete(m.hosts, id)
-	m.mu.Unlock()
+	next := m.cloneHosts()
+	delete(next, id)
 
-	// Remove nginx config
-	m.removeNginxConfig(host.Domain)
-
-	return m.save()
+	return m.commit(ctx, "delete", next, nil, []*ProxyHost{host})
 }
 
-// Toggle enables or disables a proxy host
-func (m *ProxyHostManager) Toggle(ctx context.Context, id string, enabled bool) error {
+// Restore puts a host back to a recorded state. A nil snapshot deletes the
+// host; otherwise the host is recreated or replaced under its original ID.
+func (m *ProxyHostManager) Restore(ctx context.Context, id string, snapshot *ProxyHost) error {
+	if snapshot != nil {
+		if err := checkPeerNames(snapshot.ServerNames(), m.peers); err != nil {
+			return err
+		}
+	}
+
 	m.mu.Lock()
-	host, ok := m.hosts[id]
-	if !ok {
-		m.mu.Unlock()
-		return fmt.Errorf("proxy host not found: %s", id)
+	defer m.mu.Unlock()
+
+	current, exists := m.hosts[id]
+	next := m.cloneHosts()
+
+	if snapshot == nil {
+		if !exists {
+			return nil
+		}
+		delete(next, id)
+		return m.commit(ctx, "restore", next, nil, []*ProxyHost{current})
 	}
 
-	host.Enabled = enabled
-	host.UpdatedAt = time.Now()
-	m.mu.Unlock()
+	if err := m.checkNameConflicts(snapshot, id); err != nil {
+		return err
+	}
 
-	// Update symlink
-	if err := m.updateSymlink(host); err != nil {
+	host := *snapshot
+	host.ID = id
+	host.UpdatedAt = time.Now()
+	normalizeForceSSL(&host)
+	if err := validateHost(&host); err != nil {
 		return err
 	}
+	// Old revisions may predate the current snippet policy
+	if !exists || host.CustomNginx != current.CustomNginx {
+		if err := m.checkSnippet(ctx, &host); err != nil {
+			return err
+		}
+	}
+	next[id] = &host
 
-	return m.save()
+	var removed []*ProxyHost
+	if exists && current.Domain != host.Domain {
+		removed = append(removed, current)
+	}
+
+	return m.commit(ctx, "restore", next, []*ProxyHost{&host}, removed)
+}
+
+// Toggle enables or disables a proxy host
+func (m *ProxyHostManager) Toggle(ctx context.Context, id string, enabled bool) error {
+	return m.modify(ctx, id, "toggle", true, func(host *ProxyHost) error {
+		host.Enabled = enabled
+		return nil
+	})
 }
 
 // SetMaintenance enables or disables maintenance mode for a proxy host
 func (m *ProxyHostManager) SetMaintenance(ctx context.Context, id string, maintenance bool) error {
+	return m.modify(ctx, id, "maintenance", true, func(host *ProxyHost) error {
+		host.Maintenance = maintenance
+		host.MaintenanceMessage = ""
+		return nil
+	})
+}
+
+// modify applies fn to a copy of the host and commits it. When render is
+// false only the data file changes (e.g. tags, which are not rendered).
+func (m *ProxyHostManager) modify(ctx context.Context, id, action string, render bool, fn func(host *ProxyHost) error) error {
 	m.mu.Lock()
-	host, ok := m.hosts[id]
+	defer m.mu.Unlock()
+
+	current, ok := m.hosts[id]
 	if !ok {
-		m.mu.Unlock()
 		return fmt.Errorf("proxy host not found: %s", id)
 	}
 
-	host.Maintenance = maintenance
+	host := *current
+	if err := fn(&host); err != nil {
+		return err
+	}
+	normalizeForceSSL(&host)
 	host.UpdatedAt = time.Now()
-	m.mu.Unlock()
 
-	// Rewrite nginx config with new maintenance status
-	if err := m.writeNginxConfig(host); err != nil {
-		return err
+	if render {
+		if err := validateHost(&host); err != nil {
+			return err
+		}
+	}
+
+	next := m.cloneHosts()
+	next[id] = &host
+
+	var written []*ProxyHost
+	if render {
+		written = append(written, &host)
 	}
+	return m.commit(ctx, action, next, written, nil)
+}
 
-	return m.save()
+// Render returns the nginx configuration generated for a host
+func (m *ProxyHostManager) Render(host *ProxyHost) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := m.tmpl.Execute(&buf, host); err != nil {
+		return nil, fmt.Errorf("failed to render config: %w", err)
+	}
+	return buf.Bytes(), nil
 }
 
-// writeNginxConfig generates and writes the nginx configuration file
-func (m *ProxyHostManager) writeNginxConfig(host *ProxyHost) error {
-	configPath := m.configPath(host.Domain)
+// Preview renders the config of a saved host, along with the http-level
+// definitions it needs (nil when it needs none)
+func (m *ProxyHostManager) Preview(id string) (config, httpConfig []byte, err error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	host, ok := m.hosts[id]
+	if !ok {
+		return nil, nil, fmt.Errorf("proxy host not found: %s", id)
+	}
+	config, err = m.Render(host)
+	if err != nil {
+		return nil, nil, err
+	}
+	return config, m.renderHTTPConfig(map[string]*ProxyHost{id: host}), nil
+}
 
-	// Ensure directory exists
-	if err := os.MkdirAll(m.configDir, 0755); err != nil {
+// stageHost renders the host config and stages it, together with the
+// sites-enabled symlink matching its enabled status
+func (m *ProxyHostManager) stageHost(tx *Transaction, host *ProxyHost) error {
+	if err := m.checkAccessLists(host); err != nil {
+		return err
+	}
+	if err := m.checkCORSPolicies(host); err != nil {
+		return err
+	}
+	if err := m.checkCompression(host); err != nil {
+		return err
+	}
+	if err := m.checkSlowStart(host); err != nil {
 		return err
 	}
 
-	f, err := os.Create(configPath)
+	data, err := m.Render(host)
 	if err != nil {
-		return fmt.Errorf("failed to create config file: %w", err)
+		return err
+	}
+	if err := m.stageErrorPages(tx, host); err != nil {
+		return err
 	}
-	defer f.Close()
 
-	if err := m.tmpl.Execute(f, host); err != nil {
-		return fmt.Errorf("failed to write config: %w", err)
+	configPath := m.configPath(host.ID)
+	symlinkPath := m.symlinkPath(host.ID)
+
+	// Drop files from before configs were named by host ID
+	tx.Remove(m.legacySymlinkPath(host.Domain))
+	tx.Remove(m.legacyConfigPath(host.Domain))
+
+	tx.WriteFile(configPath, data, 0644)
+	if host.Enabled {
+		tx.Symlink(configPath, symlinkPath)
+	} else {
+		tx.Remove(symlinkPath)
+	}
+	return nil
+}
+
+// stageRemove stages the removal of a host's config files
+func (m *ProxyHostManager) stageRemove(tx *Transaction, host *ProxyHost) {
+	tx.Remove(m.symlinkPath(host.ID))
+	tx.Remove(m.configPath(host.ID))
+	tx.Remove(m.legacySymlinkPath(host.Domain))
+	tx.Remove(m.legacyConfigPath(host.Domain))
+	stageRemoveErrorPages(tx, host.ErrorPageDir(), nil)
+}
+
+// ApplyCertificate applies a certificate to a host
+func (m *ProxyHostManager) ApplyCertificate(ctx context.Context, hostID string, cert *Certificate) error {
+	results, err := m.BindCertificate(ctx, cert, []string{hostID})
+	if err != nil {
+		return err
 	}
+	if len(results) == 1 && !results[0].OK {
+		return fmt.Errorf("%s", results[0].Error)
+	}
+	return nil
+}
+
+// BindCertificate binds cert to the given hosts in a single apply. Hosts that
+// are missing or fail validation are reported individually and left out; the
+// rest are rendered, tested with one nginx -t and committed together. The
+// returned error is only set when that shared apply fails.
+func (m *ProxyHostManager) BindCertificate(ctx context.Context, cert *Certificate, hostIDs []string) ([]HostBindResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-	// Update symlink based on enabled status
-	return m.updateSymlink(host)
+	return m.rebind(ctx, "certificate", cert, hostIDs, true)
 }
 
-// updateSymlink creates or removes the symlink in sites-enabled
-func (m *ProxyHostManager) updateSymlink(host *ProxyHost) error {
-	configPath := m.configPath(host.Domain)
-	symlinkPath := m.symlinkPath(host.Domain)
+// stageCertificateRefresh stages the re-render of every host bound to cert
+// into tx, picking up changed file paths. m.mu stays locked until the
+// returned refresh is finished.
+func (m *ProxyHostManager) stageCertificateRefresh(tx *Transaction, cert *Certificate) (*certificateRefresh, error) {
+	m.mu.Lock()
 
-	// Remove existing symlink
-	_ = os.Remove(symlinkPath)
+	var hostIDs []string
+	for _, h := range m.hosts {
+		if h.CertificateID == cert.ID {
+			hostIDs = append(hostIDs, h.ID)
+		}
+	}
 
-	// Create symlink if enabled
-	if host.Enabled {
-		if err := os.MkdirAll(m.enabledDir, 0755); err != nil {
-			return err
+	refresh := &certificateRefresh{unlock: m.mu.Unlock}
+	next, written, results := m.prepareRebind(cert, hostIDs, false)
+	refresh.results = results
+	if len(written) == 0 {
+		return refresh, nil
+	}
+	if err := m.stageCommit(tx, next, written, nil); err != nil {
+		m.mu.Unlock()
+		return nil, err
+	}
+	refresh.swap = func(ctx context.Context) {
+		m.swap(ctx, "certificate_refresh", next, written, nil)
+	}
+	return refresh, nil
+}
+
+// HostsUsingCertificate returns the IDs of hosts bound to a certificate or
+// verifying clients against it
+func (m *ProxyHostManager) HostsUsingCertificate(certID string) []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var ids []string
+	for _, h := range m.hosts {
+		if h.CertificateID == certID || h.usesClientCA(certID) {
+			ids = append(ids, h.ID)
+		}
+	}
+	return ids
+}
+
+// listenPorts returns the ports the enabled hosts' server blocks listen on,
+// including listens added by custom snippets
+func (m *ProxyHostManager) listenPorts() []int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var ports []int
+	for _, h := range m.hosts {
+		if !h.Enabled {
+			continue
 		}
-		if err := os.Symlink(configPath, symlinkPath); err != nil {
-			return fmt.Errorf("failed to create symlink: %w", err)
+		if data, err := m.Render(h); err == nil {
+			ports = append(ports, parseListenPorts(data)...)
 		}
 	}
+	return ports
+}
 
-	return nil
+// rebind points the hosts at cert's files and commits them together. When
+// enableSSL is set the hosts are switched to SSL as well. Callers must hold m.mu.
+func (m *ProxyHostManager) rebind(ctx context.Context, action string, cert *Certificate, hostIDs []string, enableSSL bool) ([]HostBindResult, error) {
+	next, written, results := m.prepareRebind(cert, hostIDs, enableSSL)
+	if len(written) == 0 {
+		return results, nil
+	}
+
+	if err := m.commit(ctx, action, next, written, nil); err != nil {
+		for i := range results {
+			if results[i].Error == "" {
+				results[i].Error = err.Error()
+			}
+		}
+		return results, err
+	}
+
+	for i := range results {
+		results[i].OK = results[i].Error == ""
+	}
+	return results, nil
 }
 
-// removeNginxConfig deletes the nginx configuration files
-func (m *ProxyHostManager) removeNginxConfig(domain string) {
-	configPath := m.configPath(domain)
-	symlinkPath := m.symlinkPath(domain)
+// prepareRebind returns the host set with the given hosts pointed at cert's
+// files. Hosts that are missing or fail validation are reported and left out.
+// Callers must hold m.mu.
+func (m *ProxyHostManager) prepareRebind(cert *Certificate, hostIDs []string, enableSSL bool) (map[string]*ProxyHost, []*ProxyHost, []HostBindResult) {
+	results := make([]HostBindResult, 0, len(hostIDs))
+	next := m.cloneHosts()
+	var written []*ProxyHost
+
+	for _, id := range hostIDs {
+		current, ok := next[id]
+		if !ok {
+			results = append(results, HostBindResult{HostID: id, Error: "proxy host not found: " + id})
+			continue
+		}
+
+		host := *current
+		host.CertificateID = cert.ID
+		host.CertPath = cert.CertPath
+		host.KeyPath = cert.KeyPath
+		host.ChainPath = cert.ChainPath
+		if enableSSL {
+			host.SSL = true
+		}
+		normalizeForceSSL(&host)
+		host.UpdatedAt = time.Now()
+
+		if err := validateHost(&host); err != nil {
+			results = append(results, HostBindResult{HostID: id, Domain: host.Domain, Error: err.Error()})
+			continue
+		}
 
-	_ = os.Remove(symlinkPath)
-	_ = os.Remove(configPath)
+		next[id] = &host
+		written = append(written, &host)
+		results = append(results, HostBindResult{HostID: id, Domain: host.Domain})
+	}
+	return next, written, results
 }
 
-// ApplyCertificate applies a certificate to a host
-func (m *ProxyHostManager) ApplyCertificate(ctx context.Context, hostID, certID, certPath, keyPath string) error {
+// SetAccessLists attaches the access list store used to render the access
+// lists referenced by hosts and locations
+func (m *ProxyHostManager) SetAccessLists(lists *AccessListManager) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	m.accessLists = lists
+}
 
-	host, ok := m.hosts[hostID]
-	if !ok {
-		return fmt.Errorf("host not found: %s", hostID)
+// accessList resolves an access list, from the lists of a staged list
+// change while one is in progress. Callers hold m.mu.
+func (m *ProxyHostManager) accessList(id string) (*AccessList, error) {
+	if m.accessLists == nil {
+		return nil, fmt.Errorf("access lists are not available")
 	}
+	if m.accessListView != nil {
+		list, ok := m.accessListView[id]
+		if !ok {
+			return nil, fmt.Errorf("access list not found: %s", id)
+		}
+		return list, nil
+	}
+	return m.accessLists.Get(id)
+}
 
-	host.CertificateID = certID
-	host.CertPath = certPath
-	host.KeyPath = keyPath
-	host.SSL = true
-	host.UpdatedAt = time.Now()
+// accessDirectives renders an access list for the template. Callers hold m.mu.
+func (m *ProxyHostManager) accessDirectives(id string, override bool) ([]string, error) {
+	if id == "" {
+		return nil, nil
+	}
+	list, err := m.accessList(id)
+	if err != nil {
+		return nil, err
+	}
+	return m.accessLists.listDirectives(list, override), nil
+}
 
-	if err := m.save(); err != nil {
-		return err
+// checkAccessLists verifies that every access list a host references exists
+func (m *ProxyHostManager) checkAccessLists(host *ProxyHost) error {
+	for _, id := range host.accessListIDs() {
+		if _, err := m.accessList(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// accessListIDs returns the access lists referenced by the host and its
+// locations
+func (h *ProxyHost) accessListIDs() []string {
+	var ids []string
+	if h.AccessListID != "" {
+		ids = append(ids, h.AccessListID)
+	}
+	for _, l := range h.Locations {
+		if l.AccessListID != "" {
+			ids = append(ids, l.AccessListID)
+		}
 	}
+	return ids
+}
 
-	// Regenerate nginx config
-	return m.writeConfig(host)
+// usesAccessList reports whether the host or one of its locations references
+// an access list
+func (h *ProxyHost) usesAccessList(listID string) bool {
+	for _, id := range h.accessListIDs() {
+		if id == listID {
+			return true
+		}
+	}
+	return false
 }
 
-func (m *ProxyHostManager) writeConfig(host *ProxyHost) error {
-	panic("unimplemented")
+// HostsUsingAccessList returns the IDs of hosts referencing an access list
+func (m *ProxyHostManager) HostsUsingAccessList(listID string) []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var ids []string
+	for _, h := range m.hosts {
+		if h.usesAccessList(listID) {
+			ids = append(ids, h.ID)
+		}
+	}
+	return ids
 }
 
-// AddTag adds a tag to a host
-func (m *ProxyHostManager) AddTag(ctx context.Context, hostID, tagID string) error {
+// stageAccessListRefresh stages the re-render of every host using an access
+// list into tx, rendering access lists from lists instead of the live set.
+// m.mu stays locked until the returned refresh is finished.
+func (m *ProxyHostManager) stageAccessListRefresh(tx *Transaction, listID string, lists map[string]*AccessList) (*hostRefresh, error) {
 	m.mu.Lock()
-	defer m.mu.Unlock()
+	m.accessListView = lists
+	unlock := func() {
+		m.accessListView = nil
+		m.mu.Unlock()
+	}
 
-	host, ok := m.hosts[hostID]
-	if !ok {
-		return fmt.Errorf("host not found: %s", hostID)
+	return m.stageRefresh(tx, "access_list_refresh", unlock, func(h *ProxyHost) bool {
+		return h.usesAccessList(listID)
+	})
+}
+
+// stageRefresh stages the re-render of every host matching uses into tx.
+// Callers hold m.mu; unlock releases it when the refresh is finished, or
+// right away when staging fails.
+func (m *ProxyHostManager) stageRefresh(tx *Transaction, action string, unlock func(), uses func(h *ProxyHost) bool) (*hostRefresh, error) {
+	refresh := &hostRefresh{unlock: unlock}
+
+	next := m.cloneHosts()
+	var written []*ProxyHost
+	for id, current := range m.hosts {
+		if !uses(current) {
+			continue
+		}
+		host := *current
+		host.UpdatedAt = time.Now()
+		next[id] = &host
+		written = append(written, &host)
+	}
+
+	if len(written) == 0 {
+		return refresh, nil
+	}
+	if err := m.stageCommit(tx, next, written, nil); err != nil {
+		unlock()
+		return nil, err
+	}
+	refresh.swap = func(ctx context.Context) {
+		m.swap(ctx, action, next, written, nil)
+	}
+	return refresh, nil
+}
+
+// AddTag adds a tag to a host
+func (m *ProxyHostManager) AddTag(ctx context.Context, hostID, tagID string) error {
+	host, err := m.Get(hostID)
+	if err != nil {
+		return err
 	}
 
 	// Check if tag already exists
@@ -503,48 +1282,205 @@ func (m *ProxyHostManager) AddTag(ctx context.Context, hostID, tagID string) err
 		}
 	}
 
-	host.Tags = append(host.Tags, tagID)
-	host.UpdatedAt = time.Now()
-
-	return m.save()
+	return m.modify(ctx, hostID, "tag", false, func(host *ProxyHost) error {
+		host.Tags = append(append([]string{}, host.Tags...), tagID)
+		return nil
+	})
 }
 
 // RemoveTag removes a tag from a host
 func (m *ProxyHostManager) RemoveTag(ctx context.Context, hostID, tagID string) error {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+	return m.modify(ctx, hostID, "untag", false, func(host *ProxyHost) error {
+		newTags := make([]string, 0, len(host.Tags))
+		for _, t := range host.Tags {
+			if t != tagID {
+				newTags = append(newTags, t)
+			}
+		}
+		host.Tags = newTags
+		return nil
+	})
+}
 
-	host, ok := m.hosts[hostID]
-	if !ok {
-		return fmt.Errorf("host not found: %s", hostID)
-	}
+// configPath returns the path to the nginx config file
+func (m *ProxyHostManager) configPath(id string) string {
+	return filepath.Join(m.configDir, "nubi-host-"+id+".conf")
+}
 
-	newTags := make([]string, 0, len(host.Tags))
-	for _, t := range host.Tags {
-		if t != tagID {
-			newTags = append(newTags, t)
+// symlinkPath returns the path to the symlink in sites-enabled
+func (m *ProxyHostManager) symlinkPath(id string) string {
+	return filepath.Join(m.enabledDir, "nubi-host-"+id+".conf")
+}
+
+// legacyConfigPath returns the domain-based config path used by older versions
+func (m *ProxyHostManager) legacyConfigPath(domain string) string {
+	return filepath.Join(m.configDir, "nubi-host-"+legacySafeDomain(domain)+".conf")
+}
+
+// legacySymlinkPath returns the domain-based symlink path used by older versions
+func (m *ProxyHostManager) legacySymlinkPath(domain string) string {
+	return filepath.Join(m.enabledDir, "nubi-host-"+legacySafeDomain(domain)+".conf")
+}
+
+// legacySafeDomain sanitizes a domain the way older config filenames did
+func legacySafeDomain(domain string) string {
+	safeDomain := strings.ReplaceAll(domain, "*", "_wildcard_")
+	return strings.ReplaceAll(safeDomain, ".", "_")
+}
+
+// claimedNames returns the server names used by proxy hosts
+func (m *ProxyHostManager) claimedNames() map[string]string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	names := make(map[string]string)
+	for _, h := range m.hosts {
+		for _, name := range h.ServerNames() {
+			names[strings.ToLower(name)] = "proxy host " + h.Domain
 		}
 	}
+	return names
+}
 
-	host.Tags = newTags
-	host.UpdatedAt = time.Now()
+// addPeer registers another host kind whose names must not clash
+func (m *ProxyHostManager) addPeer(peer nameClaimer) {
+	m.peers = append(m.peers, peer)
+}
 
-	return m.save()
+// nameClaimer is implemented by managers whose hosts own server names
+type nameClaimer interface {
+	claimedNames() map[string]string // lower-cased name -> owning host
+	addPeer(peer nameClaimer)
 }
 
-// configPath returns the path to the nginx config file
-func (m *ProxyHostManager) configPath(domain string) string {
-	// Sanitize domain for filename
-	safeDomain := strings.ReplaceAll(domain, "*", "_wildcard_")
-	safeDomain = strings.ReplaceAll(safeDomain, ".", "_")
-	return filepath.Join(m.configDir, "nubi-host-"+safeDomain+".conf")
+// ShareServerNames makes every given host kind reject names that one of the
+// others already serves. Call it once at startup, before serving requests.
+func ShareServerNames(managers ...nameClaimer) {
+	for _, m := range managers {
+		for _, peer := range managers {
+			if peer != m {
+				m.addPeer(peer)
+			}
+		}
+	}
 }
 
-// symlinkPath returns the path to the symlink in sites-enabled
-func (m *ProxyHostManager) symlinkPath(domain string) string {
-	safeDomain := strings.ReplaceAll(domain, "*", "_wildcard_")
-	safeDomain = strings.ReplaceAll(safeDomain, ".", "_")
-	return filepath.Join(m.enabledDir, "nubi-host-"+safeDomain+".conf")
+// checkPeerNames rejects names already claimed by another kind of host. It
+// takes the peers' locks, so callers must not hold their own manager's lock.
+func checkPeerNames(names []string, peers []nameClaimer) error {
+	for _, peer := range peers {
+		claimed := peer.claimedNames()
+		for _, name := range names {
+			if owner, ok := claimed[strings.ToLower(name)]; ok {
+				return fmt.Errorf("domain already exists: %s (used by %s)", name, owner)
+			}
+		}
+	}
+	return nil
+}
+
+// checkNameConflicts returns an error if any server name of host is already
+// used by another host. excludeID skips the host being updated. Callers must
+// hold m.mu.
+func (m *ProxyHostManager) checkNameConflicts(host *ProxyHost, excludeID string) error {
+	used := make(map[string]bool)
+	for _, h := range m.hosts {
+		if h.ID == excludeID {
+			continue
+		}
+		for _, name := range h.ServerNames() {
+			used[strings.ToLower(name)] = true
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, name := range host.ServerNames() {
+		key := strings.ToLower(name)
+		if used[key] {
+			return fmt.Errorf("domain already exists: %s", name)
+		}
+		if seen[key] {
+			return fmt.Errorf("duplicate server name: %s", name)
+		}
+		seen[key] = true
+	}
+
+	return nil
+}
+
+// nginxTimeRegex matches nginx time values such as "30s", "1h30m" or "1d"
+var nginxTimeRegex = regexp.MustCompile(`^(\d+(ms|s|m|h|d|w|M|y)?)+$`)
+
+// validateHost checks a host before it is rendered
+func validateHost(host *ProxyHost) error {
+	// Validate domain and aliases
+	if err := validateDomain(host.Domain); err != nil {
+		return err
+	}
+	for _, alias := range host.Aliases {
+		if err := validateServerName(alias); err != nil {
+			return err
+		}
+	}
+	if host.RedirectsAliases() && strings.HasPrefix(host.Domain, "*.") {
+		return fmt.Errorf("cannot redirect aliases to a wildcard primary domain: %s", host.Domain)
+	}
+
+	// Validate target URL (only if not using load balancing)
+	if len(host.Backends) == 0 {
+		if err := validateTarget(host.Target); err != nil {
+			return err
+		}
+	}
+	if err := validateBackends(host.Backends); err != nil {
+		return err
+	}
+	normalizeLBOptions(host.LBMethod, &host.LBOptions)
+	if err := validateBalancing(host.LBMethod, host.LBOptions, host.Backends, true); err != nil {
+		return err
+	}
+	if err := validateUpstreamSettings(host.Upstream); err != nil {
+		return err
+	}
+	if err := validateHeaderRules(host.HeaderRules); err != nil {
+		return err
+	}
+	if err := validateCachePolicy(host.Cache); err != nil {
+		return err
+	}
+	if err := validateCompression(host.Compression); err != nil {
+		return err
+	}
+	if err := validateHostErrorPages(host); err != nil {
+		return err
+	}
+	if err := validateRollout(host); err != nil {
+		return err
+	}
+	if err := validateMirror(host.Mirror); err != nil {
+		return err
+	}
+
+	normalizeLocations(host)
+	if err := validateLocations(host); err != nil {
+		return err
+	}
+	if err := validateSecurityHeaders(host); err != nil {
+		return err
+	}
+
+	if err := validateRateLimit(host.RateLimit); err != nil {
+		return err
+	}
+
+	if err := validateHealthCheck(host); err != nil {
+		return err
+	}
+
+	if err := validateTLS(host); err != nil {
+		return err
+	}
+	return validateClientAuth(host)
 }
 
 // validateDomain checks if a domain name is valid
@@ -565,15 +1501,37 @@ func validateDomain(domain string) error {
 	return nil
 }
 
+// validateServerName checks an alias: a domain, a wildcard such as
+// "*.example.com" or "example.*", or an nginx regex name starting with "~"
+func validateServerName(name string) error {
+	if strings.HasPrefix(name, "~") {
+		if len(name) == 1 || strings.ContainsAny(name, " \t\r\n;\"'") {
+			return fmt.Errorf("invalid regex server name: %s", name)
+		}
+		return nil
+	}
+
+	if strings.HasSuffix(name, ".*") && !strings.HasPrefix(name, "*.") {
+		return validateDomain(strings.TrimSuffix(name, ".*"))
+	}
+
+	return validateDomain(name)
+}
+
 // validateTarget checks if a target URL is valid
 func validateTarget(target string) error {
 	if target == "" {
 		return fmt.Errorf("target is required")
 	}
 
-	// Basic URL validation - should start with http:// or https://
-	if !strings.HasPrefix(target, "http://") && !strings.HasPrefix(target, "https://") {
-		return fmt.Errorf("target must start with http:// or https://")
+	// The target is rendered into proxy_pass as is, so it must not be able
+	// to end the directive or open a block
+	if strings.ContainsAny(target, " \t\r\n\v\f;{}\"'$") {
+		return fmt.Errorf("target contains invalid characters: %q", target)
+	}
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("target must be an absolute http:// or https:// URL")
 	}
 
 	return nil
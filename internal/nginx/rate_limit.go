@@ -0,0 +1,229 @@
+package nginx
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Rate limit keys
+const (
+	LimitKeyClientIP   = "client_ip"   // $binary_remote_addr
+	LimitKeyHeader     = "header"      // $http_<name> of a request header
+	LimitKeyServerName = "server_name" // $server_name, i.e. the whole host shares one bucket
+)
+
+// limitHeaderRegex matches header names usable in $http_ variables
+var limitHeaderRegex = regexp.MustCompile(`^[A-Za-z0-9-]+$`)
+
+// zoneNameRegex matches characters replaced in zone names
+var zoneNameRegex = regexp.MustCompile(`[^a-zA-Z0-9]`)
+
+// bandwidthRegex matches limit_rate values like "512k" or "2m"
+var bandwidthRegex = regexp.MustCompile(`^[1-9][0-9]*[kKmMgG]?$`)
+
+// RateLimit limits requests, concurrent connections and bandwidth for a host
+// or a location. On a location, each kind of limit it sets replaces the
+// host's; kinds it leaves unset are inherited from the host.
+type RateLimit struct {
+	Rate           int    `json:"rate"`           // Requests allowed per unit (0 = no request limit)
+	Unit           string `json:"unit"`           // second or minute
+	Burst          int    `json:"burst"`          // Requests above the rate that are queued instead of rejected
+	NoDelay        bool   `json:"nodelay"`        // Serve burst requests immediately instead of pacing them
+	Key            string `json:"key"`            // client_ip (default), header or server_name
+	Header         string `json:"header"`         // Request header used when key is header
+	MaxConnections int    `json:"maxConnections"` // Concurrent connections per key (0 = unlimited)
+	Status         int    `json:"status"`         // Status returned to limited clients (default 429)
+	Bandwidth      string `json:"bandwidth"`      // limit_rate per connection, e.g. "512k" (optional)
+}
+
+// normalize fills in defaults
+func (r *RateLimit) normalize() {
+	if r.Unit == "" {
+		r.Unit = "second"
+	}
+	if r.Key == "" {
+		r.Key = LimitKeyClientIP
+	}
+	if r.Status == 0 {
+		r.Status = 429
+	}
+}
+
+// Validate checks the limit values
+func (r *RateLimit) Validate() error {
+	if r.Rate < 0 || r.Burst < 0 || r.MaxConnections < 0 {
+		return fmt.Errorf("rate limit values must not be negative")
+	}
+	if r.Rate == 0 && r.MaxConnections == 0 && r.Bandwidth == "" {
+		return fmt.Errorf("rate limit needs a rate, maxConnections or bandwidth")
+	}
+	if r.Rate == 0 && (r.Burst > 0 || r.NoDelay) {
+		return fmt.Errorf("burst and nodelay require a rate")
+	}
+	if r.Unit != "second" && r.Unit != "minute" {
+		return fmt.Errorf("invalid rate limit unit: %s (expected second or minute)", r.Unit)
+	}
+
+	switch r.Key {
+	case LimitKeyClientIP, LimitKeyServerName:
+		if r.Header != "" {
+			return fmt.Errorf("header is only used with the header key")
+		}
+	case LimitKeyHeader:
+		if r.Header == "" || !limitHeaderRegex.MatchString(r.Header) {
+			return fmt.Errorf("invalid rate limit header: %q", r.Header)
+		}
+	default:
+		return fmt.Errorf("invalid rate limit key: %s (expected client_ip, header or server_name)", r.Key)
+	}
+
+	// nginx accepts limit_req_status and limit_conn_status between 400 and 599
+	if r.Status < 400 || r.Status > 599 {
+		return fmt.Errorf("invalid rate limit status: %d (expected 400-599)", r.Status)
+	}
+
+	if r.Bandwidth != "" && !bandwidthRegex.MatchString(r.Bandwidth) {
+		return fmt.Errorf("invalid bandwidth: %s (expected e.g. 512k or 2m)", r.Bandwidth)
+	}
+
+	return nil
+}
+
+// KeyVariable returns the nginx variable requests are bucketed by
+func (r *RateLimit) KeyVariable() string {
+	switch r.Key {
+	case LimitKeyHeader:
+		return "$http_" + r.headerSlug()
+	case LimitKeyServerName:
+		return "$server_name"
+	default:
+		return "$binary_remote_addr"
+	}
+}
+
+// headerSlug returns the header name as used in $http_ variables
+func (r *RateLimit) headerSlug() string {
+	return strings.ReplaceAll(strings.ToLower(r.Header), "-", "_")
+}
+
+// keySlug returns a short name for the key, used in zone names
+func (r *RateLimit) keySlug() string {
+	switch r.Key {
+	case LimitKeyHeader:
+		return "hdr_" + r.headerSlug()
+	case LimitKeyServerName:
+		return "server"
+	default:
+		return "ip"
+	}
+}
+
+// RateValue returns the limit_req_zone rate, e.g. "10r/s"
+func (r *RateLimit) RateValue() string {
+	if r.Unit == "minute" {
+		return fmt.Sprintf("%dr/m", r.Rate)
+	}
+	return fmt.Sprintf("%dr/s", r.Rate)
+}
+
+// RequestZone returns the limit_req zone name for the host or location
+// identified by scope. Every host and location gets its own zone so clients
+// are only counted against the limit they hit; the key is part of the name
+// because nginx refuses to reload a zone whose key changed.
+func (r *RateLimit) RequestZone(scope string) string {
+	return "nubi_req_" + r.keySlug() + "_" + scope
+}
+
+// ConnectionZone returns the limit_conn zone name for the host or location
+// identified by scope
+func (r *RateLimit) ConnectionZone(scope string) string {
+	return "nubi_conn_" + r.keySlug() + "_" + scope
+}
+
+// Directives returns the limit directives for a server or location block
+func (r *RateLimit) Directives(scope string) []string {
+	var lines []string
+	if r.Rate > 0 {
+		line := "limit_req zone=" + r.RequestZone(scope)
+		if r.Burst > 0 {
+			line += fmt.Sprintf(" burst=%d", r.Burst)
+		}
+		if r.NoDelay {
+			line += " nodelay"
+		}
+		lines = append(lines, line+";", fmt.Sprintf("limit_req_status %d;", r.Status))
+	}
+	if r.MaxConnections > 0 {
+		lines = append(lines,
+			fmt.Sprintf("limit_conn %s %d;", r.ConnectionZone(scope), r.MaxConnections),
+			fmt.Sprintf("limit_conn_status %d;", r.Status),
+		)
+	}
+	if r.Bandwidth != "" {
+		lines = append(lines, "limit_rate "+r.Bandwidth+";")
+	}
+	return lines
+}
+
+// zoneDefinitions returns the http-level zone definitions a limit needs
+func (r *RateLimit) zoneDefinitions(scope string) []string {
+	var defs []string
+	if r.Rate > 0 {
+		defs = append(defs, fmt.Sprintf("limit_req_zone %s zone=%s:10m rate=%s;", r.KeyVariable(), r.RequestZone(scope), r.RateValue()))
+	}
+	if r.MaxConnections > 0 {
+		defs = append(defs, fmt.Sprintf("limit_conn_zone %s zone=%s:10m;", r.KeyVariable(), r.ConnectionZone(scope)))
+	}
+	return defs
+}
+
+// rateLimitScope returns the part of a zone name identifying the host, or one
+// of its locations when locationID is set
+func (h *ProxyHost) rateLimitScope(locationID string) string {
+	scope := zoneNameRegex.ReplaceAllString(h.ID, "_")
+	if locationID != "" {
+		scope += "_" + zoneNameRegex.ReplaceAllString(locationID, "_")
+	}
+	return scope
+}
+
+// RateLimitDirectives returns the limit directives of the host's server block
+func (h *ProxyHost) RateLimitDirectives() []string {
+	if h.RateLimit == nil {
+		return nil
+	}
+	return h.RateLimit.Directives(h.rateLimitScope(""))
+}
+
+// LocationRateLimitDirectives returns the limit directives of one of the
+// host's locations
+func (h *ProxyHost) LocationRateLimitDirectives(l Location) []string {
+	if l.RateLimit == nil {
+		return nil
+	}
+	return l.RateLimit.Directives(h.rateLimitScope(l.ID))
+}
+
+// rateLimitZones returns the zone definitions of the host and its locations
+func (h *ProxyHost) rateLimitZones() []string {
+	var defs []string
+	if h.RateLimit != nil {
+		defs = append(defs, h.RateLimit.zoneDefinitions(h.rateLimitScope(""))...)
+	}
+	for _, l := range h.Locations {
+		if l.RateLimit != nil {
+			defs = append(defs, l.RateLimit.zoneDefinitions(h.rateLimitScope(l.ID))...)
+		}
+	}
+	return defs
+}
+
+// validateRateLimit normalizes and checks an optional rate limit
+func validateRateLimit(r *RateLimit) error {
+	if r == nil {
+		return nil
+	}
+	r.normalize()
+	return r.Validate()
+}
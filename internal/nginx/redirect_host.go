@@ -0,0 +1,815 @@
+package nginx
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RedirectHost sends every request for its domains to another URL
+type RedirectHost struct {
+	ID            string     `json:"id"`
+	Domains       []string   `json:"domains"`       // Source names; the first one is the primary domain
+	Destination   string     `json:"destination"`   // e.g., "https://www.example.com"
+	StatusCode    int        `json:"statusCode"`    // 301, 302, 307 or 308 (defaults to 301)
+	PreservePath  bool       `json:"preservePath"`  // Append the request path to the destination
+	PreserveQuery bool       `json:"preserveQuery"` // Append the query string to the destination
+	SSL           bool       `json:"ssl"`           // Serve the redirect over HTTPS too
+	ForceSSL      bool       `json:"forceSSL"`      // Redirect HTTP to HTTPS before redirecting
+	CertificateID string     `json:"certificateId"` // ID of the certificate to use
+	CertPath      string     `json:"certPath"`      // Path to SSL certificate
+	KeyPath       string     `json:"keyPath"`       // Path to SSL private key
+	ChainPath     string     `json:"chainPath"`     // Path to CA chain used for OCSP stapling (optional)
+	TLS           *TLSPolicy `json:"tls,omitempty"` // TLS policy (defaults to the intermediate preset)
+	Enabled       bool       `json:"enabled"`       // Whether this redirect is active
+	Maintenance   bool       `json:"maintenance"`   // Show maintenance page instead of redirecting
+	Tags          []string   `json:"tags"`          // Tags for grouping and bulk operations
+	CreatedAt     time.Time  `json:"createdAt"`
+	UpdatedAt     time.Time  `json:"updatedAt"`
+}
+
+// PrimaryDomain returns the first source domain
+func (h *RedirectHost) PrimaryDomain() string {
+	if len(h.Domains) == 0 {
+		return ""
+	}
+	return h.Domains[0]
+}
+
+// ServerNameList returns the server_name value
+func (h *RedirectHost) ServerNameList() string {
+	return formatServerNames(h.Domains)
+}
+
+// ReturnURL returns the target of the return directive
+func (h *RedirectHost) ReturnURL() string {
+	switch {
+	case h.PreservePath && h.PreserveQuery:
+		return strings.TrimSuffix(h.Destination, "/") + "$request_uri"
+	case h.PreservePath:
+		return strings.TrimSuffix(h.Destination, "/") + h.PathVariable()
+	case h.PreserveQuery:
+		return h.Destination + "$is_args$args"
+	default:
+		return h.Destination
+	}
+}
+
+// PathVariable returns the variable holding the raw request path without
+// its query string. $uri is decoded, so a %0d%0a in the request would put a
+// line break into the Location header.
+func (h *RedirectHost) PathVariable() string {
+	return "$nubi_redirect_path_" + regexp.MustCompile(`[^a-zA-Z0-9]`).ReplaceAllString(h.ID, "_")
+}
+
+// TLSSettings returns the effective TLS policy for the redirect
+func (h *RedirectHost) TLSSettings() *TLSPolicy {
+	if h.TLS == nil {
+		policy := defaultTLSPolicy
+		return &policy
+	}
+	return h.TLS
+}
+
+// RedirectHostManager handles CRUD operations for redirect hosts
+type RedirectHostManager struct {
+	mu         sync.RWMutex
+	hosts      map[string]*RedirectHost
+	ctrl       *Controller   // used to test staged config before it goes live
+	history    *HistoryStore // optional revision log
+	peers      []nameClaimer // other host kinds whose server names must not clash
+	configDir  string        // e.g., /etc/nginx/sites-available
+	enabledDir string        // e.g., /etc/nginx/sites-enabled
+	dataFile   string        // e.g., /var/lib/nubi/redirect_hosts.json
+	tmpl       *template.Template
+}
+
+const redirectHostTemplate = `# Nubi managed redirect host: {{ .PrimaryDomain }}
+# Do not edit manually - changes will be overwritten
+# Host ID: {{ .ID }}
+{{- if and .PreservePath (not .PreserveQuery) }}
+
+map $request_uri {{ .PathVariable }} {
+    "~^(?<nubi_path>[^?]*)" $nubi_path;
+}
+{{- end }}
+
+server {
+    listen 80;
+{{- if .SSL }}
+    listen 443 ssl http2;
+{{- end }}
+    server_name {{ .ServerNameList }};
+
+{{- if and .SSL .ForceSSL }}
+    # Force HTTPS redirect
+    if ($scheme = http) {
+        return 301 https://$host$request_uri;
+    }
+{{- end }}
+
+{{- if .SSL }}
+{{- template "tls" . }}
+{{- end }}
+
+{{- if .Maintenance }}
+    # Maintenance mode - return 503 with custom page
+    root /var/lib/nubi/html;
+    error_page 503 /nubi_maintenance.html;
+    location / {
+        return 503;
+    }
+    location = /nubi_maintenance.html {
+        internal;
+    }
+{{- else }}
+
+    location / {
+        return {{ .StatusCode }} {{ .ReturnURL }};
+    }
+{{- end }}
+}
+`
+
+// NewRedirectHostManager creates a new redirect host manager
+func NewRedirectHostManager(ctrl *Controller, configDir, enabledDir, dataFile string) (*RedirectHostManager, error) {
+	if configDir == "" {
+		configDir = "/etc/nginx/sites-available"
+	}
+	if enabledDir == "" {
+		enabledDir = "/etc/nginx/sites-enabled"
+	}
+	if dataFile == "" {
+		dataFile = "/var/lib/nubi/redirect_hosts.json"
+	}
+
+	tmpl, err := template.New("redirect_host").Parse(redirectHostTemplate)
+	if err == nil {
+		_, err = tmpl.Parse(tlsTemplate)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse redirect host template: %w", err)
+	}
+
+	mgr := &RedirectHostManager{
+		hosts:      make(map[string]*RedirectHost),
+		ctrl:       ctrl,
+		configDir:  configDir,
+		enabledDir: enabledDir,
+		dataFile:   dataFile,
+		tmpl:       tmpl,
+	}
+
+	if err := mgr.load(); err != nil {
+		// Not a fatal error - might be first run
+		fmt.Printf("Note: Could not load existing redirect hosts: %v\n", err)
+	}
+
+	return mgr, nil
+}
+
+// load reads redirect hosts from the JSON data file
+func (m *RedirectHostManager) load() error {
+	data, err := os.ReadFile(m.dataFile)
+	if err != nil {
+		return err
+	}
+
+	var hosts []*RedirectHost
+	if err := json.Unmarshal(data, &hosts); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.hosts = make(map[string]*RedirectHost)
+	for _, h := range hosts {
+		m.hosts[h.ID] = h
+	}
+
+	return nil
+}
+
+func (m *RedirectHostManager) cloneHosts() map[string]*RedirectHost {
+	next := make(map[string]*RedirectHost, len(m.hosts))
+	for id, h := range m.hosts {
+		next[id] = h
+	}
+	return next
+}
+
+// SetHistory attaches a revision log; committed changes are recorded to it
+func (m *RedirectHostManager) SetHistory(history *HistoryStore) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.history = history
+}
+
+// commit stages the config files for the written and removed hosts together
+// with the new data file, validates the result with nginx -t and only then
+// swaps next in as the live host set. Callers must hold m.mu.
+func (m *RedirectHostManager) commit(ctx context.Context, action string, next map[string]*RedirectHost, written, removed []*RedirectHost) error {
+	tx := NewTransaction(m.ctrl)
+	if err := m.stageCommit(tx, next, written, removed); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	m.swap(ctx, action, next, written, removed)
+	return nil
+}
+
+// stageCommit stages the config files and data file of a commit into tx.
+// Callers must hold m.mu.
+func (m *RedirectHostManager) stageCommit(tx *Transaction, next map[string]*RedirectHost, written, removed []*RedirectHost) error {
+	for _, h := range removed {
+		m.stageRemove(tx, h)
+	}
+	for _, h := range written {
+		if err := m.stageHost(tx, h); err != nil {
+			return err
+		}
+	}
+
+	list := make([]*RedirectHost, 0, len(next))
+	for _, h := range next {
+		list = append(list, h)
+	}
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	tx.WriteState(m.dataFile, data)
+	return nil
+}
+
+// swap makes a committed host set live and records it. Callers must hold m.mu.
+func (m *RedirectHostManager) swap(ctx context.Context, action string, next map[string]*RedirectHost, written, removed []*RedirectHost) {
+	prev := m.hosts
+	m.hosts = next
+	m.record(ctx, action, prev, next, written, removed)
+}
+
+// record writes one revision per host touched by a committed change
+func (m *RedirectHostManager) record(ctx context.Context, action string, prev, next map[string]*RedirectHost, written, removed []*RedirectHost) {
+	if m.history == nil {
+		return
+	}
+
+	seen := make(map[string]bool)
+	for _, h := range append(append([]*RedirectHost{}, removed...), written...) {
+		if seen[h.ID] {
+			continue
+		}
+		seen[h.ID] = true
+
+		rev := &Revision{
+			Entity:   EntityRedirectHost,
+			EntityID: h.ID,
+			Action:   action,
+			Before:   marshalRevisionState(prev[h.ID]),
+			After:    marshalRevisionState(next[h.ID]),
+		}
+		if host, ok := next[h.ID]; ok {
+			if data, err := m.Render(host); err == nil {
+				rev.Rendered = string(data)
+			}
+		}
+		if err := m.history.Record(ctx, rev); err != nil {
+			log.Printf("warning: failed to record history for redirect %s: %v", h.ID, err)
+		}
+	}
+}
+
+// List returns all redirect hosts
+func (m *RedirectHostManager) List() []*RedirectHost {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	hosts := make([]*RedirectHost, 0, len(m.hosts))
+	for _, h := range m.hosts {
+		hosts = append(hosts, h)
+	}
+	return hosts
+}
+
+// Get returns a redirect host by ID
+func (m *RedirectHostManager) Get(id string) (*RedirectHost, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	host, ok := m.hosts[id]
+	if !ok {
+		return nil, fmt.Errorf("redirect host not found: %s", id)
+	}
+	return host, nil
+}
+
+// Create adds a new redirect host
+func (m *RedirectHostManager) Create(ctx context.Context, host *RedirectHost) error {
+	if err := validateRedirectHost(host); err != nil {
+		return err
+	}
+	if err := checkPeerNames(host.Domains, m.peers); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkNameConflicts(host, ""); err != nil {
+		return err
+	}
+
+	host.ID = uuid.New().String()
+	host.CreatedAt = time.Now()
+	host.UpdatedAt = time.Now()
+
+	next := m.cloneHosts()
+	next[host.ID] = host
+
+	return m.commit(ctx, "create", next, []*RedirectHost{host}, nil)
+}
+
+// Update replaces an existing redirect host, keeping its ID and creation time
+func (m *RedirectHostManager) Update(ctx context.Context, id string, updates *RedirectHost) error {
+	if err := checkPeerNames(updates.Domains, m.peers); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current, ok := m.hosts[id]
+	if !ok {
+		return fmt.Errorf("redirect host not found: %s", id)
+	}
+
+	if err := m.checkNameConflicts(updates, id); err != nil {
+		return err
+	}
+
+	host := *updates
+	host.ID = id
+	host.CreatedAt = current.CreatedAt
+	host.UpdatedAt = time.Now()
+
+	if err := validateRedirectHost(&host); err != nil {
+		return err
+	}
+
+	next := m.cloneHosts()
+	next[id] = &host
+
+	return m.commit(ctx, "update", next, []*RedirectHost{&host}, nil)
+}
+
+// Delete removes a redirect host
+func (m *RedirectHostManager) Delete(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	host, ok := m.hosts[id]
+	if !ok {
+		return fmt.Errorf("redirect host not found: %s", id)
+	}
+
+	next := m.cloneHosts()
+	delete(next, id)
+
+	return m.commit(ctx, "delete", next, nil, []*RedirectHost{host})
+}
+
+// Restore puts a redirect host back to a recorded state. A nil snapshot
+// deletes the host; otherwise it is recreated or replaced under its ID.
+func (m *RedirectHostManager) Restore(ctx context.Context, id string, snapshot *RedirectHost) error {
+	if snapshot != nil {
+		if err := checkPeerNames(snapshot.Domains, m.peers); err != nil {
+			return err
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current, exists := m.hosts[id]
+	next := m.cloneHosts()
+
+	if snapshot == nil {
+		if !exists {
+			return nil
+		}
+		delete(next, id)
+		return m.commit(ctx, "restore", next, nil, []*RedirectHost{current})
+	}
+
+	if err := m.checkNameConflicts(snapshot, id); err != nil {
+		return err
+	}
+
+	host := *snapshot
+	host.ID = id
+	host.UpdatedAt = time.Now()
+	if err := validateRedirectHost(&host); err != nil {
+		return err
+	}
+	next[id] = &host
+
+	return m.commit(ctx, "restore", next, []*RedirectHost{&host}, nil)
+}
+
+// Toggle enables or disables a redirect host
+func (m *RedirectHostManager) Toggle(ctx context.Context, id string, enabled bool) error {
+	return m.modify(ctx, id, "toggle", true, func(host *RedirectHost) error {
+		host.Enabled = enabled
+		return nil
+	})
+}
+
+// SetMaintenance enables or disables maintenance mode for a redirect host
+func (m *RedirectHostManager) SetMaintenance(ctx context.Context, id string, maintenance bool) error {
+	return m.modify(ctx, id, "maintenance", true, func(host *RedirectHost) error {
+		host.Maintenance = maintenance
+		return nil
+	})
+}
+
+func (m *RedirectHostManager) modify(ctx context.Context, id, action string, render bool, fn func(host *RedirectHost) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current, ok := m.hosts[id]
+	if !ok {
+		return fmt.Errorf("redirect host not found: %s", id)
+	}
+
+	host := *current
+	if err := fn(&host); err != nil {
+		return err
+	}
+	host.UpdatedAt = time.Now()
+
+	if render {
+		if err := validateRedirectHost(&host); err != nil {
+			return err
+		}
+	}
+
+	next := m.cloneHosts()
+	next[id] = &host
+
+	var written []*RedirectHost
+	if render {
+		written = append(written, &host)
+	}
+	return m.commit(ctx, action, next, written, nil)
+}
+
+// Render returns the nginx configuration generated for a redirect host
+func (m *RedirectHostManager) Render(host *RedirectHost) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := m.tmpl.Execute(&buf, host); err != nil {
+		return nil, fmt.Errorf("failed to render config: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// stageHost renders the host config and stages it, together with the
+// sites-enabled symlink matching its enabled status
+func (m *RedirectHostManager) stageHost(tx *Transaction, host *RedirectHost) error {
+	data, err := m.Render(host)
+	if err != nil {
+		return err
+	}
+
+	configPath := m.configPath(host.ID)
+	symlinkPath := m.symlinkPath(host.ID)
+
+	tx.WriteFile(configPath, data, 0644)
+	if host.Enabled {
+		tx.Symlink(configPath, symlinkPath)
+	} else {
+		tx.Remove(symlinkPath)
+	}
+	return nil
+}
+
+// stageRemove stages the removal of a host's config files
+func (m *RedirectHostManager) stageRemove(tx *Transaction, host *RedirectHost) {
+	tx.Remove(m.symlinkPath(host.ID))
+	tx.Remove(m.configPath(host.ID))
+}
+
+// BindCertificate binds cert to the given redirect hosts in a single apply,
+// switching them to SSL. Hosts that fail validation are reported and left out.
+func (m *RedirectHostManager) BindCertificate(ctx context.Context, cert *Certificate, hostIDs []string) ([]HostBindResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.rebind(ctx, "certificate", cert, hostIDs, true)
+}
+
+// stageCertificateRefresh stages the re-render of every redirect host bound
+// to cert into tx. m.mu stays locked until the returned refresh is finished.
+func (m *RedirectHostManager) stageCertificateRefresh(tx *Transaction, cert *Certificate) (*certificateRefresh, error) {
+	m.mu.Lock()
+
+	var hostIDs []string
+	for _, h := range m.hosts {
+		if h.CertificateID == cert.ID {
+			hostIDs = append(hostIDs, h.ID)
+		}
+	}
+
+	refresh := &certificateRefresh{unlock: m.mu.Unlock}
+	next, written, results := m.prepareRebind(cert, hostIDs, false)
+	refresh.results = results
+	if len(written) == 0 {
+		return refresh, nil
+	}
+	if err := m.stageCommit(tx, next, written, nil); err != nil {
+		m.mu.Unlock()
+		return nil, err
+	}
+	refresh.swap = func(ctx context.Context) {
+		m.swap(ctx, "certificate_refresh", next, written, nil)
+	}
+	return refresh, nil
+}
+
+// HostsUsingCertificate returns the IDs of redirect hosts bound to a certificate
+func (m *RedirectHostManager) HostsUsingCertificate(certID string) []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var ids []string
+	for _, h := range m.hosts {
+		if h.CertificateID == certID {
+			ids = append(ids, h.ID)
+		}
+	}
+	return ids
+}
+
+// listenPorts returns the ports the enabled redirect hosts listen on
+func (m *RedirectHostManager) listenPorts() []int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var ports []int
+	for _, h := range m.hosts {
+		if !h.Enabled {
+			continue
+		}
+		if data, err := m.Render(h); err == nil {
+			ports = append(ports, parseListenPorts(data)...)
+		}
+	}
+	return ports
+}
+
+// HostsWithTag returns the IDs of redirect hosts carrying a tag
+func (m *RedirectHostManager) HostsWithTag(tagID string) []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var ids []string
+	for _, h := range m.hosts {
+		if containsString(h.Tags, tagID) {
+			ids = append(ids, h.ID)
+		}
+	}
+	return ids
+}
+
+// rebind points the hosts at cert's files and commits them together. When
+// enableSSL is set the hosts are switched to SSL as well. Callers must hold m.mu.
+func (m *RedirectHostManager) rebind(ctx context.Context, action string, cert *Certificate, hostIDs []string, enableSSL bool) ([]HostBindResult, error) {
+	next, written, results := m.prepareRebind(cert, hostIDs, enableSSL)
+	if len(written) == 0 {
+		return results, nil
+	}
+
+	if err := m.commit(ctx, action, next, written, nil); err != nil {
+		for i := range results {
+			if results[i].Error == "" {
+				results[i].Error = err.Error()
+			}
+		}
+		return results, err
+	}
+
+	for i := range results {
+		results[i].OK = results[i].Error == ""
+	}
+	return results, nil
+}
+
+// prepareRebind returns the host set with the given hosts pointed at cert's
+// files. Hosts that are missing or fail validation are reported and left out.
+// Callers must hold m.mu.
+func (m *RedirectHostManager) prepareRebind(cert *Certificate, hostIDs []string, enableSSL bool) (map[string]*RedirectHost, []*RedirectHost, []HostBindResult) {
+	results := make([]HostBindResult, 0, len(hostIDs))
+	next := m.cloneHosts()
+	var written []*RedirectHost
+
+	for _, id := range hostIDs {
+		current, ok := next[id]
+		if !ok {
+			results = append(results, HostBindResult{HostID: id, Error: "redirect host not found: " + id})
+			continue
+		}
+
+		host := *current
+		host.CertificateID = cert.ID
+		host.CertPath = cert.CertPath
+		host.KeyPath = cert.KeyPath
+		host.ChainPath = cert.ChainPath
+		if enableSSL {
+			host.SSL = true
+		}
+		host.UpdatedAt = time.Now()
+
+		if err := validateRedirectHost(&host); err != nil {
+			results = append(results, HostBindResult{HostID: id, Domain: host.PrimaryDomain(), Error: err.Error()})
+			continue
+		}
+
+		next[id] = &host
+		written = append(written, &host)
+		results = append(results, HostBindResult{HostID: id, Domain: host.PrimaryDomain()})
+	}
+
+	return next, written, results
+}
+
+// AddTag adds a tag to a redirect host
+func (m *RedirectHostManager) AddTag(ctx context.Context, hostID, tagID string) error {
+	host, err := m.Get(hostID)
+	if err != nil {
+		return err
+	}
+	if containsString(host.Tags, tagID) {
+		return nil
+	}
+
+	return m.modify(ctx, hostID, "tag", false, func(host *RedirectHost) error {
+		host.Tags = append(append([]string{}, host.Tags...), tagID)
+		return nil
+	})
+}
+
+// RemoveTag removes a tag from a redirect host
+func (m *RedirectHostManager) RemoveTag(ctx context.Context, hostID, tagID string) error {
+	return m.modify(ctx, hostID, "untag", false, func(host *RedirectHost) error {
+		newTags := make([]string, 0, len(host.Tags))
+		for _, t := range host.Tags {
+			if t != tagID {
+				newTags = append(newTags, t)
+			}
+		}
+		host.Tags = newTags
+		return nil
+	})
+}
+
+// configPath returns the path to the nginx config file
+func (m *RedirectHostManager) configPath(id string) string {
+	return filepath.Join(m.configDir, "nubi-redirect-"+id+".conf")
+}
+
+// symlinkPath returns the path to the symlink in sites-enabled
+func (m *RedirectHostManager) symlinkPath(id string) string {
+	return filepath.Join(m.enabledDir, "nubi-redirect-"+id+".conf")
+}
+
+// claimedNames returns the server names used by redirect hosts
+func (m *RedirectHostManager) claimedNames() map[string]string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	names := make(map[string]string)
+	for _, h := range m.hosts {
+		for _, name := range h.Domains {
+			names[strings.ToLower(name)] = "redirect host " + h.PrimaryDomain()
+		}
+	}
+	return names
+}
+
+// addPeer registers another host kind whose names must not clash
+func (m *RedirectHostManager) addPeer(peer nameClaimer) {
+	m.peers = append(m.peers, peer)
+}
+
+// checkNameConflicts returns an error if any domain of host is already used
+// by another redirect host. Callers must hold m.mu.
+func (m *RedirectHostManager) checkNameConflicts(host *RedirectHost, excludeID string) error {
+	used := make(map[string]bool)
+	for _, h := range m.hosts {
+		if h.ID == excludeID {
+			continue
+		}
+		for _, name := range h.Domains {
+			used[strings.ToLower(name)] = true
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, name := range host.Domains {
+		key := strings.ToLower(name)
+		if used[key] {
+			return fmt.Errorf("domain already exists: %s", name)
+		}
+		if seen[key] {
+			return fmt.Errorf("duplicate server name: %s", name)
+		}
+		seen[key] = true
+	}
+
+	return nil
+}
+
+// validateRedirectHost fills in defaults and checks a redirect host before
+// it is rendered
+func validateRedirectHost(host *RedirectHost) error {
+	if len(host.Domains) == 0 {
+		return fmt.Errorf("at least one domain is required")
+	}
+	if err := validateDomain(host.Domains[0]); err != nil {
+		return err
+	}
+	for _, name := range host.Domains[1:] {
+		if err := validateServerName(name); err != nil {
+			return err
+		}
+	}
+
+	if host.StatusCode == 0 {
+		host.StatusCode = 301
+	}
+	switch host.StatusCode {
+	case 301, 302, 307, 308:
+	default:
+		return fmt.Errorf("invalid redirect status code: %d (expected 301, 302, 307 or 308)", host.StatusCode)
+	}
+
+	if err := validateDestination(host); err != nil {
+		return err
+	}
+
+	if !host.SSL {
+		if host.ForceSSL {
+			return fmt.Errorf("forceSSL requires ssl to be enabled")
+		}
+		return nil
+	}
+	if host.CertPath == "" || host.KeyPath == "" {
+		return fmt.Errorf("ssl requires a certificate to be bound to the host")
+	}
+	return host.TLSSettings().Validate()
+}
+
+// validateDestination checks the redirect target is an absolute http(s) URL
+// that is safe to place in a return directive and does not point back at
+// one of the source domains
+func validateDestination(host *RedirectHost) error {
+	dest := host.Destination
+	if dest == "" {
+		return fmt.Errorf("destination is required")
+	}
+
+	u, err := url.Parse(dest)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("destination must be an absolute http:// or https:// URL")
+	}
+	if strings.ContainsAny(dest, " \t\r\n;{}\"'$") {
+		return fmt.Errorf("destination contains invalid characters: %s", dest)
+	}
+	if (host.PreservePath || host.PreserveQuery) && (u.RawQuery != "" || u.Fragment != "") {
+		return fmt.Errorf("destination must not contain a query or fragment when preserving the path or query")
+	}
+
+	for _, name := range host.Domains {
+		if strings.EqualFold(u.Hostname(), name) {
+			return fmt.Errorf("destination %s would redirect back to source domain %s", dest, name)
+		}
+	}
+
+	return nil
+}
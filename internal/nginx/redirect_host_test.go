@@ -0,0 +1,54 @@
+package nginx
+
+import (
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+func TestRenderRedirectPreservePath(t *testing.T) {
+	dir := t.TempDir()
+	m, err := NewRedirectHostManager(nil, filepath.Join(dir, "available"), filepath.Join(dir, "enabled"),
+		filepath.Join(dir, "redirect_hosts.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	returnUsesURI := regexp.MustCompile(`return [^;]*\$uri\b`)
+	for _, tc := range []struct {
+		name        string
+		path, query bool
+		wantReturn  string
+		wantPathMap bool
+	}{
+		{"path", true, false, "return 301 https://example.org$nubi_redirect_path_a1_b2;", true},
+		{"path and query", true, true, "return 301 https://example.org$request_uri;", false},
+		{"query", false, true, "return 301 https://example.org/$is_args$args;", false},
+		{"neither", false, false, "return 301 https://example.org/;", false},
+	} {
+		host := &RedirectHost{
+			ID:            "a1-b2",
+			Domains:       []string{"old.example.com"},
+			Destination:   "https://example.org/",
+			StatusCode:    301,
+			PreservePath:  tc.path,
+			PreserveQuery: tc.query,
+		}
+		data, err := m.Render(host)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		config := string(data)
+
+		assertContains(t, config, tc.wantReturn)
+		if returnUsesURI.MatchString(config) {
+			t.Errorf("%s: return uses the decoded $uri:\n%s", tc.name, config)
+		}
+		mapLine := "map $request_uri $nubi_redirect_path_a1_b2 {"
+		if tc.wantPathMap {
+			assertContains(t, config, mapLine, `"~^(?<nubi_path>[^?]*)" $nubi_path;`)
+		} else {
+			assertNotContains(t, config, mapLine)
+		}
+	}
+}
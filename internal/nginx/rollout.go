@@ -0,0 +1,227 @@
+package nginx
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Keys that keep a client on the same side of a rollout split
+const (
+	SplitKeyRemoteAddr = "remote_addr"
+	SplitKeyCookie     = "cookie"
+	SplitKeyHeader     = "header"
+)
+
+// Rollout sends part of a host's traffic to a candidate backend set while a
+// new version is released. The host's backends, or its target, are the
+// stable set. Clients are split by percentage on a stable key, and requests
+// carrying a matching header or cookie always reach the candidate.
+type Rollout struct {
+	Candidate   []Backend `json:"candidate"`   // Backends of the new version, reached with the stable set's scheme
+	Percent     int       `json:"percent"`     // Share of clients sent to the candidate, 0-100 (100 = blue/green switch)
+	SplitKey    string    `json:"splitKey"`    // remote_addr (default), cookie or header
+	SplitName   string    `json:"splitName"`   // Cookie or header name for those keys; clients without it split by address
+	MatchHeader string    `json:"matchHeader"` // Requests with this header go to the candidate (optional)
+	MatchCookie string    `json:"matchCookie"` // Requests with this cookie go to the candidate (optional)
+	MatchValue  string    `json:"matchValue"`  // Required header or cookie value (empty = any value)
+}
+
+// validateRollout checks an optional rollout against the host it belongs to
+func validateRollout(host *ProxyHost) error {
+	r := host.Rollout
+	if r == nil {
+		return nil
+	}
+
+	if len(r.Candidate) == 0 {
+		return fmt.Errorf("rollout requires at least one candidate backend")
+	}
+	if err := validateBackends(r.Candidate); err != nil {
+		return fmt.Errorf("rollout candidate: %w", err)
+	}
+	if err := validateBalancing(host.LBMethod, host.LBOptions, r.Candidate, true); err != nil {
+		return fmt.Errorf("rollout candidate: %w", err)
+	}
+	if r.Percent < 0 || r.Percent > 100 {
+		return fmt.Errorf("rollout percent must be between 0 and 100")
+	}
+
+	if r.SplitKey == "" {
+		r.SplitKey = SplitKeyRemoteAddr
+	}
+	switch r.SplitKey {
+	case SplitKeyRemoteAddr:
+		if r.SplitName != "" {
+			return fmt.Errorf("splitName is only used with the cookie and header keys")
+		}
+	case SplitKeyCookie:
+		if !cookieNameRegex.MatchString(r.SplitName) {
+			return fmt.Errorf("invalid rollout split cookie name: %q", r.SplitName)
+		}
+	case SplitKeyHeader:
+		if !limitHeaderRegex.MatchString(r.SplitName) {
+			return fmt.Errorf("invalid rollout split header name: %q", r.SplitName)
+		}
+	default:
+		return fmt.Errorf("invalid rollout split key: %s (expected remote_addr, cookie or header)", r.SplitKey)
+	}
+
+	switch {
+	case r.MatchHeader != "" && r.MatchCookie != "":
+		return fmt.Errorf("rollout can match a header or a cookie, not both")
+	case r.MatchHeader != "" && !limitHeaderRegex.MatchString(r.MatchHeader):
+		return fmt.Errorf("invalid rollout match header name: %q", r.MatchHeader)
+	case r.MatchCookie != "" && !cookieNameRegex.MatchString(r.MatchCookie):
+		return fmt.Errorf("invalid rollout match cookie name: %q", r.MatchCookie)
+	case r.MatchHeader == "" && r.MatchCookie == "" && r.MatchValue != "":
+		return fmt.Errorf("matchValue requires matchHeader or matchCookie")
+	}
+	if strings.HasPrefix(r.MatchValue, "~") || strings.ContainsAny(r.MatchValue, "\"\\$") || strings.IndexFunc(r.MatchValue, func(c rune) bool { return c < 0x20 || c == 0x7f }) >= 0 {
+		return fmt.Errorf("rollout match value must not start with ~ or contain quotes, backslashes, variables or control characters")
+	}
+
+	// proxy_pass through a variable drops the URI part of the target
+	if len(host.Backends) == 0 {
+		u, err := url.Parse(host.Target)
+		if err != nil || (u.Path != "" && u.Path != "/") || u.RawQuery != "" {
+			return fmt.Errorf("rollout requires a target without a path")
+		}
+	}
+	return nil
+}
+
+// rolloutName returns a name for the host's rollout variables
+func (h *ProxyHost) rolloutName(kind string) string {
+	return "$nubi_rollout_" + kind + "_" + regexp.MustCompile(`[^a-zA-Z0-9]`).ReplaceAllString(h.ID, "_")
+}
+
+// CandidateUpstreamName returns the upstream name of the rollout candidate
+func (h *ProxyHost) CandidateUpstreamName() string {
+	return h.UpstreamName() + "_candidate"
+}
+
+// CandidateUpstream returns the view of the candidate upstream block, or nil
+// when no rollout is in progress
+func (h *ProxyHost) CandidateUpstream() *upstreamView {
+	if h.Rollout == nil {
+		return nil
+	}
+	return &upstreamView{
+		HostID:   h.ID,
+		Name:     h.CandidateUpstreamName(),
+		LBMethod: h.LBMethod,
+		Options:  h.LBOptions,
+		Servers:  h.Rollout.Candidate,
+		Settings: h.Upstream,
+	}
+}
+
+// stableScheme returns the scheme the stable set is reached with, which the
+// candidate shares
+func (h *ProxyHost) stableScheme() string {
+	if len(h.Backends) == 0 {
+		if u, err := url.Parse(h.Target); err == nil && u.Scheme != "" {
+			return u.Scheme
+		}
+	}
+	return "http"
+}
+
+// rolloutPass returns the proxy_pass value of location / during a rollout
+func (h *ProxyHost) rolloutPass() string {
+	return h.stableScheme() + "://" + h.rolloutTarget()
+}
+
+// rolloutTarget returns the variable holding the upstream chosen for a request
+func (h *ProxyHost) rolloutTarget() string {
+	r := h.Rollout
+	if r.MatchHeader != "" || r.MatchCookie != "" {
+		return h.rolloutName("upstream")
+	}
+	return h.rolloutName("split")
+}
+
+// rolloutDefinitions returns the http-level split_clients and maps choosing
+// the upstream of each request
+func (h *ProxyHost) rolloutDefinitions() []string {
+	r := h.Rollout
+	stable, candidate := h.UpstreamName(), h.CandidateUpstreamName()
+	var defs []string
+
+	key := "$remote_addr"
+	if r.SplitKey != SplitKeyRemoteAddr {
+		source := "$cookie_" + r.SplitName
+		if r.SplitKey == SplitKeyHeader {
+			source = "$http_" + strings.ToLower(strings.ReplaceAll(r.SplitName, "-", "_"))
+		}
+		key = h.rolloutName("key")
+		defs = append(defs, fmt.Sprintf("map %s %s {\n    \"\" $remote_addr;\n    default %s;\n}", source, key, source))
+	}
+
+	var split strings.Builder
+	fmt.Fprintf(&split, "split_clients \"%s\" %s {\n", key, h.rolloutName("split"))
+	switch r.Percent {
+	case 0:
+		fmt.Fprintf(&split, "    * %s;\n", stable)
+	case 100:
+		fmt.Fprintf(&split, "    * %s;\n", candidate)
+	default:
+		fmt.Fprintf(&split, "    %d%% %s;\n    * %s;\n", r.Percent, candidate, stable)
+	}
+	split.WriteString("}")
+	defs = append(defs, split.String())
+
+	if r.MatchHeader != "" || r.MatchCookie != "" {
+		source := "$cookie_" + r.MatchCookie
+		if r.MatchHeader != "" {
+			source = "$http_" + strings.ToLower(strings.ReplaceAll(r.MatchHeader, "-", "_"))
+		}
+		match := `"~."`
+		if r.MatchValue != "" {
+			match = `"` + r.MatchValue + `"`
+		}
+		defs = append(defs, fmt.Sprintf("map %s %s {\n    default %s;\n    %s %s;\n}", source, h.rolloutName("upstream"), h.rolloutName("split"), match, candidate))
+	}
+	return defs
+}
+
+// SetRollout starts a rollout or changes the one in progress
+func (m *ProxyHostManager) SetRollout(ctx context.Context, id string, rollout *Rollout) error {
+	return m.modify(ctx, id, "rollout", true, func(host *ProxyHost) error {
+		host.Rollout = rollout
+		return nil
+	})
+}
+
+// PromoteRollout makes the candidate the host's backends and ends the rollout
+func (m *ProxyHostManager) PromoteRollout(ctx context.Context, id string) error {
+	return m.modify(ctx, id, "rollout_promote", true, func(host *ProxyHost) error {
+		if host.Rollout == nil {
+			return fmt.Errorf("no rollout in progress")
+		}
+		candidate := host.Rollout.Candidate
+		if len(candidate) == 1 {
+			// A single backend is proxied to directly through the target
+			host.Target = host.stableScheme() + "://" + candidate[0].Address
+			host.Backends = nil
+		} else {
+			host.Backends = candidate
+		}
+		host.Rollout = nil
+		return nil
+	})
+}
+
+// AbortRollout ends the rollout and sends all traffic back to the stable set
+func (m *ProxyHostManager) AbortRollout(ctx context.Context, id string) error {
+	return m.modify(ctx, id, "rollout_abort", true, func(host *ProxyHost) error {
+		if host.Rollout == nil {
+			return fmt.Errorf("no rollout in progress")
+		}
+		host.Rollout = nil
+		return nil
+	})
+}
@@ -0,0 +1,170 @@
+package nginx
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Security header presets
+const (
+	SecurityPresetStrict   = "strict"
+	SecurityPresetBalanced = "balanced"
+	SecurityPresetOff      = "off"
+)
+
+// securityHeaderOff drops a header of the preset
+const securityHeaderOff = "off"
+
+// securityPreset holds the header values of a preset, empty when the preset
+// does not send the header
+type securityPreset struct {
+	hsts, contentTypeOptions, frameOptions, referrerPolicy, permissionsPolicy, csp string
+}
+
+var securityPresets = map[string]securityPreset{
+	SecurityPresetStrict: {
+		hsts:               "max-age=63072000; includeSubDomains",
+		contentTypeOptions: "nosniff",
+		frameOptions:       "DENY",
+		referrerPolicy:     "no-referrer",
+		permissionsPolicy:  "accelerometer=(), camera=(), geolocation=(), gyroscope=(), magnetometer=(), microphone=(), payment=(), usb=()",
+		csp:                "default-src 'self'; object-src 'none'; base-uri 'self'; frame-ancestors 'none'; form-action 'self'",
+	},
+	SecurityPresetBalanced: {
+		hsts:               "max-age=31536000",
+		contentTypeOptions: "nosniff",
+		frameOptions:       "SAMEORIGIN",
+		referrerPolicy:     "strict-origin-when-cross-origin",
+		permissionsPolicy:  "camera=(), geolocation=(), microphone=()",
+		csp:                "object-src 'none'; base-uri 'self'; frame-ancestors 'self'",
+	},
+	SecurityPresetOff: {},
+}
+
+// SecurityHeaders adds a bundle of security response headers to a host.
+// Each header takes the preset's value unless overridden; "off" drops it.
+type SecurityHeaders struct {
+	Preset             string `json:"preset"`             // strict, balanced (default) or off
+	HSTS               string `json:"hsts"`               // Strict-Transport-Security, SSL hosts only
+	ContentTypeOptions string `json:"contentTypeOptions"` // X-Content-Type-Options
+	FrameOptions       string `json:"frameOptions"`       // X-Frame-Options
+	ReferrerPolicy     string `json:"referrerPolicy"`     // Referrer-Policy
+	PermissionsPolicy  string `json:"permissionsPolicy"`  // Permissions-Policy
+	CSP                string `json:"csp"`                // Content-Security-Policy
+	CSPReportOnly      bool   `json:"cspReportOnly"`      // Send the policy as Content-Security-Policy-Report-Only
+}
+
+// securityHeader is a header of the bundle with its effective value
+type securityHeader struct {
+	name, value string
+}
+
+// headers returns the headers sent for the settings, in a fixed order.
+// Strict-Transport-Security is only sent when hsts is set.
+func (s *SecurityHeaders) headers(hsts bool) []securityHeader {
+	preset := securityPresets[s.Preset]
+	pick := func(override, value string) string {
+		switch override {
+		case "":
+			return value
+		case securityHeaderOff:
+			return ""
+		default:
+			return override
+		}
+	}
+
+	cspName := "Content-Security-Policy"
+	if s.CSPReportOnly {
+		cspName = "Content-Security-Policy-Report-Only"
+	}
+	all := []securityHeader{
+		{"Strict-Transport-Security", pick(s.HSTS, preset.hsts)},
+		{"X-Content-Type-Options", pick(s.ContentTypeOptions, preset.contentTypeOptions)},
+		{"X-Frame-Options", pick(s.FrameOptions, preset.frameOptions)},
+		{"Referrer-Policy", pick(s.ReferrerPolicy, preset.referrerPolicy)},
+		{"Permissions-Policy", pick(s.PermissionsPolicy, preset.permissionsPolicy)},
+		{cspName, pick(s.CSP, preset.csp)},
+	}
+
+	var headers []securityHeader
+	for _, h := range all {
+		if h.value == "" || (h.name == "Strict-Transport-Security" && !hsts) {
+			continue
+		}
+		headers = append(headers, h)
+	}
+	return headers
+}
+
+// validateSecurityHeaders normalizes and checks a host's security headers.
+// Header rules must not add a header of the bundle, which would send it
+// twice.
+func validateSecurityHeaders(host *ProxyHost) error {
+	s := host.SecurityHeaders
+	if s == nil {
+		return nil
+	}
+
+	if s.Preset == "" {
+		s.Preset = SecurityPresetBalanced
+	}
+	if _, ok := securityPresets[s.Preset]; !ok {
+		return fmt.Errorf("invalid security header preset: %s (expected strict, balanced or off)", s.Preset)
+	}
+
+	overrides := map[string]string{
+		"Strict-Transport-Security": s.HSTS,
+		"X-Content-Type-Options":    s.ContentTypeOptions,
+		"X-Frame-Options":           s.FrameOptions,
+		"Referrer-Policy":           s.ReferrerPolicy,
+		"Permissions-Policy":        s.PermissionsPolicy,
+		"Content-Security-Policy":   s.CSP,
+	}
+	for name, value := range overrides {
+		if err := validateHeaderValue(value); err != nil {
+			return fmt.Errorf("security header %s: %w", name, err)
+		}
+	}
+	if s.HSTS != "" && s.HSTS != securityHeaderOff && !strings.HasPrefix(s.HSTS, "max-age=") {
+		return fmt.Errorf("security header Strict-Transport-Security must start with max-age=")
+	}
+	if s.CSPReportOnly && s.CSP == securityHeaderOff {
+		return fmt.Errorf("cspReportOnly requires a Content-Security-Policy")
+	}
+
+	sent := make(map[string]bool)
+	for _, h := range host.securityHeaders() {
+		sent[strings.ToLower(h.name)] = true
+	}
+	rules := append([]HeaderRule{}, host.HeaderRules...)
+	for _, l := range host.Locations {
+		rules = append(rules, l.HeaderRules...)
+	}
+	for _, r := range rules {
+		if r.Action == HeaderAdd && sent[strings.ToLower(r.Name)] {
+			return fmt.Errorf("header %s is sent by the security headers; override it there instead", r.Name)
+		}
+	}
+	return nil
+}
+
+// securityHeaders returns the security headers of the host. HSTS is left out
+// of plain HTTP hosts, and the TLS policy's HSTS takes precedence over the
+// bundle's.
+func (h *ProxyHost) securityHeaders() []securityHeader {
+	if h.SecurityHeaders == nil {
+		return nil
+	}
+	return h.SecurityHeaders.headers(h.SSL && !h.TLSSettings().HSTS.Enabled)
+}
+
+// SecurityHeaderDirectives returns the add_header directives of the host's
+// security headers. They use always so error responses carry them too.
+func (h *ProxyHost) SecurityHeaderDirectives() []string {
+	var lines []string
+	for _, sh := range h.securityHeaders() {
+		lines = append(lines, fmt.Sprintf("add_header %s \"%s\" always;", sh.name, sh.value))
+	}
+	return lines
+}
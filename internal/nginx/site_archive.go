@@ -0,0 +1,178 @@
+package nginx
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Limits applied while unpacking an uploaded site
+const (
+	maxSiteBytes = 1 << 30 // total uncompressed size
+	maxSiteFiles = 50000
+)
+
+// archiveFormat returns "tar.gz" or "zip" for an upload filename
+func archiveFormat(filename string) (string, error) {
+	name := strings.ToLower(filename)
+	switch {
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return "tar.gz", nil
+	case strings.HasSuffix(name, ".zip"):
+		return "zip", nil
+	default:
+		return "", fmt.Errorf("unsupported archive %s (expected .tar.gz, .tgz or .zip)", filename)
+	}
+}
+
+// siteExtractor writes archive entries below dest while enforcing the limits
+type siteExtractor struct {
+	dest  string
+	bytes int64
+	files int
+}
+
+// extractArchive unpacks a tar.gz or zip archive into dest. Entries that
+// would escape dest, links and special files are rejected.
+func extractArchive(r io.ReaderAt, size int64, format, dest string) error {
+	x := &siteExtractor{dest: dest}
+
+	switch format {
+	case "tar.gz":
+		gz, err := gzip.NewReader(io.NewSectionReader(r, 0, size))
+		if err != nil {
+			return fmt.Errorf("invalid tar.gz archive: %w", err)
+		}
+		defer gz.Close()
+
+		tr := tar.NewReader(gz)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("invalid tar.gz archive: %w", err)
+			}
+
+			switch hdr.Typeflag {
+			case tar.TypeDir:
+				if err := x.dir(hdr.Name); err != nil {
+					return err
+				}
+			case tar.TypeReg:
+				if err := x.file(hdr.Name, tr); err != nil {
+					return err
+				}
+			case tar.TypeXGlobalHeader:
+			default:
+				return fmt.Errorf("unsupported archive entry %s: only files and directories are allowed", hdr.Name)
+			}
+		}
+
+	case "zip":
+		zr, err := zip.NewReader(r, size)
+		if err != nil {
+			return fmt.Errorf("invalid zip archive: %w", err)
+		}
+
+		for _, f := range zr.File {
+			mode := f.Mode()
+			switch {
+			case mode.IsDir():
+				if err := x.dir(f.Name); err != nil {
+					return err
+				}
+			case mode.IsRegular():
+				rc, err := f.Open()
+				if err != nil {
+					return fmt.Errorf("invalid zip entry %s: %w", f.Name, err)
+				}
+				err = x.file(f.Name, rc)
+				rc.Close()
+				if err != nil {
+					return err
+				}
+			default:
+				return fmt.Errorf("unsupported archive entry %s: only files and directories are allowed", f.Name)
+			}
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unsupported archive format: %s", format)
+	}
+}
+
+// target maps an archive entry name to a path below the destination
+func (x *siteExtractor) target(name string) (string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return "", fmt.Errorf("archive entry escapes the site root: %s", name)
+		}
+	}
+	return filepath.Join(x.dest, filepath.FromSlash(path.Clean("/"+name))), nil
+}
+
+func (x *siteExtractor) dir(name string) error {
+	target, err := x.target(name)
+	if err != nil {
+		return err
+	}
+	return os.MkdirAll(target, 0755)
+}
+
+func (x *siteExtractor) file(name string, r io.Reader) error {
+	target, err := x.target(name)
+	if err != nil {
+		return err
+	}
+
+	x.files++
+	if x.files > maxSiteFiles {
+		return fmt.Errorf("archive has more than %d files", maxSiteFiles)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Copy one byte past the remaining budget to detect oversized archives
+	remaining := int64(maxSiteBytes) - x.bytes
+	n, err := io.Copy(f, io.LimitReader(r, remaining+1))
+	x.bytes += n
+	if err != nil {
+		return fmt.Errorf("failed to extract %s: %w", name, err)
+	}
+	if x.bytes > maxSiteBytes {
+		return fmt.Errorf("archive expands to more than %d bytes", int64(maxSiteBytes))
+	}
+	return nil
+}
+
+// siteContentRoot returns the directory holding the site's files. Archives
+// that wrap everything in a single top-level folder (e.g. dist/) are
+// unwrapped.
+func siteContentRoot(dir string) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	if len(entries) == 1 && entries[0].IsDir() {
+		return filepath.Join(dir, entries[0].Name()), nil
+	}
+	return dir, nil
+}
@@ -0,0 +1,314 @@
+package nginx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// SnippetPolicy restricts what CustomNginx snippets may contain
+type SnippetPolicy struct {
+	DeniedDirectives []string `json:"deniedDirectives"` // Directive names, "*" matches any characters, e.g. "lua_*"
+	IncludeDirs      []string `json:"includeDirs"`      // include is only allowed for files below these directories
+}
+
+// DefaultSnippetPolicy denies directives that expose the filesystem or load
+// code into nginx
+func DefaultSnippetPolicy() SnippetPolicy {
+	return SnippetPolicy{
+		DeniedDirectives: []string{"alias", "root", "load_module", "lua_*", "*_by_lua*", "perl*", "js_*"},
+		IncludeDirs:      []string{"/etc/nginx/snippets"},
+	}
+}
+
+// SnippetIssue is a problem found in a snippet. Line is 1-based within the
+// snippet, or 0 when the problem is not tied to a snippet line.
+type SnippetIssue struct {
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+// SnippetError is returned when a CustomNginx snippet is rejected
+type SnippetError struct {
+	Issues []SnippetIssue `json:"issues"`
+	Output string         `json:"output,omitempty"` // nginx -t output, when the test failed
+}
+
+func (e *SnippetError) Error() string {
+	if len(e.Issues) == 0 {
+		return "invalid custom nginx configuration"
+	}
+	issue := e.Issues[0]
+	if issue.Line > 0 {
+		return fmt.Sprintf("custom nginx line %d: %s", issue.Line, issue.Message)
+	}
+	return "custom nginx: " + issue.Message
+}
+
+// snippetStatement is a directive of a snippet with its arguments
+type snippetStatement struct {
+	name string
+	args []string
+	line int
+}
+
+// parseSnippet splits a snippet into directives and reports unbalanced
+// braces, unterminated quotes and directives missing their semicolon
+func parseSnippet(snippet string) ([]snippetStatement, []SnippetIssue) {
+	var (
+		statements []snippetStatement
+		issues     []SnippetIssue
+		tokens     []string
+		token      strings.Builder
+		inToken    bool
+		quote      rune
+		escaped    bool
+		comment    bool
+		line       = 1
+		stmtLine   int
+		quoteLine  int
+		openBraces []int
+	)
+
+	flushToken := func() {
+		if inToken {
+			if len(tokens) == 0 {
+				stmtLine = line
+			}
+			tokens = append(tokens, token.String())
+			token.Reset()
+			inToken = false
+		}
+	}
+	endStatement := func() {
+		flushToken()
+		if len(tokens) > 0 {
+			statements = append(statements, snippetStatement{name: tokens[0], args: tokens[1:], line: stmtLine})
+		}
+		tokens = nil
+	}
+
+	for _, c := range snippet {
+		switch {
+		case comment:
+			if c == '\n' {
+				comment = false
+			}
+		case quote != 0:
+			switch {
+			case escaped:
+				escaped = false
+				token.WriteRune(c)
+			case c == '\\':
+				escaped = true
+			case c == quote:
+				quote = 0
+			default:
+				token.WriteRune(c)
+			}
+		case c == '"' || c == '\'':
+			if !inToken && len(tokens) == 0 {
+				stmtLine = line
+			}
+			inToken = true
+			quote = c
+			quoteLine = line
+		case c == '#' && !inToken:
+			comment = true
+		case c == ';':
+			flushToken()
+			if len(tokens) == 0 {
+				issues = append(issues, SnippetIssue{Line: line, Message: "unexpected \";\""})
+			}
+			endStatement()
+		case c == '{':
+			flushToken()
+			if len(tokens) == 0 {
+				issues = append(issues, SnippetIssue{Line: line, Message: "block without a directive"})
+			}
+			endStatement()
+			openBraces = append(openBraces, line)
+		case c == '}':
+			flushToken()
+			if len(tokens) > 0 {
+				issues = append(issues, SnippetIssue{Line: stmtLine, Message: fmt.Sprintf("directive %q is missing a terminating \";\"", tokens[0])})
+				tokens = nil
+			}
+			if len(openBraces) == 0 {
+				issues = append(issues, SnippetIssue{Line: line, Message: "unexpected \"}\" without a matching \"{\""})
+			} else {
+				openBraces = openBraces[:len(openBraces)-1]
+			}
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			flushToken()
+		default:
+			if !inToken && len(tokens) == 0 {
+				stmtLine = line
+			}
+			inToken = true
+			token.WriteRune(c)
+		}
+		if c == '\n' {
+			line++
+		}
+	}
+
+	if quote != 0 {
+		issues = append(issues, SnippetIssue{Line: quoteLine, Message: "unterminated quoted string"})
+	} else {
+		flushToken()
+		if len(tokens) > 0 {
+			issues = append(issues, SnippetIssue{Line: stmtLine, Message: fmt.Sprintf("directive %q is missing a terminating \";\"", tokens[0])})
+		}
+	}
+	for _, l := range openBraces {
+		issues = append(issues, SnippetIssue{Line: l, Message: "\"{\" is never closed"})
+	}
+	return statements, issues
+}
+
+// Check parses a snippet and applies the policy. It returns nil when the
+// snippet is acceptable.
+func (p SnippetPolicy) Check(snippet string) *SnippetError {
+	statements, issues := parseSnippet(snippet)
+
+	for _, stmt := range statements {
+		name := strings.ToLower(stmt.name)
+		for _, pattern := range p.DeniedDirectives {
+			if ok, _ := path.Match(strings.ToLower(pattern), name); ok {
+				issues = append(issues, SnippetIssue{Line: stmt.line, Message: fmt.Sprintf("directive %q is not allowed in custom configuration", stmt.name)})
+				break
+			}
+		}
+		if name == "include" {
+			if msg := p.checkInclude(stmt.args); msg != "" {
+				issues = append(issues, SnippetIssue{Line: stmt.line, Message: msg})
+			}
+		}
+	}
+
+	if len(issues) == 0 {
+		return nil
+	}
+	return &SnippetError{Issues: issues}
+}
+
+// checkInclude returns why an include is not allowed, or ""
+func (p SnippetPolicy) checkInclude(args []string) string {
+	if len(args) != 1 {
+		return "include takes exactly one file"
+	}
+	file := args[0]
+	if !filepath.IsAbs(file) || strings.Contains(file, "$") {
+		return fmt.Sprintf("include %q must be an absolute path", file)
+	}
+	clean := filepath.Clean(file)
+	for _, dir := range p.IncludeDirs {
+		if strings.HasPrefix(clean, filepath.Clean(dir)+string(filepath.Separator)) {
+			return ""
+		}
+	}
+	if len(p.IncludeDirs) == 0 {
+		return "include is not allowed in custom configuration"
+	}
+	return fmt.Sprintf("include %q is outside the allowed directories (%s)", file, strings.Join(p.IncludeDirs, ", "))
+}
+
+// SetSnippetPolicy replaces the policy applied to CustomNginx snippets
+func (m *ProxyHostManager) SetSnippetPolicy(policy SnippetPolicy) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.snippetPolicy = policy
+}
+
+// CheckSnippet validates a host's CustomNginx snippet without saving it
+func (m *ProxyHostManager) CheckSnippet(ctx context.Context, host *ProxyHost) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.checkSnippet(ctx, host)
+}
+
+// checkSnippet applies the snippet policy, then tests the rendered host in a
+// throwaway config tree so mistakes are caught before anything reaches
+// /etc/nginx. The caller holds m.mu.
+func (m *ProxyHostManager) checkSnippet(ctx context.Context, host *ProxyHost) error {
+	if strings.TrimSpace(host.CustomNginx) == "" {
+		return nil
+	}
+	if err := m.snippetPolicy.Check(host.CustomNginx); err != nil {
+		return err
+	}
+	if m.ctrl == nil {
+		return nil
+	}
+
+	data, err := m.Render(host)
+	if err != nil {
+		return err
+	}
+
+	dir, err := os.MkdirTemp("", "nubi-snippet-")
+	if err != nil {
+		return fmt.Errorf("failed to create test config: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	hostConf := filepath.Join(dir, "host.conf")
+	if err := os.WriteFile(hostConf, data, 0644); err != nil {
+		return fmt.Errorf("failed to write test config: %w", err)
+	}
+
+	var includes strings.Builder
+	if httpData := m.renderHTTPConfig(map[string]*ProxyHost{host.ID: host}); httpData != nil {
+		httpConf := filepath.Join(dir, "http.conf")
+		if err := os.WriteFile(httpConf, httpData, 0644); err != nil {
+			return fmt.Errorf("failed to write test config: %w", err)
+		}
+		includes.WriteString("    include " + httpConf + ";\n")
+	}
+	includes.WriteString("    include " + hostConf + ";\n")
+
+	mainConf := filepath.Join(dir, "nginx.conf")
+	conf := fmt.Sprintf("# Nubi snippet test - throwaway config\npid %s;\nerror_log %s;\nevents {}\nhttp {\n%s}\n",
+		filepath.Join(dir, "nginx.pid"), filepath.Join(dir, "error.log"), includes.String())
+	if err := os.WriteFile(mainConf, []byte(conf), 0644); err != nil {
+		return fmt.Errorf("failed to write test config: %w", err)
+	}
+
+	err = m.ctrl.TestConfigFile(ctx, dir, mainConf)
+	var testErr *ConfigTestError
+	if !errors.As(err, &testErr) {
+		return err
+	}
+	return snippetTestError(testErr, string(data), host.CustomNginx, hostConf)
+}
+
+// snippetTestError turns nginx -t issues in the throwaway host config into
+// issues numbered by snippet line
+func snippetTestError(testErr *ConfigTestError, rendered, snippet, hostConf string) *SnippetError {
+	start := 0
+	if idx := strings.Index(rendered, "# Custom configuration\n"+snippet); idx >= 0 {
+		start = strings.Count(rendered[:idx], "\n") + 2
+	}
+	end := start + strings.Count(snippet, "\n")
+
+	result := &SnippetError{Output: testErr.Output}
+	for _, issue := range testErr.Issues {
+		if issue.Level != "emerg" && issue.Level != "alert" && issue.Level != "crit" {
+			continue
+		}
+		line := 0
+		if start > 0 && issue.File == hostConf && issue.Line >= start && issue.Line <= end {
+			line = issue.Line - start + 1
+		}
+		result.Issues = append(result.Issues, SnippetIssue{Line: line, Message: issue.Message})
+	}
+	if len(result.Issues) == 0 {
+		result.Issues = append(result.Issues, SnippetIssue{Message: testErr.Error()})
+	}
+	return result
+}
@@ -0,0 +1,870 @@
+package nginx
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// CacheRule sets Cache-Control for files with the given extensions
+type CacheRule struct {
+	Extensions   []string `json:"extensions"`   // e.g., ["js", "css", "woff2"]
+	CacheControl string   `json:"cacheControl"` // e.g., "public, max-age=31536000, immutable"
+}
+
+// Pattern returns the location regex matching the rule's extensions
+func (r CacheRule) Pattern() string {
+	return `"\.(` + strings.Join(r.Extensions, "|") + `)$"`
+}
+
+// StaticHost serves files from a Nubi-managed directory. Each upload is
+// unpacked into its own release and published by switching the "current"
+// symlink, so the previous release stays on disk for instant rollback.
+type StaticHost struct {
+	ID              string      `json:"id"`
+	Domain          string      `json:"domain"`          // Primary name, e.g., "docs.example.com"
+	Aliases         []string    `json:"aliases"`         // Additional server names
+	SPA             bool        `json:"spa"`             // Fall back to /index.html for unknown paths
+	Autoindex       bool        `json:"autoindex"`       // Show directory listings
+	CacheRules      []CacheRule `json:"cacheRules"`      // Cache-Control per file extension
+	SSL             bool        `json:"ssl"`             // Enable SSL/HTTPS
+	ForceSSL        bool        `json:"forceSSL"`        // Redirect HTTP to HTTPS
+	CertificateID   string      `json:"certificateId"`   // ID of the certificate to use
+	CertPath        string      `json:"certPath"`        // Path to SSL certificate
+	KeyPath         string      `json:"keyPath"`         // Path to SSL private key
+	ChainPath       string      `json:"chainPath"`       // Path to CA chain used for OCSP stapling (optional)
+	TLS             *TLSPolicy  `json:"tls,omitempty"`   // TLS policy (defaults to the intermediate preset)
+	Enabled         bool        `json:"enabled"`         // Whether this site is active
+	Version         string      `json:"version"`         // Release currently served
+	PreviousVersion string      `json:"previousVersion"` // Release kept for rollback
+	PublishedAt     *time.Time  `json:"publishedAt,omitempty"`
+	CreatedAt       time.Time   `json:"createdAt"`
+	UpdatedAt       time.Time   `json:"updatedAt"`
+}
+
+// ServerNames returns the primary domain followed by all aliases
+func (h *StaticHost) ServerNames() []string {
+	return append([]string{h.Domain}, h.Aliases...)
+}
+
+// ServerNameList returns the server_name value
+func (h *StaticHost) ServerNameList() string {
+	return formatServerNames(h.ServerNames())
+}
+
+// TLSSettings returns the effective TLS policy for the site
+func (h *StaticHost) TLSSettings() *TLSPolicy {
+	if h.TLS == nil {
+		policy := defaultTLSPolicy
+		return &policy
+	}
+	return h.TLS
+}
+
+// StaticHostManager handles CRUD operations and releases for static sites
+type StaticHostManager struct {
+	mu         sync.RWMutex
+	hosts      map[string]*StaticHost
+	ctrl       *Controller   // used to test staged config before it goes live
+	history    *HistoryStore // optional revision log
+	peers      []nameClaimer // other host kinds whose server names must not clash
+	configDir  string        // e.g., /etc/nginx/sites-available
+	enabledDir string        // e.g., /etc/nginx/sites-enabled
+	dataFile   string        // e.g., /var/lib/nubi/static_hosts.json
+	sitesDir   string        // e.g., /var/lib/nubi/sites
+	tmpl       *template.Template
+
+	releaseMu sync.Mutex
+	releases  map[string]*sync.Mutex // per site, serialises publishes and rollbacks
+}
+
+const staticHostTemplate = `# Nubi managed static site: {{ .Domain }}
+# Do not edit manually - changes will be overwritten
+# Host ID: {{ .ID }}
+
+server {
+    listen 80;
+{{- if .SSL }}
+    listen 443 ssl http2;
+{{- end }}
+    server_name {{ .ServerNameList }};
+
+{{- if and .SSL .ForceSSL }}
+    # Force HTTPS redirect
+    if ($scheme = http) {
+        return 301 https://$host$request_uri;
+    }
+{{- end }}
+
+{{- if .SSL }}
+{{- template "tls" . }}
+{{- end }}
+
+    root {{ .Root }};
+    index index.html;
+
+    # Never serve dotfiles such as .git or .env
+    location ~ /\.(?!well-known/) {
+        deny all;
+    }
+
+{{- range .CacheRules }}
+
+    location ~* {{ .Pattern }} {
+        add_header Cache-Control "{{ .CacheControl }}" always;
+        try_files $uri =404;
+    }
+{{- end }}
+
+    location / {
+{{- if .SPA }}
+        try_files $uri $uri/ /index.html;
+{{- else }}
+        try_files $uri $uri/ =404;
+{{- end }}
+{{- if .Autoindex }}
+        autoindex on;
+{{- end }}
+    }
+}
+`
+
+// staticTemplateData adds the document root to a host for rendering
+type staticTemplateData struct {
+	*StaticHost
+	Root string
+}
+
+// NewStaticHostManager creates a new static site manager
+func NewStaticHostManager(ctrl *Controller, configDir, enabledDir, dataFile, sitesDir string) (*StaticHostManager, error) {
+	if configDir == "" {
+		configDir = "/etc/nginx/sites-available"
+	}
+	if enabledDir == "" {
+		enabledDir = "/etc/nginx/sites-enabled"
+	}
+	if dataFile == "" {
+		dataFile = "/var/lib/nubi/static_hosts.json"
+	}
+	if sitesDir == "" {
+		sitesDir = "/var/lib/nubi/sites"
+	}
+
+	tmpl, err := template.New("static_host").Parse(staticHostTemplate)
+	if err == nil {
+		_, err = tmpl.Parse(tlsTemplate)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse static host template: %w", err)
+	}
+
+	mgr := &StaticHostManager{
+		hosts:      make(map[string]*StaticHost),
+		ctrl:       ctrl,
+		configDir:  configDir,
+		enabledDir: enabledDir,
+		dataFile:   dataFile,
+		sitesDir:   sitesDir,
+		tmpl:       tmpl,
+		releases:   make(map[string]*sync.Mutex),
+	}
+
+	if err := mgr.load(); err != nil {
+		// Not a fatal error - might be first run
+		fmt.Printf("Note: Could not load existing static sites: %v\n", err)
+	}
+
+	return mgr, nil
+}
+
+// load reads static sites from the JSON data file
+func (m *StaticHostManager) load() error {
+	data, err := os.ReadFile(m.dataFile)
+	if err != nil {
+		return err
+	}
+
+	var hosts []*StaticHost
+	if err := json.Unmarshal(data, &hosts); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.hosts = make(map[string]*StaticHost)
+	for _, h := range hosts {
+		m.hosts[h.ID] = h
+	}
+
+	return nil
+}
+
+func (m *StaticHostManager) cloneHosts() map[string]*StaticHost {
+	next := make(map[string]*StaticHost, len(m.hosts))
+	for id, h := range m.hosts {
+		next[id] = h
+	}
+	return next
+}
+
+// SetHistory attaches a revision log; committed changes are recorded to it
+func (m *StaticHostManager) SetHistory(history *HistoryStore) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.history = history
+}
+
+// siteDir returns the directory holding a site's releases
+func (m *StaticHostManager) siteDir(id string) string {
+	return filepath.Join(m.sitesDir, id)
+}
+
+// releaseDir returns the directory of a single release
+func (m *StaticHostManager) releaseDir(id, version string) string {
+	return filepath.Join(m.siteDir(id), "releases", version)
+}
+
+// currentLink returns the symlink nginx serves the site from
+func (m *StaticHostManager) currentLink(id string) string {
+	return filepath.Join(m.siteDir(id), "current")
+}
+
+// commit stages the config files for the written and removed hosts together
+// with the new data file, validates the result with nginx -t and only then
+// swaps next in as the live host set. Callers must hold m.mu.
+func (m *StaticHostManager) commit(ctx context.Context, action string, next map[string]*StaticHost, written, removed []*StaticHost) error {
+	tx := NewTransaction(m.ctrl)
+	if err := m.stageCommit(tx, next, written, removed); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	m.swap(ctx, action, next, written, removed)
+	return nil
+}
+
+// stageCommit stages the config files and data file of a commit into tx.
+// Callers must hold m.mu.
+func (m *StaticHostManager) stageCommit(tx *Transaction, next map[string]*StaticHost, written, removed []*StaticHost) error {
+	for _, h := range removed {
+		m.stageRemove(tx, h)
+	}
+	for _, h := range written {
+		if err := m.stageHost(tx, h); err != nil {
+			return err
+		}
+	}
+
+	list := make([]*StaticHost, 0, len(next))
+	for _, h := range next {
+		list = append(list, h)
+	}
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	tx.WriteState(m.dataFile, data)
+	return nil
+}
+
+// swap makes a committed host set live and records it. Callers must hold m.mu.
+func (m *StaticHostManager) swap(ctx context.Context, action string, next map[string]*StaticHost, written, removed []*StaticHost) {
+	prev := m.hosts
+	m.hosts = next
+	m.record(ctx, action, prev, next, written, removed)
+}
+
+// record writes one revision per host touched by a committed change
+func (m *StaticHostManager) record(ctx context.Context, action string, prev, next map[string]*StaticHost, written, removed []*StaticHost) {
+	if m.history == nil {
+		return
+	}
+
+	seen := make(map[string]bool)
+	for _, h := range append(append([]*StaticHost{}, removed...), written...) {
+		if seen[h.ID] {
+			continue
+		}
+		seen[h.ID] = true
+
+		rev := &Revision{
+			Entity:   EntityStaticHost,
+			EntityID: h.ID,
+			Action:   action,
+			Before:   marshalRevisionState(prev[h.ID]),
+			After:    marshalRevisionState(next[h.ID]),
+		}
+		if host, ok := next[h.ID]; ok {
+			if data, err := m.Render(host); err == nil {
+				rev.Rendered = string(data)
+			}
+		}
+		if err := m.history.Record(ctx, rev); err != nil {
+			log.Printf("warning: failed to record history for static site %s: %v", h.ID, err)
+		}
+	}
+}
+
+// List returns all static sites
+func (m *StaticHostManager) List() []*StaticHost {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	hosts := make([]*StaticHost, 0, len(m.hosts))
+	for _, h := range m.hosts {
+		hosts = append(hosts, h)
+	}
+	return hosts
+}
+
+// Get returns a static site by ID
+func (m *StaticHostManager) Get(id string) (*StaticHost, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	host, ok := m.hosts[id]
+	if !ok {
+		return nil, fmt.Errorf("static site not found: %s", id)
+	}
+	return host, nil
+}
+
+// Create adds a new static site. It serves nothing until a release is
+// published.
+func (m *StaticHostManager) Create(ctx context.Context, host *StaticHost) error {
+	if err := validateStaticHost(host); err != nil {
+		return err
+	}
+	if err := checkPeerNames(host.ServerNames(), m.peers); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkNameConflicts(host, ""); err != nil {
+		return err
+	}
+
+	host.ID = uuid.New().String()
+	host.Version = ""
+	host.PreviousVersion = ""
+	host.PublishedAt = nil
+	host.CreatedAt = time.Now()
+	host.UpdatedAt = time.Now()
+
+	next := m.cloneHosts()
+	next[host.ID] = host
+
+	return m.commit(ctx, "create", next, []*StaticHost{host}, nil)
+}
+
+// Update replaces the settings of a static site. Releases are only changed
+// through Publish and Rollback.
+func (m *StaticHostManager) Update(ctx context.Context, id string, updates *StaticHost) error {
+	if err := checkPeerNames(updates.ServerNames(), m.peers); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current, ok := m.hosts[id]
+	if !ok {
+		return fmt.Errorf("static site not found: %s", id)
+	}
+
+	if err := m.checkNameConflicts(updates, id); err != nil {
+		return err
+	}
+
+	host := *updates
+	host.ID = id
+	host.Version = current.Version
+	host.PreviousVersion = current.PreviousVersion
+	host.PublishedAt = current.PublishedAt
+	host.CreatedAt = current.CreatedAt
+	host.UpdatedAt = time.Now()
+
+	if err := validateStaticHost(&host); err != nil {
+		return err
+	}
+
+	next := m.cloneHosts()
+	next[id] = &host
+
+	return m.commit(ctx, "update", next, []*StaticHost{&host}, nil)
+}
+
+// Delete removes a static site together with all of its releases
+func (m *StaticHostManager) Delete(ctx context.Context, id string) error {
+	// Hold the release lock so a publish cannot recreate the site directory
+	// after it is removed
+	lock := m.releaseLock(id)
+	lock.Lock()
+	defer lock.Unlock()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	host, ok := m.hosts[id]
+	if !ok {
+		return fmt.Errorf("static site not found: %s", id)
+	}
+
+	next := m.cloneHosts()
+	delete(next, id)
+
+	if err := m.commit(ctx, "delete", next, nil, []*StaticHost{host}); err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(m.siteDir(id)); err != nil {
+		log.Printf("warning: failed to remove files of static site %s: %v", id, err)
+	}
+	return nil
+}
+
+// Restore puts a static site back to a recorded state. A nil snapshot
+// deletes the site's config (its files are kept); otherwise the site is
+// recreated or replaced under its ID, serving the recorded release if it is
+// still on disk.
+func (m *StaticHostManager) Restore(ctx context.Context, id string, snapshot *StaticHost) error {
+	if snapshot != nil {
+		if err := checkPeerNames(snapshot.ServerNames(), m.peers); err != nil {
+			return err
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current, exists := m.hosts[id]
+	next := m.cloneHosts()
+
+	if snapshot == nil {
+		if !exists {
+			return nil
+		}
+		delete(next, id)
+		return m.commit(ctx, "restore", next, nil, []*StaticHost{current})
+	}
+
+	if err := m.checkNameConflicts(snapshot, id); err != nil {
+		return err
+	}
+
+	host := *snapshot
+	host.ID = id
+	host.UpdatedAt = time.Now()
+	if err := validateStaticHost(&host); err != nil {
+		return err
+	}
+	if host.Version != "" && !m.releaseExists(id, host.Version) {
+		return fmt.Errorf("release %s of static site %s no longer exists", host.Version, id)
+	}
+	if host.PreviousVersion != "" && !m.releaseExists(id, host.PreviousVersion) {
+		host.PreviousVersion = ""
+	}
+	next[id] = &host
+
+	return m.commit(ctx, "restore", next, []*StaticHost{&host}, nil)
+}
+
+// Toggle enables or disables a static site
+func (m *StaticHostManager) Toggle(ctx context.Context, id string, enabled bool) error {
+	return m.modify(ctx, id, "toggle", func(host *StaticHost) error {
+		host.Enabled = enabled
+		return nil
+	})
+}
+
+func (m *StaticHostManager) modify(ctx context.Context, id, action string, fn func(host *StaticHost) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current, ok := m.hosts[id]
+	if !ok {
+		return fmt.Errorf("static site not found: %s", id)
+	}
+
+	host := *current
+	if err := fn(&host); err != nil {
+		return err
+	}
+	host.UpdatedAt = time.Now()
+
+	if err := validateStaticHost(&host); err != nil {
+		return err
+	}
+
+	next := m.cloneHosts()
+	next[id] = &host
+
+	return m.commit(ctx, action, next, []*StaticHost{&host}, nil)
+}
+
+// Publish unpacks a tar.gz or zip archive into a new release and switches the
+// site to it. The release that was live becomes the rollback target; older
+// releases are deleted. nginx follows the "current" symlink per request, so
+// no reload is needed.
+func (m *StaticHostManager) Publish(ctx context.Context, id string, archive io.ReaderAt, size int64, filename string) (*StaticHost, error) {
+	format, err := archiveFormat(filename)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := m.Get(id); err != nil {
+		return nil, err
+	}
+
+	releasesDir := filepath.Join(m.siteDir(id), "releases")
+	if err := os.MkdirAll(releasesDir, 0755); err != nil {
+		return nil, err
+	}
+
+	// Unpack next to the final location so the rename below is atomic
+	uploadDir, err := os.MkdirTemp(releasesDir, ".upload-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(uploadDir)
+
+	if err := extractArchive(archive, size, format, uploadDir); err != nil {
+		return nil, err
+	}
+
+	contentDir, err := siteContentRoot(uploadDir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(contentDir, 0755); err != nil {
+		return nil, err
+	}
+
+	// From the rename until the prune no other publish or rollback of the
+	// site may run, or its prune could delete this release before it is live
+	lock := m.releaseLock(id)
+	lock.Lock()
+	defer lock.Unlock()
+
+	// The site may have been deleted while the archive was unpacked
+	if _, err := m.Get(id); err != nil {
+		os.RemoveAll(m.siteDir(id))
+		return nil, err
+	}
+
+	version := time.Now().UTC().Format("20060102T150405Z") + "-" + uuid.New().String()[:8]
+	release := m.releaseDir(id, version)
+	if err := os.Rename(contentDir, release); err != nil {
+		return nil, fmt.Errorf("failed to publish release: %w", err)
+	}
+
+	now := time.Now()
+	err = m.modify(ctx, id, "publish", func(host *StaticHost) error {
+		host.PreviousVersion = host.Version
+		host.Version = version
+		host.PublishedAt = &now
+		return nil
+	})
+	if err != nil {
+		os.RemoveAll(release)
+		return nil, err
+	}
+
+	host, err := m.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	m.pruneReleases(host)
+	return host, nil
+}
+
+// Rollback switches a site back to its previous release. The release being
+// replaced becomes the new rollback target, so a second rollback undoes the
+// first.
+func (m *StaticHostManager) Rollback(ctx context.Context, id string) (*StaticHost, error) {
+	lock := m.releaseLock(id)
+	lock.Lock()
+	defer lock.Unlock()
+
+	err := m.modify(ctx, id, "rollback", func(host *StaticHost) error {
+		if host.PreviousVersion == "" {
+			return fmt.Errorf("static site %s has no previous release", id)
+		}
+		if !m.releaseExists(id, host.PreviousVersion) {
+			return fmt.Errorf("release %s of static site %s no longer exists", host.PreviousVersion, id)
+		}
+		now := time.Now()
+		host.Version, host.PreviousVersion = host.PreviousVersion, host.Version
+		host.PublishedAt = &now
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m.Get(id)
+}
+
+// releaseLock returns the lock serialising release changes of a site
+func (m *StaticHostManager) releaseLock(id string) *sync.Mutex {
+	m.releaseMu.Lock()
+	defer m.releaseMu.Unlock()
+
+	lock, ok := m.releases[id]
+	if !ok {
+		lock = &sync.Mutex{}
+		m.releases[id] = lock
+	}
+	return lock
+}
+
+// releaseExists reports whether a release directory is on disk
+func (m *StaticHostManager) releaseExists(id, version string) bool {
+	info, err := os.Stat(m.releaseDir(id, version))
+	return err == nil && info.IsDir()
+}
+
+// pruneReleases deletes every release except the live and previous ones
+func (m *StaticHostManager) pruneReleases(host *StaticHost) {
+	releasesDir := filepath.Join(m.siteDir(host.ID), "releases")
+	entries, err := os.ReadDir(releasesDir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if name == host.Version || name == host.PreviousVersion || strings.HasPrefix(name, ".upload-") {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(releasesDir, name)); err != nil {
+			log.Printf("warning: failed to remove old release %s of static site %s: %v", name, host.ID, err)
+		}
+	}
+}
+
+// Render returns the nginx configuration generated for a static site
+func (m *StaticHostManager) Render(host *StaticHost) ([]byte, error) {
+	var buf bytes.Buffer
+	data := staticTemplateData{StaticHost: host, Root: m.currentLink(host.ID)}
+	if err := m.tmpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("failed to render config: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// stageHost renders the site config and stages it, together with the
+// sites-enabled symlink and the "current" release symlink
+func (m *StaticHostManager) stageHost(tx *Transaction, host *StaticHost) error {
+	data, err := m.Render(host)
+	if err != nil {
+		return err
+	}
+
+	configPath := m.configPath(host.ID)
+	symlinkPath := m.symlinkPath(host.ID)
+
+	tx.WriteFile(configPath, data, 0644)
+	if host.Enabled {
+		tx.Symlink(configPath, symlinkPath)
+	} else {
+		tx.Remove(symlinkPath)
+	}
+
+	if host.Version != "" {
+		tx.Symlink(m.releaseDir(host.ID, host.Version), m.currentLink(host.ID))
+	} else {
+		tx.Remove(m.currentLink(host.ID))
+	}
+	return nil
+}
+
+// stageRemove stages the removal of a site's config files
+func (m *StaticHostManager) stageRemove(tx *Transaction, host *StaticHost) {
+	tx.Remove(m.symlinkPath(host.ID))
+	tx.Remove(m.configPath(host.ID))
+}
+
+// stageCertificateRefresh stages the re-render of every static site bound to
+// cert into tx. m.mu stays locked until the returned refresh is finished.
+func (m *StaticHostManager) stageCertificateRefresh(tx *Transaction, cert *Certificate) (*certificateRefresh, error) {
+	m.mu.Lock()
+
+	next := m.cloneHosts()
+	refresh := &certificateRefresh{unlock: m.mu.Unlock}
+	var written []*StaticHost
+	for id, current := range m.hosts {
+		if current.CertificateID != cert.ID {
+			continue
+		}
+		host := *current
+		host.CertPath = cert.CertPath
+		host.KeyPath = cert.KeyPath
+		host.ChainPath = cert.ChainPath
+		host.UpdatedAt = time.Now()
+		next[id] = &host
+		written = append(written, &host)
+		refresh.results = append(refresh.results, HostBindResult{HostID: id, Domain: host.Domain})
+	}
+
+	if len(written) == 0 {
+		return refresh, nil
+	}
+	if err := m.stageCommit(tx, next, written, nil); err != nil {
+		m.mu.Unlock()
+		return nil, err
+	}
+	refresh.swap = func(ctx context.Context) {
+		m.swap(ctx, "certificate_refresh", next, written, nil)
+	}
+	return refresh, nil
+}
+
+// HostsUsingCertificate returns the IDs of static sites bound to a certificate
+func (m *StaticHostManager) HostsUsingCertificate(certID string) []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var ids []string
+	for _, h := range m.hosts {
+		if h.CertificateID == certID {
+			ids = append(ids, h.ID)
+		}
+	}
+	return ids
+}
+
+// listenPorts returns the ports the enabled static sites listen on
+func (m *StaticHostManager) listenPorts() []int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var ports []int
+	for _, h := range m.hosts {
+		if !h.Enabled {
+			continue
+		}
+		if data, err := m.Render(h); err == nil {
+			ports = append(ports, parseListenPorts(data)...)
+		}
+	}
+	return ports
+}
+
+// configPath returns the path to the nginx config file
+func (m *StaticHostManager) configPath(id string) string {
+	return filepath.Join(m.configDir, "nubi-static-"+id+".conf")
+}
+
+// symlinkPath returns the path to the symlink in sites-enabled
+func (m *StaticHostManager) symlinkPath(id string) string {
+	return filepath.Join(m.enabledDir, "nubi-static-"+id+".conf")
+}
+
+// claimedNames returns the server names used by static sites
+func (m *StaticHostManager) claimedNames() map[string]string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	names := make(map[string]string)
+	for _, h := range m.hosts {
+		for _, name := range h.ServerNames() {
+			names[strings.ToLower(name)] = "static site " + h.Domain
+		}
+	}
+	return names
+}
+
+// addPeer registers another host kind whose names must not clash
+func (m *StaticHostManager) addPeer(peer nameClaimer) {
+	m.peers = append(m.peers, peer)
+}
+
+// checkNameConflicts returns an error if any server name of host is already
+// used by another static site. Callers must hold m.mu.
+func (m *StaticHostManager) checkNameConflicts(host *StaticHost, excludeID string) error {
+	used := make(map[string]bool)
+	for _, h := range m.hosts {
+		if h.ID == excludeID {
+			continue
+		}
+		for _, name := range h.ServerNames() {
+			used[strings.ToLower(name)] = true
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, name := range host.ServerNames() {
+		key := strings.ToLower(name)
+		if used[key] {
+			return fmt.Errorf("domain already exists: %s", name)
+		}
+		if seen[key] {
+			return fmt.Errorf("duplicate server name: %s", name)
+		}
+		seen[key] = true
+	}
+
+	return nil
+}
+
+// extensionRegex matches a file extension without the leading dot
+var extensionRegex = regexp.MustCompile(`^[A-Za-z0-9]+$`)
+
+// validateStaticHost checks a static site before it is rendered
+func validateStaticHost(host *StaticHost) error {
+	if err := validateDomain(host.Domain); err != nil {
+		return err
+	}
+	for _, alias := range host.Aliases {
+		if err := validateServerName(alias); err != nil {
+			return err
+		}
+	}
+
+	if host.SPA && host.Autoindex {
+		return fmt.Errorf("directory listing cannot be combined with SPA fallback")
+	}
+
+	for i := range host.CacheRules {
+		rule := &host.CacheRules[i]
+		if len(rule.Extensions) == 0 {
+			return fmt.Errorf("cache rule needs at least one extension")
+		}
+		for j, ext := range rule.Extensions {
+			ext = strings.TrimPrefix(ext, ".")
+			if !extensionRegex.MatchString(ext) {
+				return fmt.Errorf("invalid file extension: %s", rule.Extensions[j])
+			}
+			rule.Extensions[j] = ext
+		}
+		if rule.CacheControl == "" || strings.ContainsAny(rule.CacheControl, "\"\r\n;{}") {
+			return fmt.Errorf("invalid Cache-Control value: %q", rule.CacheControl)
+		}
+	}
+
+	if !host.SSL {
+		if host.ForceSSL {
+			return fmt.Errorf("forceSSL requires ssl to be enabled")
+		}
+		return nil
+	}
+	if host.CertPath == "" || host.KeyPath == "" {
+		return fmt.Errorf("ssl requires a certificate to be bound to the host")
+	}
+	return host.TLSSettings().Validate()
+}
@@ -0,0 +1,680 @@
+package nginx
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Stream protocols
+const (
+	StreamTCP = "tcp"
+	StreamUDP = "udp"
+)
+
+// httpListener is a manager of HTTP server blocks; streams cannot take the
+// TCP ports its blocks listen on
+type httpListener interface {
+	listenPorts() []int
+}
+
+var listenRegex = regexp.MustCompile(`(?m)^\s*listen\s+([^\s;]+)`)
+
+// parseListenPorts returns the ports of the listen directives in a rendered
+// config. Addresses without a port listen on 80; unix sockets are skipped.
+func parseListenPorts(config []byte) []int {
+	var ports []int
+	for _, match := range listenRegex.FindAllSubmatch(config, -1) {
+		addr := string(match[1])
+		if strings.HasPrefix(addr, "unix:") {
+			continue
+		}
+		port := addr
+		switch {
+		case strings.HasPrefix(addr, "["):
+			port = ""
+			if i := strings.LastIndex(addr, "]:"); i >= 0 {
+				port = addr[i+2:]
+			}
+		case strings.Contains(addr, ":"):
+			port = addr[strings.LastIndex(addr, ":")+1:]
+		}
+		n, err := strconv.Atoi(port)
+		if err != nil {
+			n = 80
+		}
+		ports = append(ports, n)
+	}
+	return ports
+}
+
+// StreamHost represents a TCP or UDP proxy handled by the nginx stream module
+type StreamHost struct {
+	ID                  string     `json:"id"`
+	Name                string     `json:"name"`                // Display name, e.g., "postgres"
+	ListenPort          int        `json:"listenPort"`          // Port nginx listens on
+	Protocol            string     `json:"protocol"`            // tcp or udp
+	Backends            []Backend  `json:"backends"`            // Backend servers, e.g., "10.0.0.5:5432"
+	LBMethod            string     `json:"lbMethod"`            // round_robin, least_conn, hash or random
+	SSL                 bool       `json:"ssl"`                 // Terminate TLS (tcp only)
+	CertificateID       string     `json:"certificateId"`       // ID of the certificate to use
+	CertPath            string     `json:"certPath"`            // Path to SSL certificate
+	KeyPath             string     `json:"keyPath"`             // Path to SSL private key
+	TLS                 *TLSPolicy `json:"tls,omitempty"`       // TLS policy (defaults to the intermediate preset)
+	ProxyProtocol       bool       `json:"proxyProtocol"`       // Send the PROXY protocol header to backends
+	AcceptProxyProtocol bool       `json:"acceptProxyProtocol"` // Expect the PROXY protocol header from clients
+	ConnectTimeout      string     `json:"connectTimeout"`      // e.g., "5s" (nginx default when empty)
+	Timeout             string     `json:"timeout"`             // Idle timeout, e.g., "10m" (nginx default when empty)
+	Enabled             bool       `json:"enabled"`             // Whether this stream is active
+	CreatedAt           time.Time  `json:"createdAt"`
+	UpdatedAt           time.Time  `json:"updatedAt"`
+}
+
+// UpstreamName returns the nginx upstream name for this stream
+func (h *StreamHost) UpstreamName() string {
+	id := h.ID
+	if len(id) > 8 {
+		id = id[:8]
+	}
+	return "nubi_stream_" + strings.ReplaceAll(id, "-", "_")
+}
+
+// IsUDP reports whether the stream listens on UDP
+func (h *StreamHost) IsUDP() bool {
+	return h.Protocol == StreamUDP
+}
+
+// TLSSettings returns the effective TLS policy for the stream
+func (h *StreamHost) TLSSettings() *TLSPolicy {
+	if h.TLS == nil {
+		policy := defaultTLSPolicy
+		return &policy
+	}
+	return h.TLS
+}
+
+// StreamHostManager handles CRUD operations for stream hosts. All streams
+// are rendered into a single Nubi-owned stream {} block that nginx includes
+// from the main context.
+type StreamHostManager struct {
+	mu         sync.RWMutex
+	hosts      map[string]*StreamHost
+	ctrl       *Controller   // used to test staged config before it goes live
+	history    *HistoryStore // optional revision log
+	configPath string        // e.g., /etc/nginx/modules-enabled/99-nubi-stream.conf
+	dataFile   string        // e.g., /var/lib/nubi/stream_hosts.json
+	tmpl       *template.Template
+
+	httpListeners []httpListener // HTTP server blocks whose ports streams cannot take
+}
+
+const streamTemplate = `# Nubi managed stream hosts
+# Do not edit manually - changes will be overwritten
+
+stream {
+{{- range . }}
+
+    # {{ .Name }} ({{ .Protocol }}/{{ .ListenPort }})
+    # Stream ID: {{ .ID }}
+    upstream {{ .UpstreamName }} {
+{{- if eq .LBMethod "least_conn" }}
+        least_conn;
+{{- else if eq .LBMethod "hash" }}
+        hash $remote_addr consistent;
+{{- else if eq .LBMethod "random" }}
+        random two least_conn;
+{{- end }}
+{{- range .Backends }}
+        server {{ .Address }}{{ if gt .Weight 1 }} weight={{ .Weight }}{{ end }}{{ if .Backup }} backup{{ end }};
+{{- end }}
+    }
+
+    server {
+        listen {{ .ListenPort }}{{ if .IsUDP }} udp{{ end }}{{ if .SSL }} ssl{{ end }}{{ if .AcceptProxyProtocol }} proxy_protocol{{ end }};
+{{- if .SSL }}
+        ssl_certificate {{ .CertPath }};
+        ssl_certificate_key {{ .KeyPath }};
+{{- with .TLSSettings }}
+        ssl_protocols {{ .Protocols }};
+{{- if .Ciphers }}
+        ssl_ciphers {{ .Ciphers }};
+{{- end }}
+        ssl_prefer_server_ciphers {{ if .PreferServerCiphers }}on{{ else }}off{{ end }};
+        ssl_session_cache shared:nubi_stream_ssl:10m;
+        ssl_session_timeout {{ .Timeout }};
+        ssl_session_tickets {{ if .SessionTickets }}on{{ else }}off{{ end }};
+{{- end }}
+{{- end }}
+{{- if .ConnectTimeout }}
+        proxy_connect_timeout {{ .ConnectTimeout }};
+{{- end }}
+{{- if .Timeout }}
+        proxy_timeout {{ .Timeout }};
+{{- end }}
+{{- if .ProxyProtocol }}
+        proxy_protocol on;
+{{- end }}
+        proxy_pass {{ .UpstreamName }};
+    }
+{{- end }}
+}
+`
+
+// NewStreamHostManager creates a new stream host manager. The config file
+// must be included from the main nginx context; Debian-style installs load
+// modules-enabled/*.conf there.
+func NewStreamHostManager(ctrl *Controller, configPath, dataFile string) (*StreamHostManager, error) {
+	if configPath == "" {
+		configPath = "/etc/nginx/modules-enabled/99-nubi-stream.conf"
+	}
+	if dataFile == "" {
+		dataFile = "/var/lib/nubi/stream_hosts.json"
+	}
+
+	tmpl, err := template.New("stream").Parse(streamTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse stream template: %w", err)
+	}
+
+	mgr := &StreamHostManager{
+		hosts:      make(map[string]*StreamHost),
+		ctrl:       ctrl,
+		configPath: configPath,
+		dataFile:   dataFile,
+		tmpl:       tmpl,
+	}
+
+	if err := mgr.load(); err != nil {
+		// Not a fatal error - might be first run
+		fmt.Printf("Note: Could not load existing stream hosts: %v\n", err)
+	}
+
+	return mgr, nil
+}
+
+// load reads stream hosts from the JSON data file
+func (m *StreamHostManager) load() error {
+	data, err := os.ReadFile(m.dataFile)
+	if err != nil {
+		return err
+	}
+
+	var hosts []*StreamHost
+	if err := json.Unmarshal(data, &hosts); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.hosts = make(map[string]*StreamHost)
+	for _, h := range hosts {
+		m.hosts[h.ID] = h
+	}
+
+	return nil
+}
+
+// SetHistory attaches a revision log; committed changes are recorded to it
+func (m *StreamHostManager) SetHistory(history *HistoryStore) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.history = history
+}
+
+func (m *StreamHostManager) cloneHosts() map[string]*StreamHost {
+	next := make(map[string]*StreamHost, len(m.hosts))
+	for id, h := range m.hosts {
+		next[id] = h
+	}
+	return next
+}
+
+// sortedHosts returns the hosts ordered by port, then protocol
+func sortedHosts(hosts map[string]*StreamHost) []*StreamHost {
+	list := make([]*StreamHost, 0, len(hosts))
+	for _, h := range hosts {
+		list = append(list, h)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].ListenPort != list[j].ListenPort {
+			return list[i].ListenPort < list[j].ListenPort
+		}
+		return list[i].Protocol < list[j].Protocol
+	})
+	return list
+}
+
+// Render returns the stream {} config for the enabled hosts in hosts, or nil
+// when none are enabled
+func (m *StreamHostManager) Render(hosts map[string]*StreamHost) ([]byte, error) {
+	var enabled []*StreamHost
+	for _, h := range sortedHosts(hosts) {
+		if h.Enabled {
+			enabled = append(enabled, h)
+		}
+	}
+	if len(enabled) == 0 {
+		return nil, nil
+	}
+
+	var buf bytes.Buffer
+	if err := m.tmpl.Execute(&buf, enabled); err != nil {
+		return nil, fmt.Errorf("failed to render stream config: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// commit renders next into the stream include, tests it and swaps it in as
+// the live host set. The include is removed when no stream is enabled so
+// nginx builds without the stream module keep working. Callers must hold m.mu.
+func (m *StreamHostManager) commit(ctx context.Context, action string, next map[string]*StreamHost, changed ...string) error {
+	tx := NewTransaction(m.ctrl)
+	config, err := m.stageCommit(tx, next)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	m.swap(ctx, action, next, config, changed...)
+	return nil
+}
+
+// stageCommit stages the stream include and data file for next into tx and
+// returns the rendered include. Callers must hold m.mu.
+func (m *StreamHostManager) stageCommit(tx *Transaction, next map[string]*StreamHost) ([]byte, error) {
+	config, err := m.Render(next)
+	if err != nil {
+		return nil, err
+	}
+
+	if config == nil {
+		tx.Remove(m.configPath)
+	} else {
+		tx.WriteFile(m.configPath, config, 0644)
+	}
+
+	list := sortedHosts(next)
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	tx.WriteState(m.dataFile, data)
+	return config, nil
+}
+
+// swap makes a committed host set live and records the changed streams.
+// Callers must hold m.mu.
+func (m *StreamHostManager) swap(ctx context.Context, action string, next map[string]*StreamHost, config []byte, changed ...string) {
+	prev := m.hosts
+	m.hosts = next
+
+	if m.history == nil {
+		return
+	}
+	for _, id := range changed {
+		rev := &Revision{
+			Entity:   EntityStreamHost,
+			EntityID: id,
+			Action:   action,
+			Before:   marshalRevisionState(prev[id]),
+			After:    marshalRevisionState(next[id]),
+			Rendered: string(config),
+		}
+		if err := m.history.Record(ctx, rev); err != nil {
+			log.Printf("warning: failed to record history for stream %s: %v", id, err)
+		}
+	}
+}
+
+// List returns all stream hosts
+func (m *StreamHostManager) List() []*StreamHost {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return sortedHosts(m.hosts)
+}
+
+// Get returns a stream host by ID
+func (m *StreamHostManager) Get(id string) (*StreamHost, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	host, ok := m.hosts[id]
+	if !ok {
+		return nil, fmt.Errorf("stream host not found: %s", id)
+	}
+	return host, nil
+}
+
+// Create adds a new stream host
+func (m *StreamHostManager) Create(ctx context.Context, host *StreamHost) error {
+	normalizeStreamHost(host)
+	if err := validateStreamHost(host); err != nil {
+		return err
+	}
+	httpPorts := m.httpPorts()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkPortConflicts(host, "", httpPorts); err != nil {
+		return err
+	}
+
+	host.ID = uuid.New().String()
+	host.CreatedAt = time.Now()
+	host.UpdatedAt = time.Now()
+
+	next := m.cloneHosts()
+	next[host.ID] = host
+
+	return m.commit(ctx, "create", next, host.ID)
+}
+
+// Update modifies an existing stream host
+func (m *StreamHostManager) Update(ctx context.Context, id string, updates *StreamHost) error {
+	httpPorts := m.httpPorts()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current, ok := m.hosts[id]
+	if !ok {
+		return fmt.Errorf("stream host not found: %s", id)
+	}
+
+	host := *updates
+	host.ID = id
+	host.CreatedAt = current.CreatedAt
+	host.UpdatedAt = time.Now()
+
+	normalizeStreamHost(&host)
+	if err := validateStreamHost(&host); err != nil {
+		return err
+	}
+	if err := m.checkPortConflicts(&host, id, httpPorts); err != nil {
+		return err
+	}
+
+	next := m.cloneHosts()
+	next[id] = &host
+
+	return m.commit(ctx, "update", next, id)
+}
+
+// Delete removes a stream host
+func (m *StreamHostManager) Delete(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.hosts[id]; !ok {
+		return fmt.Errorf("stream host not found: %s", id)
+	}
+
+	next := m.cloneHosts()
+	delete(next, id)
+
+	return m.commit(ctx, "delete", next, id)
+}
+
+// Toggle enables or disables a stream host
+func (m *StreamHostManager) Toggle(ctx context.Context, id string, enabled bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current, ok := m.hosts[id]
+	if !ok {
+		return fmt.Errorf("stream host not found: %s", id)
+	}
+
+	host := *current
+	host.Enabled = enabled
+	host.UpdatedAt = time.Now()
+
+	next := m.cloneHosts()
+	next[id] = &host
+
+	return m.commit(ctx, "toggle", next, id)
+}
+
+// Restore puts a stream host back to a recorded snapshot. A nil snapshot
+// means the stream did not exist and is removed.
+func (m *StreamHostManager) Restore(ctx context.Context, id string, snapshot *StreamHost) error {
+	httpPorts := m.httpPorts()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, exists := m.hosts[id]
+	next := m.cloneHosts()
+
+	if snapshot == nil {
+		if !exists {
+			return nil
+		}
+		delete(next, id)
+		return m.commit(ctx, "restore", next, id)
+	}
+
+	host := *snapshot
+	host.ID = id
+	host.UpdatedAt = time.Now()
+	normalizeStreamHost(&host)
+	if err := validateStreamHost(&host); err != nil {
+		return err
+	}
+	if err := m.checkPortConflicts(&host, id, httpPorts); err != nil {
+		return err
+	}
+	next[id] = &host
+
+	return m.commit(ctx, "restore", next, id)
+}
+
+// stageCertificateRefresh stages the re-render of every stream bound to cert
+// into tx, picking up changed file paths. m.mu stays locked until the
+// returned refresh is finished.
+func (m *StreamHostManager) stageCertificateRefresh(tx *Transaction, cert *Certificate) (*certificateRefresh, error) {
+	m.mu.Lock()
+
+	next := m.cloneHosts()
+	refresh := &certificateRefresh{unlock: m.mu.Unlock}
+	var changed []string
+	for id, current := range m.hosts {
+		if current.CertificateID != cert.ID {
+			continue
+		}
+		host := *current
+		host.CertPath = cert.CertPath
+		host.KeyPath = cert.KeyPath
+		host.UpdatedAt = time.Now()
+		next[id] = &host
+		changed = append(changed, id)
+		refresh.results = append(refresh.results, HostBindResult{HostID: id, Domain: host.Name})
+	}
+
+	if len(changed) == 0 {
+		return refresh, nil
+	}
+	config, err := m.stageCommit(tx, next)
+	if err != nil {
+		m.mu.Unlock()
+		return nil, err
+	}
+	refresh.swap = func(ctx context.Context) {
+		m.swap(ctx, "certificate_refresh", next, config, changed...)
+	}
+	return refresh, nil
+}
+
+// HostsUsingCertificate returns the IDs of streams bound to a certificate
+func (m *StreamHostManager) HostsUsingCertificate(certID string) []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var ids []string
+	for _, h := range m.hosts {
+		if h.CertificateID == certID {
+			ids = append(ids, h.ID)
+		}
+	}
+	return ids
+}
+
+// SetHTTPListeners makes the port checks include the TCP ports the server
+// blocks of the given managers listen on
+func (m *StreamHostManager) SetHTTPListeners(listeners ...httpListener) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.httpListeners = append(m.httpListeners, listeners...)
+}
+
+// httpPorts returns the TCP ports nginx's HTTP server blocks listen on. It
+// takes the listeners' locks, so callers must not hold m.mu.
+func (m *StreamHostManager) httpPorts() map[int]bool {
+	m.mu.RLock()
+	listeners := m.httpListeners
+	m.mu.RUnlock()
+
+	ports := make(map[int]bool)
+	for _, l := range listeners {
+		for _, port := range l.listenPorts() {
+			ports[port] = true
+		}
+	}
+	return ports
+}
+
+// checkPortConflicts returns an error if host's port is taken by another
+// stream on the same protocol or by one of httpPorts. excludeID skips the
+// stream being updated. Callers must hold m.mu.
+func (m *StreamHostManager) checkPortConflicts(host *StreamHost, excludeID string, httpPorts map[int]bool) error {
+	if host.Protocol == StreamTCP && httpPorts[host.ListenPort] {
+		return fmt.Errorf("port %d/tcp is used by nginx HTTP listeners", host.ListenPort)
+	}
+
+	for _, h := range m.hosts {
+		if h.ID == excludeID {
+			continue
+		}
+		if h.ListenPort == host.ListenPort && h.Protocol == host.Protocol {
+			return fmt.Errorf("port %d/%s is already used by stream %s", host.ListenPort, host.Protocol, h.Name)
+		}
+	}
+
+	return nil
+}
+
+// normalizeStreamHost fills in defaults
+func normalizeStreamHost(host *StreamHost) {
+	if host.Protocol == "" {
+		host.Protocol = StreamTCP
+	}
+	if host.LBMethod == "" {
+		host.LBMethod = "round_robin"
+	}
+	for i := range host.Backends {
+		if host.Backends[i].Weight == 0 {
+			host.Backends[i].Weight = 1
+		}
+	}
+	if !host.SSL {
+		host.CertificateID = ""
+		host.CertPath = ""
+		host.KeyPath = ""
+	}
+}
+
+// validateStreamHost checks a stream host before it is rendered
+func validateStreamHost(host *StreamHost) error {
+	if strings.TrimSpace(host.Name) == "" {
+		return fmt.Errorf("name is required")
+	}
+	if strings.ContainsAny(host.Name, "\r\n") {
+		return fmt.Errorf("name must be a single line")
+	}
+
+	if host.ListenPort < 1 || host.ListenPort > 65535 {
+		return fmt.Errorf("invalid listen port: %d", host.ListenPort)
+	}
+
+	if host.Protocol != StreamTCP && host.Protocol != StreamUDP {
+		return fmt.Errorf("invalid protocol: %s (expected tcp or udp)", host.Protocol)
+	}
+
+	switch host.LBMethod {
+	case "round_robin", "least_conn", "hash", "random":
+	default:
+		return fmt.Errorf("invalid load balancing method: %s", host.LBMethod)
+	}
+
+	if len(host.Backends) == 0 {
+		return fmt.Errorf("at least one backend is required")
+	}
+	for _, b := range host.Backends {
+		if err := validateStreamBackend(b.Address); err != nil {
+			return err
+		}
+		if b.Weight < 1 || b.Weight > 100 {
+			return fmt.Errorf("backend %s: weight must be between 1 and 100", b.Address)
+		}
+		if b.Backup && (host.LBMethod == "hash" || host.LBMethod == "random") {
+			return fmt.Errorf("backend %s: backup servers cannot be used with %s balancing", b.Address, host.LBMethod)
+		}
+	}
+
+	if host.IsUDP() {
+		if host.SSL {
+			return fmt.Errorf("TLS termination is only supported for tcp streams")
+		}
+		if host.ProxyProtocol || host.AcceptProxyProtocol {
+			return fmt.Errorf("proxy_protocol is only supported for tcp streams")
+		}
+	}
+
+	for _, t := range []string{host.ConnectTimeout, host.Timeout} {
+		if t != "" && !nginxTimeRegex.MatchString(t) {
+			return fmt.Errorf("invalid timeout: %s", t)
+		}
+	}
+
+	if host.SSL {
+		if host.CertPath == "" || host.KeyPath == "" {
+			return fmt.Errorf("ssl requires a certificate to be bound to the stream")
+		}
+		policy := host.TLSSettings()
+		if policy.HSTS.Enabled || policy.OCSPStapling {
+			return fmt.Errorf("HSTS and OCSP stapling are not available for stream hosts")
+		}
+		return policy.Validate()
+	}
+
+	return nil
+}
+
+// validateStreamBackend checks a backend is a host:port pair
+func validateStreamBackend(address string) error {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || host == "" || port == "" {
+		return fmt.Errorf("invalid backend address: %s (expected host:port)", address)
+	}
+	if unsafePathChars.MatchString(address) {
+		return fmt.Errorf("backend address contains invalid characters: %s", address)
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+package nginx
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Cipher presets follow the Mozilla server side TLS recommendations
+const (
+	CipherPresetModern       = "modern"
+	CipherPresetIntermediate = "intermediate"
+	CipherPresetOld          = "old"
+)
+
+// tlsProtocols lists the protocol versions nginx understands, oldest first
+var tlsProtocols = []string{"TLSv1", "TLSv1.1", "TLSv1.2", "TLSv1.3"}
+
+var cipherPresets = map[string]struct {
+	minProtocol         string
+	ciphers             string
+	preferServerCiphers bool
+}{
+	CipherPresetModern: {
+		minProtocol: "TLSv1.3",
+	},
+	CipherPresetIntermediate: {
+		minProtocol: "TLSv1.2",
+		ciphers:     "ECDHE-ECDSA-AES128-GCM-SHA256:ECDHE-RSA-AES128-GCM-SHA256:ECDHE-ECDSA-AES256-GCM-SHA384:ECDHE-RSA-AES256-GCM-SHA384:ECDHE-ECDSA-CHACHA20-POLY1305:ECDHE-RSA-CHACHA20-POLY1305:DHE-RSA-AES128-GCM-SHA256:DHE-RSA-AES256-GCM-SHA384:DHE-RSA-CHACHA20-POLY1305",
+	},
+	CipherPresetOld: {
+		minProtocol:         "TLSv1",
+		ciphers:             "ECDHE-ECDSA-AES128-GCM-SHA256:ECDHE-RSA-AES128-GCM-SHA256:ECDHE-ECDSA-AES256-GCM-SHA384:ECDHE-RSA-AES256-GCM-SHA384:ECDHE-ECDSA-CHACHA20-POLY1305:ECDHE-RSA-CHACHA20-POLY1305:DHE-RSA-AES128-GCM-SHA256:DHE-RSA-AES256-GCM-SHA384:DHE-RSA-CHACHA20-POLY1305:ECDHE-ECDSA-AES128-SHA256:ECDHE-RSA-AES128-SHA256:ECDHE-ECDSA-AES128-SHA:ECDHE-RSA-AES128-SHA:ECDHE-ECDSA-AES256-SHA384:ECDHE-RSA-AES256-SHA384:ECDHE-ECDSA-AES256-SHA:ECDHE-RSA-AES256-SHA:DHE-RSA-AES128-SHA256:DHE-RSA-AES256-SHA256:AES128-GCM-SHA256:AES256-GCM-SHA384:AES128-SHA256:AES256-SHA256:AES128-SHA:AES256-SHA:DES-CBC3-SHA",
+		preferServerCiphers: true,
+	},
+}
+
+// tlsTemplate renders the SSL directives of a server block. Its argument is
+// any host with CertPath, KeyPath, ChainPath and TLSSettings.
+const tlsTemplate = `{{ define "tls" }}
+    # SSL Configuration
+    ssl_certificate {{ .CertPath }};
+    ssl_certificate_key {{ .KeyPath }};
+{{- if .ChainPath }}
+    ssl_trusted_certificate {{ .ChainPath }};
+{{- end }}
+{{- with .TLSSettings }}
+    ssl_protocols {{ .Protocols }};
+{{- if .Ciphers }}
+    ssl_ciphers {{ .Ciphers }};
+{{- end }}
+    ssl_prefer_server_ciphers {{ if .PreferServerCiphers }}on{{ else }}off{{ end }};
+    ssl_session_cache shared:nubi_ssl:10m;
+    ssl_session_timeout {{ .Timeout }};
+    ssl_session_tickets {{ if .SessionTickets }}on{{ else }}off{{ end }};
+{{- if .OCSPStapling }}
+    ssl_stapling on;
+    ssl_stapling_verify on;
+{{- end }}
+{{- if .HSTS.Enabled }}
+    add_header Strict-Transport-Security "{{ .HSTS.Value }}" always;
+{{- end }}
+{{- end }}
+{{- end }}`
+
+// HSTSPolicy configures the Strict-Transport-Security header
+type HSTSPolicy struct {
+	Enabled           bool `json:"enabled"`
+	MaxAge            int  `json:"maxAge"` // Seconds, defaults to one year
+	IncludeSubDomains bool `json:"includeSubDomains"`
+	Preload           bool `json:"preload"`
+}
+
+// Value returns the header value for this policy
+func (p HSTSPolicy) Value() string {
+	maxAge := p.MaxAge
+	if maxAge == 0 {
+		maxAge = 31536000
+	}
+
+	value := fmt.Sprintf("max-age=%d", maxAge)
+	if p.IncludeSubDomains {
+		value += "; includeSubDomains"
+	}
+	if p.Preload {
+		value += "; preload"
+	}
+	return value
+}
+
+// TLSPolicy holds per-host TLS settings. A nil policy on a host means the
+// intermediate preset with nginx defaults for everything else.
+type TLSPolicy struct {
+	MinProtocol    string     `json:"minProtocol"`    // TLSv1, TLSv1.1, TLSv1.2 or TLSv1.3 (defaults to the preset's minimum)
+	CipherPreset   string     `json:"cipherPreset"`   // modern, intermediate or old
+	HSTS           HSTSPolicy `json:"hsts"`           // Strict-Transport-Security
+	OCSPStapling   bool       `json:"ocspStapling"`   // Staple OCSP responses
+	SessionTickets bool       `json:"sessionTickets"` // Allow TLS session tickets
+	SessionTimeout string     `json:"sessionTimeout"` // e.g., "1d" (defaults to 1d)
+}
+
+// defaultTLSPolicy is used for hosts without an explicit policy
+var defaultTLSPolicy = TLSPolicy{CipherPreset: CipherPresetIntermediate}
+
+func (p *TLSPolicy) preset() string {
+	if p.CipherPreset == "" {
+		return CipherPresetIntermediate
+	}
+	return p.CipherPreset
+}
+
+// Protocols returns the ssl_protocols value
+func (p *TLSPolicy) Protocols() string {
+	min := p.MinProtocol
+	if min == "" {
+		min = cipherPresets[p.preset()].minProtocol
+	}
+
+	for i, proto := range tlsProtocols {
+		if proto == min {
+			return strings.Join(tlsProtocols[i:], " ")
+		}
+	}
+	return "TLSv1.2 TLSv1.3"
+}
+
+// Ciphers returns the ssl_ciphers value, empty when nginx defaults apply
+func (p *TLSPolicy) Ciphers() string {
+	return cipherPresets[p.preset()].ciphers
+}
+
+// PreferServerCiphers reports whether the server's cipher order wins
+func (p *TLSPolicy) PreferServerCiphers() bool {
+	return cipherPresets[p.preset()].preferServerCiphers
+}
+
+// Timeout returns the ssl_session_timeout value
+func (p *TLSPolicy) Timeout() string {
+	if p.SessionTimeout == "" {
+		return "1d"
+	}
+	return p.SessionTimeout
+}
+
+// Validate checks the policy against what nginx accepts
+func (p *TLSPolicy) Validate() error {
+	if _, ok := cipherPresets[p.preset()]; !ok {
+		return fmt.Errorf("invalid cipher preset: %s (expected modern, intermediate or old)", p.CipherPreset)
+	}
+
+	if p.MinProtocol != "" && !containsString(tlsProtocols, p.MinProtocol) {
+		return fmt.Errorf("invalid minimum TLS protocol: %s", p.MinProtocol)
+	}
+
+	if p.SessionTimeout != "" && !nginxTimeRegex.MatchString(p.SessionTimeout) {
+		return fmt.Errorf("invalid session timeout: %s", p.SessionTimeout)
+	}
+
+	if p.HSTS.Enabled {
+		if p.HSTS.MaxAge < 0 {
+			return fmt.Errorf("HSTS max-age must not be negative")
+		}
+		if p.HSTS.Preload {
+			if !p.HSTS.IncludeSubDomains {
+				return fmt.Errorf("HSTS preload requires includeSubDomains")
+			}
+			if p.HSTS.MaxAge != 0 && p.HSTS.MaxAge < 31536000 {
+				return fmt.Errorf("HSTS preload requires a max-age of at least one year")
+			}
+		}
+	}
+
+	return nil
+}
+
+// TLSSettings returns the effective TLS policy for the host
+func (h *ProxyHost) TLSSettings() *TLSPolicy {
+	if h.TLS == nil {
+		policy := defaultTLSPolicy
+		return &policy
+	}
+	return h.TLS
+}
+
+// validateTLS checks that an SSL host has a certificate bound and a valid policy
+func validateTLS(host *ProxyHost) error {
+	if !host.SSL {
+		if host.ForceSSL {
+			return fmt.Errorf("forceSSL requires ssl to be enabled")
+		}
+		return nil
+	}
+
+	if host.CertPath == "" || host.KeyPath == "" {
+		return fmt.Errorf("ssl requires a certificate to be bound to the host")
+	}
+
+	return host.TLSSettings().Validate()
+}
+
+// normalizeForceSSL drops forceSSL from a host without ssl. Hosts saved
+// before the combination was rejected keep working for actions that do not
+// edit their TLS settings; create and update still reject it.
+func normalizeForceSSL(host *ProxyHost) {
+	if !host.SSL {
+		host.ForceSSL = false
+	}
+}
+
+func containsString(list []string, item string) bool {
+	for _, s := range list {
+		if s == item {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,406 @@
+package nginx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type fileOpKind int
+
+const (
+	opWrite fileOpKind = iota
+	opSymlink
+	opRemove
+)
+
+// fileOp is a single staged change to the filesystem.
+type fileOp struct {
+	kind   fileOpKind
+	path   string
+	data   []byte
+	perm   os.FileMode
+	target string // symlink target
+	config bool   // whether the change affects the nginx config tree
+}
+
+// pathSnapshot records what a path looked like before a transaction touched it.
+type pathSnapshot struct {
+	path    string
+	exists  bool
+	symlink string // link target when the path was a symlink
+	data    []byte
+	perm    os.FileMode
+}
+
+// Transaction stages a set of file changes and applies them all-or-nothing.
+//
+// Changes are rendered in memory first. On Commit they are written into a
+// throwaway copy of the nginx config tree and `nginx -t` is run against the
+// copy, so the live tree never holds an untested file. Only when the test
+// passes are the current contents of every touched path snapshotted and the
+// new files promoted with atomic renames; the snapshots restore the tree if a
+// rename fails part way. nginx is never reloaded here.
+type Transaction struct {
+	ctrl *Controller
+	ops  []fileOp
+}
+
+// NewTransaction starts an empty transaction. With a nil controller the
+// config test is skipped.
+func NewTransaction(ctrl *Controller) *Transaction {
+	return &Transaction{ctrl: ctrl}
+}
+
+// WriteFile stages a config file write.
+func (t *Transaction) WriteFile(path string, data []byte, perm os.FileMode) {
+	t.ops = append(t.ops, fileOp{kind: opWrite, path: path, data: data, perm: perm, config: true})
+}
+
+// WriteState stages a write to a Nubi state file. State files are rolled back
+// together with the config but do not by themselves require a config test.
+func (t *Transaction) WriteState(path string, data []byte) {
+	t.ops = append(t.ops, fileOp{kind: opWrite, path: path, data: data, perm: 0644})
+}
+
+// Symlink stages the creation (or replacement) of a symlink.
+func (t *Transaction) Symlink(target, link string) {
+	t.ops = append(t.ops, fileOp{kind: opSymlink, path: link, target: target, config: true})
+}
+
+// Remove stages the removal of a path. Missing paths are ignored.
+func (t *Transaction) Remove(path string) {
+	t.ops = append(t.ops, fileOp{kind: opRemove, path: path, config: true})
+}
+
+// RemoveState stages the removal of a Nubi state file.
+func (t *Transaction) RemoveState(path string) {
+	t.ops = append(t.ops, fileOp{kind: opRemove, path: path})
+}
+
+// Empty reports whether nothing has been staged.
+func (t *Transaction) Empty() bool {
+	return len(t.ops) == 0
+}
+
+// Commit tests the staged changes against a copy of the config tree and
+// promotes them into the live tree once the test passes. A failed test is
+// reported as a *ConfigTestError and leaves the live tree untouched.
+func (t *Transaction) Commit(ctx context.Context) error {
+	if t.ctrl != nil {
+		t.ctrl.applyMu.Lock()
+		defer t.ctrl.applyMu.Unlock()
+	}
+
+	needsTest := false
+	for _, op := range t.ops {
+		if op.config {
+			needsTest = true
+			break
+		}
+	}
+	if needsTest && t.ctrl != nil {
+		if err := t.test(ctx); err != nil {
+			return err
+		}
+	}
+
+	snapshots := make([]pathSnapshot, 0, len(t.ops))
+	seen := make(map[string]bool)
+	for _, op := range t.ops {
+		if seen[op.path] {
+			continue
+		}
+		seen[op.path] = true
+
+		snap, err := takeSnapshot(op.path)
+		if err != nil {
+			return fmt.Errorf("failed to snapshot %s: %w", op.path, err)
+		}
+		snapshots = append(snapshots, snap)
+	}
+
+	for _, op := range t.ops {
+		if err := op.apply(); err != nil {
+			restoreSnapshots(snapshots)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// test copies the directory of the main nginx config into a staging
+// directory, applies the staged config changes to the copy and runs
+// `nginx -t` against it.
+func (t *Transaction) test(ctx context.Context) error {
+	dir, err := os.MkdirTemp("", "nubi-stage-")
+	if err != nil {
+		return fmt.Errorf("failed to create staging config: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	confPath := t.ctrl.ConfPath(ctx)
+	stage := newStagingTree(filepath.Dir(confPath), dir, t.ops)
+	if err := stage.copyTree(); err != nil {
+		return fmt.Errorf("failed to copy config to staging: %w", err)
+	}
+	for _, op := range t.ops {
+		if !op.config {
+			continue
+		}
+		if err := stage.op(op).apply(); err != nil {
+			return err
+		}
+	}
+
+	err = t.ctrl.TestConfigCopy(ctx, stage.path(confPath))
+	var testErr *ConfigTestError
+	if errors.As(err, &testErr) {
+		stage.unmap(testErr)
+	}
+	return err
+}
+
+// stagingTree maps live config paths into a staging directory. Paths below
+// the config directory are mirrored under conf/, touched paths outside it
+// (certificates, state shared with nginx) under ext/. References to mapped
+// paths in the staged files are rewritten so the copy only includes itself.
+type stagingTree struct {
+	confDir string
+	root    string
+	ext     map[string]bool
+	rewrite *strings.Replacer
+	reverse *strings.Replacer
+}
+
+func newStagingTree(confDir, root string, ops []fileOp) *stagingTree {
+	s := &stagingTree{confDir: filepath.Clean(confDir), root: root, ext: make(map[string]bool)}
+
+	var pairs []string
+	for _, op := range ops {
+		if !op.config || s.inConfDir(op.path) || s.ext[op.path] {
+			continue
+		}
+		s.ext[op.path] = true
+		pairs = append(pairs, op.path, s.path(op.path))
+	}
+	pairs = append(pairs, s.confDir+"/", filepath.Join(root, "conf")+"/")
+	s.rewrite = strings.NewReplacer(pairs...)
+
+	reversed := make([]string, len(pairs))
+	for i := 0; i < len(pairs); i += 2 {
+		reversed[i], reversed[i+1] = pairs[i+1], pairs[i]
+	}
+	s.reverse = strings.NewReplacer(reversed...)
+	return s
+}
+
+func (s *stagingTree) inConfDir(path string) bool {
+	return strings.HasPrefix(filepath.Clean(path), s.confDir+string(filepath.Separator))
+}
+
+// path returns where a live path lives in the staging tree. Paths outside
+// the config directory that the transaction does not touch are read live.
+func (s *stagingTree) path(path string) string {
+	switch {
+	case filepath.Clean(path) == s.confDir:
+		return filepath.Join(s.root, "conf")
+	case s.inConfDir(path):
+		rel, _ := filepath.Rel(s.confDir, filepath.Clean(path))
+		return filepath.Join(s.root, "conf", rel)
+	case s.ext[path]:
+		return filepath.Join(s.root, "ext", path)
+	}
+	return path
+}
+
+// op returns a staged change redirected into the staging tree
+func (s *stagingTree) op(op fileOp) fileOp {
+	op.path = s.path(op.path)
+	if op.kind == opSymlink && filepath.IsAbs(op.target) {
+		op.target = s.path(op.target)
+	}
+	if op.kind == opWrite {
+		op.data = []byte(s.rewrite.Replace(string(op.data)))
+	}
+	return op
+}
+
+// copyTree copies the live config directory into the staging tree,
+// rewriting the paths in files and absolute symlink targets
+func (s *stagingTree) copyTree() error {
+	return filepath.WalkDir(s.confDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		dst := s.path(path)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(dst, info.Mode().Perm()|0700)
+		case d.Type()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if filepath.IsAbs(target) {
+				target = s.path(target)
+			}
+			return os.Symlink(target, dst)
+		case d.Type().IsRegular():
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			return os.WriteFile(dst, []byte(s.rewrite.Replace(string(data))), info.Mode().Perm())
+		}
+		return nil
+	})
+}
+
+// unmap points the files named by a failed test back at the live paths
+func (s *stagingTree) unmap(testErr *ConfigTestError) {
+	testErr.Output = s.reverse.Replace(testErr.Output)
+	for i := range testErr.Issues {
+		testErr.Issues[i].Message = s.reverse.Replace(testErr.Issues[i].Message)
+		testErr.Issues[i].File = s.reverse.Replace(testErr.Issues[i].File)
+	}
+}
+
+// apply performs a single staged change.
+func (op fileOp) apply() error {
+	switch op.kind {
+	case opWrite:
+		if err := writeFileAtomic(op.path, op.data, op.perm); err != nil {
+			return fmt.Errorf("failed to write %s: %w", op.path, err)
+		}
+	case opSymlink:
+		if err := symlinkAtomic(op.target, op.path); err != nil {
+			return fmt.Errorf("failed to create symlink: %w", err)
+		}
+	case opRemove:
+		if err := os.Remove(op.path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", op.path, err)
+		}
+	}
+	return nil
+}
+
+// takeSnapshot captures the current state of path.
+func takeSnapshot(path string) (pathSnapshot, error) {
+	snap := pathSnapshot{path: path}
+
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return snap, nil
+	}
+	if err != nil {
+		return snap, err
+	}
+	snap.exists = true
+	snap.perm = info.Mode().Perm()
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		snap.symlink, err = os.Readlink(path)
+		return snap, err
+	}
+
+	snap.data, err = os.ReadFile(path)
+	return snap, err
+}
+
+// restoreSnapshots puts every path back the way it was, newest first.
+func restoreSnapshots(snapshots []pathSnapshot) {
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		snap := snapshots[i]
+		switch {
+		case !snap.exists:
+			_ = os.Remove(snap.path)
+		case snap.symlink != "":
+			_ = symlinkAtomic(snap.symlink, snap.path)
+		default:
+			_ = writeFileAtomic(snap.path, snap.data, snap.perm)
+		}
+	}
+}
+
+// writeFileAtomic writes data to a hidden temp file next to path and renames
+// it into place, so nginx never sees a half-written file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(dir, ".nubi-tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := f.Name()
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Chmod(tmpPath, perm); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// symlinkAtomic points link at target, replacing whatever link was there.
+func symlinkAtomic(target, link string) error {
+	dir := filepath.Dir(link)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	tmpPath := filepath.Join(dir, ".nubi-tmp-"+filepath.Base(link))
+	_ = os.Remove(tmpPath)
+	if err := os.Symlink(target, tmpPath); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpPath, link); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// hostRefresh is a host manager's share of a library change (an access
+// list, CORS policy or error page) staged into the library's transaction.
+// The manager stays locked from staging until finish is called.
+type hostRefresh struct {
+	swap   func(ctx context.Context) // makes the staged hosts live, nil when none changed
+	unlock func()
+}
+
+// finish swaps the staged hosts in when the shared commit succeeded and
+// unlocks the manager
+func (r *hostRefresh) finish(ctx context.Context, err error) {
+	defer r.unlock()
+	if err == nil && r.swap != nil {
+		r.swap(ctx)
+	}
+}
@@ -0,0 +1,289 @@
+package nginx
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// UpstreamSettings tunes the upstream blocks rendered for a host and its
+// locations
+type UpstreamSettings struct {
+	Keepalive         int    `json:"keepalive"`         // Idle connections to the backends cached per worker (0 = off)
+	KeepaliveTimeout  int    `json:"keepaliveTimeout"`  // Seconds an idle cached connection stays open (0 = nginx default 60s)
+	KeepaliveRequests int    `json:"keepaliveRequests"` // Requests served over one cached connection (0 = nginx default 1000)
+	ZoneSize          string `json:"zoneSize"`          // Shared memory zone, e.g. "64k", so max_conns and failures are shared across workers
+}
+
+// zoneSizeRegex matches nginx sizes like "64k" or "1m"
+var zoneSizeRegex = regexp.MustCompile(`^([1-9][0-9]*)([kKmM]?)$`)
+
+// minZoneSize is the smallest upstream zone nginx accepts (8 pages)
+const minZoneSize = 32 << 10
+
+// Validate checks the settings against nginx limits
+func (s *UpstreamSettings) Validate() error {
+	if s.Keepalive < 0 || s.KeepaliveTimeout < 0 || s.KeepaliveRequests < 0 {
+		return fmt.Errorf("upstream settings must not be negative")
+	}
+	if s.Keepalive == 0 && (s.KeepaliveTimeout > 0 || s.KeepaliveRequests > 0) {
+		return fmt.Errorf("keepaliveTimeout and keepaliveRequests require keepalive")
+	}
+	if s.Keepalive > 10000 {
+		return fmt.Errorf("keepalive must be at most 10000 connections")
+	}
+	if s.KeepaliveTimeout > 3600 {
+		return fmt.Errorf("keepaliveTimeout must be at most 3600 seconds")
+	}
+
+	if s.ZoneSize != "" {
+		size, err := zoneSizeBytes(s.ZoneSize)
+		if err != nil {
+			return err
+		}
+		if size < minZoneSize {
+			return fmt.Errorf("zone size must be at least 32k")
+		}
+	}
+	return nil
+}
+
+// zoneSizeBytes parses a shared memory zone size like "64k" or "1m"
+func zoneSizeBytes(s string) (int, error) {
+	m := zoneSizeRegex.FindStringSubmatch(s)
+	if m == nil {
+		return 0, fmt.Errorf("invalid zone size: %s (expected e.g. 64k or 1m)", s)
+	}
+	size, _ := strconv.Atoi(m[1])
+	switch strings.ToLower(m[2]) {
+	case "k":
+		size <<= 10
+	case "m":
+		size <<= 20
+	}
+	return size, nil
+}
+
+// KeepaliveDirectives returns the keepalive directives of the upstream block.
+// nginx requires them after the balancing method.
+func (s *UpstreamSettings) KeepaliveDirectives() []string {
+	if s.Keepalive == 0 {
+		return nil
+	}
+	lines := []string{fmt.Sprintf("keepalive %d;", s.Keepalive)}
+	if s.KeepaliveTimeout > 0 {
+		lines = append(lines, fmt.Sprintf("keepalive_timeout %ds;", s.KeepaliveTimeout))
+	}
+	if s.KeepaliveRequests > 0 {
+		lines = append(lines, fmt.Sprintf("keepalive_requests %d;", s.KeepaliveRequests))
+	}
+	return lines
+}
+
+// Params returns the server parameters following the backend address
+func (b Backend) Params() string {
+	var params []string
+	if b.Weight > 1 {
+		params = append(params, fmt.Sprintf("weight=%d", b.Weight))
+	}
+	if b.MaxFails != nil {
+		params = append(params, fmt.Sprintf("max_fails=%d", *b.MaxFails))
+	}
+	if b.FailTimeout > 0 {
+		params = append(params, fmt.Sprintf("fail_timeout=%ds", b.FailTimeout))
+	}
+	if b.MaxConns > 0 {
+		params = append(params, fmt.Sprintf("max_conns=%d", b.MaxConns))
+	}
+	if b.SlowStart > 0 {
+		params = append(params, fmt.Sprintf("slow_start=%ds", b.SlowStart))
+	}
+	if b.Backup {
+		params = append(params, "backup")
+	}
+	if len(params) == 0 {
+		return ""
+	}
+	return " " + strings.Join(params, " ")
+}
+
+// validateBackends checks the servers of an upstream
+func validateBackends(backends []Backend) error {
+	for _, b := range backends {
+		if b.Address == "" || strings.ContainsAny(b.Address, " \t\r\n;{}\"'") {
+			return fmt.Errorf("invalid backend address: %q", b.Address)
+		}
+		if b.Weight < 0 {
+			return fmt.Errorf("backend %s: weight must not be negative", b.Address)
+		}
+		if b.MaxFails != nil && *b.MaxFails < 0 {
+			return fmt.Errorf("backend %s: maxFails must not be negative", b.Address)
+		}
+		if b.FailTimeout < 0 || b.MaxConns < 0 || b.SlowStart < 0 {
+			return fmt.Errorf("backend %s: failTimeout, maxConns and slowStart must not be negative", b.Address)
+		}
+	}
+	return nil
+}
+
+// checkSlowStart refuses slow_start unless the installed nginx is NGINX Plus,
+// as open source nginx -t rejects it as an invalid server parameter
+func (m *ProxyHostManager) checkSlowStart(host *ProxyHost) error {
+	backends := append([]Backend{}, host.Backends...)
+	if host.Rollout != nil {
+		backends = append(backends, host.Rollout.Candidate...)
+	}
+	for _, l := range host.Locations {
+		backends = append(backends, l.Backends...)
+	}
+
+	for _, b := range backends {
+		if b.SlowStart > 0 && !m.ctrl.IsPlus(context.Background()) {
+			return fmt.Errorf("backend %s: slowStart requires NGINX Plus", b.Address)
+		}
+	}
+	return nil
+}
+
+// validateUpstreamSettings checks optional upstream settings
+func validateUpstreamSettings(s *UpstreamSettings) error {
+	if s == nil {
+		return nil
+	}
+	return s.Validate()
+}
+
+// upstreamView is the data rendered into an upstream block
+type upstreamView struct {
+	HostID   string
+	Name     string
+	LBMethod string
+	Options  *LBOptions
+	Servers  []Backend
+	Settings *UpstreamSettings
+}
+
+// KeepaliveEnabled reports whether upstream connections are kept alive
+func (h *ProxyHost) KeepaliveEnabled() bool {
+	return h.Upstream != nil && h.Upstream.Keepalive > 0
+}
+
+// UsesUpstream reports whether location / proxies through an upstream block.
+// That is the case for load balanced hosts, hosts with a rollout in progress,
+// and for single-target hosts when keepalive is requested, as nginx only
+// keeps connections to upstream blocks.
+func (h *ProxyHost) UsesUpstream() bool {
+	return h.HasLoadBalancing() || h.Rollout != nil || (h.KeepaliveEnabled() && (len(h.Backends) > 0 || h.Target != ""))
+}
+
+// UpstreamServers returns the servers of the host's upstream block: its
+// backends, or the target's host and port
+func (h *ProxyHost) UpstreamServers() []Backend {
+	if len(h.Backends) > 0 {
+		return h.Backends
+	}
+	u, err := url.Parse(h.Target)
+	if err != nil || u.Host == "" {
+		return nil
+	}
+	address := u.Host
+	if u.Port() == "" {
+		port := "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+		address = net.JoinHostPort(u.Hostname(), port)
+	}
+	return []Backend{{Address: address}}
+}
+
+// ProxyPass returns the proxy_pass value of location /
+func (h *ProxyHost) ProxyPass() string {
+	if !h.UsesUpstream() {
+		return h.Target
+	}
+	if h.Rollout != nil {
+		return h.rolloutPass()
+	}
+	if len(h.Backends) > 0 {
+		return "http://" + h.UpstreamName()
+	}
+	// Keep the target's scheme and path when it is turned into an upstream
+	u, err := url.Parse(h.Target)
+	if err != nil {
+		return h.Target
+	}
+	return u.Scheme + "://" + h.UpstreamName() + u.EscapedPath()
+}
+
+// HostUpstream returns the view of the host's upstream block
+func (h *ProxyHost) HostUpstream() upstreamView {
+	return upstreamView{
+		HostID:   h.ID,
+		Name:     h.UpstreamName(),
+		LBMethod: h.LBMethod,
+		Options:  h.LBOptions,
+		Servers:  h.UpstreamServers(),
+		Settings: h.Upstream,
+	}
+}
+
+// LocationUpstreamView returns the view of a location's upstream block
+func (h *ProxyHost) LocationUpstreamView(l Location) upstreamView {
+	return upstreamView{
+		HostID:   h.ID,
+		Name:     h.LocationUpstream(l),
+		LBMethod: l.LBMethod,
+		Options:  l.LBOptions,
+		Servers:  l.Backends,
+		Settings: h.Upstream,
+	}
+}
+
+// ProxyHeaderOptions is the argument of the proxy_headers template
+type ProxyHeaderOptions struct {
+	WebSocket  bool
+	Keepalive  bool
+	Rules      []string        // Header rule directives rendered after the standard headers
+	CORS       string          // CORS policy whose add_header directives are repeated in the location
+	ClientCert []string        // Headers forwarding the verified client certificate
+	overrides  map[string]bool // Request headers changed by the rules
+}
+
+// headerOptions returns the proxy_headers options for a location proxying
+// through an upstream block or not
+func (h *ProxyHost) headerOptions(websocket, upstream bool) ProxyHeaderOptions {
+	return ProxyHeaderOptions{
+		WebSocket:  websocket,
+		Keepalive:  upstream && h.KeepaliveEnabled(),
+		ClientCert: h.clientCertDirectives(),
+	}
+}
+
+// needsConnectionUpgradeMap reports whether a WebSocket location of the host
+// reuses upstream connections, which needs the $nubi_connection_upgrade map
+func (h *ProxyHost) needsConnectionUpgradeMap() bool {
+	if !h.KeepaliveEnabled() {
+		return false
+	}
+	if h.WebSocket && h.UsesUpstream() {
+		return true
+	}
+	for _, l := range h.Locations {
+		if l.WebSocket && l.HasUpstream() {
+			return true
+		}
+	}
+	return false
+}
+
+// connectionUpgradeMap keeps upstream connections reusable for WebSocket
+// locations: Connection is only set to "upgrade" on upgrade requests
+const connectionUpgradeMap = `map $http_upgrade $nubi_connection_upgrade {
+    default upgrade;
+    "" "";
+}`